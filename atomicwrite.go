@@ -0,0 +1,107 @@
+package polytopiamapmodel
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"time"
+)
+
+// renameFile moves the temporary file over the output file. Tests replace it to count the writes made by an edit.
+var renameFile = os.Rename
+
+// WriteFileAtomic writes the data to a temporary file in the same directory and renames it over outputFilename,
+// so the file contains either the old or the new data if the program stops during the write
+func WriteFileAtomic(outputFilename string, fileData []byte) error {
+	fileMode := os.FileMode(0644)
+	if fileInfo, err := os.Stat(outputFilename); err == nil {
+		fileMode = fileInfo.Mode().Perm()
+	}
+
+	tempFile, err := os.CreateTemp(filepath.Dir(outputFilename), filepath.Base(outputFilename)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	tempFilename := tempFile.Name()
+	removeTempFile := func() {
+		tempFile.Close()
+		os.Remove(tempFilename)
+	}
+
+	if _, err := tempFile.Write(fileData); err != nil {
+		removeTempFile()
+		return err
+	}
+	if err := tempFile.Sync(); err != nil {
+		removeTempFile()
+		return err
+	}
+	if err := tempFile.Chmod(fileMode); err != nil {
+		removeTempFile()
+		return err
+	}
+	if err := tempFile.Close(); err != nil {
+		os.Remove(tempFilename)
+		return err
+	}
+	if err := renameFile(tempFilename, outputFilename); err != nil {
+		os.Remove(tempFilename)
+		return err
+	}
+	return syncDirectory(filepath.Dir(outputFilename))
+}
+
+// syncDirectory flushes the directory entry changed by the rename, so the new file is still there after a crash.
+// Windows can't open a directory for syncing, so there the rename is left to the file system.
+func syncDirectory(dirname string) error {
+	if runtime.GOOS == "windows" {
+		return nil
+	}
+	dir, err := os.Open(dirname)
+	if err != nil {
+		return err
+	}
+	if err := dir.Sync(); err != nil {
+		dir.Close()
+		return err
+	}
+	return dir.Close()
+}
+
+// BackupFile copies the file to a timestamped .bak file next to it and returns the backup filename.
+// The backup and its directory entry are synced before returning, so the backup survives a crash while the original is replaced.
+func BackupFile(inputFilename string) (string, error) {
+	fileData, err := os.ReadFile(inputFilename)
+	if err != nil {
+		return "", fmt.Errorf("failed to read file for backup: %w", err)
+	}
+
+	timestamp := time.Now().Format("20060102-150405")
+	backupFilename := fmt.Sprintf("%v.%v.bak", inputFilename, timestamp)
+	for i := 1; ; i++ {
+		backupFile, err := os.OpenFile(backupFilename, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if os.IsExist(err) {
+			backupFilename = fmt.Sprintf("%v.%v-%v.bak", inputFilename, timestamp, i)
+			continue
+		}
+		if err != nil {
+			return "", fmt.Errorf("failed to create backup: %w", err)
+		}
+		if _, err := backupFile.Write(fileData); err != nil {
+			backupFile.Close()
+			return "", fmt.Errorf("failed to write backup: %w", err)
+		}
+		if err := backupFile.Sync(); err != nil {
+			backupFile.Close()
+			return "", fmt.Errorf("failed to write backup: %w", err)
+		}
+		if err := backupFile.Close(); err != nil {
+			return "", fmt.Errorf("failed to write backup: %w", err)
+		}
+		if err := syncDirectory(filepath.Dir(backupFilename)); err != nil {
+			return "", fmt.Errorf("failed to write backup: %w", err)
+		}
+		return backupFilename, nil
+	}
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	polytopiamapmodel "github.com/samuelyuan/polytopiamapmodelgo"
+	"os"
+)
+
+func main() {
+	outputFilename := flag.String("o", "", "output file, prints the schema if not set")
+	flag.Parse()
+
+	schema, err := polytopiamapmodel.BuildJsonSchema()
+	if err != nil {
+		fmt.Printf("FAILED: %v\n", err)
+		os.Exit(1)
+	}
+	if *outputFilename == "" {
+		os.Stdout.Write(schema)
+		return
+	}
+	if err := polytopiamapmodel.WriteFileAtomic(*outputFilename, schema); err != nil {
+		fmt.Printf("FAILED: %v\n", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	polytopiamapmodel "github.com/samuelyuan/polytopiamapmodelgo"
+	"os"
+)
+
+func main() {
+	unknownOnly := flag.Bool("unknown", false, "only show fields that have not been decoded yet")
+	readNewerVersions := flag.Bool("newer", false, "read a version newer than every known layout with the newest layout")
+	flag.Usage = func() {
+		fmt.Println("Usage: go run main.go [-unknown] [-newer] <polytopia_file.state> [other_file.state]")
+		fmt.Println("Prints every field with its offset, length, hex and value. With two files, prints the fields that changed.")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() < 1 || flag.NArg() > 2 {
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	options := polytopiamapmodel.ParseOptions{ReadNewerVersions: *readNewerVersions}
+	oldLayout := readLayout(flag.Arg(0), options, *unknownOnly)
+	if flag.NArg() == 1 {
+		if err := polytopiamapmodel.WriteLayout(os.Stdout, oldLayout); err != nil {
+			fmt.Printf("FAILED: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	newLayout := readLayout(flag.Arg(1), options, *unknownOnly)
+	if err := polytopiamapmodel.WriteLayoutDiff(os.Stdout, polytopiamapmodel.DiffLayouts(oldLayout, newLayout)); err != nil {
+		fmt.Printf("FAILED: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func readLayout(filename string, options polytopiamapmodel.ParseOptions, unknownOnly bool) []polytopiamapmodel.LayoutField {
+	saveOutput, _, err := polytopiamapmodel.OpenWithOptions(filename, options)
+	if err != nil {
+		fmt.Printf("FAILED to read %s: %v\n", filename, err)
+		os.Exit(1)
+	}
+	for _, warning := range saveOutput.Warnings {
+		fmt.Fprintf(os.Stderr, "Warning: %s: %v\n", filename, warning)
+	}
+	fields, err := polytopiamapmodel.BuildLayout(saveOutput)
+	if err != nil {
+		fmt.Printf("FAILED to build layout of %s: %v\n", filename, err)
+		os.Exit(1)
+	}
+	if !unknownOnly {
+		return fields
+	}
+
+	unknownFields := make([]polytopiamapmodel.LayoutField, 0)
+	for _, field := range fields {
+		if field.IsUnknown() {
+			unknownFields = append(unknownFields, field)
+		}
+	}
+	return unknownFields
+}
@@ -0,0 +1,519 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	polytopiamapmodel "github.com/samuelyuan/polytopiamapmodelgo"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// parseFileArgs parses the flags and returns the single file argument
+func parseFileArgs(flagSet *flag.FlagSet, args []string) (string, error) {
+	if err := flagSet.Parse(args); err != nil {
+		return "", err
+	}
+	if flagSet.NArg() != 1 {
+		return "", fmt.Errorf("%v expects one file, got %v arguments", flagSet.Name(), flagSet.NArg())
+	}
+	return flagSet.Arg(0), nil
+}
+
+func runInfo(args []string) error {
+	flagSet := flag.NewFlagSet("info", flag.ContinueOnError)
+	filename, err := parseFileArgs(flagSet, args)
+	if err != nil {
+		return err
+	}
+	saveOutput, saveFormat, err := polytopiamapmodel.Open(filename)
+	if err != nil {
+		return err
+	}
+
+	printWarnings(saveOutput)
+	fmt.Printf("Format: %v\n", saveFormat)
+	fmt.Printf("Map Size: %dx%d\n", saveOutput.MapWidth, saveOutput.MapHeight)
+	fmt.Printf("Game Version: %d\n", saveOutput.GameVersion)
+	fmt.Printf("Current Turn: %d\n", saveOutput.MaxTurn)
+	fmt.Printf("Players: %d\n", len(saveOutput.PlayerData))
+	for _, player := range saveOutput.PlayerData {
+		fmt.Printf("  %3d %-20s %v\n", player.PlayerId, player.Name, polytopiamapmodel.TribeType(player.Tribe))
+	}
+	return nil
+}
+
+// printWarnings prints the problems found while reading the save that didn't stop the parse
+func printWarnings(saveOutput *polytopiamapmodel.PolytopiaSaveOutput) {
+	for _, warning := range saveOutput.Warnings {
+		fmt.Fprintf(os.Stderr, "Warning: %v\n", warning)
+	}
+}
+
+func runDecompress(args []string) error {
+	flagSet := flag.NewFlagSet("decompress", flag.ContinueOnError)
+	output := flagSet.String("o", "", "output file, defaults to <file>.decomp")
+	filename, err := parseFileArgs(flagSet, args)
+	if err != nil {
+		return err
+	}
+	if *output == "" {
+		*output = filename + ".decomp"
+	}
+
+	inputFile, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer inputFile.Close()
+	decompressedContents, err := polytopiamapmodel.Decompress(inputFile)
+	if err != nil {
+		return err
+	}
+	if err := polytopiamapmodel.WriteFileAtomic(*output, decompressedContents); err != nil {
+		return err
+	}
+	fmt.Printf("Wrote %v\n", *output)
+	return nil
+}
+
+func runCompress(args []string) error {
+	flagSet := flag.NewFlagSet("compress", flag.ContinueOnError)
+	output := flagSet.String("o", "", "output file, defaults to <file> without .decomp")
+	level := flagSet.Int("level", polytopiamapmodel.CompressionLevelFast,
+		fmt.Sprintf("0 for fast compression or an LZ4 HC level up to %v", polytopiamapmodel.CompressionLevelMax))
+	filename, err := parseFileArgs(flagSet, args)
+	if err != nil {
+		return err
+	}
+	if *output == "" {
+		if !strings.HasSuffix(filename, ".decomp") {
+			return fmt.Errorf("-o is required when the file name does not end with .decomp")
+		}
+		*output = strings.TrimSuffix(filename, ".decomp")
+	}
+
+	decompressedContents, err := os.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	var compressedContents bytes.Buffer
+	if err := polytopiamapmodel.CompressWithOptions(&compressedContents, decompressedContents, polytopiamapmodel.CompressOptions{Level: *level}); err != nil {
+		return err
+	}
+	if err := polytopiamapmodel.WriteFileAtomic(*output, compressedContents.Bytes()); err != nil {
+		return err
+	}
+	fmt.Printf("Wrote %v\n", *output)
+	return nil
+}
+
+func runExportJson(args []string) error {
+	flagSet := flag.NewFlagSet("export-json", flag.ContinueOnError)
+	output := flagSet.String("o", "", "output file, defaults to <file>.json")
+	useEnumNames := flagSet.Bool("enum-names", false, "write terrain, unit, tech and tribe ids as names")
+	filename, err := parseFileArgs(flagSet, args)
+	if err != nil {
+		return err
+	}
+	if *output == "" {
+		*output = filename + ".json"
+	}
+
+	saveOutput, _, err := polytopiamapmodel.Open(filename)
+	if err != nil {
+		return err
+	}
+	if err := polytopiamapmodel.ExportPolytopiaJsonFileWithOptions(saveOutput, *output, polytopiamapmodel.JsonExportOptions{UseEnumNames: *useEnumNames}); err != nil {
+		return err
+	}
+	fmt.Printf("Wrote %v\n", *output)
+	return nil
+}
+
+func runExportTables(args []string) error {
+	flagSet := flag.NewFlagSet("export-tables", flag.ContinueOnError)
+	output := flagSet.String("o", "", "output prefix, defaults to <file>")
+	useTsv := flagSet.Bool("tsv", false, "write tab separated files instead of csv")
+	useEnumNames := flagSet.Bool("enum-names", false, "write terrain, unit, tech and tribe ids as names")
+	initial := flagSet.Bool("initial", false, "export the tiles and players of the initial state")
+	perTurn := flagSet.Bool("per-turn", false, "export the tiles and players at the end of every turn, rebuilt by replaying the actions")
+	filename, err := parseFileArgs(flagSet, args)
+	if err != nil {
+		return err
+	}
+	if *output == "" {
+		*output = filename
+	}
+
+	saveOutput, _, err := polytopiamapmodel.Open(filename)
+	if err != nil {
+		return err
+	}
+	options := polytopiamapmodel.TableExportOptions{UseEnumNames: *useEnumNames, Initial: *initial, PerTurn: *perTurn}
+	if *useTsv {
+		options.Format = polytopiamapmodel.TableFormatTSV
+	}
+	if err := polytopiamapmodel.ExportPolytopiaTables(saveOutput, *output, options); err != nil {
+		return err
+	}
+	fmt.Printf("Wrote %v_tiles%v, %v_players%v and %v_actions%v\n", *output, options.Format.Extension(),
+		*output, options.Format.Extension(), *output, options.Format.Extension())
+	return nil
+}
+
+func runImportJson(args []string) error {
+	flagSet := flag.NewFlagSet("import-json", flag.ContinueOnError)
+	output := flagSet.String("o", "", "output file, defaults to <file> without .json")
+	filename, err := parseFileArgs(flagSet, args)
+	if err != nil {
+		return err
+	}
+	if *output == "" {
+		if !strings.HasSuffix(filename, ".json") {
+			return fmt.Errorf("-o is required when the file name does not end with .json")
+		}
+		*output = strings.TrimSuffix(filename, ".json")
+	}
+
+	if err := polytopiamapmodel.ConvertJsonToStateFile(filename, *output); err != nil {
+		return err
+	}
+	fmt.Printf("Wrote %v\n", *output)
+	return nil
+}
+
+// playerFlag is a required flag with a player id between 0 and 255 or a tribe name.
+// The id is resolved once the save is open.
+type playerFlag struct {
+	name  string
+	value string
+	id    int
+}
+
+func (player *playerFlag) String() string {
+	if player == nil {
+		return ""
+	}
+	return player.value
+}
+
+func (player *playerFlag) Set(value string) error {
+	if playerId, err := strconv.Atoi(strings.TrimSpace(value)); err == nil {
+		if playerId < 0 || playerId > 255 {
+			return fmt.Errorf("player id must be between 0 and 255, value is %v", playerId)
+		}
+	} else if _, err := polytopiamapmodel.ParseTribeType(value); err != nil {
+		return err
+	}
+	player.value = value
+	return nil
+}
+
+// editFlagSet has the flags shared by every command that modifies a save
+type editFlagSet struct {
+	*flag.FlagSet
+	output  *string
+	target  *string
+	backup  *bool
+	noState *bool
+	players []*playerFlag
+}
+
+func newEditFlagSet(name string) *editFlagSet {
+	flagSet := flag.NewFlagSet(name, flag.ContinueOnError)
+	return &editFlagSet{
+		FlagSet: flagSet,
+		output:  flagSet.String("o", "", "output file, defaults to overwriting the input. Names ending with .state are always compressed."),
+		target:  flagSet.String("target", "current", "map state to edit: current, initial or both"),
+		backup:  flagSet.Bool("backup", false, "copy the existing output files to timestamped .bak files first"),
+		noState: flagSet.Bool("no-state", false, "only update a decompressed input, without writing the compressed .state next to it"),
+	}
+}
+
+func (flagSet *editFlagSet) tilePosition() (*int, *int) {
+	return flagSet.Int("x", -1, "tile x coordinate"), flagSet.Int("y", -1, "tile y coordinate")
+}
+
+// player adds a required player flag that accepts a player id or a tribe name
+func (flagSet *editFlagSet) player(name string, usage string) *playerFlag {
+	player := &playerFlag{name: name}
+	flagSet.Var(player, name, usage+", as a player id or a tribe name")
+	flagSet.players = append(flagSet.players, player)
+	return player
+}
+
+// checkPlayersSet returns an error if a required player flag is missing
+func (flagSet *editFlagSet) checkPlayersSet() error {
+	for _, player := range flagSet.players {
+		if player.value == "" {
+			return fmt.Errorf("-%v is required", player.name)
+		}
+	}
+	return nil
+}
+
+// resolvePlayers looks up the id of every player flag and checks that the players exist in the save
+func (flagSet *editFlagSet) resolvePlayers(doc *polytopiamapmodel.SaveDocument) error {
+	for _, player := range flagSet.players {
+		playerId, err := doc.PlayerId(player.value)
+		if err != nil {
+			return fmt.Errorf("-%v: %w", player.name, err)
+		}
+		player.id = playerId
+	}
+	return nil
+}
+
+// edit parses the arguments, then opens the save, applies the edit and writes the save in the same format it was read in
+func (flagSet *editFlagSet) edit(args []string, apply func(doc *polytopiamapmodel.SaveDocument) error) error {
+	filename, err := parseFileArgs(flagSet.FlagSet, args)
+	if err != nil {
+		return err
+	}
+	if err := flagSet.checkPlayersSet(); err != nil {
+		return err
+	}
+	return flagSet.editFile(filename, apply)
+}
+
+// open opens the save with the target and backup settings from the flags
+func (flagSet *editFlagSet) open(filename string) (*polytopiamapmodel.SaveDocument, error) {
+	target, err := polytopiamapmodel.ParseEditTarget(*flagSet.target)
+	if err != nil {
+		return nil, err
+	}
+	doc, err := polytopiamapmodel.OpenDetectedSaveDocument(filename)
+	if err != nil {
+		return nil, err
+	}
+	printWarnings(doc.Output)
+	doc.Target = target
+	doc.Backup = *flagSet.backup
+	return doc, nil
+}
+
+func (flagSet *editFlagSet) editFile(filename string, apply func(doc *polytopiamapmodel.SaveDocument) error) error {
+	doc, err := flagSet.open(filename)
+	if err != nil {
+		return err
+	}
+	if err := flagSet.resolvePlayers(doc); err != nil {
+		return err
+	}
+	if err := apply(doc); err != nil {
+		return err
+	}
+
+	for _, output := range flagSet.outputFiles(filename, doc.Compressed) {
+		if err := doc.SaveAs(output.filename, output.compressed); err != nil {
+			return err
+		}
+		fmt.Printf("Wrote %v\n", output.filename)
+	}
+	return nil
+}
+
+type outputFile struct {
+	filename   string
+	compressed bool
+}
+
+// outputFiles returns the files an edit is written to.
+// Without -o the input is overwritten in its own format. A decompressed input is also compressed to the .state file
+// next to it, the input name without .decomp or with .state added, so the game can load the edit. -no-state skips that.
+// A decompressed input whose name already ends with .state is compressed in place.
+// With -o only that file is written, compressed if the input was or if the name ends with .state.
+func (flagSet *editFlagSet) outputFiles(filename string, inputCompressed bool) []outputFile {
+	if *flagSet.output != "" {
+		return []outputFile{{*flagSet.output, inputCompressed || strings.HasSuffix(*flagSet.output, ".state")}}
+	}
+	if inputCompressed || *flagSet.noState {
+		return []outputFile{{filename, inputCompressed}}
+	}
+	if strings.HasSuffix(filename, ".state") {
+		return []outputFile{{filename, true}}
+	}
+	return []outputFile{{filename, false}, {compressedFilename(filename), true}}
+}
+
+// compressedFilename returns the name of the .state file for a decompressed save
+func compressedFilename(filename string) string {
+	if strings.HasSuffix(filename, ".decomp") {
+		return strings.TrimSuffix(filename, ".decomp")
+	}
+	return filename + ".state"
+}
+
+func runSetTerrain(args []string) error {
+	flagSet := newEditFlagSet("set-terrain")
+	x, y := flagSet.tilePosition()
+	terrainName := flagSet.String("terrain", "", "terrain name or id")
+	return flagSet.edit(args, func(doc *polytopiamapmodel.SaveDocument) error {
+		terrain, err := polytopiamapmodel.ParseTerrainType(*terrainName)
+		if err != nil {
+			return err
+		}
+		return doc.SetTerrain(*x, *y, int(terrain))
+	})
+}
+
+func runSetUnitType(args []string) error {
+	flagSet := newEditFlagSet("set-unit-type")
+	x, y := flagSet.tilePosition()
+	unitName := flagSet.String("unit", "", "unit type name or id")
+	return flagSet.edit(args, func(doc *polytopiamapmodel.SaveDocument) error {
+		unitType, err := polytopiamapmodel.ParseUnitType(*unitName)
+		if err != nil {
+			return err
+		}
+		return doc.SetUnitType(*x, *y, int(unitType))
+	})
+}
+
+func runSetUnitOwner(args []string) error {
+	flagSet := newEditFlagSet("set-unit-owner")
+	x, y := flagSet.tilePosition()
+	owner := flagSet.player("owner", "new owner")
+	return flagSet.edit(args, func(doc *polytopiamapmodel.SaveDocument) error {
+		return doc.SetUnitOwner(*x, *y, owner.id)
+	})
+}
+
+func runConvertTribe(args []string) error {
+	flagSet := newEditFlagSet("convert-tribe")
+	oldOwner := flagSet.player("from", "player that owns the units")
+	newOwner := flagSet.player("to", "new owner")
+	return flagSet.edit(args, func(doc *polytopiamapmodel.SaveDocument) error {
+		numConverted, err := doc.ConvertTribe(oldOwner.id, newOwner.id)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Converted %v units\n", numConverted)
+		return nil
+	})
+}
+
+func runSwapPlayers(args []string) error {
+	flagSet := newEditFlagSet("swap-players")
+	player1 := flagSet.player("player1", "first player")
+	player2 := flagSet.player("player2", "second player")
+	return flagSet.edit(args, func(doc *polytopiamapmodel.SaveDocument) error {
+		return doc.SwapPlayers(player1.id, player2.id)
+	})
+}
+
+func runAddCity(args []string) error {
+	flagSet := newEditFlagSet("add-city")
+	x, y := flagSet.tilePosition()
+	cityName := flagSet.String("name", "", "city name")
+	owner := flagSet.player("owner", "city owner")
+	return flagSet.edit(args, func(doc *polytopiamapmodel.SaveDocument) error {
+		return doc.AddCity(*x, *y, *cityName, owner.id)
+	})
+}
+
+func runSetCapital(args []string) error {
+	flagSet := newEditFlagSet("set-capital")
+	x, y := flagSet.tilePosition()
+	cityName := flagSet.String("name", "", "city name")
+	owner := flagSet.player("owner", "capital owner")
+	return flagSet.edit(args, func(doc *polytopiamapmodel.SaveDocument) error {
+		return doc.SetCapital(*x, *y, *cityName, owner.id)
+	})
+}
+
+func runResetTile(args []string) error {
+	flagSet := newEditFlagSet("reset-tile")
+	x, y := flagSet.tilePosition()
+	return flagSet.edit(args, func(doc *polytopiamapmodel.SaveDocument) error {
+		return doc.ResetTile(*x, *y)
+	})
+}
+
+func runReveal(args []string) error {
+	flagSet := newEditFlagSet("reveal")
+	x, y := flagSet.tilePosition()
+	player := flagSet.player("player", "player that can see the tiles")
+	return flagSet.edit(args, func(doc *polytopiamapmodel.SaveDocument) error {
+		if *x < 0 && *y < 0 {
+			return doc.RevealAllTiles(player.id)
+		}
+		return doc.RevealTile(*x, *y, player.id)
+	})
+}
+
+func runExpand(args []string) error {
+	flagSet := newEditFlagSet("expand")
+	size := flagSet.Int("size", 0, "new width and height")
+	width := flagSet.Int("width", 0, "new width")
+	height := flagSet.Int("height", 0, "new height")
+	return flagSet.edit(args, func(doc *polytopiamapmodel.SaveDocument) error {
+		if *size > 0 {
+			if *width > 0 || *height > 0 {
+				return fmt.Errorf("-size can't be combined with -width or -height")
+			}
+			return doc.ExpandTiles(*size)
+		}
+		if *width == 0 && *height == 0 {
+			return fmt.Errorf("-size, -width or -height is required")
+		}
+		if *width > 0 {
+			if err := doc.ExpandColumns(*width); err != nil {
+				return err
+			}
+		}
+		if *height > 0 {
+			return doc.ExpandRows(*height)
+		}
+		return nil
+	})
+}
+
+func runAddPlayer(args []string) error {
+	flagSet := newEditFlagSet("add-player")
+	return flagSet.edit(args, func(doc *polytopiamapmodel.SaveDocument) error {
+		newPlayerId, err := doc.AddPlayer()
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Added player %v\n", newPlayerId)
+		return nil
+	})
+}
+
+func runApplyPlan(args []string) error {
+	flagSet := newEditFlagSet("apply-plan")
+	planFilename := flagSet.String("plan", "", "json edit plan")
+	dryRun := flagSet.Bool("dry-run", false, "check the plan and print the summary without writing the save")
+	filename, err := parseFileArgs(flagSet.FlagSet, args)
+	if err != nil {
+		return err
+	}
+	plan, err := polytopiamapmodel.LoadEditPlan(*planFilename)
+	if err != nil {
+		return err
+	}
+	if *dryRun {
+		doc, err := flagSet.open(filename)
+		if err != nil {
+			return err
+		}
+		summary, err := polytopiamapmodel.ValidateEditPlan(doc.Output, plan, doc.Target)
+		if err != nil {
+			return err
+		}
+		fmt.Print(summary)
+		fmt.Printf("Plan with %v edits is valid, %v was not modified\n", len(plan.Edits), filename)
+		return nil
+	}
+	return flagSet.editFile(filename, func(doc *polytopiamapmodel.SaveDocument) error {
+		summary, err := polytopiamapmodel.ApplyEditPlan(doc, plan)
+		if err != nil {
+			return err
+		}
+		fmt.Print(summary)
+		return nil
+	})
+}
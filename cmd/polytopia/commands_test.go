@@ -0,0 +1,206 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	polytopiamapmodel "github.com/samuelyuan/polytopiamapmodelgo"
+)
+
+// testSaveFilename is an 8x8 version 105 save, see testdata/README.md
+const testSaveFilename = "../../testdata/map8x8_v105.state"
+
+// writeTestSave copies the test save to a temporary directory with the game version changed to gameVersion.
+// Player 2 is made the only Imperius player so it can be looked up by tribe, the other players are Ai-Mo.
+// The save is written decompressed if filename ends with .decomp and compressed otherwise.
+func writeTestSave(t *testing.T, filename string, gameVersion int) string {
+	saveOutput, err := polytopiamapmodel.ReadPolytopiaCompressedFile(testSaveFilename)
+	if err != nil {
+		t.Fatalf(`Failed to read test save: %v`, err)
+	}
+	saveOutput.GameVersion = gameVersion
+	saveOutput.InitialMapHeaderOutput.MapHeaderInput.Version1 = uint32(gameVersion)
+	saveOutput.MapHeaderOutput.MapHeaderInput.Version1 = uint32(gameVersion)
+	saveOutput.InitialPlayerData[1].Tribe = int(polytopiamapmodel.TribeImperius)
+	saveOutput.PlayerData[1].Tribe = int(polytopiamapmodel.TribeImperius)
+	fileData, err := polytopiamapmodel.SerializePolytopiaSave(saveOutput)
+	if err != nil {
+		t.Fatalf(`Failed to serialize test save: %v`, err)
+	}
+	if !strings.HasSuffix(filename, ".decomp") {
+		var compressed bytes.Buffer
+		if err := polytopiamapmodel.Compress(&compressed, fileData); err != nil {
+			t.Fatalf(`Failed to compress test save: %v`, err)
+		}
+		fileData = compressed.Bytes()
+	}
+
+	outputFilename := filepath.Join(t.TempDir(), filename)
+	if err := os.WriteFile(outputFilename, fileData, 0666); err != nil {
+		t.Fatal(err)
+	}
+	return outputFilename
+}
+
+// openTestSave reads a save written by a command and returns the detected format
+func openTestSave(t *testing.T, filename string) (*polytopiamapmodel.PolytopiaSaveOutput, polytopiamapmodel.SaveFormat) {
+	saveOutput, saveFormat, err := polytopiamapmodel.Open(filename)
+	if err != nil {
+		t.Fatalf(`Failed to open %v: %v`, filename, err)
+	}
+	return saveOutput, saveFormat
+}
+
+func TestParseFileArgs(t *testing.T) {
+	testCases := []struct {
+		args    []string
+		message string
+	}{
+		{[]string{}, "set-terrain expects one file, got 0 arguments"},
+		{[]string{"a.state", "b.state"}, "set-terrain expects one file, got 2 arguments"},
+		{[]string{"-unknown", "a.state"}, "flag provided but not defined: -unknown"},
+	}
+	for _, testCase := range testCases {
+		flagSet := newEditFlagSet("set-terrain")
+		flagSet.SetOutput(&bytes.Buffer{})
+		if _, err := parseFileArgs(flagSet.FlagSet, testCase.args); err == nil || !strings.Contains(err.Error(), testCase.message) {
+			t.Fatalf(`Error for %v = %v, expected %q`, testCase.args, err, testCase.message)
+		}
+	}
+
+	flagSet := newEditFlagSet("set-terrain")
+	x, y := flagSet.tilePosition()
+	filename, err := parseFileArgs(flagSet.FlagSet, []string{"-x", "3", "-y", "5", "a.state"})
+	if err != nil || filename != "a.state" || *x != 3 || *y != 5 {
+		t.Fatalf(`Parsed file = %v, x = %v, y = %v, error: %v, expected a.state, 3, 5`, filename, *x, *y, err)
+	}
+}
+
+func TestPlayerFlags(t *testing.T) {
+	filename := writeTestSave(t, "test.state", 105)
+	for _, args := range [][]string{
+		{filename},
+		{"-owner", "300", filename},
+		{"-owner", "not-a-tribe", filename},
+		{"-owner", "7", filename},
+		{"-owner", "vengir", filename},
+		{"-owner", "ai-mo", filename},
+	} {
+		if err := runSetUnitOwner(append([]string{"-x", "6", "-y", "5"}, args...)); err == nil {
+			t.Fatalf(`Expected set-unit-owner %v to fail`, args)
+		}
+	}
+
+	if err := runSetUnitOwner([]string{"-x", "6", "-y", "5", "-owner", "imperius", filename}); err != nil {
+		t.Fatalf(`Failed to set the owner by tribe name: %v`, err)
+	}
+	saveOutput, _ := openTestSave(t, filename)
+	if unit := saveOutput.TileData[5][6].Unit; unit == nil || unit.Owner != 2 {
+		t.Fatalf(`Unit on (6, 5) = %+v, expected owner 2`, unit)
+	}
+}
+
+func TestEnumNames(t *testing.T) {
+	filename := writeTestSave(t, "test.state", 105)
+	if err := runSetTerrain([]string{"-x", "1", "-y", "2", "-terrain", "forest", filename}); err != nil {
+		t.Fatalf(`Failed to set terrain by name: %v`, err)
+	}
+	if err := runSetUnitType([]string{"-x", "6", "-y", "5", "-unit", "rider", filename}); err != nil {
+		t.Fatalf(`Failed to set unit type by name: %v`, err)
+	}
+	if err := runSetUnitType([]string{"-x", "6", "-y", "5", "-unit", "dragon-rider", filename}); err == nil {
+		t.Fatalf(`Expected an unknown unit name to fail`)
+	}
+
+	saveOutput, _ := openTestSave(t, filename)
+	if terrain := polytopiamapmodel.TerrainType(saveOutput.TileData[2][1].Terrain); terrain != polytopiamapmodel.TerrainForest {
+		t.Fatalf(`Terrain on (1, 2) = %v, expected forest`, terrain)
+	}
+	if unit := saveOutput.TileData[5][6].Unit; unit == nil || polytopiamapmodel.UnitType(unit.UnitType) != polytopiamapmodel.UnitRider {
+		t.Fatalf(`Unit on (6, 5) = %+v, expected a rider`, unit)
+	}
+}
+
+func TestEditWritesBackFormat(t *testing.T) {
+	// a compressed input is overwritten compressed
+	filename := writeTestSave(t, "test.state", 105)
+	if err := runSetTerrain([]string{"-x", "1", "-y", "2", "-terrain", "forest", filename}); err != nil {
+		t.Fatalf(`Failed to edit compressed save: %v`, err)
+	}
+	if _, saveFormat := openTestSave(t, filename); saveFormat != polytopiamapmodel.SaveFormatCompressed {
+		t.Fatalf(`Format of %v = %v, expected compressed`, filename, saveFormat)
+	}
+
+	// a decompressed input is updated and compressed to the .state next to it
+	filename = writeTestSave(t, "test.state.decomp", 105)
+	if err := runSetTerrain([]string{"-x", "1", "-y", "2", "-terrain", "forest", filename}); err != nil {
+		t.Fatalf(`Failed to edit decompressed save: %v`, err)
+	}
+	for outputFilename, expectedFormat := range map[string]polytopiamapmodel.SaveFormat{
+		filename:                                polytopiamapmodel.SaveFormatDecompressed,
+		strings.TrimSuffix(filename, ".decomp"): polytopiamapmodel.SaveFormatCompressed,
+	} {
+		saveOutput, saveFormat := openTestSave(t, outputFilename)
+		if saveFormat != expectedFormat || saveOutput.TileData[2][1].Terrain != int(polytopiamapmodel.TerrainForest) {
+			t.Fatalf(`Format of %v = %v, expected %v with the edited terrain`, outputFilename, saveFormat, expectedFormat)
+		}
+	}
+
+	// -no-state only updates the decompressed input
+	filename = writeTestSave(t, "other.decomp", 105)
+	directory := filepath.Dir(filename)
+	if err := runSetTerrain([]string{"-no-state", "-x", "1", "-y", "2", "-terrain", "forest", filename}); err != nil {
+		t.Fatalf(`Failed to edit decompressed save: %v`, err)
+	}
+	if entries, err := os.ReadDir(directory); err != nil || len(entries) != 1 {
+		t.Fatalf(`Expected only %v in the directory, found %v`, filename, entries)
+	}
+
+	// -o names ending with .state are compressed
+	outputFilename := filepath.Join(directory, "edited.state")
+	if err := runSetTerrain([]string{"-o", outputFilename, "-x", "1", "-y", "2", "-terrain", "mountain", filename}); err != nil {
+		t.Fatalf(`Failed to edit decompressed save: %v`, err)
+	}
+	if _, saveFormat := openTestSave(t, outputFilename); saveFormat != polytopiamapmodel.SaveFormatCompressed {
+		t.Fatalf(`Format of %v = %v, expected compressed`, outputFilename, saveFormat)
+	}
+}
+
+func TestAddPlayer(t *testing.T) {
+	for _, gameVersion := range []int{105, 114} {
+		filename := writeTestSave(t, "test.state", gameVersion)
+		if err := runAddPlayer([]string{"-target", "both", filename}); err != nil {
+			t.Fatalf(`Failed to add a player to version %v: %v`, gameVersion, err)
+		}
+		saveOutput, _ := openTestSave(t, filename)
+		if len(saveOutput.InitialPlayerData) != 4 || len(saveOutput.PlayerData) != 4 || saveOutput.PlayerData[2].PlayerId != 3 {
+			t.Fatalf(`Version %v players = %+v, expected player 3 before player 255`, gameVersion, saveOutput.PlayerData)
+		}
+	}
+}
+
+func TestExpand(t *testing.T) {
+	filename := writeTestSave(t, "test.state", 105)
+	for _, args := range [][]string{
+		{"-size", "10", "-width", "12", filename},
+		{"-size", "10", "-height", "12", filename},
+		{filename},
+	} {
+		if err := runExpand(args); err == nil {
+			t.Fatalf(`Expected expand %v to fail`, args)
+		}
+	}
+	if saveOutput, _ := openTestSave(t, filename); saveOutput.MapWidth != 8 || saveOutput.MapHeight != 8 {
+		t.Fatalf(`Map size = %vx%v after a rejected expand, expected 8x8`, saveOutput.MapWidth, saveOutput.MapHeight)
+	}
+
+	if err := runExpand([]string{"-size", "10", filename}); err != nil {
+		t.Fatalf(`Failed to expand: %v`, err)
+	}
+	if saveOutput, _ := openTestSave(t, filename); saveOutput.MapWidth != 10 || saveOutput.MapHeight != 10 {
+		t.Fatalf(`Map size = %vx%v, expected 10x10`, saveOutput.MapWidth, saveOutput.MapHeight)
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+)
+
+type command struct {
+	usage       string
+	description string
+	run         func(args []string) error
+}
+
+var commands = map[string]command{
+	"info":           {"info <file>", "print the map size, version, turn and players", runInfo},
+	"decompress":     {"decompress [-o output] <file.state>", "write the decompressed save, defaults to <file>.decomp", runDecompress},
+	"compress":       {"compress [-o output] [-level n] <file>", "write the compressed save, defaults to <file> without .decomp", runCompress},
+	"export-json":    {"export-json [-o output] [-enum-names] <file>", "write the map and players as json, defaults to <file>.json", runExportJson},
+	"export-tables":  {"export-tables [-o prefix] [-tsv] [-enum-names] [-initial | -per-turn] <file>", "write csv tables of the tiles, players and actions, defaults to <file>_tiles.csv and so on", runExportTables},
+	"import-json":    {"import-json [-o output] <file.json>", "write a compressed save from an exported json file, defaults to <file> without .json", runImportJson},
+	"set-terrain":    {"set-terrain -x n -y n -terrain name <file>", "change the terrain of a tile", runSetTerrain},
+	"set-unit-type":  {"set-unit-type -x n -y n -unit name <file>", "change the type of the unit on a tile", runSetUnitType},
+	"set-unit-owner": {"set-unit-owner -x n -y n -owner player <file>", "change the owner of the unit on a tile", runSetUnitOwner},
+	"convert-tribe":  {"convert-tribe -from player -to player <file>", "give all units of one player to another player", runConvertTribe},
+	"swap-players":   {"swap-players -player1 player -player2 player <file>", "swap the tiles, units and data of two players", runSwapPlayers},
+	"add-city":       {"add-city -x n -y n -name name -owner player <file>", "add a city to a tile", runAddCity},
+	"set-capital":    {"set-capital -x n -y n -name name -owner player <file>", "make a tile the capital of a player", runSetCapital},
+	"reset-tile":     {"reset-tile -x n -y n <file>", "replace a tile with an empty field", runResetTile},
+	"reveal":         {"reveal -player player [-x n -y n] <file>", "reveal one tile, or every tile if no position is given, to a player", runReveal},
+	"expand":         {"expand [-size n | [-width n] [-height n]] <file>", "add empty rows and columns to the map", runExpand},
+	"add-player":     {"add-player <file>", "add a new player", runAddPlayer},
+	"apply-plan":     {"apply-plan -plan edits.json [-dry-run] <file>", "check every edit in a json edit plan, then apply them all", runApplyPlan},
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		printUsage()
+		os.Exit(1)
+	}
+	cmd, ok := commands[os.Args[1]]
+	if !ok {
+		fmt.Printf("Unknown command: %v\n", os.Args[1])
+		printUsage()
+		os.Exit(1)
+	}
+	err := cmd.run(os.Args[2:])
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "FAILED: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func printUsage() {
+	fmt.Println("Usage: polytopia <command> [flags] <file>")
+	fmt.Println("Files can be compressed .state files or decompressed saves. Edits are written back in the same format,")
+	fmt.Println("and an edited decompressed save is also compressed to the .state file next to it, unless -no-state is given.")
+	fmt.Println("Terrain and unit types can be names or ids, and players can be ids or tribe names. Run a command with -h to list its flags.")
+	fmt.Println()
+	fmt.Println("Commands:")
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Printf("  %-52s %s\n", commands[name].usage, commands[name].description)
+	}
+}
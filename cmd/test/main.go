@@ -19,14 +19,6 @@ func main() {
 	// Enable debug mode for troubleshooting
 	polytopiamapmodel.DebugMode = true
 
-	// Try to read the file (catch any panics from log.Fatal)
-	defer func() {
-		if r := recover(); r != nil {
-			fmt.Printf("FAILED: %v\n", r)
-			os.Exit(1)
-		}
-	}()
-
 	saveOutput, err := polytopiamapmodel.ReadPolytopiaCompressedFile(filename)
 	if err != nil {
 		fmt.Printf("FAILED: %v\n", err)
@@ -3,102 +3,248 @@ package polytopiamapmodel
 import (
 	"bytes"
 	"encoding/binary"
-	"log"
+	"errors"
+	"fmt"
+	"io"
 	"os"
 
 	lz4 "github.com/pierrec/lz4/v4"
 )
 
-func DecompressFile(inputFilename string) {
-	decompressedContents := GetDecompressedContents(inputFilename)
-	decompressedLength := len(decompressedContents)
+// DecompressFile writes the decompressed contents of a .state file to <inputFilename>.decomp
+func DecompressFile(inputFilename string) error {
+	decompressedContents, err := GetDecompressedContents(inputFilename)
+	if err != nil {
+		return err
+	}
 
 	decompressedFilename := inputFilename + ".decomp"
-	if err := os.WriteFile(decompressedFilename, decompressedContents[:decompressedLength], 0666); err != nil {
-		log.Fatal("Error writing decompressed contents", err)
+	if err := WriteFileAtomic(decompressedFilename, decompressedContents); err != nil {
+		return fmt.Errorf("error writing decompressed contents: %w", err)
+	}
+	return nil
+}
+
+func BuildReaderForDecompressedFile(inputFilename string) (*bytes.Reader, int, error) {
+	decompressedContents, err := GetDecompressedContents(inputFilename)
+	if err != nil {
+		return nil, 0, err
 	}
+	return bytes.NewReader(decompressedContents), len(decompressedContents), nil
 }
 
-func BuildReaderForDecompressedFile(inputFilename string) (*bytes.Reader, int) {
-	decompressedContents := GetDecompressedContents(inputFilename)
-	decompressedLength := len(decompressedContents)
-	return bytes.NewReader(decompressedContents), decompressedLength
+func GetDecompressedContents(inputFilename string) ([]byte, error) {
+	decompressedContents, err := readDecompressedContents(inputFilename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load state file: %w", err)
+	}
+	return decompressedContents, nil
 }
 
-func GetDecompressedContents(inputFilename string) []byte {
+func readDecompressedContents(inputFilename string) ([]byte, error) {
 	inputFile, err := os.Open(inputFilename)
+	if err != nil {
+		return nil, err
+	}
 	defer inputFile.Close()
+
+	return Decompress(inputFile)
+}
+
+const (
+	// DefaultMaxDecompressedSize is the largest decompressed size accepted by Decompress
+	DefaultMaxDecompressedSize = 64 * 1024 * 1024
+
+	compressionVersionMask = 0x07
+)
+
+var ErrDecompressedSizeTooLarge = errors.New("decompressed size is over the limit")
+
+type DecompressOptions struct {
+	// MaxDecompressedSize limits the memory allocated for untrusted files. Zero uses DefaultMaxDecompressedSize.
+	MaxDecompressedSize int
+}
+
+// Decompress reads a compressed .state file and returns the decompressed data
+func Decompress(reader io.Reader) ([]byte, error) {
+	return DecompressWithOptions(reader, DecompressOptions{})
+}
+
+func DecompressWithOptions(reader io.Reader, options DecompressOptions) ([]byte, error) {
+	maxDecompressedSize := options.MaxDecompressedSize
+	if maxDecompressedSize <= 0 {
+		maxDecompressedSize = DefaultMaxDecompressedSize
+	}
+
+	// The size difference in the header is never negative, so valid data is never longer than the header and the decompressed size
+	maxInputSize := int64(maxDecompressedSize) + 5
+	inputBytes, err := io.ReadAll(io.LimitReader(reader, maxInputSize+1))
 	if err != nil {
-		log.Fatal("Failed to load state file: ", err)
-		return nil
+		return nil, err
+	}
+	if int64(len(inputBytes)) > maxInputSize {
+		return nil, fmt.Errorf("%w, compressed size is over %v bytes", ErrDecompressedSizeTooLarge, maxInputSize)
 	}
+	return decompressBytes(inputBytes, maxDecompressedSize)
+}
+
+// compressionHeader is the start of a .state file, before the LZ4 block
+type compressionHeader struct {
+	sizeOfDiff int // bytes used to store resultDiff: 0, 1, 2 or 4
+	resultDiff int // decompressed size minus compressed size, 0 if the data is stored without compression
+}
+
+func (header compressionHeader) dataOffset() int {
+	return 1 + header.sizeOfDiff
+}
 
-	inputBuffer := new(bytes.Buffer)
-	inputBuffer.ReadFrom(inputFile)
+func readCompressionHeader(inputBytes []byte) (compressionHeader, error) {
+	if len(inputBytes) == 0 {
+		return compressionHeader{}, fmt.Errorf("compressed data is empty")
+	}
 
-	inputBytes := inputBuffer.Bytes()
 	firstByte := inputBytes[0]
-	sizeOfDiff := ((firstByte >> 6) & 3)
-	if sizeOfDiff == 3 {
-		sizeOfDiff = 4
-	}
-	dataOffset := 1 + int(sizeOfDiff)
-	var resultDiff int
-	if sizeOfDiff == 4 {
-		resultDiff = int(binary.LittleEndian.Uint32(inputBytes[1 : 1+sizeOfDiff]))
-	} else if sizeOfDiff == 2 {
-		resultDiff = int(binary.LittleEndian.Uint16(inputBytes[1 : 1+sizeOfDiff]))
-	} else {
-		log.Fatal("Header sizeOfDiff is unrecognized value: ", sizeOfDiff)
+	if version := firstByte & compressionVersionMask; version != 0 {
+		return compressionHeader{}, fmt.Errorf("header version is unrecognized value: %v", version)
+	}
+	header := compressionHeader{sizeOfDiff: int((firstByte >> 6) & 3)}
+	if header.sizeOfDiff == 3 {
+		header.sizeOfDiff = 4
 	}
+	dataOffset := header.dataOffset()
+	if len(inputBytes) < dataOffset {
+		return compressionHeader{}, fmt.Errorf("compressed data is truncated, header needs %v bytes and size is %v", dataOffset, len(inputBytes))
+	}
+	switch header.sizeOfDiff {
+	case 1:
+		header.resultDiff = int(inputBytes[1])
+	case 2:
+		header.resultDiff = int(binary.LittleEndian.Uint16(inputBytes[1:dataOffset]))
+	case 4:
+		header.resultDiff = int(binary.LittleEndian.Uint32(inputBytes[1:dataOffset]))
+	}
+	return header, nil
+}
+
+func decompressBytes(inputBytes []byte, maxDecompressedSize int) ([]byte, error) {
+	header, err := readCompressionHeader(inputBytes)
+	if err != nil {
+		return nil, err
+	}
+	dataOffset := header.dataOffset()
+	resultDiff := header.resultDiff
 	dataLength := len(inputBytes) - dataOffset
 	resultLength := dataLength + resultDiff
+	if resultLength > maxDecompressedSize {
+		return nil, fmt.Errorf("%w, declared size is %v and limit is %v", ErrDecompressedSizeTooLarge, resultLength, maxDecompressedSize)
+	}
+
+	// data is stored without compression when the size difference is 0
+	if resultDiff == 0 {
+		decompressedContents := make([]byte, dataLength)
+		copy(decompressedContents, inputBytes[dataOffset:])
+		return decompressedContents, nil
+	}
 
 	// decompress
 	decompressedContents := make([]byte, resultLength)
 	decompressedLength, err := lz4.UncompressBlock(inputBytes[dataOffset:], decompressedContents)
 	if err != nil {
-		panic(err)
+		return nil, fmt.Errorf("failed to decompress: %w", err)
+	}
+	if decompressedLength != resultLength {
+		return nil, fmt.Errorf("decompressed size is %v, expected %v", decompressedLength, resultLength)
 	}
 
-	return decompressedContents[:decompressedLength]
+	return decompressedContents, nil
 }
 
-func CompressFile(inputFilename string, outputFilename string) {
-	inputFile, err := os.Open(inputFilename)
-	defer inputFile.Close()
+// CompressFile writes the compressed contents of a decompressed save to outputFilename
+func CompressFile(inputFilename string, outputFilename string) error {
+	inputBytes, err := os.ReadFile(inputFilename)
 	if err != nil {
-		log.Fatal("Failed to load state file: ", err)
-		return
+		return fmt.Errorf("failed to load state file: %w", err)
 	}
 
-	inputBuffer := new(bytes.Buffer)
-	inputBuffer.ReadFrom(inputFile)
-	inputBytes := inputBuffer.Bytes()
+	var compressedContents bytes.Buffer
+	if err := Compress(&compressedContents, inputBytes); err != nil {
+		return fmt.Errorf("error writing compressed contents: %w", err)
+	}
+	if err := WriteFileAtomic(outputFilename, compressedContents.Bytes()); err != nil {
+		return fmt.Errorf("error writing compressed contents: %w", err)
+	}
+	return nil
+}
 
-	decompressedLength := len(inputBytes)
-	compressedContents := make([]byte, decompressedLength)
-	compressedLength, err := lz4.CompressBlock(inputBytes, compressedContents, []int{0})
+const (
+	// CompressionLevelFast uses the default LZ4 compressor
+	CompressionLevelFast = 0
+	// CompressionLevelMax is the highest LZ4 HC level. It is the slowest, but produces the smallest files.
+	CompressionLevelMax = 9
+)
+
+type CompressOptions struct {
+	// Level is CompressionLevelFast or an LZ4 HC level from 1 to CompressionLevelMax
+	Level int
+}
+
+// Compress writes decompressed save data to writer in the compressed .state format
+func Compress(writer io.Writer, decompressedData []byte) error {
+	return CompressWithOptions(writer, decompressedData, CompressOptions{})
+}
+
+func CompressWithOptions(writer io.Writer, decompressedData []byte, options CompressOptions) error {
+	compressedContents, err := compressBytesWithLevel(decompressedData, options.Level)
 	if err != nil {
-		panic(err)
+		return err
 	}
+	_, err = writer.Write(compressedContents)
+	return err
+}
 
-	newCompressedContents := []byte{}
-	if decompressedLength >= 65536 {
-		byteArrDecompressedSize := make([]byte, 4)
-		binary.LittleEndian.PutUint32(byteArrDecompressedSize, uint32(decompressedLength-compressedLength))
+// compressBytes compresses decompressed save data and adds the header used by .state files
+func compressBytes(inputBytes []byte) ([]byte, error) {
+	return compressBytesWithLevel(inputBytes, CompressionLevelFast)
+}
 
-		newCompressedContents = append([]byte{0xC0}, byteArrDecompressedSize...)
-		newCompressedContents = append(newCompressedContents, compressedContents[:compressedLength]...)
+func compressBytesWithLevel(inputBytes []byte, level int) ([]byte, error) {
+	if level < CompressionLevelFast || level > CompressionLevelMax {
+		return nil, fmt.Errorf("compression level must be between %v and %v, value is %v",
+			CompressionLevelFast, CompressionLevelMax, level)
+	}
+
+	decompressedLength := len(inputBytes)
+	compressedContents := make([]byte, lz4.CompressBlockBound(decompressedLength))
+	var compressedLength int
+	var err error
+	if level == CompressionLevelFast {
+		var compressor lz4.Compressor
+		compressedLength, err = compressor.CompressBlock(inputBytes, compressedContents)
 	} else {
-		byteArrDecompressedSize := make([]byte, 2)
-		binary.LittleEndian.PutUint16(byteArrDecompressedSize, uint16(decompressedLength-compressedLength))
+		compressor := lz4.CompressorHC{Level: lz4.Level1 << (level - 1)}
+		compressedLength, err = compressor.CompressBlock(inputBytes, compressedContents)
+	}
+	if err != nil {
+		return nil, err
+	}
 
-		newCompressedContents = append([]byte{0x80}, byteArrDecompressedSize...)
-		newCompressedContents = append(newCompressedContents, compressedContents[:compressedLength]...)
+	// store the data without compression if compressing does not make it smaller
+	if compressedLength == 0 || compressedLength >= decompressedLength {
+		return append([]byte{0x00}, inputBytes...), nil
 	}
+	return append(buildCompressionHeader(decompressedLength-compressedLength), compressedContents[:compressedLength]...), nil
+}
 
-	if err := os.WriteFile(outputFilename, newCompressedContents, 0666); err != nil {
-		log.Fatal("Error writing compressed contents", err)
+// buildCompressionHeader uses the smallest field that can hold the difference between the decompressed and compressed sizes
+func buildCompressionHeader(resultDiff int) []byte {
+	if resultDiff <= 0xFF {
+		return []byte{0x40, byte(resultDiff)}
+	} else if resultDiff <= 0xFFFF {
+		header := []byte{0x80, 0, 0}
+		binary.LittleEndian.PutUint16(header[1:], uint16(resultDiff))
+		return header
 	}
+	header := []byte{0xC0, 0, 0, 0, 0}
+	binary.LittleEndian.PutUint32(header[1:], uint32(resultDiff))
+	return header
 }
@@ -0,0 +1,230 @@
+package polytopiamapmodel
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestCompressAndDecompress(t *testing.T) {
+	inputByteData := buildDetailedTestSaveBytes(105)
+
+	var compressed bytes.Buffer
+	if err := Compress(&compressed, inputByteData); err != nil {
+		t.Fatalf(`Failed to compress: %v`, err)
+	}
+	result, err := Decompress(bytes.NewReader(compressed.Bytes()))
+	if err != nil {
+		t.Fatalf(`Failed to decompress: %v`, err)
+	}
+	compareArrays(t, result, inputByteData)
+}
+
+func TestReadPolytopiaSave(t *testing.T) {
+	inputByteData := buildTestSaveBytes()
+
+	var compressed bytes.Buffer
+	if err := Compress(&compressed, inputByteData); err != nil {
+		t.Fatalf(`Failed to compress: %v`, err)
+	}
+	saveOutput, err := ReadPolytopiaSave(&compressed)
+	if err != nil {
+		t.Fatalf(`Failed to read save: %v`, err)
+	}
+	if saveOutput.MapWidth != 2 || saveOutput.MapHeight != 2 {
+		t.Fatalf(`Map size not equal. Result = %vx%v, expected = 2x2`, saveOutput.MapWidth, saveOutput.MapHeight)
+	}
+	if !reflect.DeepEqual(saveOutput.Actions, actionList) {
+		t.Fatalf(`Contents not equal. Result = %+v, expected = %+v`, saveOutput.Actions, actionList)
+	}
+}
+
+func TestDecompressHeaderSizes(t *testing.T) {
+	expected := bytes.Repeat([]byte("polytopia"), 10)
+	compressed, err := compressBytes(expected)
+	if err != nil {
+		t.Fatalf(`Failed to compress: %v`, err)
+	}
+	resultDiff := compressed[1]
+	compressedBlock := compressed[2:]
+	testCases := [][]byte{
+		append([]byte{0x00}, expected...),
+		append([]byte{0x40, 0}, expected...),
+		append([]byte{0x40, resultDiff}, compressedBlock...),
+		append([]byte{0x80, resultDiff, 0}, compressedBlock...),
+		append([]byte{0xC0, resultDiff, 0, 0, 0}, compressedBlock...),
+	}
+	for _, inputByteData := range testCases {
+		result, err := Decompress(bytes.NewReader(inputByteData))
+		if err != nil {
+			t.Fatalf(`Failed to decompress %v: %v`, inputByteData, err)
+		}
+		compareArrays(t, result, expected)
+	}
+}
+
+func TestDecompressInvalidData(t *testing.T) {
+	testCases := [][]byte{
+		{},
+		{0x80, 1},
+		{0xC0, 1, 0, 0},
+		{0x80, 5, 0, 0xFF, 0xFF},
+		// version bits are not 0
+		{0x01, 1, 2, 3},
+		// declared size is larger than the decompressed data
+		{0x40, 1, 0x90, 'p', 'o', 'l', 'y', 't', 'o', 'p', 'i', 'a'},
+	}
+	for _, inputByteData := range testCases {
+		if _, err := Decompress(bytes.NewReader(inputByteData)); err == nil {
+			t.Fatalf(`Expected error for compressed data %v`, inputByteData)
+		}
+	}
+}
+
+func TestDecompressMaxSize(t *testing.T) {
+	inputByteData := []byte{0xC0, 0xFF, 0xFF, 0xFF, 0x7F, 0x00}
+	_, err := DecompressWithOptions(bytes.NewReader(inputByteData), DecompressOptions{MaxDecompressedSize: 1024})
+	if !errors.Is(err, ErrDecompressedSizeTooLarge) {
+		t.Fatalf(`Expected ErrDecompressedSizeTooLarge, result = %v`, err)
+	}
+
+	_, err = DecompressWithOptions(bytes.NewReader(make([]byte, 2048)), DecompressOptions{MaxDecompressedSize: 1024})
+	if !errors.Is(err, ErrDecompressedSizeTooLarge) {
+		t.Fatalf(`Expected ErrDecompressedSizeTooLarge, result = %v`, err)
+	}
+}
+
+func TestCompressLevels(t *testing.T) {
+	inputByteData := buildDetailedTestSaveBytes(105)
+	for level := CompressionLevelFast; level <= CompressionLevelMax; level++ {
+		var compressed bytes.Buffer
+		if err := CompressWithOptions(&compressed, inputByteData, CompressOptions{Level: level}); err != nil {
+			t.Fatalf(`Failed to compress at level %v: %v`, level, err)
+		}
+		result, err := Decompress(&compressed)
+		if err != nil {
+			t.Fatalf(`Failed to decompress level %v: %v`, level, err)
+		}
+		compareArrays(t, result, inputByteData)
+	}
+
+	if err := CompressWithOptions(&bytes.Buffer{}, inputByteData, CompressOptions{Level: 10}); err == nil {
+		t.Fatalf(`Expected error for compression level 10`)
+	}
+}
+
+func TestCompressIncompressibleData(t *testing.T) {
+	testCases := [][]byte{
+		{},
+		{1},
+		[]byte("polytopia"),
+	}
+	for _, inputByteData := range testCases {
+		result, err := compressBytes(inputByteData)
+		if err != nil {
+			t.Fatalf(`Failed to compress: %v`, err)
+		}
+		compareArrays(t, result, append([]byte{0x00}, inputByteData...))
+	}
+}
+
+func TestBuildCompressionHeader(t *testing.T) {
+	testCases := []struct {
+		resultDiff int
+		expected   []byte
+	}{
+		{1, []byte{0x40, 1}},
+		{255, []byte{0x40, 255}},
+		{256, []byte{0x80, 0, 1}},
+		{65535, []byte{0x80, 255, 255}},
+		{65536, []byte{0xC0, 0, 0, 1, 0}},
+	}
+	for _, testCase := range testCases {
+		compareArrays(t, buildCompressionHeader(testCase.resultDiff), testCase.expected)
+	}
+}
+
+// testStateFilenames returns the compressed saves in testdata, see testdata/README.md
+func testStateFilenames(t *testing.T) []string {
+	filenames, err := filepath.Glob(filepath.Join("testdata", "*.state"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(filenames) == 0 {
+		t.Fatalf(`No .state files in testdata`)
+	}
+	return filenames
+}
+
+func TestRecompressSaves(t *testing.T) {
+	for _, filename := range testStateFilenames(t) {
+		original, err := os.ReadFile(filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		decompressed, err := Decompress(bytes.NewReader(original))
+		if err != nil {
+			t.Fatalf(`Failed to decompress %v: %v`, filename, err)
+		}
+		if expected, err := os.ReadFile(filename + ".decomp"); err == nil {
+			compareArrays(t, decompressed, expected)
+		}
+
+		for _, level := range []int{CompressionLevelFast, CompressionLevelMax} {
+			var compressed bytes.Buffer
+			if err := CompressWithOptions(&compressed, decompressed, CompressOptions{Level: level}); err != nil {
+				t.Fatalf(`Failed to compress %v: %v`, filename, err)
+			}
+			if compressed.Len() >= len(decompressed) {
+				t.Fatalf(`Recompressed %v at level %v is %v bytes, expected less than %v`, filename, level, compressed.Len(), len(decompressed))
+			}
+			result, err := Decompress(&compressed)
+			if err != nil {
+				t.Fatalf(`Failed to decompress recompressed %v: %v`, filename, err)
+			}
+			compareArrays(t, result, decompressed)
+		}
+	}
+}
+
+// TestRecompressGameSaves checks that compressing the decompressed data of a save gives back the bytes the game wrote
+func TestRecompressGameSaves(t *testing.T) {
+	headersFound := make(map[int]bool)
+	for filename, decompressed := range testGameSaves(t) {
+		original, err := os.ReadFile(filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		gameHeader, err := readCompressionHeader(original)
+		if err != nil {
+			t.Fatalf(`Failed to read header of %v: %v`, filename, err)
+		}
+		headersFound[gameHeader.sizeOfDiff] = true
+		if gameHeader.resultDiff != 0 {
+			compareArrays(t, buildCompressionHeader(gameHeader.resultDiff), original[:gameHeader.dataOffset()])
+		}
+
+		var recompressed bytes.Buffer
+		if err := Compress(&recompressed, decompressed); err != nil {
+			t.Fatalf(`Failed to compress %v: %v`, filename, err)
+		}
+		if recompressed.Bytes()[0] != original[0] {
+			t.Fatalf(`Recompressed %v has header byte %#x, the game wrote %#x`, filename, recompressed.Bytes()[0], original[0])
+		}
+		compareArrays(t, recompressed.Bytes(), original)
+	}
+	// the stored (0x00) and 1 byte (0x40) headers are required, the larger ones are only logged
+	for _, sizeOfDiff := range []int{0, 1, 2, 4} {
+		if headersFound[sizeOfDiff] {
+			continue
+		}
+		if sizeOfDiff <= 1 {
+			t.Errorf(`No save from the game in testdata/game has a %v byte size difference in its header`, sizeOfDiff)
+		} else {
+			t.Logf(`No save from the game in testdata/game has a %v byte size difference in its header`, sizeOfDiff)
+		}
+	}
+}
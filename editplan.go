@@ -0,0 +1,383 @@
+package polytopiamapmodel
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// EditPlan is a list of edits that can be applied to many saves. Plans are stored as json, for example
+//
+//	{"target": "both", "edits": [{"op": "set-terrain", "x": 1, "y": 2, "terrain": "forest"}, {"op": "swap-players", "player1": 1, "player2": "imperius"}]}
+//
+// Keys are matched without regard to case, so plans written with PascalCase keys still load.
+type EditPlan struct {
+	Target string     `json:"target,omitempty"` // current, initial or both, defaults to the Target of the document
+	Edits  []PlanEdit `json:"edits"`
+}
+
+// PlanEdit is one edit in a plan. Op selects the edit and the other fields are its arguments.
+type PlanEdit struct {
+	Op      string     `json:"op"`
+	X       *int       `json:"x,omitempty"`
+	Y       *int       `json:"y,omitempty"`
+	Terrain string     `json:"terrain,omitempty"` // name or id
+	Unit    string     `json:"unit,omitempty"`    // name or id
+	Owner   PlanPlayer `json:"owner,omitempty"`
+	Name    string     `json:"name,omitempty"`
+	Player  PlanPlayer `json:"player,omitempty"`
+	Player1 PlanPlayer `json:"player1,omitempty"`
+	Player2 PlanPlayer `json:"player2,omitempty"`
+	From    PlanPlayer `json:"from,omitempty"`
+	To      PlanPlayer `json:"to,omitempty"`
+	Width   int        `json:"width,omitempty"`
+	Height  int        `json:"height,omitempty"`
+	Size    int        `json:"size,omitempty"`
+}
+
+// PlanPlayer is a player id or a tribe name, like the player flags of the command-line tool.
+// It is read from a json number or string and resolved with SaveDocument.PlayerId when the edit is applied.
+type PlanPlayer string
+
+func (player *PlanPlayer) UnmarshalJSON(data []byte) error {
+	var playerId int
+	if err := json.Unmarshal(data, &playerId); err == nil {
+		*player = PlanPlayer(strconv.Itoa(playerId))
+		return nil
+	}
+	var name string
+	if err := json.Unmarshal(data, &name); err != nil {
+		return fmt.Errorf("player must be a player id or a tribe name, value is %s", data)
+	}
+	*player = PlanPlayer(name)
+	return nil
+}
+
+// check returns an error if the player is neither an id between 0 and 255 nor a tribe name
+func (player PlanPlayer) check() error {
+	if playerId, err := strconv.Atoi(strings.TrimSpace(string(player))); err == nil {
+		if playerId < 0 || playerId > 255 {
+			return fmt.Errorf("player id must be between 0 and 255, value is %v", playerId)
+		}
+		return nil
+	}
+	_, err := ParseTribeType(string(player))
+	return err
+}
+
+type planPlayerField struct {
+	name  string
+	value PlanPlayer
+}
+
+// playerFields returns the fields of the edit that hold a player
+func (edit PlanEdit) playerFields() []planPlayerField {
+	return []planPlayerField{
+		{"owner", edit.Owner}, {"player", edit.Player}, {"player1", edit.Player1},
+		{"player2", edit.Player2}, {"from", edit.From}, {"to", edit.To},
+	}
+}
+
+// resolvePlayers looks up the id of every player field that is set in the document
+func (edit PlanEdit) resolvePlayers(doc *SaveDocument) (map[string]int, error) {
+	playerIds := make(map[string]int)
+	for _, field := range edit.playerFields() {
+		if field.value == "" {
+			continue
+		}
+		playerId, err := doc.PlayerId(string(field.value))
+		if err != nil {
+			return nil, fmt.Errorf("%v: %w", field.name, err)
+		}
+		playerIds[field.name] = playerId
+	}
+	return playerIds, nil
+}
+
+func (edit PlanEdit) tilePosition() string {
+	if edit.X == nil || edit.Y == nil {
+		return ""
+	}
+	return fmt.Sprintf("(%v, %v)", *edit.X, *edit.Y)
+}
+
+type planOperation struct {
+	required []string
+	apply    func(doc *SaveDocument, edit PlanEdit, players map[string]int) (string, error)
+}
+
+var planOperations = map[string]planOperation{
+	"set-terrain": {[]string{"x", "y", "terrain"}, func(doc *SaveDocument, edit PlanEdit, players map[string]int) (string, error) {
+		terrain, err := ParseTerrainType(edit.Terrain)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("set terrain at %v to %v", edit.tilePosition(), terrain), doc.SetTerrain(*edit.X, *edit.Y, int(terrain))
+	}},
+	"place-unit": {[]string{"x", "y", "unit", "owner"}, func(doc *SaveDocument, edit PlanEdit, players map[string]int) (string, error) {
+		unitType, err := ParseUnitType(edit.Unit)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("placed %v for player %v at %v", unitType, players["owner"], edit.tilePosition()),
+			doc.PlaceUnit(*edit.X, *edit.Y, int(unitType), players["owner"])
+	}},
+	"set-unit-type": {[]string{"x", "y", "unit"}, func(doc *SaveDocument, edit PlanEdit, players map[string]int) (string, error) {
+		unitType, err := ParseUnitType(edit.Unit)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("changed unit at %v to %v", edit.tilePosition(), unitType), doc.SetUnitType(*edit.X, *edit.Y, int(unitType))
+	}},
+	"set-unit-owner": {[]string{"x", "y", "owner"}, func(doc *SaveDocument, edit PlanEdit, players map[string]int) (string, error) {
+		return fmt.Sprintf("gave unit at %v to player %v", edit.tilePosition(), players["owner"]), doc.SetUnitOwner(*edit.X, *edit.Y, players["owner"])
+	}},
+	"add-city": {[]string{"x", "y", "name", "owner"}, func(doc *SaveDocument, edit PlanEdit, players map[string]int) (string, error) {
+		return fmt.Sprintf("added city %q for player %v at %v", edit.Name, players["owner"], edit.tilePosition()),
+			doc.AddCity(*edit.X, *edit.Y, edit.Name, players["owner"])
+	}},
+	"set-capital": {[]string{"x", "y", "name", "owner"}, func(doc *SaveDocument, edit PlanEdit, players map[string]int) (string, error) {
+		return fmt.Sprintf("set capital %q of player %v at %v", edit.Name, players["owner"], edit.tilePosition()),
+			doc.SetCapital(*edit.X, *edit.Y, edit.Name, players["owner"])
+	}},
+	"reset-tile": {[]string{"x", "y"}, func(doc *SaveDocument, edit PlanEdit, players map[string]int) (string, error) {
+		return fmt.Sprintf("reset tile %v", edit.tilePosition()), doc.ResetTile(*edit.X, *edit.Y)
+	}},
+	"reveal": {[]string{"player"}, func(doc *SaveDocument, edit PlanEdit, players map[string]int) (string, error) {
+		if edit.X == nil && edit.Y == nil {
+			return fmt.Sprintf("revealed all tiles to player %v", players["player"]), doc.RevealAllTiles(players["player"])
+		}
+		if edit.X == nil || edit.Y == nil {
+			return "", fmt.Errorf("x and y must both be set to reveal one tile")
+		}
+		return fmt.Sprintf("revealed tile %v to player %v", edit.tilePosition(), players["player"]), doc.RevealTile(*edit.X, *edit.Y, players["player"])
+	}},
+	"swap-players": {[]string{"player1", "player2"}, func(doc *SaveDocument, edit PlanEdit, players map[string]int) (string, error) {
+		return fmt.Sprintf("swapped players %v and %v", players["player1"], players["player2"]), doc.SwapPlayers(players["player1"], players["player2"])
+	}},
+	"convert-tribe": {[]string{"from", "to"}, func(doc *SaveDocument, edit PlanEdit, players map[string]int) (string, error) {
+		numConverted, err := doc.ConvertTribe(players["from"], players["to"])
+		return fmt.Sprintf("gave %v units of player %v to player %v", numConverted, players["from"], players["to"]), err
+	}},
+	"add-player": {nil, func(doc *SaveDocument, edit PlanEdit, players map[string]int) (string, error) {
+		newPlayerId, err := doc.AddPlayer()
+		return fmt.Sprintf("added player %v", newPlayerId), err
+	}},
+	"expand": {nil, func(doc *SaveDocument, edit PlanEdit, players map[string]int) (string, error) {
+		if edit.Size > 0 {
+			if edit.Width > 0 || edit.Height > 0 {
+				return "", fmt.Errorf("size can't be combined with width or height")
+			}
+			return fmt.Sprintf("expanded map to %vx%v", edit.Size, edit.Size), doc.ExpandTiles(edit.Size)
+		}
+		if edit.Width == 0 && edit.Height == 0 {
+			return "", fmt.Errorf("size, width or height is required")
+		}
+		if edit.Width > 0 {
+			if err := doc.ExpandColumns(edit.Width); err != nil {
+				return "", err
+			}
+		}
+		if edit.Height > 0 {
+			if err := doc.ExpandRows(edit.Height); err != nil {
+				return "", err
+			}
+		}
+		return fmt.Sprintf("expanded map to %vx%v", doc.Output.MapWidth, doc.Output.MapHeight), nil
+	}},
+}
+
+// LoadEditPlan reads an edit plan from a json file
+func LoadEditPlan(inputFilename string) (*EditPlan, error) {
+	planContents, err := os.ReadFile(inputFilename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load edit plan: %w", err)
+	}
+	return ParseEditPlan(planContents)
+}
+
+func ParseEditPlan(planContents []byte) (*EditPlan, error) {
+	decoder := json.NewDecoder(bytes.NewReader(planContents))
+	decoder.DisallowUnknownFields()
+	plan := &EditPlan{}
+	if err := decoder.Decode(plan); err != nil {
+		return nil, fmt.Errorf("failed to parse edit plan: %w", err)
+	}
+	return plan, nil
+}
+
+// checkFields returns an error for each edit with an unknown operation, missing arguments
+// or a player that is neither an id that fits in a byte nor a tribe name
+func (plan *EditPlan) checkFields() error {
+	errs := make([]error, 0)
+	if plan.Target != "" {
+		if _, err := ParseEditTarget(plan.Target); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	for i, edit := range plan.Edits {
+		operation, ok := planOperations[edit.Op]
+		if !ok {
+			errs = append(errs, fmt.Errorf("edit %v: unknown operation %q", i, edit.Op))
+			continue
+		}
+		fieldValues := map[string]bool{
+			"x": edit.X != nil, "y": edit.Y != nil, "terrain": edit.Terrain != "", "unit": edit.Unit != "", "name": edit.Name != "",
+		}
+		for _, field := range edit.playerFields() {
+			fieldValues[field.name] = field.value != ""
+		}
+		for _, field := range operation.required {
+			if !fieldValues[field] {
+				errs = append(errs, fmt.Errorf("edit %v (%v): %v is required", i, edit.Op, field))
+			}
+		}
+		for _, field := range edit.playerFields() {
+			if field.value == "" {
+				continue
+			}
+			if err := field.value.check(); err != nil {
+				errs = append(errs, fmt.Errorf("edit %v (%v): %v: %w", i, edit.Op, field.name, err))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// EditPlanSummary describes the result of applying a plan
+type EditPlanSummary struct {
+	Edits               []string // one line for each edit
+	ChangedInitialTiles int
+	ChangedCurrentTiles int
+	ChangedPlayers      []int // ids of the players with different data in the current state
+	OldWidth            int
+	OldHeight           int
+	NewWidth            int
+	NewHeight           int
+}
+
+func (summary EditPlanSummary) String() string {
+	var builder strings.Builder
+	for i, edit := range summary.Edits {
+		fmt.Fprintf(&builder, "%3d. %v\n", i+1, edit)
+	}
+	fmt.Fprintf(&builder, "Changed tiles: %v in the initial state, %v in the current state\n",
+		summary.ChangedInitialTiles, summary.ChangedCurrentTiles)
+	fmt.Fprintf(&builder, "Changed players: %v\n", summary.ChangedPlayers)
+	if summary.OldWidth != summary.NewWidth || summary.OldHeight != summary.NewHeight {
+		fmt.Fprintf(&builder, "Map size: %vx%v -> %vx%v\n", summary.OldWidth, summary.OldHeight, summary.NewWidth, summary.NewHeight)
+	}
+	return builder.String()
+}
+
+// ValidateEditPlan checks that every edit in the plan can be applied to the save without modifying it
+// and returns the changes the plan would make. The target is used when the plan doesn't set one.
+func ValidateEditPlan(saveOutput *PolytopiaSaveOutput, plan *EditPlan, target EditTarget) (EditPlanSummary, error) {
+	editedOutput, editDescriptions, err := applyEditPlanToCopy(saveOutput, plan, target)
+	if err != nil {
+		return EditPlanSummary{}, err
+	}
+	summary := buildEditPlanSummary(saveOutput, editedOutput)
+	summary.Edits = editDescriptions
+	return summary, nil
+}
+
+// ApplyEditPlan validates the whole plan and then applies it to the document.
+// The document is not modified if any edit fails. Call Save to write the result.
+func ApplyEditPlan(doc *SaveDocument, plan *EditPlan) (EditPlanSummary, error) {
+	editedOutput, editDescriptions, err := applyEditPlanToCopy(doc.Output, plan, doc.Target)
+	if err != nil {
+		return EditPlanSummary{}, err
+	}
+	summary := buildEditPlanSummary(doc.Output, editedOutput)
+	summary.Edits = editDescriptions
+	doc.Output = editedOutput
+	return summary, nil
+}
+
+// applyEditPlanToCopy runs the plan on a copy of the save and returns the edited copy with a description of each edit
+func applyEditPlanToCopy(saveOutput *PolytopiaSaveOutput, plan *EditPlan, target EditTarget) (*PolytopiaSaveOutput, []string, error) {
+	if err := plan.checkFields(); err != nil {
+		return nil, nil, err
+	}
+	if plan.Target != "" {
+		target, _ = ParseEditTarget(plan.Target)
+	}
+
+	fileData, err := SerializePolytopiaSave(saveOutput)
+	if err != nil {
+		return nil, nil, err
+	}
+	editedOutput, err := ParsePolytopiaFile(io.NewSectionReader(bytes.NewReader(fileData), 0, int64(len(fileData))))
+	if err != nil {
+		return nil, nil, fmt.Errorf("save is invalid before applying the plan: %w", err)
+	}
+	doc := &SaveDocument{Output: editedOutput, Target: target}
+	editDescriptions := make([]string, len(plan.Edits))
+	for i, edit := range plan.Edits {
+		players, err := edit.resolvePlayers(doc)
+		if err != nil {
+			return nil, nil, fmt.Errorf("edit %v (%v): %w", i, edit.Op, err)
+		}
+		description, err := planOperations[edit.Op].apply(doc, edit, players)
+		if err != nil {
+			return nil, nil, fmt.Errorf("edit %v (%v): %w", i, edit.Op, err)
+		}
+		editDescriptions[i] = description
+	}
+
+	// parse the result so derived fields such as OwnerTribeMap match the edits and invalid results are caught
+	fileData, err = SerializePolytopiaSave(doc.Output)
+	if err != nil {
+		return nil, nil, err
+	}
+	editedOutput, err = ParsePolytopiaFile(io.NewSectionReader(bytes.NewReader(fileData), 0, int64(len(fileData))))
+	if err != nil {
+		return nil, nil, fmt.Errorf("edited save is invalid: %w", err)
+	}
+	return editedOutput, editDescriptions, nil
+}
+
+func buildEditPlanSummary(oldOutput *PolytopiaSaveOutput, newOutput *PolytopiaSaveOutput) EditPlanSummary {
+	summary := EditPlanSummary{
+		ChangedInitialTiles: countChangedTiles(oldOutput.InitialTileData, newOutput.InitialTileData),
+		ChangedCurrentTiles: countChangedTiles(oldOutput.TileData, newOutput.TileData),
+		ChangedPlayers:      make([]int, 0),
+		OldWidth:            oldOutput.MapWidth,
+		OldHeight:           oldOutput.MapHeight,
+		NewWidth:            newOutput.MapWidth,
+		NewHeight:           newOutput.MapHeight,
+	}
+
+	oldPlayers := make(map[int]PlayerData)
+	for _, player := range oldOutput.PlayerData {
+		oldPlayers[player.PlayerId] = player
+	}
+	for _, player := range newOutput.PlayerData {
+		if oldPlayer, ok := oldPlayers[player.PlayerId]; !ok || !reflect.DeepEqual(oldPlayer, player) {
+			summary.ChangedPlayers = append(summary.ChangedPlayers, player.PlayerId)
+		}
+	}
+	sort.Ints(summary.ChangedPlayers)
+	return summary
+}
+
+// countChangedTiles counts the tiles that are different or were added
+func countChangedTiles(oldTileData [][]TileData, newTileData [][]TileData) int {
+	numChanged := 0
+	for i := 0; i < len(newTileData); i++ {
+		for j := 0; j < len(newTileData[i]); j++ {
+			if i >= len(oldTileData) || j >= len(oldTileData[i]) || !reflect.DeepEqual(oldTileData[i][j], newTileData[i][j]) {
+				numChanged++
+			}
+		}
+	}
+	return numChanged
+}
@@ -0,0 +1,265 @@
+package polytopiamapmodel
+
+import (
+	"bytes"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestApplyEditPlan(t *testing.T) {
+	plan, err := ParseEditPlan([]byte(`{
+		"target": "both",
+		"edits": [
+			{"op": "set-terrain", "x": 1, "y": 0, "terrain": "forest"},
+			{"op": "place-unit", "x": 0, "y": 0, "unit": "warrior", "owner": 1},
+			{"op": "add-city", "x": 1, "y": 1, "name": "Test City", "owner": 1},
+			{"op": "reveal", "player": 1},
+			{"op": "add-player"},
+			{"op": "swap-players", "player1": 1, "player2": 2}
+		]
+	}`))
+	if err != nil {
+		t.Fatalf(`Failed to parse plan: %v`, err)
+	}
+	doc, err := OpenSaveDocument(writeTestSaveFile(t))
+	if err != nil {
+		t.Fatalf(`Failed to open save: %v`, err)
+	}
+
+	summary, err := ApplyEditPlan(doc, plan)
+	if err != nil {
+		t.Fatalf(`Failed to apply plan: %v`, err)
+	}
+	if len(summary.Edits) != 6 || summary.Edits[1] != "placed Warrior for player 1 at (0, 0)" {
+		t.Fatalf(`Summary edits = %v`, summary.Edits)
+	}
+	if summary.ChangedInitialTiles != 4 || summary.ChangedCurrentTiles != 4 {
+		t.Fatalf(`Changed tiles = %v, %v, expected 4 in both states`, summary.ChangedInitialTiles, summary.ChangedCurrentTiles)
+	}
+	if !reflect.DeepEqual(summary.ChangedPlayers, []int{1, 2, 255}) {
+		t.Fatalf(`Changed players = %v, expected [1 2 255]`, summary.ChangedPlayers)
+	}
+
+	for _, tileData := range [][][]TileData{doc.Output.InitialTileData, doc.Output.TileData} {
+		if tileData[0][1].Terrain != int(TerrainForest) {
+			t.Fatalf(`Terrain = %v, expected forest`, tileData[0][1].Terrain)
+		}
+		unit := tileData[0][0].Unit
+		if unit == nil || unit.UnitType != uint16(UnitWarrior) || unit.Owner != 2 {
+			t.Fatalf(`Unit = %+v, expected a warrior owned by player 2 after the swap`, unit)
+		}
+	}
+}
+
+func TestApplyEditPlanIsAllOrNothing(t *testing.T) {
+	plan, err := ParseEditPlan([]byte(`{"edits": [
+		{"op": "set-terrain", "x": 1, "y": 0, "terrain": "forest"},
+		{"op": "reset-tile", "x": 5, "y": 5}
+	]}`))
+	if err != nil {
+		t.Fatalf(`Failed to parse plan: %v`, err)
+	}
+	doc, err := OpenSaveDocument(writeTestSaveFile(t))
+	if err != nil {
+		t.Fatalf(`Failed to open save: %v`, err)
+	}
+	originalOutput := doc.Output
+
+	if _, err := ValidateEditPlan(doc.Output, plan, EditTargetCurrent); err == nil || !strings.Contains(err.Error(), "edit 1 (reset-tile)") {
+		t.Fatalf(`Validate error = %v, expected an error for edit 1`, err)
+	}
+	if _, err := ApplyEditPlan(doc, plan); err == nil {
+		t.Fatalf(`Apply should fail`)
+	}
+	if doc.Output != originalOutput {
+		t.Fatalf(`Document was modified by a plan that failed`)
+	}
+	compareArrays(t, serializeTestSave(t, doc.Output), buildTestSaveBytes())
+}
+
+func TestEditPlanExpandRejectsSizeWithWidth(t *testing.T) {
+	plan, err := ParseEditPlan([]byte(`{"edits": [{"op": "expand", "size": 4, "width": 3}]}`))
+	if err != nil {
+		t.Fatalf(`Failed to parse plan: %v`, err)
+	}
+	doc, err := OpenSaveDocument(writeTestSaveFile(t))
+	if err != nil {
+		t.Fatalf(`Failed to open save: %v`, err)
+	}
+	if _, err := ValidateEditPlan(doc.Output, plan, EditTargetCurrent); err == nil || !strings.Contains(err.Error(), "size can't be combined") {
+		t.Fatalf(`Validate error = %v, expected an error for size and width`, err)
+	}
+}
+
+func TestEditPlanFieldErrors(t *testing.T) {
+	if _, err := ParseEditPlan([]byte(`{"edits": [{"op": "set-terrain", "terain": "forest"}]}`)); err == nil {
+		t.Fatalf(`Plan with an unknown field should fail to parse`)
+	}
+
+	plan, err := ParseEditPlan([]byte(`{"target": "everything", "edits": [
+		{"op": "set-terrain", "x": 1, "terrain": "forest"},
+		{"op": "paint"},
+		{"op": "swap-players", "player1": 1}
+	]}`))
+	if err != nil {
+		t.Fatalf(`Failed to parse plan: %v`, err)
+	}
+	err = plan.checkFields()
+	if err == nil {
+		t.Fatalf(`Expected errors for the plan`)
+	}
+	for _, expected := range []string{"unknown edit target", "edit 0 (set-terrain): y is required", `edit 1: unknown operation "paint"`, "edit 2 (swap-players): player2 is required"} {
+		if !strings.Contains(err.Error(), expected) {
+			t.Fatalf(`Error %q does not contain %q`, err, expected)
+		}
+	}
+}
+
+func TestValidateEditPlanUsesTarget(t *testing.T) {
+	plan, err := ParseEditPlan([]byte(`{"edits": [{"op": "set-unit-type", "x": 1, "y": 1, "unit": "rider"}]}`))
+	if err != nil {
+		t.Fatalf(`Failed to parse plan: %v`, err)
+	}
+	inputByteData := buildDetailedTestSaveBytes(104)
+	saveOutput, err := ParsePolytopiaFile(io.NewSectionReader(bytes.NewReader(inputByteData), 0, int64(len(inputByteData))))
+	if err != nil {
+		t.Fatalf(`Failed to parse save: %v`, err)
+	}
+
+	// the unit on (1, 1) only exists in the current state
+	summary, err := ValidateEditPlan(saveOutput, plan, EditTargetCurrent)
+	if err != nil {
+		t.Fatalf(`Plan should be valid for the current state: %v`, err)
+	}
+	if summary.ChangedCurrentTiles != 1 || summary.ChangedInitialTiles != 0 || len(summary.Edits) != 1 {
+		t.Fatalf(`Summary = %+v, expected one changed tile in the current state`, summary)
+	}
+	if _, err := ValidateEditPlan(saveOutput, plan, EditTargetInitial); err == nil {
+		t.Fatalf(`Plan should be invalid for the initial state`)
+	}
+	compareArrays(t, serializeTestSave(t, saveOutput), inputByteData)
+}
+
+func TestSwapPlayersChecksEveryTargetedState(t *testing.T) {
+	plan, err := ParseEditPlan([]byte(`{"edits": [{"op": "swap-players", "player1": 1, "player2": 2}]}`))
+	if err != nil {
+		t.Fatalf(`Failed to parse plan: %v`, err)
+	}
+	doc, err := OpenSaveDocument(writeTestSaveFile(t))
+	if err != nil {
+		t.Fatalf(`Failed to open save: %v`, err)
+	}
+	// player 2 is only added to the current state
+	if _, err := doc.AddPlayer(); err != nil {
+		t.Fatalf(`Failed to add player: %v`, err)
+	}
+
+	if _, err := ValidateEditPlan(doc.Output, plan, EditTargetCurrent); err != nil {
+		t.Fatalf(`Swap should be valid in the current state: %v`, err)
+	}
+	for _, target := range []EditTarget{EditTargetInitial, EditTargetBoth} {
+		if _, err := ValidateEditPlan(doc.Output, plan, target); err == nil || !strings.Contains(err.Error(), "player 2 doesn't exist in the initial state") {
+			t.Fatalf(`Validate error with target %v = %v, expected missing player in the initial state`, target, err)
+		}
+	}
+}
+
+func TestEditPlanRejectsInvalidPlayerIds(t *testing.T) {
+	inputByteData := buildTestSaveBytes()
+	saveOutput, err := ParsePolytopiaFile(io.NewSectionReader(bytes.NewReader(inputByteData), 0, int64(len(inputByteData))))
+	if err != nil {
+		t.Fatalf(`Failed to parse save: %v`, err)
+	}
+
+	for planJson, expected := range map[string]string{
+		`{"edits": [{"op": "reveal", "player": 300}]}`:                                 "player: player id must be between 0 and 255, value is 300",
+		`{"edits": [{"op": "add-city", "x": 0, "y": 0, "name": "City", "owner": -1}]}`: "owner: player id must be between 0 and 255, value is -1",
+		`{"edits": [{"op": "reveal", "player": 7}]}`:                                   "player 7 doesn't exist in the current state",
+		`{"edits": [{"op": "convert-tribe", "from": 1, "to": 9}]}`:                     "player 9 doesn't exist in the current state",
+	} {
+		plan, err := ParseEditPlan([]byte(planJson))
+		if err != nil {
+			t.Fatalf(`Failed to parse plan: %v`, err)
+		}
+		if _, err := ValidateEditPlan(saveOutput, plan, EditTargetCurrent); err == nil || !strings.Contains(err.Error(), expected) {
+			t.Fatalf(`Validate error for %v = %v, expected %q`, planJson, err, expected)
+		}
+	}
+	compareArrays(t, serializeTestSave(t, saveOutput), inputByteData)
+}
+
+func TestEditPlanAddPlayer(t *testing.T) {
+	plan, err := ParseEditPlan([]byte(`{"edits": [{"op": "add-player"}]}`))
+	if err != nil {
+		t.Fatalf(`Failed to parse plan: %v`, err)
+	}
+	for _, gameVersion := range []int{105, 114} {
+		doc, err := OpenSaveDocument(writeTestSaveBytes(t, buildDetailedTestSaveBytes(gameVersion)))
+		if err != nil {
+			t.Fatalf(`Failed to open version %v save: %v`, gameVersion, err)
+		}
+		doc.Target = EditTargetBoth
+
+		summary, err := ValidateEditPlan(doc.Output, plan, doc.Target)
+		if err != nil {
+			t.Fatalf(`Plan should be valid for version %v: %v`, gameVersion, err)
+		}
+		if len(summary.Edits) != 1 || summary.Edits[0] != "added player 2" {
+			t.Fatalf(`Version %v summary = %+v, expected "added player 2"`, gameVersion, summary)
+		}
+		if _, err := ApplyEditPlan(doc, plan); err != nil {
+			t.Fatalf(`Failed to apply plan to version %v: %v`, gameVersion, err)
+		}
+		if err := doc.Save(); err != nil {
+			t.Fatalf(`Failed to save version %v: %v`, gameVersion, err)
+		}
+		if len(doc.Output.InitialPlayerData) != 3 || len(doc.Output.PlayerData) != 3 {
+			t.Fatalf(`Version %v player count = %v (initial), %v (current), expected = 3, 3`,
+				gameVersion, len(doc.Output.InitialPlayerData), len(doc.Output.PlayerData))
+		}
+	}
+}
+
+func TestEditPlanPlayerNames(t *testing.T) {
+	doc, err := OpenSaveDocument(writeTestSaveFile(t))
+	if err != nil {
+		t.Fatalf(`Failed to open save: %v`, err)
+	}
+	doc.Output.InitialPlayerData[0].Tribe = int(TribeImperius)
+	doc.Output.PlayerData[0].Tribe = int(TribeImperius)
+
+	// keys are matched without regard to case, so plans with PascalCase keys load the same way
+	for _, planJson := range []string{
+		`{"target": "both", "edits": [{"op": "reveal", "player": "imperius"}]}`,
+		`{"Target": "both", "Edits": [{"Op": "reveal", "Player": "Imperius"}]}`,
+	} {
+		plan, err := ParseEditPlan([]byte(planJson))
+		if err != nil {
+			t.Fatalf(`Failed to parse plan %v: %v`, planJson, err)
+		}
+		summary, err := ValidateEditPlan(doc.Output, plan, EditTargetCurrent)
+		if err != nil {
+			t.Fatalf(`Failed to validate plan %v: %v`, planJson, err)
+		}
+		if len(summary.Edits) != 1 || summary.Edits[0] != "revealed all tiles to player 1" {
+			t.Fatalf(`Summary edits for %v = %v, expected player 1`, planJson, summary.Edits)
+		}
+	}
+
+	if _, err := ParseEditPlan([]byte(`{"edits": [{"op": "reveal", "player": true}]}`)); err == nil {
+		t.Fatalf(`Plan with a player that isn't a number or a string should fail to parse`)
+	}
+	plan, err := ParseEditPlan([]byte(`{"edits": [{"op": "reveal", "player": "not-a-tribe"}, {"op": "reveal", "player": "vengir"}]}`))
+	if err != nil {
+		t.Fatalf(`Failed to parse plan: %v`, err)
+	}
+	if err := plan.checkFields(); err == nil || !strings.Contains(err.Error(), "edit 0 (reveal): player:") {
+		t.Fatalf(`Error for an unknown tribe name = %v`, err)
+	}
+	plan.Edits = plan.Edits[1:]
+	if _, err := ValidateEditPlan(doc.Output, plan, EditTargetCurrent); err == nil || !strings.Contains(err.Error(), "no player has tribe") {
+		t.Fatalf(`Error for a tribe without a player = %v`, err)
+	}
+}
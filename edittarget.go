@@ -0,0 +1,87 @@
+package polytopiamapmodel
+
+import "fmt"
+
+// EditTarget selects which copy of the map an edit is applied to.
+// The initial state is shown when viewing the start of a game and is the starting point for replays.
+type EditTarget int
+
+const (
+	EditTargetCurrent EditTarget = iota
+	EditTargetInitial
+	EditTargetBoth
+)
+
+func (target EditTarget) String() string {
+	switch target {
+	case EditTargetCurrent:
+		return "current"
+	case EditTargetInitial:
+		return "initial"
+	case EditTargetBoth:
+		return "both"
+	}
+	return fmt.Sprintf("EditTarget(%d)", int(target))
+}
+
+func ParseEditTarget(name string) (EditTarget, error) {
+	for _, target := range []EditTarget{EditTargetCurrent, EditTargetInitial, EditTargetBoth} {
+		if target.String() == name {
+			return target, nil
+		}
+	}
+	return EditTargetCurrent, fmt.Errorf("unknown edit target %q, expected current, initial or both", name)
+}
+
+// mapState points to one copy of the map header, tiles and players in a parsed save
+type mapState struct {
+	initial    bool
+	mapHeader  *MapHeaderOutput
+	tileData   *[][]TileData
+	playerData *[]PlayerData
+}
+
+func (state mapState) String() string {
+	if state.initial {
+		return "initial state"
+	}
+	return "current state"
+}
+
+func (state mapState) getTile(targetX int, targetY int) (*TileData, error) {
+	tileData := *state.tileData
+	if targetY < 0 || targetY >= len(tileData) || targetX < 0 || targetX >= len(tileData[targetY]) {
+		return nil, fmt.Errorf("tile (%v, %v) is outside the map in the %v, width: %v, height: %v",
+			targetX, targetY, state, state.mapHeader.MapWidth, state.mapHeader.MapHeight)
+	}
+	return &tileData[targetY][targetX], nil
+}
+
+func initialMapState(saveOutput *PolytopiaSaveOutput) mapState {
+	return mapState{
+		initial:    true,
+		mapHeader:  &saveOutput.InitialMapHeaderOutput,
+		tileData:   &saveOutput.InitialTileData,
+		playerData: &saveOutput.InitialPlayerData,
+	}
+}
+
+func currentMapState(saveOutput *PolytopiaSaveOutput) mapState {
+	return mapState{
+		initial:    false,
+		mapHeader:  &saveOutput.MapHeaderOutput,
+		tileData:   &saveOutput.TileData,
+		playerData: &saveOutput.PlayerData,
+	}
+}
+
+// targetMapStates returns the copies of the map selected by target
+func targetMapStates(saveOutput *PolytopiaSaveOutput, target EditTarget) []mapState {
+	switch target {
+	case EditTargetInitial:
+		return []mapState{initialMapState(saveOutput)}
+	case EditTargetBoth:
+		return []mapState{initialMapState(saveOutput), currentMapState(saveOutput)}
+	}
+	return []mapState{currentMapState(saveOutput)}
+}
@@ -0,0 +1,437 @@
+package polytopiamapmodel
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// The ids below are the values stored in save files.
+// Ids that aren't listed are formatted as a number, for example Unit(45), and can still be parsed from that form.
+
+// TerrainType is the value of TileData.Terrain
+type TerrainType int
+
+const (
+	TerrainNone     TerrainType = 0
+	TerrainWater    TerrainType = 1
+	TerrainOcean    TerrainType = 2
+	TerrainField    TerrainType = 3
+	TerrainMountain TerrainType = 4
+	TerrainForest   TerrainType = 5
+	TerrainIce      TerrainType = 6
+)
+
+var terrainTypeNames = map[TerrainType]string{
+	TerrainNone:     "None",
+	TerrainWater:    "Water",
+	TerrainOcean:    "Ocean",
+	TerrainField:    "Field",
+	TerrainMountain: "Mountain",
+	TerrainForest:   "Forest",
+	TerrainIce:      "Ice",
+}
+
+func (terrainType TerrainType) String() string {
+	return formatEnumName(terrainTypeNames, terrainType, "Terrain")
+}
+
+// ParseTerrainType accepts a name like "Water", ignoring case, spaces, dashes and underscores, or a numeric id
+func ParseTerrainType(name string) (TerrainType, error) {
+	return parseEnumName(terrainTypeNames, name, "Terrain")
+}
+
+// ClimateType is the value of TileData.Climate. Each climate is named after the tribe that starts on it.
+type ClimateType int
+
+const (
+	ClimateNone     ClimateType = 0
+	ClimateXinXi    ClimateType = 1
+	ClimateImperius ClimateType = 2
+	ClimateBardur   ClimateType = 3
+	ClimateOumaji   ClimateType = 4
+	ClimateKickoo   ClimateType = 5
+	ClimateHoodrick ClimateType = 6
+	ClimateLuxidoor ClimateType = 7
+	ClimateVengir   ClimateType = 8
+	ClimateZebasi   ClimateType = 9
+	ClimateAiMo     ClimateType = 10
+	ClimateQuetzali ClimateType = 11
+	ClimateYadakk   ClimateType = 12
+	ClimateAquarion ClimateType = 13
+	ClimateElyrion  ClimateType = 14
+	ClimatePolaris  ClimateType = 15
+	ClimateCymanti  ClimateType = 16
+)
+
+var climateTypeNames = map[ClimateType]string{
+	ClimateNone:     "None",
+	ClimateXinXi:    "XinXi",
+	ClimateImperius: "Imperius",
+	ClimateBardur:   "Bardur",
+	ClimateOumaji:   "Oumaji",
+	ClimateKickoo:   "Kickoo",
+	ClimateHoodrick: "Hoodrick",
+	ClimateLuxidoor: "Luxidoor",
+	ClimateVengir:   "Vengir",
+	ClimateZebasi:   "Zebasi",
+	ClimateAiMo:     "AiMo",
+	ClimateQuetzali: "Quetzali",
+	ClimateYadakk:   "Yadakk",
+	ClimateAquarion: "Aquarion",
+	ClimateElyrion:  "Elyrion",
+	ClimatePolaris:  "Polaris",
+	ClimateCymanti:  "Cymanti",
+}
+
+func (climateType ClimateType) String() string {
+	return formatEnumName(climateTypeNames, climateType, "Climate")
+}
+
+// ParseClimateType accepts a name like "XinXi", ignoring case, spaces, dashes and underscores, or a numeric id
+func ParseClimateType(name string) (ClimateType, error) {
+	return parseEnumName(climateTypeNames, name, "Climate")
+}
+
+// ResourceType is the value of TileData.ResourceType
+type ResourceType int
+
+const (
+	ResourceGame     ResourceType = 1
+	ResourceFruit    ResourceType = 2
+	ResourceFish     ResourceType = 3
+	ResourceWhale    ResourceType = 4
+	ResourceCrop     ResourceType = 5
+	ResourceMetal    ResourceType = 6
+	ResourceStarfish ResourceType = 7
+	ResourceSpores   ResourceType = 8
+)
+
+var resourceTypeNames = map[ResourceType]string{
+	ResourceGame:     "Game",
+	ResourceFruit:    "Fruit",
+	ResourceFish:     "Fish",
+	ResourceWhale:    "Whale",
+	ResourceCrop:     "Crop",
+	ResourceMetal:    "Metal",
+	ResourceStarfish: "Starfish",
+	ResourceSpores:   "Spores",
+}
+
+func (resourceType ResourceType) String() string {
+	return formatEnumName(resourceTypeNames, resourceType, "Resource")
+}
+
+// ParseResourceType accepts a name like "Fruit", ignoring case, spaces, dashes and underscores, or a numeric id
+func ParseResourceType(name string) (ResourceType, error) {
+	return parseEnumName(resourceTypeNames, name, "Resource")
+}
+
+// ImprovementType is the value of TileData.ImprovementType and the improvement built by ActionBuild
+type ImprovementType int
+
+const (
+	ImprovementCity           ImprovementType = 1
+	ImprovementRuin           ImprovementType = 2
+	ImprovementFarm           ImprovementType = 5
+	ImprovementMine           ImprovementType = 6
+	ImprovementForge          ImprovementType = 7
+	ImprovementSawmill        ImprovementType = 8
+	ImprovementWindmill       ImprovementType = 9
+	ImprovementCustomsHouse   ImprovementType = 10
+	ImprovementPort           ImprovementType = 11
+	ImprovementTemple         ImprovementType = 12
+	ImprovementWaterTemple    ImprovementType = 13
+	ImprovementForestTemple   ImprovementType = 14
+	ImprovementMountainTemple ImprovementType = 15
+	ImprovementIceTemple      ImprovementType = 16
+	ImprovementLumberHut      ImprovementType = 17
+	ImprovementAltarOfPeace   ImprovementType = 18
+	ImprovementTowerOfWisdom  ImprovementType = 19
+	ImprovementGrandBazaar    ImprovementType = 20
+	ImprovementEmperorsTomb   ImprovementType = 21
+	ImprovementGateOfPower    ImprovementType = 22
+	ImprovementParkOfFortune  ImprovementType = 23
+	ImprovementEyeOfGod       ImprovementType = 24
+)
+
+var improvementTypeNames = map[ImprovementType]string{
+	ImprovementCity:           "City",
+	ImprovementRuin:           "Ruin",
+	ImprovementFarm:           "Farm",
+	ImprovementMine:           "Mine",
+	ImprovementForge:          "Forge",
+	ImprovementSawmill:        "Sawmill",
+	ImprovementWindmill:       "Windmill",
+	ImprovementCustomsHouse:   "CustomsHouse",
+	ImprovementPort:           "Port",
+	ImprovementTemple:         "Temple",
+	ImprovementWaterTemple:    "WaterTemple",
+	ImprovementForestTemple:   "ForestTemple",
+	ImprovementMountainTemple: "MountainTemple",
+	ImprovementIceTemple:      "IceTemple",
+	ImprovementLumberHut:      "LumberHut",
+	ImprovementAltarOfPeace:   "AltarOfPeace",
+	ImprovementTowerOfWisdom:  "TowerOfWisdom",
+	ImprovementGrandBazaar:    "GrandBazaar",
+	ImprovementEmperorsTomb:   "EmperorsTomb",
+	ImprovementGateOfPower:    "GateOfPower",
+	ImprovementParkOfFortune:  "ParkOfFortune",
+	ImprovementEyeOfGod:       "EyeOfGod",
+}
+
+func (improvementType ImprovementType) String() string {
+	return formatEnumName(improvementTypeNames, improvementType, "Improvement")
+}
+
+// ParseImprovementType accepts a name like "Ruin", ignoring case, spaces, dashes and underscores, or a numeric id
+func ParseImprovementType(name string) (ImprovementType, error) {
+	return parseEnumName(improvementTypeNames, name, "Improvement")
+}
+
+// UnitType is the value of UnitData.UnitType
+type UnitType int
+
+const (
+	UnitScout       UnitType = 1
+	UnitWarrior     UnitType = 2
+	UnitRider       UnitType = 3
+	UnitKnight      UnitType = 4
+	UnitDefender    UnitType = 5
+	UnitShip        UnitType = 6
+	UnitBattleship  UnitType = 7
+	UnitCatapult    UnitType = 8
+	UnitArcher      UnitType = 9
+	UnitMindBender  UnitType = 10
+	UnitSwordsman   UnitType = 11
+	UnitGiant       UnitType = 12
+	UnitPolytaur    UnitType = 13
+	UnitNavalon     UnitType = 14
+	UnitDragonEgg   UnitType = 15
+	UnitBabyDragon  UnitType = 16
+	UnitFireDragon  UnitType = 17
+	UnitAmphibian   UnitType = 18
+	UnitTridention  UnitType = 19
+	UnitMooni       UnitType = 20
+	UnitBattleSled  UnitType = 21
+	UnitIceFortress UnitType = 22
+	UnitCrab        UnitType = 23
+	UnitGaami       UnitType = 24
+	UnitHexapod     UnitType = 25
+	UnitKiton       UnitType = 26
+	UnitPhychi      UnitType = 27
+	UnitRaychi      UnitType = 28
+	UnitShaman      UnitType = 29
+	UnitExida       UnitType = 30
+	UnitDoomux      UnitType = 31
+	UnitCentipede   UnitType = 32
+	UnitSegment     UnitType = 33
+)
+
+var unitTypeNames = map[UnitType]string{
+	UnitScout:       "Scout",
+	UnitWarrior:     "Warrior",
+	UnitRider:       "Rider",
+	UnitKnight:      "Knight",
+	UnitDefender:    "Defender",
+	UnitShip:        "Ship",
+	UnitBattleship:  "Battleship",
+	UnitCatapult:    "Catapult",
+	UnitArcher:      "Archer",
+	UnitMindBender:  "MindBender",
+	UnitSwordsman:   "Swordsman",
+	UnitGiant:       "Giant",
+	UnitPolytaur:    "Polytaur",
+	UnitNavalon:     "Navalon",
+	UnitDragonEgg:   "DragonEgg",
+	UnitBabyDragon:  "BabyDragon",
+	UnitFireDragon:  "FireDragon",
+	UnitAmphibian:   "Amphibian",
+	UnitTridention:  "Tridention",
+	UnitMooni:       "Mooni",
+	UnitBattleSled:  "BattleSled",
+	UnitIceFortress: "IceFortress",
+	UnitCrab:        "Crab",
+	UnitGaami:       "Gaami",
+	UnitHexapod:     "Hexapod",
+	UnitKiton:       "Kiton",
+	UnitPhychi:      "Phychi",
+	UnitRaychi:      "Raychi",
+	UnitShaman:      "Shaman",
+	UnitExida:       "Exida",
+	UnitDoomux:      "Doomux",
+	UnitCentipede:   "Centipede",
+	UnitSegment:     "Segment",
+}
+
+func (unitType UnitType) String() string {
+	return formatEnumName(unitTypeNames, unitType, "Unit")
+}
+
+// ParseUnitType accepts a name like "Warrior", ignoring case, spaces, dashes and underscores, or a numeric id
+func ParseUnitType(name string) (UnitType, error) {
+	return parseEnumName(unitTypeNames, name, "Unit")
+}
+
+// TechType is a value in PlayerData.AvailableTech
+type TechType int
+
+const (
+	TechBasic        TechType = 0
+	TechRiding       TechType = 1
+	TechFreeSpirit   TechType = 2
+	TechChivalry     TechType = 3
+	TechRoads        TechType = 4
+	TechTrade        TechType = 5
+	TechOrganization TechType = 6
+	TechShields      TechType = 7
+	TechFarming      TechType = 8
+	TechConstruction TechType = 9
+	TechFishing      TechType = 10
+	TechWhaling      TechType = 11
+	TechAquatism     TechType = 12
+	TechSailing      TechType = 13
+	TechNavigation   TechType = 14
+	TechHunting      TechType = 15
+	TechForestry     TechType = 16
+	TechMathematics  TechType = 17
+	TechArchery      TechType = 18
+	TechSpiritualism TechType = 19
+	TechClimbing     TechType = 20
+	TechMeditation   TechType = 21
+	TechPhilosophy   TechType = 22
+	TechMining       TechType = 23
+	TechSmithery     TechType = 24
+	TechFreeDiving   TechType = 25
+	TechSpearing     TechType = 26
+	TechForestMagic  TechType = 27
+)
+
+var techTypeNames = map[TechType]string{
+	TechBasic:        "Basic",
+	TechRiding:       "Riding",
+	TechFreeSpirit:   "FreeSpirit",
+	TechChivalry:     "Chivalry",
+	TechRoads:        "Roads",
+	TechTrade:        "Trade",
+	TechOrganization: "Organization",
+	TechShields:      "Shields",
+	TechFarming:      "Farming",
+	TechConstruction: "Construction",
+	TechFishing:      "Fishing",
+	TechWhaling:      "Whaling",
+	TechAquatism:     "Aquatism",
+	TechSailing:      "Sailing",
+	TechNavigation:   "Navigation",
+	TechHunting:      "Hunting",
+	TechForestry:     "Forestry",
+	TechMathematics:  "Mathematics",
+	TechArchery:      "Archery",
+	TechSpiritualism: "Spiritualism",
+	TechClimbing:     "Climbing",
+	TechMeditation:   "Meditation",
+	TechPhilosophy:   "Philosophy",
+	TechMining:       "Mining",
+	TechSmithery:     "Smithery",
+	TechFreeDiving:   "FreeDiving",
+	TechSpearing:     "Spearing",
+	TechForestMagic:  "ForestMagic",
+}
+
+func (techType TechType) String() string {
+	return formatEnumName(techTypeNames, techType, "Tech")
+}
+
+// ParseTechType accepts a name like "Riding", ignoring case, spaces, dashes and underscores, or a numeric id
+func ParseTechType(name string) (TechType, error) {
+	return parseEnumName(techTypeNames, name, "Tech")
+}
+
+// TribeType is the value of PlayerData.Tribe and the tribe lists in the map header
+type TribeType int
+
+const (
+	TribeNone     TribeType = 0
+	TribeNature   TribeType = 1
+	TribeAiMo     TribeType = 2
+	TribeAquarion TribeType = 3
+	TribeBardur   TribeType = 4
+	TribeElyrion  TribeType = 5
+	TribeHoodrick TribeType = 6
+	TribeImperius TribeType = 7
+	TribeKickoo   TribeType = 8
+	TribeLuxidoor TribeType = 9
+	TribeOumaji   TribeType = 10
+	TribeQuetzali TribeType = 11
+	TribeVengir   TribeType = 12
+	TribeXinXi    TribeType = 13
+	TribeYadakk   TribeType = 14
+	TribeZebasi   TribeType = 15
+	TribePolaris  TribeType = 16
+	TribeCymanti  TribeType = 17
+)
+
+var tribeTypeNames = map[TribeType]string{
+	TribeNone:     "None",
+	TribeNature:   "Nature",
+	TribeAiMo:     "AiMo",
+	TribeAquarion: "Aquarion",
+	TribeBardur:   "Bardur",
+	TribeElyrion:  "Elyrion",
+	TribeHoodrick: "Hoodrick",
+	TribeImperius: "Imperius",
+	TribeKickoo:   "Kickoo",
+	TribeLuxidoor: "Luxidoor",
+	TribeOumaji:   "Oumaji",
+	TribeQuetzali: "Quetzali",
+	TribeVengir:   "Vengir",
+	TribeXinXi:    "XinXi",
+	TribeYadakk:   "Yadakk",
+	TribeZebasi:   "Zebasi",
+	TribePolaris:  "Polaris",
+	TribeCymanti:  "Cymanti",
+}
+
+func (tribeType TribeType) String() string {
+	return formatEnumName(tribeTypeNames, tribeType, "Tribe")
+}
+
+// ParseTribeType accepts a name like "Nature", ignoring case, spaces, dashes and underscores, or a numeric id
+func ParseTribeType(name string) (TribeType, error) {
+	return parseEnumName(tribeTypeNames, name, "Tribe")
+}
+
+func formatEnumName[T ~int](names map[T]string, value T, prefix string) string {
+	name, ok := names[value]
+	if !ok {
+		return fmt.Sprintf("%s(%d)", prefix, int(value))
+	}
+	return name
+}
+
+func parseEnumName[T ~int](names map[T]string, name string, prefix string) (T, error) {
+	normalizedName := normalizeEnumName(name)
+	for value, valueName := range names {
+		if normalizeEnumName(valueName) == normalizedName {
+			return value, nil
+		}
+	}
+
+	// allow ids without a name, either as a plain number or in the format returned by String
+	numericName := strings.TrimSpace(name)
+	if strings.HasPrefix(numericName, prefix+"(") && strings.HasSuffix(numericName, ")") {
+		numericName = numericName[len(prefix)+1 : len(numericName)-1]
+	}
+	if value, err := strconv.Atoi(numericName); err == nil {
+		return T(value), nil
+	}
+	return 0, fmt.Errorf("unknown %s name %q", strings.ToLower(prefix), name)
+}
+
+func normalizeEnumName(name string) string {
+	normalizedName := strings.ToLower(name)
+	for _, separator := range []string{" ", "-", "_", "'"} {
+		normalizedName = strings.ReplaceAll(normalizedName, separator, "")
+	}
+	return normalizedName
+}
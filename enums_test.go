@@ -0,0 +1,87 @@
+package polytopiamapmodel
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestEnumString(t *testing.T) {
+	if TerrainMountain.String() != "Mountain" {
+		t.Fatalf(`String = %v, expected = Mountain`, TerrainMountain.String())
+	}
+	if TribeAiMo.String() != "AiMo" {
+		t.Fatalf(`String = %v, expected = AiMo`, TribeAiMo.String())
+	}
+	if UnitType(200).String() != "Unit(200)" {
+		t.Fatalf(`String = %v, expected = Unit(200)`, UnitType(200).String())
+	}
+}
+
+func TestParseEnumNames(t *testing.T) {
+	testCases := []struct {
+		name     string
+		parse    func(string) (int, error)
+		expected int
+	}{
+		{"ai-mo", parseEnumId(ParseTribeType), int(TribeAiMo)},
+		{"Xin_Xi", parseEnumId(ParseTribeType), int(TribeXinXi)},
+		{"water", parseEnumId(ParseTerrainType), int(TerrainWater)},
+		{"Mind Bender", parseEnumId(ParseUnitType), int(UnitMindBender)},
+		{"Unit(200)", parseEnumId(ParseUnitType), 200},
+		{"39", parseEnumId(ParseTechType), 39},
+	}
+	for _, testCase := range testCases {
+		result, err := testCase.parse(testCase.name)
+		if err != nil {
+			t.Fatalf(`Failed to parse %v: %v`, testCase.name, err)
+		}
+		if result != testCase.expected {
+			t.Fatalf(`Parse %v = %v, expected = %v`, testCase.name, result, testCase.expected)
+		}
+	}
+
+	if _, err := ParseTerrainType("lava"); err == nil {
+		t.Fatalf(`Expected error for unknown terrain`)
+	}
+}
+
+func TestExportJsonWithEnumNames(t *testing.T) {
+	inputFilename := writeTestSaveFile(t)
+	saveOutput, err := ReadPolytopiaDecompressedFile(inputFilename)
+	if err != nil {
+		t.Fatalf(`Failed to read save: %v`, err)
+	}
+	saveOutput.PlayerData[0].AvailableTech = []int{0, 8}
+	saveOutput.Actions = []ReplayAction{{Index: 0, Turn: 1, Action: ActionResearch{PlayerId: 1, TechType: 9}}}
+
+	outputFilename := filepath.Join(t.TempDir(), "save.json")
+	if err := ExportPolytopiaJsonFileWithOptions(saveOutput, outputFilename, JsonExportOptions{UseEnumNames: true}); err != nil {
+		t.Fatalf(`Failed to export json: %v`, err)
+	}
+	jsonContents, err := os.ReadFile(outputFilename)
+	if err != nil {
+		t.Fatalf(`Failed to read json: %v`, err)
+	}
+	for _, expected := range []string{`"terrain": "Field"`, `"tribe": "AiMo"`, `"Farming"`, `"improvementType": -1`, `"techType": "Construction"`} {
+		if !strings.Contains(string(jsonContents), expected) {
+			t.Fatalf(`Exported json doesn't contain %v`, expected)
+		}
+	}
+
+	result, err := ImportPolytopiaDataFromJson(outputFilename)
+	if err != nil {
+		t.Fatalf(`Failed to import json: %v`, err)
+	}
+	if !reflect.DeepEqual(result.TileData, saveOutput.TileData) {
+		t.Fatalf(`Imported tiles don't match. Result = %+v, expected = %+v`, result.TileData, saveOutput.TileData)
+	}
+	if !reflect.DeepEqual(result.PlayerData, saveOutput.PlayerData) {
+		t.Fatalf(`Imported players don't match. Result = %+v, expected = %+v`, result.PlayerData, saveOutput.PlayerData)
+	}
+	if !reflect.DeepEqual(result.Actions, saveOutput.Actions) {
+		t.Fatalf(`Imported actions don't match. Result = %+v, expected = %+v`, result.Actions, saveOutput.Actions)
+	}
+}
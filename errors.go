@@ -0,0 +1,92 @@
+package polytopiamapmodel
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// ParseError describes where decoding a save file failed.
+// TileX, TileY and PlayerIndex are -1 when they don't apply to the failure.
+type ParseError struct {
+	Section     string
+	Field       string
+	TileX       int
+	TileY       int
+	PlayerIndex int
+	Offset      int64
+	Err         error
+}
+
+func (e *ParseError) Error() string {
+	details := make([]string, 0)
+	if e.Section != "" {
+		details = append(details, "section "+e.Section)
+	}
+	if e.TileX >= 0 && e.TileY >= 0 {
+		details = append(details, fmt.Sprintf("tile (%v, %v)", e.TileX, e.TileY))
+	}
+	if e.PlayerIndex >= 0 {
+		details = append(details, fmt.Sprintf("player index %v", e.PlayerIndex))
+	}
+	if e.Field != "" {
+		details = append(details, "field "+e.Field)
+	}
+	details = append(details, fmt.Sprintf("offset %v", e.Offset))
+	return fmt.Sprintf("failed to parse save (%s): %v", strings.Join(details, ", "), e.Err)
+}
+
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+func newParseError(offset int64, fieldName string, err error) *ParseError {
+	return &ParseError{
+		Field:       fieldName,
+		TileX:       -1,
+		TileY:       -1,
+		PlayerIndex: -1,
+		Offset:      offset,
+		Err:         err,
+	}
+}
+
+// newParseErrorAtReader builds an error located at the reader's current position
+func newParseErrorAtReader(reader *io.SectionReader, fieldName string, err error) *ParseError {
+	return newParseError(currentOffset(reader), fieldName, err)
+}
+
+func currentOffset(reader *io.SectionReader) int64 {
+	offset, err := reader.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return -1
+	}
+	return offset
+}
+
+// withSection fills in the section name if a lower level error didn't set one
+func withSection(err error, section string) error {
+	var parseErr *ParseError
+	if errors.As(err, &parseErr) && parseErr.Section == "" {
+		parseErr.Section = section
+	}
+	return err
+}
+
+func withTile(err error, x int, y int) error {
+	var parseErr *ParseError
+	if errors.As(err, &parseErr) {
+		parseErr.TileX = x
+		parseErr.TileY = y
+	}
+	return err
+}
+
+func withPlayer(err error, playerIndex int) error {
+	var parseErr *ParseError
+	if errors.As(err, &parseErr) {
+		parseErr.PlayerIndex = playerIndex
+	}
+	return err
+}
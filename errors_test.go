@@ -0,0 +1,124 @@
+package polytopiamapmodel
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"testing"
+)
+
+func TestDeserializeTruncatedTileReturnsParseError(t *testing.T) {
+	// tile header is complete but the resource flag is missing
+	inputByteData := []byte{3, 0, 0, 0, 1, 0, 0, 0, 3, 0, 8, 0, 1, 0, 0, 0,
+		255, 255, 255, 255, 255, 255, 255, 255,
+	}
+	streamReader := io.NewSectionReader(bytes.NewReader(inputByteData), 0, int64(len(inputByteData)))
+	_, err := DeserializeTileDataFromBytes(streamReader, 1, 3, 104)
+
+	var parseErr *ParseError
+	if !errors.As(err, &parseErr) {
+		t.Fatalf(`Expected ParseError, got %v`, err)
+	}
+	if parseErr.Section != "tile" || parseErr.TileX != 3 || parseErr.TileY != 1 {
+		t.Fatalf(`Unexpected location, section = %v, tile = (%v, %v)`, parseErr.Section, parseErr.TileX, parseErr.TileY)
+	}
+	if parseErr.Offset != 24 {
+		t.Fatalf(`Offset = %v, expected = 24`, parseErr.Offset)
+	}
+	if !errors.Is(err, io.EOF) {
+		t.Fatalf(`Expected error to wrap io.EOF, got %v`, err)
+	}
+}
+
+func TestDeserializeTileAtWrongLocationReturnsParseError(t *testing.T) {
+	inputByteData := []byte{3, 0, 0, 0, 1, 0, 0, 0, 3, 0, 8, 0, 1, 0, 0, 0,
+		255, 255, 255, 255, 255, 255, 255, 255,
+		0, 0, 0, 0, 0, 0, 0, 0,
+	}
+	streamReader := io.NewSectionReader(bytes.NewReader(inputByteData), 0, int64(len(inputByteData)))
+	_, err := DeserializeTileDataFromBytes(streamReader, 2, 3, 104)
+
+	var parseErr *ParseError
+	if !errors.As(err, &parseErr) {
+		t.Fatalf(`Expected ParseError, got %v`, err)
+	}
+	if parseErr.Field != "WorldCoordinates" || parseErr.Offset != 0 {
+		t.Fatalf(`Unexpected field or offset, field = %v, offset = %v`, parseErr.Field, parseErr.Offset)
+	}
+}
+
+func TestReadTruncatedPlayerListReturnsPlayerIndex(t *testing.T) {
+	inputByteData := []byte{2, 0}
+	inputByteData = append(inputByteData, playerBytes...)
+	inputByteData = append(inputByteData, playerBytes[:20]...)
+	streamReader := io.NewSectionReader(bytes.NewReader(inputByteData), 0, int64(len(inputByteData)))
+	_, err := readAllPlayerData(streamReader, make(map[string]int), 100)
+
+	var parseErr *ParseError
+	if !errors.As(err, &parseErr) {
+		t.Fatalf(`Expected ParseError, got %v`, err)
+	}
+	if parseErr.Section != "player" || parseErr.PlayerIndex != 1 {
+		t.Fatalf(`Unexpected location, section = %v, player index = %v`, parseErr.Section, parseErr.PlayerIndex)
+	}
+	if parseErr.Offset != int64(2+len(playerBytes)+12) {
+		t.Fatalf(`Offset = %v, expected = %v`, parseErr.Offset, 2+len(playerBytes)+12)
+	}
+}
+
+func TestDeserializeTruncatedMapHeaderReturnsParseError(t *testing.T) {
+	inputByteData := mapHeaderBytes[:len(mapHeaderBytes)-1]
+	streamReader := io.NewSectionReader(bytes.NewReader(inputByteData), 0, int64(len(inputByteData)))
+	_, err := DeserializeMapHeaderFromBytes(streamReader)
+
+	var parseErr *ParseError
+	if !errors.As(err, &parseErr) {
+		t.Fatalf(`Expected ParseError, got %v`, err)
+	}
+	if parseErr.Section != "map header" || parseErr.Field != "map height" {
+		t.Fatalf(`Unexpected location, section = %v, field = %v`, parseErr.Section, parseErr.Field)
+	}
+	if parseErr.Offset != int64(len(mapHeaderBytes)-2) {
+		t.Fatalf(`Offset = %v, expected = %v`, parseErr.Offset, len(mapHeaderBytes)-2)
+	}
+}
+
+func TestParseRejectsOversizedMap(t *testing.T) {
+	emptyTile := BuildEmptyTile(0, 0)
+	emptyTile.PlayerVisibility = []int{}
+	if tileSize := len(mustSerialize(SerializeTileToBytes(emptyTile, 104))); tileSize != minTileSize {
+		t.Fatalf(`Empty tile size = %v, expected minTileSize = %v`, tileSize, minTileSize)
+	}
+
+	for _, size := range [][2]int{{65535, 65535}, {200, 200}, {0, 8}, {8, 0}} {
+		mapHeader := mapHeaderOutput
+		mapHeader.MapHeaderInput.Version1 = 104
+		mapHeader.MapWidth = size[0]
+		mapHeader.MapHeight = size[1]
+		inputByteData := mustSerialize(SerializeMapHeaderToBytes(mapHeader))
+		_, err := ParsePolytopiaFile(io.NewSectionReader(bytes.NewReader(inputByteData), 0, int64(len(inputByteData))))
+
+		var parseErr *ParseError
+		if !errors.As(err, &parseErr) {
+			t.Fatalf(`Expected ParseError for a %vx%v map, got %v`, size[0], size[1], err)
+		}
+		if parseErr.Section != "initial map header" || parseErr.Field != "map size" {
+			t.Fatalf(`Unexpected location, section = %v, field = %v`, parseErr.Section, parseErr.Field)
+		}
+	}
+}
+
+func TestLocationHelpersFillWrappedParseError(t *testing.T) {
+	parseErr := newParseError(12, "unit type", io.EOF)
+	err := fmt.Errorf("reading unit: %w", parseErr)
+	err = withPlayer(withTile(withSection(err, "tile"), 4, 5), 2)
+
+	if !errors.Is(err, io.EOF) {
+		t.Fatalf(`Expected error to wrap io.EOF, got %v`, err)
+	}
+	if parseErr.Section != "tile" || parseErr.TileX != 4 || parseErr.TileY != 5 || parseErr.PlayerIndex != 2 {
+		t.Fatalf(`Unexpected location, section = %v, tile = (%v, %v), player index = %v`,
+			parseErr.Section, parseErr.TileX, parseErr.TileY, parseErr.PlayerIndex)
+	}
+}
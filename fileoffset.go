@@ -3,7 +3,6 @@ package polytopiamapmodel
 import (
 	"fmt"
 	"io"
-	"log"
 )
 
 func buildMapStartKey() string {
@@ -30,6 +29,14 @@ func buildAllPlayersEndKey() string {
 	return "AllPlayersEnd"
 }
 
+func buildPlayerStartKey(index int) string {
+	return fmt.Sprintf("PlayerStart%v", index)
+}
+
+func buildPlayerEndKey(index int) string {
+	return fmt.Sprintf("PlayerEnd%v", index)
+}
+
 func buildMapHeaderStartKey() string {
 	return "MapHeaderStart"
 }
@@ -38,10 +45,118 @@ func buildMapHeaderEndKey() string {
 	return "MapHeaderEnd"
 }
 
+func buildActionsStartKey() string {
+	return "ActionsStart"
+}
+
+func buildActionsEndKey() string {
+	return "ActionsEnd"
+}
+
 func updateFileOffsetMap(fileOffsetMap map[string]int, streamReader *io.SectionReader, unitLocationKey string) {
-	fileOffset, err := streamReader.Seek(0, io.SeekCurrent)
-	if err != nil {
-		log.Fatal(err)
+	fileOffsetMap[unitLocationKey] = int(currentOffset(streamReader))
+}
+
+// OffsetRange is the half-open range [Start, End) of bytes in the decompressed file
+type OffsetRange struct {
+	Start int
+	End   int
+}
+
+func (offsetRange OffsetRange) Length() int {
+	return offsetRange.End - offsetRange.Start
+}
+
+// StateOffsetIndex contains the offsets of one map state, either the initial state or the current state
+type StateOffsetIndex struct {
+	MapHeader       OffsetRange
+	MapHeaderFields map[string]OffsetRange // MapSquareSize, MapWidth and MapHeight
+	Map             OffsetRange            // all tiles
+	Tiles           [][]OffsetRange        // indexed by [y][x] like TileData
+	Players         OffsetRange            // includes the number of players
+	PlayerRanges    []OffsetRange
+}
+
+// OffsetIndex contains the offsets of every section in the parsed file.
+// The offsets are not updated when the parsed data is modified.
+type OffsetIndex struct {
+	Initial         StateOffsetIndex
+	Current         StateOffsetIndex
+	InitialStateGap OffsetRange
+	CurrentStateGap OffsetRange
+	Actions         OffsetRange // includes the number of actions
+	ActionRanges    []OffsetRange
+	Trailer         OffsetRange
+}
+
+// buildStateOffsetIndex copies the offsets of one state before the keys are overwritten by the next state
+func buildStateOffsetIndex(fileOffsetMap map[string]int, mapWidth int, mapHeight int, numPlayers int) StateOffsetIndex {
+	stateOffsetIndex := StateOffsetIndex{
+		MapHeader: OffsetRange{fileOffsetMap[buildMapHeaderStartKey()], fileOffsetMap[buildMapHeaderEndKey()]},
+		MapHeaderFields: map[string]OffsetRange{
+			"MapSquareSize": {fileOffsetMap["SquareSizeKey"], fileOffsetMap["SquareSizeKey"] + 4},
+			"MapWidth":      {fileOffsetMap["MapWidth"], fileOffsetMap["MapWidth"] + 2},
+			"MapHeight":     {fileOffsetMap["MapHeight"], fileOffsetMap["MapHeight"] + 2},
+		},
+		Map:          OffsetRange{fileOffsetMap[buildMapStartKey()], fileOffsetMap[buildMapEndKey()]},
+		Tiles:        make([][]OffsetRange, mapHeight),
+		Players:      OffsetRange{fileOffsetMap[buildAllPlayersStartKey()], fileOffsetMap[buildAllPlayersEndKey()]},
+		PlayerRanges: make([]OffsetRange, numPlayers),
+	}
+	for i := 0; i < mapHeight; i++ {
+		stateOffsetIndex.Tiles[i] = make([]OffsetRange, mapWidth)
+		for j := 0; j < mapWidth; j++ {
+			stateOffsetIndex.Tiles[i][j] = OffsetRange{fileOffsetMap[buildTileStartKey(j, i)], fileOffsetMap[buildTileEndKey(j, i)]}
+		}
+	}
+	for i := 0; i < numPlayers; i++ {
+		stateOffsetIndex.PlayerRanges[i] = OffsetRange{fileOffsetMap[buildPlayerStartKey(i)], fileOffsetMap[buildPlayerEndKey(i)]}
+	}
+	return stateOffsetIndex
+}
+
+func buildActionRanges(actionsStart int, actions []ReplayAction) ([]OffsetRange, error) {
+	actionRanges := make([]OffsetRange, len(actions))
+	offset := actionsStart + 2
+	for i := 0; i < len(actions); i++ {
+		actionBytes, err := SerializeActionToBytes(actions[i].Action)
+		if err != nil {
+			return nil, err
+		}
+		actionLength := 2 + len(actionBytes)
+		actionRanges[i] = OffsetRange{offset, offset + actionLength}
+		offset += actionLength
+	}
+	return actionRanges, nil
+}
+
+// InitialTileFieldOffsets returns the offset of each field in a tile of the initial state, using the field names from BuildLayout
+func (saveOutput *PolytopiaSaveOutput) InitialTileFieldOffsets(targetX int, targetY int) (map[string]OffsetRange, error) {
+	return buildTileFieldOffsets(saveOutput.Offsets.Initial, saveOutput.InitialTileData, targetX, targetY, saveOutput.GameVersion)
+}
+
+// CurrentTileFieldOffsets returns the offset of each field in a tile of the current state, using the field names from BuildLayout
+func (saveOutput *PolytopiaSaveOutput) CurrentTileFieldOffsets(targetX int, targetY int) (map[string]OffsetRange, error) {
+	return buildTileFieldOffsets(saveOutput.Offsets.Current, saveOutput.TileData, targetX, targetY, saveOutput.GameVersion)
+}
+
+func buildTileFieldOffsets(stateOffsetIndex StateOffsetIndex, tileData [][]TileData, targetX int, targetY int, gameVersion int) (map[string]OffsetRange, error) {
+	if targetY < 0 || targetY >= len(stateOffsetIndex.Tiles) || targetX < 0 || targetX >= len(stateOffsetIndex.Tiles[targetY]) ||
+		targetY >= len(tileData) || targetX >= len(tileData[targetY]) {
+		return nil, fmt.Errorf("tile (%v, %v) is outside the map", targetX, targetY)
+	}
+
+	tileRange := stateOffsetIndex.Tiles[targetY][targetX]
+	b := &layoutBuilder{offset: tileRange.Start}
+	b.addTile("", targetX, targetY, tileData[targetY][targetX], gameVersion)
+	if b.offset != tileRange.End {
+		return nil, fmt.Errorf("tile (%v, %v) was modified after parsing, layout ends at %v and parsed tile ends at %v",
+			targetX, targetY, b.offset, tileRange.End)
+	}
+
+	fieldOffsets := make(map[string]OffsetRange)
+	for _, field := range b.fields {
+		fieldOffsets[field.Field] = OffsetRange{field.Offset, field.Offset + field.Length}
 	}
-	fileOffsetMap[unitLocationKey] = int(fileOffset)
+	return fieldOffsets, nil
 }
@@ -0,0 +1,89 @@
+package polytopiamapmodel
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"testing"
+)
+
+func TestOffsetIndex(t *testing.T) {
+	gameVersion := 105
+	inputByteData := buildDetailedTestSaveBytes(gameVersion)
+	saveOutput, err := ParsePolytopiaFile(io.NewSectionReader(bytes.NewReader(inputByteData), 0, int64(len(inputByteData))))
+	if err != nil {
+		t.Fatalf(`Failed to parse: %v`, err)
+	}
+	offsets := saveOutput.Offsets
+	bytesInRange := func(offsetRange OffsetRange) []byte {
+		return inputByteData[offsetRange.Start:offsetRange.End]
+	}
+
+	compareArrays(t, bytesInRange(offsets.Initial.MapHeader), mustSerialize(SerializeMapHeaderToBytes(saveOutput.InitialMapHeaderOutput)))
+	compareArrays(t, bytesInRange(offsets.Current.MapHeader), mustSerialize(SerializeMapHeaderToBytes(saveOutput.MapHeaderOutput)))
+	if offsets.Initial.MapHeader.Start != 0 || offsets.Current.MapHeader.Start <= offsets.Initial.MapHeader.Start {
+		t.Fatalf(`Initial and current map header offsets should be separate, initial = %+v, current = %+v`,
+			offsets.Initial.MapHeader, offsets.Current.MapHeader)
+	}
+	mapWidth := binary.LittleEndian.Uint16(bytesInRange(offsets.Initial.MapHeaderFields["MapWidth"]))
+	if int(mapWidth) != saveOutput.InitialMapHeaderOutput.MapWidth {
+		t.Fatalf(`MapWidth not equal. Result = %v, expected = %v`, mapWidth, saveOutput.InitialMapHeaderOutput.MapWidth)
+	}
+
+	for i := 0; i < saveOutput.MapHeight; i++ {
+		for j := 0; j < saveOutput.MapWidth; j++ {
+			compareArrays(t, bytesInRange(offsets.Initial.Tiles[i][j]), mustSerialize(SerializeTileToBytes(saveOutput.InitialTileData[i][j], gameVersion)))
+			compareArrays(t, bytesInRange(offsets.Current.Tiles[i][j]), mustSerialize(SerializeTileToBytes(saveOutput.TileData[i][j], gameVersion)))
+		}
+	}
+	for i := 0; i < len(saveOutput.PlayerData); i++ {
+		compareArrays(t, bytesInRange(offsets.Initial.PlayerRanges[i]), mustSerialize(SerializePlayerDataToBytes(saveOutput.InitialPlayerData[i], gameVersion)))
+		compareArrays(t, bytesInRange(offsets.Current.PlayerRanges[i]), mustSerialize(SerializePlayerDataToBytes(saveOutput.PlayerData[i], gameVersion)))
+	}
+
+	compareArrays(t, bytesInRange(offsets.InitialStateGap), []byte{1, 2, 3})
+	compareArrays(t, bytesInRange(offsets.CurrentStateGap), []byte{4, 5})
+	compareArrays(t, bytesInRange(offsets.Actions), actionListBytes)
+	if len(offsets.ActionRanges) != len(actionList) {
+		t.Fatalf(`Action range count not equal. Result = %v, expected = %v`, len(offsets.ActionRanges), len(actionList))
+	}
+	compareArrays(t, bytesInRange(offsets.ActionRanges[1]), []byte{15, 0, 255})
+	compareArrays(t, bytesInRange(offsets.Trailer), []byte{9, 8, 7})
+	if offsets.Trailer.End != len(inputByteData) {
+		t.Fatalf(`Trailer should end at the end of the file, end = %v, file size = %v`, offsets.Trailer.End, len(inputByteData))
+	}
+}
+
+func TestTileFieldOffsets(t *testing.T) {
+	inputByteData := buildDetailedTestSaveBytes(105)
+	saveOutput, err := ParsePolytopiaFile(io.NewSectionReader(bytes.NewReader(inputByteData), 0, int64(len(inputByteData))))
+	if err != nil {
+		t.Fatalf(`Failed to parse: %v`, err)
+	}
+
+	fieldOffsets, err := saveOutput.CurrentTileFieldOffsets(1, 1)
+	if err != nil {
+		t.Fatalf(`Failed to get tile field offsets: %v`, err)
+	}
+	healthRange := fieldOffsets["Unit.Health"]
+	health := binary.LittleEndian.Uint16(inputByteData[healthRange.Start:healthRange.End])
+	if health != saveOutput.TileData[1][1].Unit.Health {
+		t.Fatalf(`Health not equal. Result = %v, expected = %v`, health, saveOutput.TileData[1][1].Unit.Health)
+	}
+
+	initialFieldOffsets, err := saveOutput.InitialTileFieldOffsets(1, 1)
+	if err != nil {
+		t.Fatalf(`Failed to get tile field offsets: %v`, err)
+	}
+	if initialFieldOffsets["Terrain"].Start >= fieldOffsets["Terrain"].Start {
+		t.Fatalf(`Initial tile should be before the current tile`)
+	}
+
+	if _, err := saveOutput.CurrentTileFieldOffsets(2, 0); err == nil {
+		t.Fatalf(`Expected error for tile outside the map`)
+	}
+	saveOutput.TileData[1][1].PlayerVisibility = append(saveOutput.TileData[1][1].PlayerVisibility, 2)
+	if _, err := saveOutput.CurrentTileFieldOffsets(1, 1); err == nil {
+		t.Fatalf(`Expected error for modified tile`)
+	}
+}
@@ -0,0 +1,88 @@
+package polytopiamapmodel
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+)
+
+type SaveFormat int
+
+const (
+	SaveFormatCompressed SaveFormat = iota
+	SaveFormatDecompressed
+)
+
+func (f SaveFormat) String() string {
+	switch f {
+	case SaveFormatCompressed:
+		return "compressed"
+	case SaveFormatDecompressed:
+		return "decompressed"
+	}
+	return fmt.Sprintf("SaveFormat(%d)", int(f))
+}
+
+// The game doesn't create maps larger than this, so larger sizes in a header mean the data is corrupted
+const maxMapSize = 255
+
+// Versions above the last known layout are still accepted as a map header so they are reported as unsupported instead of unrecognized
+const maxPlausibleVersionDistance = 100
+
+// Open reads a save file in either format and returns the format that was detected.
+// The format can be used to save the file again in the same format.
+func Open(inputFilename string) (*PolytopiaSaveOutput, SaveFormat, error) {
+	return OpenWithOptions(inputFilename, ParseOptions{})
+}
+
+func OpenWithOptions(inputFilename string, options ParseOptions) (*PolytopiaSaveOutput, SaveFormat, error) {
+	fileData, err := os.ReadFile(inputFilename)
+	if err != nil {
+		return nil, SaveFormatCompressed, fmt.Errorf("failed to load state file: %w", err)
+	}
+	decompressedContents, saveFormat, err := decompressDetectedFormat(fileData)
+	if err != nil {
+		return nil, saveFormat, err
+	}
+	saveOutput, err := ParsePolytopiaFileWithOptions(io.NewSectionReader(bytes.NewReader(decompressedContents), 0, int64(len(decompressedContents))), options)
+	if err != nil {
+		return nil, saveFormat, err
+	}
+	return saveOutput, saveFormat, nil
+}
+
+// DetectSaveFormat checks whether the file data starts with a map header or with a compression header
+func DetectSaveFormat(fileData []byte) (SaveFormat, error) {
+	_, saveFormat, err := decompressDetectedFormat(fileData)
+	return saveFormat, err
+}
+
+func decompressDetectedFormat(fileData []byte) ([]byte, SaveFormat, error) {
+	// A compressed file starts with the compression header and the size difference, which is never a plausible version
+	if isPlausibleMapHeader(fileData) {
+		return fileData, SaveFormatDecompressed, nil
+	}
+
+	decompressedContents, err := decompressBytes(fileData, DefaultMaxDecompressedSize)
+	if err != nil {
+		return nil, SaveFormatCompressed, fmt.Errorf("file is not a decompressed save and failed to decompress: %w", err)
+	}
+	if !isPlausibleMapHeader(decompressedContents) {
+		return nil, SaveFormatCompressed, fmt.Errorf("decompressed data does not start with a map header")
+	}
+	return decompressedContents, SaveFormatCompressed, nil
+}
+
+func isPlausibleMapHeader(fileData []byte) bool {
+	mapHeaderOutput, err := DeserializeMapHeaderFromBytes(io.NewSectionReader(bytes.NewReader(fileData), 0, int64(len(fileData))))
+	if err != nil {
+		return false
+	}
+	gameVersion := int(mapHeaderOutput.MapHeaderInput.Version1)
+	lastLayout := versionLayouts[len(versionLayouts)-1]
+	if gameVersion == 0 || gameVersion > lastLayout.MaxVersion+maxPlausibleVersionDistance {
+		return false
+	}
+	return mapHeaderOutput.MapWidth <= maxMapSize && mapHeaderOutput.MapHeight <= maxMapSize
+}
@@ -0,0 +1,67 @@
+package polytopiamapmodel
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestOpenDetectsFormat(t *testing.T) {
+	decompressedData := buildDetailedTestSaveBytes(105)
+	compressedData, err := compressBytes(decompressedData)
+	if err != nil {
+		t.Fatalf(`Failed to compress: %v`, err)
+	}
+	storedData := append([]byte{0x00}, decompressedData...)
+
+	testCases := []struct {
+		fileData []byte
+		expected SaveFormat
+	}{
+		{decompressedData, SaveFormatDecompressed},
+		{compressedData, SaveFormatCompressed},
+		{storedData, SaveFormatCompressed},
+	}
+	for _, testCase := range testCases {
+		inputFilename := filepath.Join(t.TempDir(), "test.state")
+		if err := os.WriteFile(inputFilename, testCase.fileData, 0666); err != nil {
+			t.Fatal(err)
+		}
+
+		saveOutput, saveFormat, err := Open(inputFilename)
+		if err != nil {
+			t.Fatalf(`Failed to open %v file: %v`, testCase.expected, err)
+		}
+		if saveFormat != testCase.expected {
+			t.Fatalf(`Format not equal. Result = %v, expected = %v`, saveFormat, testCase.expected)
+		}
+		compareArrays(t, serializeTestSave(t, saveOutput), decompressedData)
+	}
+}
+
+func TestOpenReadOnlyFile(t *testing.T) {
+	inputFilename := writeTestSaveFile(t)
+	if err := os.Chmod(inputFilename, 0444); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadPolytopiaDecompressedFile(inputFilename); err != nil {
+		t.Fatalf(`Failed to read read-only file: %v`, err)
+	}
+	if _, _, err := Open(inputFilename); err != nil {
+		t.Fatalf(`Failed to open read-only file: %v`, err)
+	}
+}
+
+func TestDetectSaveFormatInvalidData(t *testing.T) {
+	testCases := [][]byte{
+		{},
+		[]byte("not a save file"),
+		append([]byte{0x00}, bytes.Repeat([]byte{0xFF}, 64)...),
+	}
+	for _, inputByteData := range testCases {
+		if _, err := DetectSaveFormat(inputByteData); err == nil {
+			t.Fatalf(`Expected error for data %v`, inputByteData)
+		}
+	}
+}
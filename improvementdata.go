@@ -1,56 +1,115 @@
 package polytopiamapmodel
 
 import (
+	"fmt"
 	"io"
+	"math"
 )
 
 type ImprovementData struct {
-	Level                  int
-	FoundedTurn            int
-	CurrentPopulation      int
-	TotalPopulation        int
-	Production             int
-	BaseScore              int
-	BorderSize             int // For cities, 1 is default, 2 is expanded border
-	UpgradeCount           int // For cities, seems to be -1 * (level - 1). Level 1 is starting point and no upgrades.
-	ConnectedPlayerCapital int
-	HasCityName            int
-	CityName               string
-	FoundedTribe           int
-	CityRewards            []int
-	RebellionFlag          int
-	RebellionBuffer        []int
+	Level                  int    `json:"level"`
+	FoundedTurn            int    `json:"foundedTurn"`
+	CurrentPopulation      int    `json:"currentPopulation"`
+	TotalPopulation        int    `json:"totalPopulation"`
+	Production             int    `json:"production"`
+	BaseScore              int    `json:"baseScore"`
+	BorderSize             int    `json:"borderSize"`   // For cities, 1 is default, 2 is expanded border
+	UpgradeCount           int    `json:"upgradeCount"` // For cities, seems to be -1 * (level - 1). Level 1 is starting point and no upgrades.
+	ConnectedPlayerCapital int    `json:"connectedPlayerCapital"`
+	HasCityName            int    `json:"hasCityName"`
+	CityName               string `json:"cityName"`
+	FoundedTribe           int    `json:"foundedTribe"`
+	CityRewards            []int  `json:"cityRewards"`
+	RebellionFlag          int    `json:"rebellionFlag"`
+	RebellionBuffer        []int  `json:"rebellionBuffer"`
 }
 
-func DeserializeImprovementDataFromBytes(streamReader *io.SectionReader) ImprovementData {
-	level := unsafeReadUint16(streamReader)
-	foundedTurn := unsafeReadUint16(streamReader)
-	currentPopulation := unsafeReadInt16(streamReader)
-	totalPopulation := unsafeReadUint16(streamReader)
-	production := unsafeReadInt16(streamReader)
-	baseScore := unsafeReadInt16(streamReader)
-	borderSize := unsafeReadInt16(streamReader)
-	upgradeCount := unsafeReadInt16(streamReader)
-	connectedPlayerCapital := unsafeReadUint8(streamReader)
-	hasCityName := unsafeReadUint8(streamReader)
+func DeserializeImprovementDataFromBytes(streamReader *io.SectionReader) (ImprovementData, error) {
+	improvementData, err := deserializeImprovementData(streamReader)
+	if err != nil {
+		return ImprovementData{}, withSection(err, "improvement")
+	}
+	return improvementData, nil
+}
+
+func deserializeImprovementData(streamReader *io.SectionReader) (ImprovementData, error) {
+	level, err := readUint16Safe(streamReader, "level")
+	if err != nil {
+		return ImprovementData{}, err
+	}
+	foundedTurn, err := readUint16Safe(streamReader, "founded turn")
+	if err != nil {
+		return ImprovementData{}, err
+	}
+	currentPopulation, err := readInt16Safe(streamReader, "current population")
+	if err != nil {
+		return ImprovementData{}, err
+	}
+	totalPopulation, err := readUint16Safe(streamReader, "total population")
+	if err != nil {
+		return ImprovementData{}, err
+	}
+	production, err := readInt16Safe(streamReader, "production")
+	if err != nil {
+		return ImprovementData{}, err
+	}
+	baseScore, err := readInt16Safe(streamReader, "base score")
+	if err != nil {
+		return ImprovementData{}, err
+	}
+	borderSize, err := readInt16Safe(streamReader, "border size")
+	if err != nil {
+		return ImprovementData{}, err
+	}
+	upgradeCount, err := readInt16Safe(streamReader, "upgrade count")
+	if err != nil {
+		return ImprovementData{}, err
+	}
+	connectedPlayerCapital, err := readUint8Safe(streamReader, "connected player capital")
+	if err != nil {
+		return ImprovementData{}, err
+	}
+	hasCityName, err := readUint8Safe(streamReader, "has city name")
+	if err != nil {
+		return ImprovementData{}, err
+	}
 	cityName := ""
 	if hasCityName == 1 {
-		cityName = readVarString(streamReader, "CityName")
+		cityName, err = readVarString(streamReader, "CityName")
+		if err != nil {
+			return ImprovementData{}, err
+		}
 	}
 
-	foundedTribe := unsafeReadUint8(streamReader)
+	foundedTribe, err := readUint8Safe(streamReader, "founded tribe")
+	if err != nil {
+		return ImprovementData{}, err
+	}
 
-	cityRewardsSize := unsafeReadUint16(streamReader)
+	cityRewardsSize, err := readUint16Safe(streamReader, "city rewards size")
+	if err != nil {
+		return ImprovementData{}, err
+	}
 	cityRewards := make([]int, cityRewardsSize)
 	for i := 0; i < int(cityRewardsSize); i++ {
-		cityReward := unsafeReadUint16(streamReader)
+		cityReward, err := readUint16Safe(streamReader, fmt.Sprintf("city reward %d", i))
+		if err != nil {
+			return ImprovementData{}, err
+		}
 		cityRewards[i] = int(cityReward)
 	}
 
-	rebellionFlag := unsafeReadUint16(streamReader)
+	rebellionFlag, err := readUint16Safe(streamReader, "rebellion flag")
+	if err != nil {
+		return ImprovementData{}, err
+	}
 	rebellionBuffer := []int{}
 	if rebellionFlag != 0 {
-		rebellionBuffer = convertByteListToInt(readFixedList(streamReader, 2))
+		buffer, err := readFixedList(streamReader, 2, "rebellion buffer")
+		if err != nil {
+			return ImprovementData{}, err
+		}
+		rebellionBuffer = convertByteListToInt(buffer)
 	}
 
 	return ImprovementData{
@@ -69,10 +128,33 @@ func DeserializeImprovementDataFromBytes(streamReader *io.SectionReader) Improve
 		CityRewards:            cityRewards,
 		RebellionFlag:          int(rebellionFlag),
 		RebellionBuffer:        rebellionBuffer,
-	}
+	}, nil
 }
 
-func SerializeImprovementDataToBytes(improvementData ImprovementData) []byte {
+// checkImprovementFields returns an error for the first value that doesn't fit in its field when the improvement is serialized
+func checkImprovementFields(improvementData ImprovementData) error {
+	return firstFieldError(
+		checkUint16Field("Level", improvementData.Level),
+		checkUint16Field("FoundedTurn", improvementData.FoundedTurn),
+		checkInt16Field("CurrentPopulation", improvementData.CurrentPopulation),
+		checkUint16Field("TotalPopulation", improvementData.TotalPopulation),
+		checkInt16Field("Production", improvementData.Production),
+		checkInt16Field("BaseScore", improvementData.BaseScore),
+		checkInt16Field("BorderSize", improvementData.BorderSize),
+		checkInt16Field("UpgradeCount", improvementData.UpgradeCount),
+		checkUint8Field("ConnectedPlayerCapital", improvementData.ConnectedPlayerCapital),
+		checkUint8Field("HasCityName", improvementData.HasCityName),
+		checkVarStringField("CityName", improvementData.CityName),
+		checkUint8Field("FoundedTribe", improvementData.FoundedTribe),
+		checkListFields("CityRewards", improvementData.CityRewards, math.MaxUint16, checkUint16Field),
+		checkUint16Field("RebellionFlag", improvementData.RebellionFlag),
+	)
+}
+
+func SerializeImprovementDataToBytes(improvementData ImprovementData) ([]byte, error) {
+	if err := checkImprovementFields(improvementData); err != nil {
+		return nil, err
+	}
 	data := make([]byte, 0)
 	data = append(data, ConvertUint16Bytes(int(improvementData.Level))...)
 	data = append(data, ConvertUint16Bytes(int(improvementData.FoundedTurn))...)
@@ -94,7 +176,7 @@ func SerializeImprovementDataToBytes(improvementData ImprovementData) []byte {
 	}
 	data = append(data, ConvertUint16Bytes(int(improvementData.RebellionFlag))...)
 	if improvementData.RebellionFlag != 0 {
-		data = append(data, ConvertByteList(improvementData.RebellionBuffer)...)
+		return appendByteList(data, "RebellionBuffer", improvementData.RebellionBuffer)
 	}
-	return data
+	return data, nil
 }
@@ -10,7 +10,10 @@ import (
 func TestDeserializeCityDataFromBytes(t *testing.T) {
 	inputByteData := []byte{3, 0, 0, 0, 1, 0, 6, 0, 1, 0, 0, 0, 1, 0, 254, 255, 1, 1, 4, 84, 101, 115, 116, 0, 2, 0, 4, 0, 7, 0, 0, 0}
 	streamReader := io.NewSectionReader(bytes.NewReader(inputByteData), 0, int64(len(inputByteData)))
-	result := DeserializeImprovementDataFromBytes(streamReader)
+	result, err := DeserializeImprovementDataFromBytes(streamReader)
+	if err != nil {
+		t.Fatalf(`Failed to deserialize: %v`, err)
+	}
 	expected := ImprovementData{
 		Level:                  3,
 		CurrentPopulation:      1,
@@ -36,7 +39,10 @@ func TestDeserializeCityDataFromBytes(t *testing.T) {
 func TestDeserializeImprovementDataFromBytes(t *testing.T) {
 	inputByteData := []byte{1, 0, 0, 0, 0, 0, 0, 0, 1, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}
 	streamReader := io.NewSectionReader(bytes.NewReader(inputByteData), 0, int64(len(inputByteData)))
-	result := DeserializeImprovementDataFromBytes(streamReader)
+	result, err := DeserializeImprovementDataFromBytes(streamReader)
+	if err != nil {
+		t.Fatalf(`Failed to deserialize: %v`, err)
+	}
 	expected := ImprovementData{
 		Level:                  1,
 		FoundedTurn:            0,
@@ -76,7 +82,10 @@ func TestSerializeCityDataToBytes(t *testing.T) {
 		RebellionFlag:          0,
 		RebellionBuffer:        []int{},
 	}
-	resultBytes := SerializeImprovementDataToBytes(cityData)
+	resultBytes, err := SerializeImprovementDataToBytes(cityData)
+	if err != nil {
+		t.Fatalf(`Failed to serialize: %v`, err)
+	}
 	expectedBytes := []byte{3, 0, 0, 0, 1, 0, 6, 0, 1, 0, 0, 0, 1, 0, 254, 255, 1, 1, 4, 84, 101, 115, 116, 0, 2, 0, 4, 0, 7, 0, 0, 0}
 	if !reflect.DeepEqual(resultBytes, expectedBytes) {
 		t.Fatalf(`result = %v, expected = %v`, resultBytes, expectedBytes)
@@ -100,7 +109,10 @@ func TestSerializeOtherImprovementDataToBytes(t *testing.T) {
 		RebellionFlag:          0,
 		RebellionBuffer:        []int{},
 	}
-	resultBytes := SerializeImprovementDataToBytes(improvementData)
+	resultBytes, err := SerializeImprovementDataToBytes(improvementData)
+	if err != nil {
+		t.Fatalf(`Failed to serialize: %v`, err)
+	}
 	expectedBytes := []byte{1, 0, 0, 0, 0, 0, 0, 0, 1, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}
 	if !reflect.DeepEqual(resultBytes, expectedBytes) {
 		t.Fatalf(`result = %v, expected = %v`, resultBytes, expectedBytes)
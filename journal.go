@@ -0,0 +1,288 @@
+package polytopiamapmodel
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+type JournalBlock string
+
+const (
+	JournalBlockMapHeader JournalBlock = "mapHeader"
+	JournalBlockTile      JournalBlock = "tile"
+	JournalBlockMap       JournalBlock = "map" // all tiles, used when the map dimensions change
+	JournalBlockPlayers   JournalBlock = "players"
+	JournalBlockStateGap  JournalBlock = "stateGap" // unknown bytes after a state
+	JournalBlockActions   JournalBlock = "actions"  // action list with its count, shared by both states
+	JournalBlockTrailer   JournalBlock = "trailer"  // unknown bytes after the action list, shared by both states
+)
+
+// BlockChange is the data of one block of the decompressed file before and after an edit
+type BlockChange struct {
+	Block   JournalBlock
+	Initial bool // block is in the initial state instead of the current state, not used by the actions and trailer
+	X       int  // tile position, only used by JournalBlockTile
+	Y       int
+	Before  []byte
+	After   []byte
+}
+
+func (change BlockChange) String() string {
+	state := mapState{initial: change.Initial}
+	if change.Block == JournalBlockActions || change.Block == JournalBlockTrailer {
+		return string(change.Block)
+	}
+	if change.Block == JournalBlockTile {
+		return fmt.Sprintf("tile (%v, %v) in %v", change.X, change.Y, state)
+	}
+	return fmt.Sprintf("%v in %v", change.Block, state)
+}
+
+type JournalEntry struct {
+	Operation string
+	Changes   []BlockChange
+}
+
+// EditJournal records the blocks changed by each edit to a decompressed save file so edits can be undone.
+// The journal is saved next to the save file after every change.
+type EditJournal struct {
+	InputFilename string `json:"-"`
+	Entries       []JournalEntry
+	Position      int // number of entries that are applied, entries after Position can be redone
+}
+
+func JournalFilename(inputFilename string) string {
+	return inputFilename + ".journal.json"
+}
+
+// OpenEditJournal loads the journal saved next to the save file or starts a new journal if there is none
+func OpenEditJournal(inputFilename string) (*EditJournal, error) {
+	journal := &EditJournal{InputFilename: inputFilename}
+	journalContents, err := os.ReadFile(JournalFilename(inputFilename))
+	if errors.Is(err, os.ErrNotExist) {
+		return journal, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load journal: %w", err)
+	}
+	if err := json.Unmarshal(journalContents, journal); err != nil {
+		return nil, fmt.Errorf("failed to parse journal: %w", err)
+	}
+	if journal.Position < 0 || journal.Position > len(journal.Entries) {
+		return nil, fmt.Errorf("journal position %v is outside the %v entries", journal.Position, len(journal.Entries))
+	}
+	return journal, nil
+}
+
+func (journal *EditJournal) save() error {
+	journalContents, err := json.MarshalIndent(journal, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := WriteFileAtomic(JournalFilename(journal.InputFilename), journalContents); err != nil {
+		return fmt.Errorf("failed to write journal: %w", err)
+	}
+	return nil
+}
+
+// Record runs an edit, such as a call to ModifyTileTerrain or AddCityToTile, and records the blocks it changed.
+// Entries that were undone can no longer be redone after a new edit is recorded.
+// If the edit fails after writing part of its changes, the written blocks are still recorded so they can be undone.
+func (journal *EditJournal) Record(operation string, edit func() error) error {
+	oldFileData, oldSave, err := readJournalSave(journal.InputFilename)
+	if err != nil {
+		return err
+	}
+	editErr := edit()
+	newFileData, newSave, err := readJournalSave(journal.InputFilename)
+	if err != nil {
+		return errors.Join(editErr, err)
+	}
+
+	changes := diffJournalBlocks(oldFileData, oldSave, newFileData, newSave)
+	if len(changes) == 0 {
+		return editErr
+	}
+	journal.Entries = append(journal.Entries[:journal.Position], JournalEntry{Operation: operation, Changes: changes})
+	journal.Position = len(journal.Entries)
+	return errors.Join(editErr, journal.save())
+}
+
+// Undo restores the blocks changed by the last applied entry
+func (journal *EditJournal) Undo() error {
+	if journal.Position == 0 {
+		return fmt.Errorf("nothing to undo")
+	}
+	if err := applyBlockChanges(journal.InputFilename, journal.Entries[journal.Position-1].Changes, false); err != nil {
+		return err
+	}
+	journal.Position--
+	return journal.save()
+}
+
+// Redo applies the entry after the last applied entry again
+func (journal *EditJournal) Redo() error {
+	if journal.Position == len(journal.Entries) {
+		return fmt.Errorf("nothing to redo")
+	}
+	if err := applyBlockChanges(journal.InputFilename, journal.Entries[journal.Position].Changes, true); err != nil {
+		return err
+	}
+	journal.Position++
+	return journal.save()
+}
+
+// Revert undoes one applied entry without undoing the entries after it.
+// It fails if a later entry changed the same blocks. The revert is recorded as a new entry so it can be undone.
+func (journal *EditJournal) Revert(index int) error {
+	if index < 0 || index >= journal.Position {
+		return fmt.Errorf("entry %v is not applied, %v entries are applied", index, journal.Position)
+	}
+	entry := journal.Entries[index]
+	if err := applyBlockChanges(journal.InputFilename, entry.Changes, false); err != nil {
+		return err
+	}
+
+	revertChanges := make([]BlockChange, len(entry.Changes))
+	for i, change := range entry.Changes {
+		revertChanges[i] = change
+		revertChanges[i].Before, revertChanges[i].After = change.After, change.Before
+	}
+	journal.Entries = append(journal.Entries[:journal.Position], JournalEntry{
+		Operation: fmt.Sprintf("Revert %v", entry.Operation),
+		Changes:   revertChanges,
+	})
+	journal.Position = len(journal.Entries)
+	return journal.save()
+}
+
+func readJournalSave(inputFilename string) ([]byte, *PolytopiaSaveOutput, error) {
+	fileData, err := os.ReadFile(inputFilename)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to load save state: %w", err)
+	}
+	saveOutput, err := ParsePolytopiaFile(io.NewSectionReader(bytes.NewReader(fileData), 0, int64(len(fileData))))
+	if err != nil {
+		return nil, nil, err
+	}
+	return fileData, saveOutput, nil
+}
+
+// diffJournalBlocks compares every block of the file, so an edit to any part of the save can be undone
+func diffJournalBlocks(oldFileData []byte, oldSave *PolytopiaSaveOutput, newFileData []byte, newSave *PolytopiaSaveOutput) []BlockChange {
+	changes := make([]BlockChange, 0)
+	addFileChange := func(block JournalBlock, initial bool, x int, y int, oldRange OffsetRange, newRange OffsetRange) {
+		before := oldFileData[oldRange.Start:oldRange.End]
+		after := newFileData[newRange.Start:newRange.End]
+		if !bytes.Equal(before, after) {
+			changes = append(changes, BlockChange{Block: block, Initial: initial, X: x, Y: y, Before: before, After: after})
+		}
+	}
+	for _, initial := range []bool{true, false} {
+		oldOffsets, newOffsets := stateOffsets(oldSave.Offsets, initial), stateOffsets(newSave.Offsets, initial)
+		addChange := func(block JournalBlock, x int, y int, oldRange OffsetRange, newRange OffsetRange) {
+			addFileChange(block, initial, x, y, oldRange, newRange)
+		}
+
+		addChange(JournalBlockMapHeader, 0, 0, oldOffsets.MapHeader, newOffsets.MapHeader)
+		if !sameMapDimensions(oldOffsets.Tiles, newOffsets.Tiles) {
+			addChange(JournalBlockMap, 0, 0, oldOffsets.Map, newOffsets.Map)
+		} else {
+			for y := range oldOffsets.Tiles {
+				for x := range oldOffsets.Tiles[y] {
+					addChange(JournalBlockTile, x, y, oldOffsets.Tiles[y][x], newOffsets.Tiles[y][x])
+				}
+			}
+		}
+		addChange(JournalBlockPlayers, 0, 0, oldOffsets.Players, newOffsets.Players)
+		addChange(JournalBlockStateGap, 0, 0, stateGapRange(oldSave.Offsets, initial), stateGapRange(newSave.Offsets, initial))
+	}
+	addFileChange(JournalBlockActions, false, 0, 0, oldSave.Offsets.Actions, newSave.Offsets.Actions)
+	addFileChange(JournalBlockTrailer, false, 0, 0, oldSave.Offsets.Trailer, newSave.Offsets.Trailer)
+	return changes
+}
+
+func stateGapRange(offsetIndex OffsetIndex, initial bool) OffsetRange {
+	if initial {
+		return offsetIndex.InitialStateGap
+	}
+	return offsetIndex.CurrentStateGap
+}
+
+func sameMapDimensions(oldTiles [][]OffsetRange, newTiles [][]OffsetRange) bool {
+	if len(oldTiles) != len(newTiles) {
+		return false
+	}
+	for i := range oldTiles {
+		if len(oldTiles[i]) != len(newTiles[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func getJournalBlockRange(offsetIndex OffsetIndex, change BlockChange) (OffsetRange, error) {
+	stateOffsets := stateOffsets(offsetIndex, change.Initial)
+	switch change.Block {
+	case JournalBlockMapHeader:
+		return stateOffsets.MapHeader, nil
+	case JournalBlockTile:
+		if change.Y < 0 || change.Y >= len(stateOffsets.Tiles) || change.X < 0 || change.X >= len(stateOffsets.Tiles[change.Y]) {
+			return OffsetRange{}, fmt.Errorf("%v is outside the map", change)
+		}
+		return stateOffsets.Tiles[change.Y][change.X], nil
+	case JournalBlockMap:
+		return stateOffsets.Map, nil
+	case JournalBlockPlayers:
+		return stateOffsets.Players, nil
+	case JournalBlockStateGap:
+		return stateGapRange(offsetIndex, change.Initial), nil
+	case JournalBlockActions:
+		return offsetIndex.Actions, nil
+	case JournalBlockTrailer:
+		return offsetIndex.Trailer, nil
+	}
+	return OffsetRange{}, fmt.Errorf("unknown journal block: %v", change.Block)
+}
+
+// applyBlockChanges replaces the data of each block with After if redo is set or Before otherwise.
+// Every block must still contain the other value, so blocks changed by a later edit are not overwritten.
+// All blocks are written at once since the map header and the map are only valid together when the dimensions change.
+func applyBlockChanges(inputFilename string, changes []BlockChange, redo bool) error {
+	fileData, saveOutput, err := readJournalSave(inputFilename)
+	if err != nil {
+		return err
+	}
+
+	writes := make([]fileSection, len(changes))
+	for i, change := range changes {
+		expectedData, newData := change.After, change.Before
+		if redo {
+			expectedData, newData = change.Before, change.After
+		}
+		offsetRange, err := getJournalBlockRange(saveOutput.Offsets, change)
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(fileData[offsetRange.Start:offsetRange.End], expectedData) {
+			return fmt.Errorf("%v has changed since the edit was recorded", change)
+		}
+		writes[i] = fileSection{offsetRange: offsetRange, data: newData}
+	}
+
+	updatedData, err := spliceSections(fileData, writes)
+	if err != nil {
+		return err
+	}
+	if _, err := ParsePolytopiaFile(io.NewSectionReader(bytes.NewReader(updatedData), 0, int64(len(updatedData)))); err != nil {
+		return fmt.Errorf("restored save is invalid: %w", err)
+	}
+	if err := WriteFileAtomic(inputFilename, updatedData); err != nil {
+		return fmt.Errorf("failed to write save state: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,160 @@
+package polytopiamapmodel
+
+import (
+	"os"
+	"reflect"
+	"testing"
+)
+
+func readTestSaveFile(t *testing.T, inputFilename string) []byte {
+	fileData, err := os.ReadFile(inputFilename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fileData
+}
+
+func TestEditJournalUndoRedo(t *testing.T) {
+	inputFilename := writeTestSaveFile(t)
+	fileInfo := FileInfo{InputFilename: inputFilename, GameVersion: 104}
+	originalData := readTestSaveFile(t, inputFilename)
+
+	journal, err := OpenEditJournal(inputFilename)
+	if err != nil {
+		t.Fatalf(`Failed to open journal: %v`, err)
+	}
+	if err := journal.Record("ModifyTileTerrain", func() error { return ModifyTileTerrain(fileInfo, 1, 0, 4) }); err != nil {
+		t.Fatalf(`Failed to record edit: %v`, err)
+	}
+	terrainData := readTestSaveFile(t, inputFilename)
+	if err := journal.Record("AddCityToTile", func() error { return AddCityToTile(fileInfo, 0, 1, "Test City", 1) }); err != nil {
+		t.Fatalf(`Failed to record edit: %v`, err)
+	}
+	cityData := readTestSaveFile(t, inputFilename)
+	if len(journal.Entries) != 2 || len(journal.Entries[0].Changes) != 1 || journal.Entries[0].Changes[0].Block != JournalBlockTile {
+		t.Fatalf(`Journal entries = %+v, expected one tile change for the first edit`, journal.Entries)
+	}
+
+	if err := journal.Undo(); err != nil {
+		t.Fatalf(`Failed to undo: %v`, err)
+	}
+	compareArrays(t, readTestSaveFile(t, inputFilename), terrainData)
+	if err := journal.Undo(); err != nil {
+		t.Fatalf(`Failed to undo: %v`, err)
+	}
+	compareArrays(t, readTestSaveFile(t, inputFilename), originalData)
+	if err := journal.Undo(); err == nil {
+		t.Fatalf(`Undo with no applied entries should fail`)
+	}
+
+	// the journal is saved next to the save file
+	journal, err = OpenEditJournal(inputFilename)
+	if err != nil {
+		t.Fatalf(`Failed to open journal: %v`, err)
+	}
+	if journal.Position != 0 || len(journal.Entries) != 2 {
+		t.Fatalf(`Loaded journal position = %v, entries = %v, expected 0 and 2`, journal.Position, len(journal.Entries))
+	}
+	if err := journal.Redo(); err != nil {
+		t.Fatalf(`Failed to redo: %v`, err)
+	}
+	if err := journal.Redo(); err != nil {
+		t.Fatalf(`Failed to redo: %v`, err)
+	}
+	compareArrays(t, readTestSaveFile(t, inputFilename), cityData)
+	if err := journal.Redo(); err == nil {
+		t.Fatalf(`Redo with no undone entries should fail`)
+	}
+}
+
+func TestEditJournalRevert(t *testing.T) {
+	inputFilename := writeTestSaveFile(t)
+	fileInfo := FileInfo{InputFilename: inputFilename, GameVersion: 104}
+	originalOutput, err := ReadPolytopiaDecompressedFile(inputFilename)
+	if err != nil {
+		t.Fatalf(`Failed to read save: %v`, err)
+	}
+
+	journal, err := OpenEditJournal(inputFilename)
+	if err != nil {
+		t.Fatalf(`Failed to open journal: %v`, err)
+	}
+	for _, edit := range []func() error{
+		func() error { return ModifyTileTerrain(fileInfo, 1, 0, 4) },
+		func() error { return AddCityToTile(fileInfo, 0, 1, "Test City", 1) },
+		func() error { return ModifyTileTerrain(fileInfo, 0, 1, 2) },
+	} {
+		if err := journal.Record("ModifyTileTerrain", edit); err != nil {
+			t.Fatalf(`Failed to record edit: %v`, err)
+		}
+	}
+
+	// the last edit changed the same tile as the city
+	if err := journal.Revert(1); err == nil {
+		t.Fatalf(`Revert of a tile changed by a later edit should fail`)
+	}
+	if err := journal.Revert(0); err != nil {
+		t.Fatalf(`Failed to revert: %v`, err)
+	}
+	saveOutput, err := ReadPolytopiaDecompressedFile(inputFilename)
+	if err != nil {
+		t.Fatalf(`Failed to read save: %v`, err)
+	}
+	if !reflect.DeepEqual(saveOutput.TileData[0][1], originalOutput.TileData[0][1]) || saveOutput.TileData[1][0].ImprovementData == nil || saveOutput.TileData[1][0].Terrain != 2 {
+		t.Fatalf(`Revert changed the wrong tiles, tile (1, 0): %+v, tile (0, 1): %+v`, saveOutput.TileData[0][1], saveOutput.TileData[1][0])
+	}
+	if journal.Position != 4 || journal.Entries[3].Operation != "Revert ModifyTileTerrain" {
+		t.Fatalf(`Revert was not recorded, entries: %+v`, journal.Entries)
+	}
+}
+
+func TestEditJournalUndoResize(t *testing.T) {
+	inputFilename := writeTestSaveFile(t)
+	originalData := readTestSaveFile(t, inputFilename)
+
+	journal, err := OpenEditJournal(inputFilename)
+	if err != nil {
+		t.Fatalf(`Failed to open journal: %v`, err)
+	}
+	if err := journal.Record("ExpandRows", func() error { return ExpandRows(FileInfo{InputFilename: inputFilename, GameVersion: 104}, 4) }); err != nil {
+		t.Fatalf(`Failed to record edit: %v`, err)
+	}
+	if err := journal.Undo(); err != nil {
+		t.Fatalf(`Failed to undo: %v`, err)
+	}
+	compareArrays(t, readTestSaveFile(t, inputFilename), originalData)
+}
+
+func TestEditJournalUndoActionEdit(t *testing.T) {
+	inputFilename := writeTestSaveFile(t)
+	originalData := readTestSaveFile(t, inputFilename)
+
+	journal, err := OpenEditJournal(inputFilename)
+	if err != nil {
+		t.Fatalf(`Failed to open journal: %v`, err)
+	}
+	if err := journal.Record("WriteActionsToFile", func() error {
+		return WriteActionsToFile(FileInfo{InputFilename: inputFilename, Target: EditTargetBoth}, actionList[:1])
+	}); err != nil {
+		t.Fatalf(`Failed to record edit: %v`, err)
+	}
+	editedData := readTestSaveFile(t, inputFilename)
+
+	blocks := make([]string, 0)
+	for _, change := range journal.Entries[0].Changes {
+		blocks = append(blocks, change.String())
+	}
+	expectedBlocks := []string{"mapHeader in initial state", "mapHeader in current state", "actions"}
+	if !reflect.DeepEqual(blocks, expectedBlocks) {
+		t.Fatalf(`Changed blocks = %v, expected = %v`, blocks, expectedBlocks)
+	}
+
+	if err := journal.Undo(); err != nil {
+		t.Fatalf(`Failed to undo: %v`, err)
+	}
+	compareArrays(t, readTestSaveFile(t, inputFilename), originalData)
+	if err := journal.Redo(); err != nil {
+		t.Fatalf(`Failed to redo: %v`, err)
+	}
+	compareArrays(t, readTestSaveFile(t, inputFilename), editedData)
+}
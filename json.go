@@ -1,58 +1,357 @@
 package polytopiamapmodel
 
 import (
+	"bytes"
 	"encoding/json"
-	"io/ioutil"
-	"log"
+	"fmt"
+	"io"
 	"os"
+	"strings"
 )
 
+type JsonExportOptions struct {
+	// Write terrain, climate, resource, improvement, unit, tech and tribe ids as names
+	UseEnumNames bool
+}
+
+type enumJsonField struct {
+	format func(int) string
+	parse  func(string) (int, error)
+}
+
+// Fields in the exported json that hold ids with names in enums.go, by json key
+var enumJsonFields = map[string]enumJsonField{
+	"terrain":           {format: func(id int) string { return TerrainType(id).String() }, parse: parseEnumId(ParseTerrainType)},
+	"climate":           {format: func(id int) string { return ClimateType(id).String() }, parse: parseEnumId(ParseClimateType)},
+	"resourceType":      {format: func(id int) string { return ResourceType(id).String() }, parse: parseEnumId(ParseResourceType)},
+	"improvementType":   {format: func(id int) string { return ImprovementType(id).String() }, parse: parseEnumId(ParseImprovementType)},
+	"unitType":          {format: func(id int) string { return UnitType(id).String() }, parse: parseEnumId(ParseUnitType)},
+	"availableTech":     {format: func(id int) string { return TechType(id).String() }, parse: parseEnumId(ParseTechType)},
+	"techType":          {format: func(id int) string { return TechType(id).String() }, parse: parseEnumId(ParseTechType)},
+	"tribe":             {format: func(id int) string { return TribeType(id).String() }, parse: parseEnumId(ParseTribeType)},
+	"disabledTribesArr": {format: func(id int) string { return TribeType(id).String() }, parse: parseEnumId(ParseTribeType)},
+	"unlockedTribesArr": {format: func(id int) string { return TribeType(id).String() }, parse: parseEnumId(ParseTribeType)},
+}
+
+func parseEnumId[T ~int](parseName func(string) (T, error)) func(string) (int, error) {
+	return func(name string) (int, error) {
+		value, err := parseName(name)
+		return int(value), err
+	}
+}
+
+// findEnumJsonField also accepts keys starting with an upper case letter, which were written before the keys were tagged
+func findEnumJsonField(key string) (enumJsonField, bool) {
+	if key == "" {
+		return enumJsonField{}, false
+	}
+	enumField, ok := enumJsonFields[strings.ToLower(key[:1])+key[1:]]
+	return enumField, ok
+}
+
+// JsonSchemaVersion is increased when a change to the exported json could break existing readers.
+// docs/polytopia-save.schema.json describes the current version.
+const JsonSchemaVersion = 2
+
+// Keys of fields that haven't been decoded were renamed in schema version 2, older files are converted when imported
+var jsonKeysBeforeVersion2 = map[string]string{
+	"unknown":         "undecodedBytes",
+	"unknownByte1":    "undecodedByte1",
+	"unknownInt2":     "undecodedInt2",
+	"unknownBuffer2":  "undecodedBytes2",
+	"unknownBuffer3":  "undecodedBytes3",
+	"unknownSettings": "undecodedSettings",
+	"unknownFloat1":   "undecodedFloat1",
+	"unknownFloat2":   "undecodedFloat2",
+}
+
+// PolytopiaSaveJson contains every field needed to build the save file again.
+// Offsets and derived maps such as TribeCityMap are not included since they are rebuilt when the save is parsed.
 type PolytopiaSaveJson struct {
-	GameName        string
-	FileFormat      string
-	TileData        [][]TileData
-	PlayerData      []PlayerData
-	MapHeaderOutput MapHeaderOutput
+	SchemaVersion          int             `json:"schemaVersion"`
+	GameName               string          `json:"gameName"`
+	FileFormat             string          `json:"fileFormat"`
+	GameVersion            int             `json:"gameVersion"`
+	InitialMapHeaderOutput MapHeaderOutput `json:"initialMapHeaderOutput"`
+	InitialTileData        [][]TileData    `json:"initialTileData"`
+	InitialPlayerData      []PlayerData    `json:"initialPlayerData"`
+	InitialStateGap        []byte          `json:"initialStateGap"`
+	TileData               [][]TileData    `json:"tileData"`
+	PlayerData             []PlayerData    `json:"playerData"`
+	MapHeaderOutput        MapHeaderOutput `json:"mapHeaderOutput"`
+	CurrentStateGap        []byte          `json:"currentStateGap"`
+	Actions                []ReplayAction  `json:"actions"`
+	Trailer                []byte          `json:"trailer"`
 }
 
-func ImportPolytopiaDataFromJson(inputFilename string) *PolytopiaSaveJson {
-	jsonFile, err := os.Open(inputFilename)
+// ImportPolytopiaDataFromJson reads a json file written by ExportPolytopiaJsonFile without rebuilding the save
+func ImportPolytopiaDataFromJson(inputFilename string) (*PolytopiaSaveJson, error) {
+	jsonContents, err := os.ReadFile(inputFilename)
 	if err != nil {
-		log.Fatal("Failed to open json file", err)
+		return nil, fmt.Errorf("failed to open json file: %w", err)
 	}
-	defer jsonFile.Close()
+	polytopiaSaveJson, err := parsePolytopiaJson(jsonContents)
+	if err != nil {
+		return nil, fmt.Errorf("the json data in %v is missing or incorrect: %w", inputFilename, err)
+	}
+	return polytopiaSaveJson, nil
+}
 
-	jsonContents, err := ioutil.ReadAll(jsonFile)
+func parsePolytopiaJson(jsonContents []byte) (*PolytopiaSaveJson, error) {
+	jsonData, err := decodeJsonValue(jsonContents)
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
+	}
+	if readJsonSchemaVersion(jsonData) < 2 {
+		renameJsonKeys(jsonData, jsonKeysBeforeVersion2)
+	}
+	// enum names are converted back to ids, so files exported with names can be imported
+	if err := convertJsonEnumValue(jsonData, false); err != nil {
+		return nil, fmt.Errorf("failed to read enum names: %w", err)
+	}
+	if jsonContents, err = json.Marshal(jsonData); err != nil {
+		return nil, err
 	}
 
 	var polytopiaSaveJson *PolytopiaSaveJson
-	json.Unmarshal(jsonContents, &polytopiaSaveJson)
-
+	if err := json.Unmarshal(jsonContents, &polytopiaSaveJson); err != nil {
+		return nil, err
+	}
 	if polytopiaSaveJson == nil {
-		log.Fatal("The json data in " + inputFilename + " is missing or incorrect")
+		return nil, fmt.Errorf("json data is empty")
+	}
+	if polytopiaSaveJson.SchemaVersion > JsonSchemaVersion {
+		return nil, fmt.Errorf("json schema version %v is newer than the supported version %v",
+			polytopiaSaveJson.SchemaVersion, JsonSchemaVersion)
 	}
+	return polytopiaSaveJson, nil
+}
 
-	return polytopiaSaveJson
+// ImportPolytopiaSaveFromJson reads a json file written by ExportPolytopiaJsonFile and rebuilds the save.
+// The result is parsed again, so hand edits that would produce an invalid save are reported as errors.
+func ImportPolytopiaSaveFromJson(inputFilename string) (*PolytopiaSaveOutput, error) {
+	polytopiaSaveJson, err := ImportPolytopiaDataFromJson(inputFilename)
+	if err != nil {
+		return nil, err
+	}
+	return polytopiaSaveJson.ToSaveOutput()
 }
 
-func ExportPolytopiaJsonFile(saveOutput *PolytopiaSaveOutput, outputFilename string) {
+// ToSaveOutput serializes the json data and parses the result.
+// A value that doesn't fit in its field in the save, such as an owner over 255, is reported as an error.
+func (polytopiaSaveJson *PolytopiaSaveJson) ToSaveOutput() (*PolytopiaSaveOutput, error) {
+	if polytopiaSaveJson.GameVersion == 0 || polytopiaSaveJson.InitialTileData == nil || polytopiaSaveJson.Actions == nil {
+		return nil, fmt.Errorf("json data doesn't contain the game version, initial state and actions, export it again to include them")
+	}
+	if _, _, err := readableVersionLayout(polytopiaSaveJson.GameVersion, false); err != nil {
+		return nil, err
+	}
+
+	fileData, err := SerializePolytopiaSave(&PolytopiaSaveOutput{
+		GameVersion:            polytopiaSaveJson.GameVersion,
+		InitialMapHeaderOutput: polytopiaSaveJson.InitialMapHeaderOutput,
+		InitialTileData:        polytopiaSaveJson.InitialTileData,
+		InitialPlayerData:      polytopiaSaveJson.InitialPlayerData,
+		InitialStateGap:        polytopiaSaveJson.InitialStateGap,
+		MapHeaderOutput:        polytopiaSaveJson.MapHeaderOutput,
+		TileData:               polytopiaSaveJson.TileData,
+		PlayerData:             polytopiaSaveJson.PlayerData,
+		CurrentStateGap:        polytopiaSaveJson.CurrentStateGap,
+		Actions:                polytopiaSaveJson.Actions,
+		Trailer:                polytopiaSaveJson.Trailer,
+	})
+	if err != nil {
+		return nil, err
+	}
+	saveOutput, err := ParsePolytopiaFile(io.NewSectionReader(bytes.NewReader(fileData), 0, int64(len(fileData))))
+	if err != nil {
+		return nil, fmt.Errorf("json data doesn't produce a valid save: %w", err)
+	}
+	return saveOutput, nil
+}
+
+// ConvertJsonToStateFile imports a json file and writes it as a compressed .state file that can be loaded in the game
+func ConvertJsonToStateFile(inputFilename string, outputFilename string) error {
+	saveOutput, err := ImportPolytopiaSaveFromJson(inputFilename)
+	if err != nil {
+		return err
+	}
+	fileData, err := SerializePolytopiaSave(saveOutput)
+	if err != nil {
+		return err
+	}
+	compressedContents, err := compressBytes(fileData)
+	if err != nil {
+		return fmt.Errorf("failed to compress save: %w", err)
+	}
+	return WriteFileAtomic(outputFilename, compressedContents)
+}
+
+func ExportPolytopiaJsonFile(saveOutput *PolytopiaSaveOutput, outputFilename string) error {
+	return ExportPolytopiaJsonFileWithOptions(saveOutput, outputFilename, JsonExportOptions{})
+}
+
+func ExportPolytopiaJsonFileWithOptions(saveOutput *PolytopiaSaveOutput, outputFilename string, options JsonExportOptions) error {
 	polytopiaJson := &PolytopiaSaveJson{
-		GameName:        "Battle of Polytopia",
-		FileFormat:      "Polytopia Save State",
-		TileData:        saveOutput.TileData,
-		PlayerData:      saveOutput.PlayerData,
-		MapHeaderOutput: saveOutput.MapHeaderOutput,
+		SchemaVersion:          JsonSchemaVersion,
+		GameName:               "Battle of Polytopia",
+		FileFormat:             "Polytopia Save State",
+		GameVersion:            saveOutput.GameVersion,
+		InitialMapHeaderOutput: saveOutput.InitialMapHeaderOutput,
+		InitialTileData:        saveOutput.InitialTileData,
+		InitialPlayerData:      saveOutput.InitialPlayerData,
+		InitialStateGap:        saveOutput.InitialStateGap,
+		TileData:               saveOutput.TileData,
+		PlayerData:             saveOutput.PlayerData,
+		MapHeaderOutput:        saveOutput.MapHeaderOutput,
+		CurrentStateGap:        saveOutput.CurrentStateGap,
+		Actions:                saveOutput.Actions,
+		Trailer:                saveOutput.Trailer,
 	}
 
 	file, err := json.MarshalIndent(polytopiaJson, "", " ")
 	if err != nil {
-		log.Fatal("Failed to marshal data: ", err)
+		return fmt.Errorf("failed to marshal data: %w", err)
+	}
+	if options.UseEnumNames {
+		file, err = convertJsonEnums(file, true)
+		if err != nil {
+			return fmt.Errorf("failed to convert enum ids to names: %w", err)
+		}
 	}
 
-	err = ioutil.WriteFile(outputFilename, file, 0644)
+	if err := WriteFileAtomic(outputFilename, file); err != nil {
+		return fmt.Errorf("failed to write %v: %w", outputFilename, err)
+	}
+	return nil
+}
+
+// decodeJsonValue decodes json data into maps and lists, keeping numbers as json.Number
+func decodeJsonValue(jsonContents []byte) (interface{}, error) {
+	decoder := json.NewDecoder(bytes.NewReader(jsonContents))
+	decoder.UseNumber()
+	var jsonData interface{}
+	if err := decoder.Decode(&jsonData); err != nil {
+		return nil, err
+	}
+	return jsonData, nil
+}
+
+// readJsonSchemaVersion returns 0 for files written before the schema was versioned
+func readJsonSchemaVersion(jsonData interface{}) int {
+	value, ok := jsonData.(map[string]interface{})
+	if !ok {
+		return 0
+	}
+	number, ok := value["schemaVersion"].(json.Number)
+	if !ok {
+		return 0
+	}
+	schemaVersion, err := number.Int64()
+	if err != nil {
+		return 0
+	}
+	return int(schemaVersion)
+}
+
+// renameJsonKeys also renames keys starting with an upper case letter, which were written before the keys were tagged
+func renameJsonKeys(jsonData interface{}, newKeys map[string]string) {
+	switch value := jsonData.(type) {
+	case map[string]interface{}:
+		keys := make([]string, 0, len(value))
+		for key := range value {
+			keys = append(keys, key)
+		}
+		for _, key := range keys {
+			child := value[key]
+			renameJsonKeys(child, newKeys)
+			if key == "" {
+				continue
+			}
+			if newKey, ok := newKeys[strings.ToLower(key[:1])+key[1:]]; ok {
+				delete(value, key)
+				value[newKey] = child
+			}
+		}
+	case []interface{}:
+		for i := 0; i < len(value); i++ {
+			renameJsonKeys(value[i], newKeys)
+		}
+	}
+}
+
+// convertJsonEnums converts the enum fields in json data from ids to names or from names to ids
+func convertJsonEnums(jsonContents []byte, toNames bool) ([]byte, error) {
+	jsonData, err := decodeJsonValue(jsonContents)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := convertJsonEnumValue(jsonData, toNames); err != nil {
+		return nil, err
+	}
+	return json.MarshalIndent(jsonData, "", " ")
+}
+
+func convertJsonEnumValue(jsonData interface{}, toNames bool) error {
+	switch value := jsonData.(type) {
+	case map[string]interface{}:
+		for key, child := range value {
+			enumField, ok := findEnumJsonField(key)
+			if !ok {
+				if err := convertJsonEnumValue(child, toNames); err != nil {
+					return err
+				}
+				continue
+			}
+
+			if list, isList := child.([]interface{}); isList {
+				for i := 0; i < len(list); i++ {
+					convertedValue, err := convertJsonEnumField(list[i], enumField, toNames)
+					if err != nil {
+						return fmt.Errorf("%v[%v]: %w", key, i, err)
+					}
+					list[i] = convertedValue
+				}
+			} else {
+				convertedValue, err := convertJsonEnumField(child, enumField, toNames)
+				if err != nil {
+					return fmt.Errorf("%v: %w", key, err)
+				}
+				value[key] = convertedValue
+			}
+		}
+	case []interface{}:
+		for i := 0; i < len(value); i++ {
+			if err := convertJsonEnumValue(value[i], toNames); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func convertJsonEnumField(jsonValue interface{}, enumField enumJsonField, toNames bool) (interface{}, error) {
+	if toNames {
+		number, ok := jsonValue.(json.Number)
+		if !ok {
+			return jsonValue, nil
+		}
+		id, err := number.Int64()
+		// -1 is used when the tile has no resource or improvement
+		if err != nil || id < 0 {
+			return jsonValue, nil
+		}
+		return enumField.format(int(id)), nil
+	}
+
+	name, ok := jsonValue.(string)
+	if !ok {
+		return jsonValue, nil
+	}
+	id, err := enumField.parse(name)
 	if err != nil {
-		log.Fatal("Error writing to ", outputFilename)
+		return nil, err
 	}
+	return id, nil
 }
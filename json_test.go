@@ -0,0 +1,200 @@
+package polytopiamapmodel
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestJsonRoundTrip(t *testing.T) {
+	fixtures := map[string][]byte{
+		"empty map":      buildTestSaveBytes(),
+		"version 104":    buildDetailedTestSaveBytes(104),
+		"version 105":    buildDetailedTestSaveBytes(105),
+		"unknown action": append(buildTestSaveBytes()[:len(buildTestSaveBytes())-3], 19, 0, 1, 2, 3),
+	}
+
+	for name, inputByteData := range fixtures {
+		for _, useEnumNames := range []bool{false, true} {
+			saveOutput, err := ParsePolytopiaFile(io.NewSectionReader(bytes.NewReader(inputByteData), 0, int64(len(inputByteData))))
+			if err != nil {
+				t.Fatalf(`Failed to parse %v: %v`, name, err)
+			}
+			jsonFilename := filepath.Join(t.TempDir(), "save.json")
+			if err := ExportPolytopiaJsonFileWithOptions(saveOutput, jsonFilename, JsonExportOptions{UseEnumNames: useEnumNames}); err != nil {
+				t.Fatalf(`Failed to export json: %v`, err)
+			}
+
+			stateFilename := filepath.Join(t.TempDir(), "save.state")
+			if err := ConvertJsonToStateFile(jsonFilename, stateFilename); err != nil {
+				t.Fatalf(`Failed to convert json of %v: %v`, name, err)
+			}
+			resultBytes, err := readDecompressedContents(stateFilename)
+			if err != nil {
+				t.Fatalf(`Failed to decompress %v: %v`, name, err)
+			}
+			if !bytes.Equal(resultBytes, inputByteData) {
+				t.Errorf(`Json round trip of %v with enum names %v doesn't match, size = %v, expected size = %v`,
+					name, useEnumNames, len(resultBytes), len(inputByteData))
+				findArrayDifference(resultBytes, inputByteData)
+			}
+		}
+	}
+}
+
+func TestReplayActionJson(t *testing.T) {
+	actions := []ReplayAction{
+		{Index: 0, Turn: 1, Action: ActionMove{PlayerId: 1, OldPosition: [2]uint32{1, 2}, NewPosition: [2]uint32{2, 3}, UnitId: 4}},
+		{Index: 1, Turn: 1, Action: ActionEndTurn{PlayerId: 255}},
+		{Index: 2, Turn: 2, Action: RawAction{Type: 19, Payload: []byte{1, 2, 3}, SkippedActions: 1}},
+	}
+	jsonContents, err := json.Marshal(actions)
+	if err != nil {
+		t.Fatalf(`Failed to marshal actions: %v`, err)
+	}
+	for _, expected := range []string{`"type":"Move"`, `"type":"EndTurn"`, `"type":"Raw"`} {
+		if !strings.Contains(string(jsonContents), expected) {
+			t.Fatalf(`Action json %s doesn't contain %v`, jsonContents, expected)
+		}
+	}
+
+	var result []ReplayAction
+	if err := json.Unmarshal(jsonContents, &result); err != nil {
+		t.Fatalf(`Failed to unmarshal actions: %v`, err)
+	}
+	if !reflect.DeepEqual(result, actions) {
+		t.Fatalf(`Actions = %+v, expected = %+v`, result, actions)
+	}
+
+	if err := json.Unmarshal([]byte(`[{"Index": 0, "Turn": 1, "Type": "Teleport", "Action": {}}]`), &result); err == nil {
+		t.Fatalf(`Expected error for unknown action type`)
+	}
+}
+
+func TestImportJsonWithoutInitialState(t *testing.T) {
+	jsonFilename := filepath.Join(t.TempDir(), "save.json")
+	if err := os.WriteFile(jsonFilename, []byte(`{"GameName": "Battle of Polytopia", "TileData": [], "PlayerData": []}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ImportPolytopiaSaveFromJson(jsonFilename); err == nil || !strings.Contains(err.Error(), "export it again") {
+		t.Fatalf(`Import error = %v, expected missing data error`, err)
+	}
+}
+
+func TestJsonErrorsAreReturned(t *testing.T) {
+	saveOutput, err := ReadPolytopiaDecompressedFile(writeTestSaveFile(t))
+	if err != nil {
+		t.Fatalf(`Failed to read save: %v`, err)
+	}
+	missingDirectory := filepath.Join(t.TempDir(), "missing", "save.json")
+	if err := ExportPolytopiaJsonFile(saveOutput, missingDirectory); err == nil {
+		t.Fatalf(`Expected error when writing to a missing directory`)
+	}
+	if _, err := ImportPolytopiaDataFromJson(missingDirectory); err == nil {
+		t.Fatalf(`Expected error when reading a missing file`)
+	}
+
+	jsonFilename := filepath.Join(t.TempDir(), "save.json")
+	if err := os.WriteFile(jsonFilename, []byte(`{"tileData": `), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ImportPolytopiaDataFromJson(jsonFilename); err == nil || !strings.Contains(err.Error(), "missing or incorrect") {
+		t.Fatalf(`Import error = %v, expected invalid json error`, err)
+	}
+}
+
+func TestImportJsonVersion1Keys(t *testing.T) {
+	inputByteData := buildDetailedTestSaveBytes(105)
+	saveOutput, err := ParsePolytopiaFile(io.NewSectionReader(bytes.NewReader(inputByteData), 0, int64(len(inputByteData))))
+	if err != nil {
+		t.Fatalf(`Failed to parse: %v`, err)
+	}
+	jsonFilename := filepath.Join(t.TempDir(), "save.json")
+	if err := ExportPolytopiaJsonFile(saveOutput, jsonFilename); err != nil {
+		t.Fatalf(`Failed to export json: %v`, err)
+	}
+
+	// write the file with the keys used before schema version 2
+	jsonContents, err := os.ReadFile(jsonFilename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jsonData, err := decodeJsonValue(jsonContents)
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldKeys := make(map[string]string)
+	for oldKey, newKey := range jsonKeysBeforeVersion2 {
+		oldKeys[newKey] = oldKey
+	}
+	renameJsonKeys(jsonData, oldKeys)
+	jsonData.(map[string]interface{})["schemaVersion"] = 1
+	if jsonContents, err = json.Marshal(jsonData); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(jsonContents), "undecoded") || !strings.Contains(string(jsonContents), `"unknownBuffer2"`) {
+		t.Fatalf(`Keys were not renamed to the version 1 keys`)
+	}
+	if err := os.WriteFile(jsonFilename, jsonContents, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := ImportPolytopiaSaveFromJson(jsonFilename)
+	if err != nil {
+		t.Fatalf(`Failed to import version 1 json: %v`, err)
+	}
+	compareArrays(t, serializeTestSave(t, result), inputByteData)
+}
+
+func TestImportJsonRejectsValuesOutsideTheirFields(t *testing.T) {
+	inputByteData := buildDetailedTestSaveBytes(105)
+	saveOutput, err := ParsePolytopiaFile(io.NewSectionReader(bytes.NewReader(inputByteData), 0, int64(len(inputByteData))))
+	if err != nil {
+		t.Fatalf(`Failed to parse: %v`, err)
+	}
+	jsonFilename := filepath.Join(t.TempDir(), "save.json")
+	if err := ExportPolytopiaJsonFile(saveOutput, jsonFilename); err != nil {
+		t.Fatalf(`Failed to export json: %v`, err)
+	}
+	jsonContents, err := os.ReadFile(jsonFilename)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	editTests := []struct {
+		edit     func(jsonData map[string]interface{})
+		expected string
+	}{
+		{func(jsonData map[string]interface{}) {
+			jsonData["tileData"].([]interface{})[0].([]interface{})[1].(map[string]interface{})["owner"] = 300
+		}, "current state: tile (1, 0): Owner must be between 0 and 255, value is 300"},
+		{func(jsonData map[string]interface{}) {
+			jsonData["initialPlayerData"].([]interface{})[0].(map[string]interface{})["tribe"] = 300
+		}, "initial state: player 1: Tribe must be between 0 and 255, value is 300"},
+		{func(jsonData map[string]interface{}) {
+			jsonData["tileData"].([]interface{})[0].([]interface{})[0].(map[string]interface{})["terrain"] = 70000
+		}, "Terrain must be between 0 and 65535, value is 70000"},
+	}
+	for _, editTest := range editTests {
+		jsonData, err := decodeJsonValue(jsonContents)
+		if err != nil {
+			t.Fatal(err)
+		}
+		editTest.edit(jsonData.(map[string]interface{}))
+		editedContents, err := json.Marshal(jsonData)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(jsonFilename, editedContents, 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := ImportPolytopiaSaveFromJson(jsonFilename); err == nil || !strings.Contains(err.Error(), editTest.expected) {
+			t.Fatalf(`Import error = %v, expected %q`, err, editTest.expected)
+		}
+	}
+}
@@ -0,0 +1,193 @@
+package polytopiamapmodel
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+//go:generate go run ./cmd/jsonschema -o docs/polytopia-save.schema.json
+
+type jsonSchema map[string]interface{}
+
+// jsonSchemaBuilder converts Go types to json schemas. Structs are added to defs and referenced by name.
+type jsonSchemaBuilder struct {
+	defs jsonSchema
+}
+
+// BuildJsonSchema generates the json schema of the data written by ExportPolytopiaJsonFile.
+// Every field must have a json tag so renaming a Go field can't change the exported json.
+func BuildJsonSchema() ([]byte, error) {
+	builder := &jsonSchemaBuilder{defs: make(jsonSchema)}
+	rootSchema, err := builder.buildStructSchema(reflect.TypeOf(PolytopiaSaveJson{}))
+	if err != nil {
+		return nil, err
+	}
+	rootSchema["$schema"] = "https://json-schema.org/draft/2020-12/schema"
+	rootSchema["title"] = "Polytopia save"
+	rootSchema["description"] = fmt.Sprintf("Save file exported by polytopiamapmodelgo, schema version %v", JsonSchemaVersion)
+	rootSchema["$defs"] = builder.defs
+
+	var buffer bytes.Buffer
+	encoder := json.NewEncoder(&buffer)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(rootSchema); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+func (builder *jsonSchemaBuilder) buildSchema(valueType reflect.Type) (jsonSchema, error) {
+	if valueType == reflect.TypeOf(ReplayAction{}) {
+		if _, ok := builder.defs["ReplayAction"]; !ok {
+			if err := builder.addReplayActionSchema(); err != nil {
+				return nil, err
+			}
+		}
+		return jsonSchema{"$ref": "#/$defs/ReplayAction"}, nil
+	}
+
+	switch valueType.Kind() {
+	case reflect.Bool:
+		return jsonSchema{"type": "boolean"}, nil
+	case reflect.Int, reflect.Int64:
+		return jsonSchema{"type": "integer"}, nil
+	case reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		minimum, maximum := integerRange(valueType)
+		return jsonSchema{"type": "integer", "minimum": minimum, "maximum": maximum}, nil
+	case reflect.Float32, reflect.Float64:
+		return jsonSchema{"type": "number"}, nil
+	case reflect.String:
+		return jsonSchema{"type": "string"}, nil
+	case reflect.Pointer:
+		return builder.buildSchema(valueType.Elem())
+	case reflect.Slice:
+		if valueType.Elem().Kind() == reflect.Uint8 {
+			return jsonSchema{"type": "string", "contentEncoding": "base64"}, nil
+		}
+		itemSchema, err := builder.buildSchema(valueType.Elem())
+		if err != nil {
+			return nil, err
+		}
+		return jsonSchema{"type": "array", "items": itemSchema}, nil
+	case reflect.Array:
+		itemSchema, err := builder.buildSchema(valueType.Elem())
+		if err != nil {
+			return nil, err
+		}
+		return jsonSchema{"type": "array", "items": itemSchema, "minItems": valueType.Len(), "maxItems": valueType.Len()}, nil
+	case reflect.Struct:
+		return builder.buildStructRef(valueType)
+	}
+	return nil, fmt.Errorf("type %v can't be converted to a json schema", valueType)
+}
+
+func integerRange(valueType reflect.Type) (int64, int64) {
+	bits := valueType.Bits()
+	if valueType.Kind() >= reflect.Uint8 && valueType.Kind() <= reflect.Uint64 {
+		return 0, int64(uint64(math.MaxUint64) >> (64 - bits))
+	}
+	return -1 << (bits - 1), 1<<(bits-1) - 1
+}
+
+func (builder *jsonSchemaBuilder) buildStructRef(structType reflect.Type) (jsonSchema, error) {
+	if _, ok := builder.defs[structType.Name()]; !ok {
+		// add a placeholder first in case the struct refers to itself
+		builder.defs[structType.Name()] = jsonSchema{}
+		structSchema, err := builder.buildStructSchema(structType)
+		if err != nil {
+			return nil, err
+		}
+		builder.defs[structType.Name()] = structSchema
+	}
+	return jsonSchema{"$ref": "#/$defs/" + structType.Name()}, nil
+}
+
+func (builder *jsonSchemaBuilder) buildStructSchema(structType reflect.Type) (jsonSchema, error) {
+	properties := make(jsonSchema)
+	required := make([]string, 0)
+	for i := 0; i < structType.NumField(); i++ {
+		field := structType.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		tag, ok := field.Tag.Lookup("json")
+		if !ok {
+			return nil, fmt.Errorf("field %v.%v has no json tag", structType.Name(), field.Name)
+		}
+		name, options, _ := strings.Cut(tag, ",")
+		if name == "-" {
+			continue
+		}
+
+		fieldSchema, err := builder.buildSchema(field.Type)
+		if err != nil {
+			return nil, fmt.Errorf("%v.%v: %w", structType.Name(), field.Name, err)
+		}
+		if _, ok := findEnumJsonField(name); ok {
+			// fields with enum names can be written as names or ids
+			if fieldSchema["type"] == "array" {
+				fieldSchema["items"] = jsonSchema{"anyOf": []jsonSchema{fieldSchema["items"].(jsonSchema), {"type": "string"}}}
+			} else {
+				fieldSchema = jsonSchema{"anyOf": []jsonSchema{fieldSchema, {"type": "string"}}}
+			}
+		}
+		if strings.Contains(field.Name, "Unknown") {
+			fieldSchema["description"] = "Not decoded yet. Kept so the save can be rebuilt, the value should not be edited."
+		}
+		if name == "schemaVersion" {
+			fieldSchema["const"] = JsonSchemaVersion
+		}
+		properties[name] = fieldSchema
+		if options != "omitempty" {
+			required = append(required, name)
+		}
+	}
+	return jsonSchema{
+		"type":                 "object",
+		"properties":           properties,
+		"required":             required,
+		"additionalProperties": false,
+	}, nil
+}
+
+// addReplayActionSchema describes the type tagged json written by ReplayAction.MarshalJSON
+func (builder *jsonSchemaBuilder) addReplayActionSchema() error {
+	actionTypes := make([]ActionType, 0, len(actionTypeNames))
+	for actionType := range actionTypeNames {
+		actionTypes = append(actionTypes, actionType)
+	}
+	sort.Slice(actionTypes, func(i, j int) bool { return actionTypes[i] < actionTypes[j] })
+
+	typeNames := make([]string, 0, len(actionTypes)+1)
+	actionSchemas := make([]jsonSchema, 0, len(actionTypes)+1)
+	for _, actionType := range actionTypes {
+		actionSchema, err := builder.buildSchema(reflect.TypeOf(newEmptyAction(actionType)))
+		if err != nil {
+			return err
+		}
+		typeNames = append(typeNames, actionType.String())
+		actionSchemas = append(actionSchemas, actionSchema)
+	}
+	rawActionSchema, err := builder.buildSchema(reflect.TypeOf(RawAction{}))
+	if err != nil {
+		return err
+	}
+	typeNames = append(typeNames, rawActionJsonType)
+	actionSchemas = append(actionSchemas, rawActionSchema)
+
+	replayActionSchema, err := builder.buildStructSchema(reflect.TypeOf(replayActionJson{}))
+	if err != nil {
+		return err
+	}
+	properties := replayActionSchema["properties"].(jsonSchema)
+	properties["type"] = jsonSchema{"enum": typeNames}
+	properties["action"] = jsonSchema{"anyOf": actionSchemas}
+	builder.defs["ReplayAction"] = replayActionSchema
+	return nil
+}
@@ -0,0 +1,156 @@
+package polytopiamapmodel
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const jsonSchemaFilename = "docs/polytopia-save.schema.json"
+
+func TestJsonSchemaIsUpToDate(t *testing.T) {
+	schema, err := BuildJsonSchema()
+	if err != nil {
+		t.Fatalf(`Failed to build schema: %v`, err)
+	}
+	checkedInSchema, err := os.ReadFile(jsonSchemaFilename)
+	if err != nil {
+		t.Fatalf(`Failed to read %v: %v`, jsonSchemaFilename, err)
+	}
+	if !bytes.Equal(schema, checkedInSchema) {
+		t.Fatalf(`%v doesn't match the Go model, run "go generate" and increase JsonSchemaVersion if existing readers could break`, jsonSchemaFilename)
+	}
+}
+
+func TestExportedJsonMatchesSchema(t *testing.T) {
+	var schema map[string]interface{}
+	schemaContents, err := os.ReadFile(jsonSchemaFilename)
+	if err != nil {
+		t.Fatalf(`Failed to read %v: %v`, jsonSchemaFilename, err)
+	}
+	if err := json.Unmarshal(schemaContents, &schema); err != nil {
+		t.Fatalf(`Failed to parse schema: %v`, err)
+	}
+
+	fixtures := map[string][]byte{
+		"version 104":    buildDetailedTestSaveBytes(104),
+		"version 105":    buildDetailedTestSaveBytes(105),
+		"version 114":    buildDetailedTestSaveBytes(114),
+		"unknown action": append(buildTestSaveBytes()[:len(buildTestSaveBytes())-3], 19, 0, 1, 2, 3),
+	}
+	for name, inputByteData := range fixtures {
+		saveOutput, err := ParsePolytopiaFile(io.NewSectionReader(bytes.NewReader(inputByteData), 0, int64(len(inputByteData))))
+		if err != nil {
+			t.Fatalf(`Failed to parse %v: %v`, name, err)
+		}
+		saveOutput.Actions = append(saveOutput.Actions, ReplayAction{Action: ActionTrain{PlayerId: 1, UnitType: 2}})
+		for _, useEnumNames := range []bool{false, true} {
+			jsonFilename := filepath.Join(t.TempDir(), "save.json")
+			if err := ExportPolytopiaJsonFileWithOptions(saveOutput, jsonFilename, JsonExportOptions{UseEnumNames: useEnumNames}); err != nil {
+				t.Fatalf(`Failed to export json: %v`, err)
+			}
+			jsonContents, err := os.ReadFile(jsonFilename)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var jsonData interface{}
+			if err := json.Unmarshal(jsonContents, &jsonData); err != nil {
+				t.Fatal(err)
+			}
+			if err := validateJsonSchema(schema, schema, jsonData, "$"); err != nil {
+				t.Fatalf(`Exported json of %v with enum names %v doesn't match the schema: %v`, name, useEnumNames, err)
+			}
+		}
+	}
+}
+
+func TestJsonSchemaVersion(t *testing.T) {
+	if _, err := parsePolytopiaJson([]byte(fmt.Sprintf(`{"schemaVersion": %v}`, JsonSchemaVersion+1))); err == nil {
+		t.Fatalf(`Expected error for a newer schema version`)
+	}
+}
+
+// validateJsonSchema supports the subset of json schema used by BuildJsonSchema
+func validateJsonSchema(root map[string]interface{}, schema map[string]interface{}, value interface{}, path string) error {
+	if ref, ok := schema["$ref"].(string); ok {
+		return validateJsonSchema(root, root["$defs"].(map[string]interface{})[strings.TrimPrefix(ref, "#/$defs/")].(map[string]interface{}), value, path)
+	}
+	if anyOf, ok := schema["anyOf"].([]interface{}); ok {
+		for _, option := range anyOf {
+			if validateJsonSchema(root, option.(map[string]interface{}), value, path) == nil {
+				return nil
+			}
+		}
+		return fmt.Errorf("%v: %v doesn't match any schema", path, value)
+	}
+	if enum, ok := schema["enum"].([]interface{}); ok {
+		for _, option := range enum {
+			if reflect.DeepEqual(option, value) {
+				return nil
+			}
+		}
+		return fmt.Errorf("%v: %v isn't one of %v", path, value, enum)
+	}
+	if constValue, ok := schema["const"]; ok && !reflect.DeepEqual(constValue, value) {
+		return fmt.Errorf("%v: %v should be %v", path, value, constValue)
+	}
+
+	switch schema["type"] {
+	case "object":
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("%v: expected object", path)
+		}
+		properties := schema["properties"].(map[string]interface{})
+		for _, name := range schema["required"].([]interface{}) {
+			if _, ok := object[name.(string)]; !ok {
+				return fmt.Errorf("%v: %v is required", path, name)
+			}
+		}
+		for name, child := range object {
+			propertySchema, ok := properties[name]
+			if !ok {
+				return fmt.Errorf("%v: %v isn't in the schema", path, name)
+			}
+			if err := validateJsonSchema(root, propertySchema.(map[string]interface{}), child, path+"."+name); err != nil {
+				return err
+			}
+		}
+	case "array":
+		list, ok := value.([]interface{})
+		if !ok {
+			return fmt.Errorf("%v: expected array", path)
+		}
+		if minItems, ok := schema["minItems"].(float64); ok && (len(list) < int(minItems) || len(list) > int(schema["maxItems"].(float64))) {
+			return fmt.Errorf("%v: expected %v items, found %v", path, minItems, len(list))
+		}
+		for i, child := range list {
+			if err := validateJsonSchema(root, schema["items"].(map[string]interface{}), child, fmt.Sprintf("%v[%v]", path, i)); err != nil {
+				return err
+			}
+		}
+	case "integer", "number":
+		number, ok := value.(float64)
+		if !ok || (schema["type"] == "integer" && number != float64(int64(number))) {
+			return fmt.Errorf("%v: expected %v, found %v", path, schema["type"], value)
+		}
+		if minimum, ok := schema["minimum"].(float64); ok && (number < minimum || number > schema["maximum"].(float64)) {
+			return fmt.Errorf("%v: %v is out of range", path, number)
+		}
+	case "string":
+		if _, ok := value.(string); !ok {
+			return fmt.Errorf("%v: expected string, found %v", path, value)
+		}
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			return fmt.Errorf("%v: expected boolean, found %v", path, value)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,461 @@
+package polytopiamapmodel
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// LayoutField is one field of a save file with its position and raw bytes
+type LayoutField struct {
+	Section string
+	Field   string
+	Offset  int
+	Length  int
+	Bytes   []byte
+	Value   string
+}
+
+func (field LayoutField) Hex() string {
+	return hex.EncodeToString(field.Bytes)
+}
+
+// IsUnknown returns true for fields whose meaning has not been decoded yet
+func (field LayoutField) IsUnknown() bool {
+	return strings.Contains(field.Field, "Unknown") || strings.Contains(field.Section, "gap") || field.Section == "trailer"
+}
+
+// LayoutDifference is a field that changed between two layouts. Old or New is nil if the field only exists in one layout.
+type LayoutDifference struct {
+	Section string
+	Field   string
+	Old     *LayoutField
+	New     *LayoutField
+}
+
+type layoutBuilder struct {
+	fields  []LayoutField
+	offset  int
+	section string
+	err     error // first value that couldn't be converted to its field
+}
+
+func (b *layoutBuilder) add(field string, data []byte, value interface{}) {
+	b.fields = append(b.fields, LayoutField{
+		Section: b.section,
+		Field:   field,
+		Offset:  b.offset,
+		Length:  len(data),
+		Bytes:   data,
+		Value:   fmt.Sprint(value),
+	})
+	b.offset += len(data)
+}
+
+func (b *layoutBuilder) addByte(field string, value int) {
+	b.add(field, []byte{byte(value)}, value)
+}
+
+func (b *layoutBuilder) addBool(field string, value bool) {
+	b.add(field, []byte{ConvertBoolToByte(value)}, value)
+}
+
+func (b *layoutBuilder) addUint16(field string, value int) {
+	b.add(field, ConvertUint16Bytes(value), value)
+}
+
+func (b *layoutBuilder) addUint32(field string, value int) {
+	b.add(field, ConvertUint32Bytes(value), value)
+}
+
+func (b *layoutBuilder) addFloat32(field string, value float32) {
+	b.add(field, ConvertFloat32Bytes(value), value)
+}
+
+func (b *layoutBuilder) addVarString(field string, value string) {
+	b.add(field, ConvertVarString(value), fmt.Sprintf("%q", value))
+}
+
+func (b *layoutBuilder) addByteList(field string, value []int) {
+	byteList, err := ConvertByteList(value)
+	if err != nil && b.err == nil {
+		b.err = fmt.Errorf("%v %v: %w", b.section, field, err)
+	}
+	b.add(field, byteList, value)
+}
+
+// checkOffset compares the layout with an offset recorded while parsing
+func (b *layoutBuilder) checkOffset(fileOffsetMap map[string]int, key string) error {
+	parsedOffset, ok := fileOffsetMap[key]
+	if !ok || parsedOffset == b.offset {
+		return nil
+	}
+	return fmt.Errorf("layout offset of %v is %v, parsed offset is %v", key, b.offset, parsedOffset)
+}
+
+// BuildLayout lists every field of the save with its offset in the decompressed file.
+// The offsets of the current state are checked against FileOffsetMap.
+func BuildLayout(saveOutput *PolytopiaSaveOutput) ([]LayoutField, error) {
+	b := &layoutBuilder{}
+	gameVersion := saveOutput.GameVersion
+
+	b.addMapHeader("initial state/map header", saveOutput.InitialMapHeaderOutput)
+	b.addTiles("initial state", saveOutput.InitialTileData, gameVersion)
+	b.addPlayers("initial state", saveOutput.InitialPlayerData, gameVersion)
+	b.section = "initial state gap"
+	b.add("InitialStateGap", saveOutput.InitialStateGap, saveOutput.InitialStateGap)
+
+	if err := b.checkOffset(saveOutput.FileOffsetMap, buildMapHeaderStartKey()); err != nil {
+		return nil, err
+	}
+	b.addMapHeader("current state/map header", saveOutput.MapHeaderOutput)
+	for i := 0; i < len(saveOutput.TileData); i++ {
+		for j := 0; j < len(saveOutput.TileData[i]); j++ {
+			if err := b.checkOffset(saveOutput.FileOffsetMap, buildTileStartKey(j, i)); err != nil {
+				return nil, err
+			}
+			b.addTile("current state", j, i, saveOutput.TileData[i][j], gameVersion)
+		}
+	}
+	if err := b.checkOffset(saveOutput.FileOffsetMap, buildAllPlayersStartKey()); err != nil {
+		return nil, err
+	}
+	b.addPlayers("current state", saveOutput.PlayerData, gameVersion)
+	b.section = "current state gap"
+	b.add("CurrentStateGap", saveOutput.CurrentStateGap, saveOutput.CurrentStateGap)
+
+	if err := b.checkOffset(saveOutput.FileOffsetMap, buildActionsStartKey()); err != nil {
+		return nil, err
+	}
+	if err := b.addActions(saveOutput.Actions); err != nil {
+		return nil, err
+	}
+	if err := b.checkOffset(saveOutput.FileOffsetMap, buildActionsEndKey()); err != nil {
+		return nil, err
+	}
+	b.section = "trailer"
+	b.add("Trailer", saveOutput.Trailer, len(saveOutput.Trailer))
+
+	if b.err != nil {
+		return nil, b.err
+	}
+	return b.fields, nil
+}
+
+func (b *layoutBuilder) addMapHeader(section string, mapHeaderOutput MapHeaderOutput) {
+	b.section = section
+	mapHeaderInput := mapHeaderOutput.MapHeaderInput
+	b.addUint32("Version1", int(mapHeaderInput.Version1))
+	b.addUint32("Version2", int(mapHeaderInput.Version2))
+	b.addUint16("TotalActions", int(mapHeaderInput.TotalActions))
+	b.addUint32("CurrentTurn", int(mapHeaderInput.CurrentTurn))
+	b.addByte("CurrentPlayerIndex", int(mapHeaderInput.CurrentPlayerIndex))
+	b.addUint32("MaxUnitId", int(mapHeaderInput.MaxUnitId))
+	b.addByte("CurrentGameState", int(mapHeaderInput.CurrentGameState))
+	b.addUint32("Seed", int(mapHeaderInput.Seed))
+	b.addUint32("TurnLimit", int(mapHeaderInput.TurnLimit))
+	b.addUint32("ScoreLimit", int(mapHeaderInput.ScoreLimit))
+	b.addByte("WinByCapital", int(mapHeaderInput.WinByCapital))
+	b.add("UnknownSettings", mapHeaderInput.UnknownSettings[:], mapHeaderInput.UnknownSettings)
+	b.addByte("GameModeBase", int(mapHeaderInput.GameModeBase))
+	b.addByte("GameModeRules", int(mapHeaderInput.GameModeRules))
+
+	b.addVarString("MapName", mapHeaderOutput.MapName)
+	b.addUint32("MapSquareSize", mapHeaderOutput.MapSquareSize)
+	b.addUint16("DisabledTribesArr.Count", len(mapHeaderOutput.DisabledTribesArr))
+	b.add("DisabledTribesArr", convertUint16List(mapHeaderOutput.DisabledTribesArr), mapHeaderOutput.DisabledTribesArr)
+	b.addUint16("UnlockedTribesArr.Count", len(mapHeaderOutput.UnlockedTribesArr))
+	b.add("UnlockedTribesArr", convertUint16List(mapHeaderOutput.UnlockedTribesArr), mapHeaderOutput.UnlockedTribesArr)
+	b.addUint16("GameDifficulty", mapHeaderOutput.GameDifficulty)
+	b.addUint32("NumOpponents", mapHeaderOutput.NumOpponents)
+	b.addUint16("GameType", mapHeaderOutput.GameType)
+	b.addByte("MapPreset", mapHeaderOutput.MapPreset)
+	b.addUint32("TurnTimeLimitMinutes", mapHeaderOutput.TurnTimeLimitMinutes)
+	b.addFloat32("UnknownFloat1", mapHeaderOutput.UnknownFloat1)
+	b.addFloat32("UnknownFloat2", mapHeaderOutput.UnknownFloat2)
+	b.addFloat32("BaseTimeSeconds", mapHeaderOutput.BaseTimeSeconds)
+	b.addByteList("TimeSettings", mapHeaderOutput.TimeSettings)
+
+	b.addUint32("SelectedTribeSkins.Count", len(mapHeaderOutput.SelectedTribeSkins))
+	skinBytes := make([]byte, 0)
+	for i := 0; i < len(mapHeaderOutput.SelectedTribeSkins); i++ {
+		skinBytes = append(skinBytes, ConvertUint16Bytes(mapHeaderOutput.SelectedTribeSkins[i].Tribe)...)
+		skinBytes = append(skinBytes, ConvertUint16Bytes(mapHeaderOutput.SelectedTribeSkins[i].Skin)...)
+	}
+	b.add("SelectedTribeSkins", skinBytes, mapHeaderOutput.SelectedTribeSkins)
+
+	b.addUint16("MapWidth", mapHeaderOutput.MapWidth)
+	b.addUint16("MapHeight", mapHeaderOutput.MapHeight)
+}
+
+func (b *layoutBuilder) addTiles(statePrefix string, tileData [][]TileData, gameVersion int) {
+	for i := 0; i < len(tileData); i++ {
+		for j := 0; j < len(tileData[i]); j++ {
+			b.addTile(statePrefix, j, i, tileData[i][j], gameVersion)
+		}
+	}
+}
+
+func (b *layoutBuilder) addTile(statePrefix string, x int, y int, tileData TileData, gameVersion int) {
+	b.section = fmt.Sprintf("%v/tile (%v, %v)", statePrefix, x, y)
+	b.addUint32("WorldCoordinates[0]", tileData.WorldCoordinates[0])
+	b.addUint32("WorldCoordinates[1]", tileData.WorldCoordinates[1])
+	b.add("Terrain", ConvertUint16Bytes(tileData.Terrain), TerrainType(tileData.Terrain))
+	b.add("Climate", ConvertUint16Bytes(tileData.Climate), ClimateType(tileData.Climate))
+	b.addUint16("Altitude", tileData.Altitude)
+	b.addByte("Owner", tileData.Owner)
+	b.addByte("Capital", tileData.Capital)
+	b.addUint32("CapitalCoordinates[0]", tileData.CapitalCoordinates[0])
+	b.addUint32("CapitalCoordinates[1]", tileData.CapitalCoordinates[1])
+
+	b.addBool("ResourceExists", tileData.ResourceExists)
+	if tileData.ResourceExists {
+		b.add("ResourceType", ConvertUint16Bytes(tileData.ResourceType), ResourceType(tileData.ResourceType))
+	}
+	b.addBool("ImprovementExists", tileData.ImprovementExists)
+	if tileData.ImprovementExists {
+		b.add("ImprovementType", ConvertUint16Bytes(tileData.ImprovementType), ImprovementType(tileData.ImprovementType))
+	}
+	if tileData.ImprovementData != nil {
+		b.addImprovement(*tileData.ImprovementData)
+	}
+
+	b.addBool("HasUnit", tileData.Unit != nil)
+	if tileData.Unit != nil {
+		b.addUnit("Unit", *tileData.Unit)
+		b.addBool("HasPassengerUnit", tileData.PassengerUnit != nil)
+		if tileData.PassengerUnit != nil {
+			b.addUnit("PassengerUnit", *tileData.PassengerUnit)
+			b.addByte("PassengerUnit.HasPassengerUnit", 0)
+			b.addUint16("PassengerUnitEffectData.Count", len(tileData.PassengerUnitEffectData))
+			b.add("PassengerUnitEffectData", convertUint16List(tileData.PassengerUnitEffectData), tileData.PassengerUnitEffectData)
+			b.addByteList("PassengerUnitDirectionData", tileData.PassengerUnitDirectionData)
+		}
+		b.addUint16("UnitEffectData.Count", len(tileData.UnitEffectData))
+		b.add("UnitEffectData", convertUint16List(tileData.UnitEffectData), tileData.UnitEffectData)
+		b.addByteList("UnitDirectionData", tileData.UnitDirectionData)
+	}
+
+	b.addByte("PlayerVisibility.Count", len(tileData.PlayerVisibility))
+	b.addByteList("PlayerVisibility", tileData.PlayerVisibility)
+	b.addBool("HasRoad", tileData.HasRoad)
+	b.addBool("HasWaterRoute", tileData.HasWaterRoute)
+	b.addUint16("TileSkin", tileData.TileSkin)
+	b.addByteList("Unknown", tileData.Unknown)
+	if nearestVersionLayout(gameVersion).HasTileFlooding {
+		b.addByte("FloodedFlag", tileData.FloodedFlag)
+		if tileData.FloodedFlag == 1 {
+			b.addUint32("FloodedValue", tileData.FloodedValue)
+		}
+	}
+}
+
+func (b *layoutBuilder) addImprovement(improvementData ImprovementData) {
+	b.addUint16("ImprovementData.Level", improvementData.Level)
+	b.addUint16("ImprovementData.FoundedTurn", improvementData.FoundedTurn)
+	b.addUint16("ImprovementData.CurrentPopulation", improvementData.CurrentPopulation)
+	b.addUint16("ImprovementData.TotalPopulation", improvementData.TotalPopulation)
+	b.addUint16("ImprovementData.Production", improvementData.Production)
+	b.addUint16("ImprovementData.BaseScore", improvementData.BaseScore)
+	b.addUint16("ImprovementData.BorderSize", improvementData.BorderSize)
+	b.addUint16("ImprovementData.UpgradeCount", improvementData.UpgradeCount)
+	b.addByte("ImprovementData.ConnectedPlayerCapital", improvementData.ConnectedPlayerCapital)
+	b.addByte("ImprovementData.HasCityName", improvementData.HasCityName)
+	if improvementData.HasCityName == 1 {
+		b.addVarString("ImprovementData.CityName", improvementData.CityName)
+	}
+	b.addByte("ImprovementData.FoundedTribe", improvementData.FoundedTribe)
+	b.addUint16("ImprovementData.CityRewards.Count", len(improvementData.CityRewards))
+	b.add("ImprovementData.CityRewards", convertUint16List(improvementData.CityRewards), improvementData.CityRewards)
+	b.addUint16("ImprovementData.RebellionFlag", improvementData.RebellionFlag)
+	if improvementData.RebellionFlag != 0 {
+		b.addByteList("ImprovementData.RebellionBuffer", improvementData.RebellionBuffer)
+	}
+}
+
+func (b *layoutBuilder) addUnit(prefix string, unitData UnitData) {
+	b.addUint32(prefix+".Id", int(unitData.Id))
+	b.addByte(prefix+".Owner", int(unitData.Owner))
+	b.add(prefix+".UnitType", ConvertUint16Bytes(int(unitData.UnitType)), UnitType(unitData.UnitType))
+	b.addUint32(prefix+".FollowerUnitId", int(unitData.FollowerUnitId))
+	b.addUint32(prefix+".LeaderUnitId", int(unitData.LeaderUnitId))
+	b.add(prefix+".CurrentCoordinates[0]", ConvertUint32Bytes(int(unitData.CurrentCoordinates[0])), unitData.CurrentCoordinates[0])
+	b.add(prefix+".CurrentCoordinates[1]", ConvertUint32Bytes(int(unitData.CurrentCoordinates[1])), unitData.CurrentCoordinates[1])
+	b.add(prefix+".HomeCoordinates[0]", ConvertUint32Bytes(int(unitData.HomeCoordinates[0])), unitData.HomeCoordinates[0])
+	b.add(prefix+".HomeCoordinates[1]", ConvertUint32Bytes(int(unitData.HomeCoordinates[1])), unitData.HomeCoordinates[1])
+	b.addUint16(prefix+".Health", int(unitData.Health))
+	b.addUint16(prefix+".PromotionLevel", int(unitData.PromotionLevel))
+	b.addUint16(prefix+".Experience", int(unitData.Experience))
+	b.addBool(prefix+".Moved", unitData.Moved)
+	b.addBool(prefix+".Attacked", unitData.Attacked)
+	b.addBool(prefix+".Flipped", unitData.Flipped)
+	b.addUint16(prefix+".CreatedTurn", int(unitData.CreatedTurn))
+}
+
+func (b *layoutBuilder) addPlayers(statePrefix string, allPlayerData []PlayerData, gameVersion int) {
+	b.section = statePrefix + "/players"
+	b.addUint16("Count", len(allPlayerData))
+	for i := 0; i < len(allPlayerData); i++ {
+		b.section = fmt.Sprintf("%v/player %v", statePrefix, i)
+		b.addPlayer(allPlayerData[i], gameVersion)
+	}
+}
+
+func (b *layoutBuilder) addPlayer(playerData PlayerData, gameVersion int) {
+	b.addByte("PlayerId", playerData.PlayerId)
+	b.addVarString("Name", playerData.Name)
+	b.addVarString("AccountId", playerData.AccountId)
+	b.addBool("AutoPlay", playerData.AutoPlay)
+	b.addUint32("StartTileCoordinates[0]", playerData.StartTileCoordinates[0])
+	b.addUint32("StartTileCoordinates[1]", playerData.StartTileCoordinates[1])
+	b.add("Tribe", ConvertUint16Bytes(playerData.Tribe), TribeType(playerData.Tribe))
+	b.addByte("UnknownByte1", playerData.UnknownByte1)
+	b.addUint32("DifficultyHandicap", playerData.DifficultyHandicap)
+
+	if nearestVersionLayout(gameVersion).HasPlayerAggressions {
+		b.addUint16("AggressionsByPlayers.Count", len(playerData.AggressionsByPlayers))
+		aggressionBytes := make([]byte, 0)
+		for i := 0; i < len(playerData.AggressionsByPlayers); i++ {
+			aggressionBytes = append(aggressionBytes, byte(playerData.AggressionsByPlayers[i].PlayerId))
+			aggressionBytes = append(aggressionBytes, ConvertUint32Bytes(playerData.AggressionsByPlayers[i].Aggression)...)
+		}
+		b.add("AggressionsByPlayers", aggressionBytes, playerData.AggressionsByPlayers)
+	}
+
+	b.addUint32("Currency", playerData.Currency)
+	b.addUint32("Score", playerData.Score)
+	b.addUint32("UnknownInt2", playerData.UnknownInt2)
+	b.addUint16("NumCities", playerData.NumCities)
+	b.addUint16("AvailableTech.Count", len(playerData.AvailableTech))
+	b.add("AvailableTech", convertUint16List(playerData.AvailableTech), playerData.AvailableTech)
+	b.addUint16("EncounteredPlayers.Count", len(playerData.EncounteredPlayers))
+	b.addByteList("EncounteredPlayers", playerData.EncounteredPlayers)
+
+	b.addUint16("Tasks.Count", len(playerData.Tasks))
+	for i := 0; i < len(playerData.Tasks); i++ {
+		b.addUint16(fmt.Sprintf("Tasks[%v].Type", i), playerData.Tasks[i].Type)
+		b.addByteList(fmt.Sprintf("Tasks[%v].Buffer", i), playerData.Tasks[i].Buffer)
+	}
+
+	b.addUint32("TotalUnitsKilled", playerData.TotalUnitsKilled)
+	b.addUint32("TotalUnitsLost", playerData.TotalUnitsLost)
+	b.addUint32("TotalTribesDestroyed", playerData.TotalTribesDestroyed)
+	b.addByteList("OverrideColor", playerData.OverrideColor)
+	b.addByte("OverrideTribe", int(playerData.OverrideTribe))
+	b.addUint16("UniqueImprovements.Count", len(playerData.UniqueImprovements))
+	b.add("UniqueImprovements", convertUint16List(playerData.UniqueImprovements), playerData.UniqueImprovements)
+
+	b.addUint16("DiplomacyArr.Count", len(playerData.DiplomacyArr))
+	for i := 0; i < len(playerData.DiplomacyArr); i++ {
+		b.add(fmt.Sprintf("DiplomacyArr[%v]", i), SerializeDiplomacyDataToBytes(playerData.DiplomacyArr[i]), fmt.Sprintf("%+v", playerData.DiplomacyArr[i]))
+	}
+	b.addUint16("DiplomacyMessages.Count", len(playerData.DiplomacyMessages))
+	messageBytes := make([]byte, 0)
+	for i := 0; i < len(playerData.DiplomacyMessages); i++ {
+		messageBytes = append(messageBytes, byte(playerData.DiplomacyMessages[i].MessageType), byte(playerData.DiplomacyMessages[i].Sender))
+	}
+	b.add("DiplomacyMessages", messageBytes, playerData.DiplomacyMessages)
+
+	b.addByte("DestroyedByTribe", playerData.DestroyedByTribe)
+	b.addUint32("DestroyedTurn", playerData.DestroyedTurn)
+	b.addByteList("UnknownBuffer2", playerData.UnknownBuffer2)
+	b.addUint32("EndScore", playerData.EndScore)
+	b.addUint16("PlayerSkin", playerData.PlayerSkin)
+	b.addByteList("UnknownBuffer3", playerData.UnknownBuffer3)
+}
+
+func (b *layoutBuilder) addActions(actions []ReplayAction) error {
+	b.section = "actions"
+	b.addUint16("Count", countSerializedActions(actions))
+	for i := 0; i < len(actions); i++ {
+		actionType := actions[i].Action.ActionType()
+		payload, err := SerializeActionToBytes(actions[i].Action)
+		if err != nil {
+			return err
+		}
+		actionBytes := append(ConvertUint16Bytes(int(actionType)), payload...)
+		b.add(fmt.Sprintf("Actions[%v] (%v)", i, actionType), actionBytes, fmt.Sprintf("turn %v %+v", actions[i].Turn, actions[i].Action))
+	}
+	return nil
+}
+
+func convertUint16List(values []int) []byte {
+	data := make([]byte, 0)
+	for i := 0; i < len(values); i++ {
+		data = append(data, ConvertUint16Bytes(values[i])...)
+	}
+	return data
+}
+
+// DiffLayouts returns the fields that have different bytes. Fields are matched by section and field name so they can be compared when offsets have shifted.
+func DiffLayouts(oldLayout []LayoutField, newLayout []LayoutField) []LayoutDifference {
+	newFields := make(map[string]*LayoutField)
+	for i := range newLayout {
+		newFields[newLayout[i].Section+"\x00"+newLayout[i].Field] = &newLayout[i]
+	}
+
+	differences := make([]LayoutDifference, 0)
+	for i := range oldLayout {
+		key := oldLayout[i].Section + "\x00" + oldLayout[i].Field
+		newField, ok := newFields[key]
+		delete(newFields, key)
+		if ok && string(newField.Bytes) == string(oldLayout[i].Bytes) {
+			continue
+		}
+		differences = append(differences, LayoutDifference{
+			Section: oldLayout[i].Section,
+			Field:   oldLayout[i].Field,
+			Old:     &oldLayout[i],
+			New:     newField,
+		})
+	}
+
+	// fields that are only in the new layout are kept in file order
+	for i := range newLayout {
+		if _, ok := newFields[newLayout[i].Section+"\x00"+newLayout[i].Field]; ok {
+			differences = append(differences, LayoutDifference{
+				Section: newLayout[i].Section,
+				Field:   newLayout[i].Field,
+				New:     &newLayout[i],
+			})
+		}
+	}
+	return differences
+}
+
+// WriteLayout prints one field per line: offset, length, section and field, hex and decoded value
+func WriteLayout(writer io.Writer, fields []LayoutField) error {
+	for _, field := range fields {
+		if _, err := fmt.Fprintf(writer, "%08x %6d  %-40s %-40s %-24s %s\n",
+			field.Offset, field.Length, field.Section, field.Field, field.Hex(), field.Value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteLayoutDiff prints the old and new value of each changed field
+func WriteLayoutDiff(writer io.Writer, differences []LayoutDifference) error {
+	for _, difference := range differences {
+		if _, err := fmt.Fprintf(writer, "%v %v\n", difference.Section, difference.Field); err != nil {
+			return err
+		}
+		if err := writeLayoutDiffSide(writer, "-", difference.Old); err != nil {
+			return err
+		}
+		if err := writeLayoutDiffSide(writer, "+", difference.New); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeLayoutDiffSide(writer io.Writer, prefix string, field *LayoutField) error {
+	if field == nil {
+		_, err := fmt.Fprintf(writer, "  %v (missing)\n", prefix)
+		return err
+	}
+	_, err := fmt.Fprintf(writer, "  %v %08x %s %s\n", prefix, field.Offset, field.Hex(), field.Value)
+	return err
+}
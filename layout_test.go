@@ -0,0 +1,96 @@
+package polytopiamapmodel
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestBuildLayoutMatchesFile(t *testing.T) {
+	for _, gameVersion := range []int{104, 105} {
+		inputByteData := buildDetailedTestSaveBytes(gameVersion)
+		saveOutput, err := ParsePolytopiaFile(io.NewSectionReader(bytes.NewReader(inputByteData), 0, int64(len(inputByteData))))
+		if err != nil {
+			t.Fatalf(`Failed to parse: %v`, err)
+		}
+		fields, err := BuildLayout(saveOutput)
+		if err != nil {
+			t.Fatalf(`Failed to build layout: %v`, err)
+		}
+
+		layoutBytes := make([]byte, 0)
+		for _, field := range fields {
+			if field.Offset != len(layoutBytes) {
+				t.Fatalf(`Offset of %v %v is %v, expected %v`, field.Section, field.Field, field.Offset, len(layoutBytes))
+			}
+			if !bytes.Equal(field.Bytes, inputByteData[field.Offset:field.Offset+field.Length]) {
+				t.Fatalf(`Bytes of %v %v don't match the file`, field.Section, field.Field)
+			}
+			layoutBytes = append(layoutBytes, field.Bytes...)
+		}
+		compareArrays(t, layoutBytes, inputByteData)
+	}
+}
+
+func TestBuildLayoutDetectsWrongOffsets(t *testing.T) {
+	inputByteData := buildDetailedTestSaveBytes(105)
+	saveOutput, err := ParsePolytopiaFile(io.NewSectionReader(bytes.NewReader(inputByteData), 0, int64(len(inputByteData))))
+	if err != nil {
+		t.Fatalf(`Failed to parse: %v`, err)
+	}
+	saveOutput.InitialStateGap = []byte{1, 2}
+	if _, err := BuildLayout(saveOutput); err == nil {
+		t.Fatalf(`Expected error when layout doesn't match the parsed offsets`)
+	}
+}
+
+func TestDiffLayouts(t *testing.T) {
+	inputByteData := buildDetailedTestSaveBytes(105)
+	oldSave, err := ParsePolytopiaFile(io.NewSectionReader(bytes.NewReader(inputByteData), 0, int64(len(inputByteData))))
+	if err != nil {
+		t.Fatalf(`Failed to parse: %v`, err)
+	}
+	newSave, err := ParsePolytopiaFile(io.NewSectionReader(bytes.NewReader(inputByteData), 0, int64(len(inputByteData))))
+	if err != nil {
+		t.Fatalf(`Failed to parse: %v`, err)
+	}
+	newSave.FileOffsetMap = nil
+	newSave.MapHeaderOutput.UnknownFloat1 = 2.5
+	newSave.MapHeaderOutput.MapName = "Longer map name"
+	newSave.PlayerData[0].UnknownBuffer3 = append(newSave.PlayerData[0].UnknownBuffer3, 1)
+
+	oldLayout, err := BuildLayout(oldSave)
+	if err != nil {
+		t.Fatalf(`Failed to build layout: %v`, err)
+	}
+	newLayout, err := BuildLayout(newSave)
+	if err != nil {
+		t.Fatalf(`Failed to build layout: %v`, err)
+	}
+	differences := DiffLayouts(oldLayout, newLayout)
+
+	result := make([]string, 0)
+	for _, difference := range differences {
+		result = append(result, difference.Section+" "+difference.Field)
+	}
+	expected := []string{
+		"current state/map header MapName",
+		"current state/map header UnknownFloat1",
+		"current state/player 0 UnknownBuffer3",
+	}
+	if strings.Join(result, "\n") != strings.Join(expected, "\n") {
+		t.Fatalf(`Differences not equal. Result = %v, expected = %v`, result, expected)
+	}
+	if !differences[1].New.IsUnknown() || differences[0].New.IsUnknown() {
+		t.Fatalf(`Only UnknownFloat1 should be an unknown field`)
+	}
+
+	var output bytes.Buffer
+	if err := WriteLayoutDiff(&output, differences); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(output.String(), "+ ") || !strings.Contains(output.String(), "2.5") {
+		t.Fatalf(`Diff output is missing the new value: %v`, output.String())
+	}
+}
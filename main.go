@@ -1,15 +1,14 @@
 package polytopiamapmodel
 
 import (
+	"bytes"
 	"fmt"
 	"io"
-	"log"
 	"os"
 )
 
 var (
-	fileOffsetMap = make(map[string]int)
-	DebugMode     = false // Set to true to enable debug output
+	DebugMode = false // Set to true to enable debug output
 )
 
 // debugPrint prints a message only if debug mode is enabled
@@ -20,26 +19,51 @@ func debugPrint(format string, args ...interface{}) {
 }
 
 type PolytopiaSaveOutput struct {
-	MapHeight         int
-	MapWidth          int
-	GameVersion       int
-	MapHeaderOutput   MapHeaderOutput
-	InitialTileData   [][]TileData
-	InitialPlayerData []PlayerData
-	TileData          [][]TileData
-	MaxTurn           int
-	PlayerData        []PlayerData
-	FileOffsetMap     map[string]int
-	OwnerTribeMap     map[int]int
-	TribeCityMap      map[int][]CityLocationData
-	TurnCaptureMap    map[int][]ActionCaptureCity
+	MapHeight              int
+	MapWidth               int
+	GameVersion            int
+	InitialMapHeaderOutput MapHeaderOutput
+	MapHeaderOutput        MapHeaderOutput
+	InitialTileData        [][]TileData
+	InitialPlayerData      []PlayerData
+	TileData               [][]TileData
+	MaxTurn                int
+	PlayerData             []PlayerData
+	FileOffsetMap          map[string]int
+	Offsets                OffsetIndex
+	OwnerTribeMap          map[int]int
+	TribeCityMap           map[int][]CityLocationData
+	TurnCaptureMap         map[int][]ActionCaptureCity
+	Actions                []ReplayAction
+
+	// Data that is not understood yet, kept so the file can be serialized again without changes
+	InitialStateGap []byte // 3 bytes after the initial player data
+	CurrentStateGap []byte // 2 bytes after the current player data
+	Trailer         []byte // everything after the actions list
+
+	// Problems that didn't stop the parse, such as a game version older than every known layout
+	Warnings []string
 }
 
 // Read compressed .state file without generating a decompressed file
 // Can be used with read only applications to display map data
 func ReadPolytopiaCompressedFile(inputFilename string) (*PolytopiaSaveOutput, error) {
-	decompressedReader, decompressedLength := BuildReaderForDecompressedFile(inputFilename)
-	streamReader := io.NewSectionReader(decompressedReader, int64(0), int64(decompressedLength))
+	inputFile, err := os.Open(inputFilename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load state file: %w", err)
+	}
+	defer inputFile.Close()
+
+	return ReadPolytopiaSave(inputFile)
+}
+
+// Read compressed .state data from any reader, such as an uploaded file
+func ReadPolytopiaSave(reader io.Reader) (*PolytopiaSaveOutput, error) {
+	decompressedContents, err := Decompress(reader)
+	if err != nil {
+		return nil, err
+	}
+	streamReader := io.NewSectionReader(bytes.NewReader(decompressedContents), int64(0), int64(len(decompressedContents)))
 
 	return ParsePolytopiaFile(streamReader)
 }
@@ -47,15 +71,13 @@ func ReadPolytopiaCompressedFile(inputFilename string) (*PolytopiaSaveOutput, er
 // Read decompressed file
 // Should be used with applications that need to modify decompressed data directly
 func ReadPolytopiaDecompressedFile(inputFilename string) (*PolytopiaSaveOutput, error) {
-	inputFile, err := os.OpenFile(inputFilename, os.O_RDWR, 0644)
-	defer inputFile.Close()
+	inputFile, err := os.Open(inputFilename)
 	if err != nil {
-		log.Fatal("Failed to load save state: ", err)
-		return nil, err
+		return nil, fmt.Errorf("failed to load save state: %w", err)
 	}
+	defer inputFile.Close()
 	fi, err := inputFile.Stat()
 	if err != nil {
-		log.Fatal(err)
 		return nil, err
 	}
 	fileLength := fi.Size()
@@ -64,74 +86,243 @@ func ReadPolytopiaDecompressedFile(inputFilename string) (*PolytopiaSaveOutput,
 	return ParsePolytopiaFile(streamReader)
 }
 
+// checkMapDimensions rejects map sizes that the game can't create or that the rest of the file is too short to hold,
+// so a corrupted header can't make the parser allocate the tile grid before reading any tiles
+func checkMapDimensions(streamReader *io.SectionReader, fileOffsetMap map[string]int, mapHeaderOutput MapHeaderOutput) error {
+	offset := int64(fileOffsetMap["MapWidth"])
+	if mapHeaderOutput.MapWidth <= 0 || mapHeaderOutput.MapHeight <= 0 {
+		return newParseError(offset, "map size", fmt.Errorf("map size %vx%v has no tiles",
+			mapHeaderOutput.MapWidth, mapHeaderOutput.MapHeight))
+	}
+	if mapHeaderOutput.MapWidth > maxMapSize || mapHeaderOutput.MapHeight > maxMapSize {
+		return newParseError(offset, "map size", fmt.Errorf("map size %vx%v is larger than %vx%v",
+			mapHeaderOutput.MapWidth, mapHeaderOutput.MapHeight, maxMapSize, maxMapSize))
+	}
+	remainingBytes := streamReader.Size() - currentOffset(streamReader)
+	if int64(mapHeaderOutput.MapWidth*mapHeaderOutput.MapHeight*minTileSize) > remainingBytes {
+		return newParseError(offset, "map size", fmt.Errorf("map size %vx%v needs at least %v bytes of tiles, only %v bytes left",
+			mapHeaderOutput.MapWidth, mapHeaderOutput.MapHeight, mapHeaderOutput.MapWidth*mapHeaderOutput.MapHeight*minTileSize, remainingBytes))
+	}
+	return nil
+}
+
+type ParseOptions struct {
+	// ReadNewerVersions reads a version newer than every known layout with the newest layout and adds a warning to
+	// Warnings, instead of returning an UnsupportedVersionError. It is meant for inspecting saves from a new game update.
+	ReadNewerVersions bool
+}
+
 func ParsePolytopiaFile(streamReader *io.SectionReader) (*PolytopiaSaveOutput, error) {
-	fileOffsetMap = make(map[string]int)
+	return ParsePolytopiaFileWithOptions(streamReader, ParseOptions{})
+}
+
+func ParsePolytopiaFileWithOptions(streamReader *io.SectionReader, options ParseOptions) (saveOutput *PolytopiaSaveOutput, err error) {
+	// a version outside every known layout is the likely cause of a parse error
+	versionWarning := ""
+	defer func() {
+		if err != nil {
+			err = withVersionWarning(versionWarning, err)
+		}
+	}()
+	fileOffsetMap := make(map[string]int)
 
 	// Read initial map state
 	debugPrint("Reading initial map header...\n")
 	updateFileOffsetMap(fileOffsetMap, streamReader, buildMapHeaderStartKey())
-	initialMapHeaderOutput := DeserializeMapHeaderFromBytes(streamReader)
+	initialMapHeaderOutput, err := readMapHeader(streamReader, fileOffsetMap)
+	if err != nil {
+		return nil, fmt.Errorf("initial state: %w", err)
+	}
 	updateFileOffsetMap(fileOffsetMap, streamReader, buildMapHeaderEndKey())
 	debugPrint("Initial map header read - Size: %dx%d, Version: %d\n",
 		initialMapHeaderOutput.MapWidth, initialMapHeaderOutput.MapHeight,
 		initialMapHeaderOutput.MapHeaderInput.Version1)
 
+	if err := checkMapDimensions(streamReader, fileOffsetMap, initialMapHeaderOutput); err != nil {
+		return nil, withSection(err, "initial map header")
+	}
 	initialTileData := make([][]TileData, initialMapHeaderOutput.MapHeight)
 	for i := 0; i < initialMapHeaderOutput.MapHeight; i++ {
 		initialTileData[i] = make([]TileData, initialMapHeaderOutput.MapWidth)
 	}
 	gameVersion := int(initialMapHeaderOutput.MapHeaderInput.Version1)
+	var versionLayout VersionLayout
+	versionLayout, versionWarning, err = readableVersionLayout(gameVersion, options.ReadNewerVersions)
+	if err != nil {
+		return nil, err
+	}
+	if versionWarning != "" {
+		debugPrint("Warning: %v\n", versionWarning)
+	}
 	debugPrint("Reading initial tile data...\n")
-	readTileData(streamReader, initialTileData, initialMapHeaderOutput.MapWidth, initialMapHeaderOutput.MapHeight, gameVersion)
+	if err := readTileData(streamReader, fileOffsetMap, initialTileData, initialMapHeaderOutput.MapWidth, initialMapHeaderOutput.MapHeight, gameVersion); err != nil {
+		return nil, fmt.Errorf("initial state: %w", err)
+	}
 	debugPrint("Reading initial player data...\n")
-	initialPlayerData := readAllPlayerData(streamReader, gameVersion)
+	initialPlayerData, err := readAllPlayerData(streamReader, fileOffsetMap, gameVersion)
+	if err != nil {
+		return nil, fmt.Errorf("initial state: %w", err)
+	}
 	debugPrint("Initial player data read - %d players\n", len(initialPlayerData))
 
-	ownerTribeMap := buildOwnerTribeMap(initialPlayerData)
+	if _, err := buildOwnerTribeMap(initialPlayerData); err != nil {
+		return nil, fmt.Errorf("initial state: %w", err)
+	}
+	offsetIndex := OffsetIndex{
+		Initial: buildStateOffsetIndex(fileOffsetMap, initialMapHeaderOutput.MapWidth, initialMapHeaderOutput.MapHeight, len(initialPlayerData)),
+	}
 
-	_ = readFixedList(streamReader, 3)
+	initialStateGapStart := int(currentOffset(streamReader))
+	initialStateGap, err := readFixedList(streamReader, 3, "initial state gap")
+	if err != nil {
+		return nil, withSection(err, "initial state gap")
+	}
+	offsetIndex.InitialStateGap = OffsetRange{initialStateGapStart, initialStateGapStart + len(initialStateGap)}
 
 	// Read current map state
 	debugPrint("Reading current map header...\n")
 	updateFileOffsetMap(fileOffsetMap, streamReader, buildMapHeaderStartKey())
-	currentMapHeaderOutput := DeserializeMapHeaderFromBytes(streamReader)
+	currentMapHeaderOutput, err := readMapHeader(streamReader, fileOffsetMap)
+	if err != nil {
+		return nil, fmt.Errorf("current state: %w", err)
+	}
 	updateFileOffsetMap(fileOffsetMap, streamReader, buildMapHeaderEndKey())
 	debugPrint("Current map header read - Size: %dx%d\n",
 		currentMapHeaderOutput.MapWidth, currentMapHeaderOutput.MapHeight)
 
+	if err := checkMapDimensions(streamReader, fileOffsetMap, currentMapHeaderOutput); err != nil {
+		return nil, withSection(err, "current map header")
+	}
 	tileData := make([][]TileData, currentMapHeaderOutput.MapHeight)
 	for i := 0; i < currentMapHeaderOutput.MapHeight; i++ {
 		tileData[i] = make([]TileData, currentMapHeaderOutput.MapWidth)
 	}
 	debugPrint("Reading current tile data...\n")
-	readTileData(streamReader, tileData, currentMapHeaderOutput.MapWidth, currentMapHeaderOutput.MapHeight, gameVersion)
+	if err := readTileData(streamReader, fileOffsetMap, tileData, currentMapHeaderOutput.MapWidth, currentMapHeaderOutput.MapHeight, gameVersion); err != nil {
+		return nil, fmt.Errorf("current state: %w", err)
+	}
 	debugPrint("Reading current player data...\n")
-	playerData := readAllPlayerData(streamReader, gameVersion)
+	playerData, err := readAllPlayerData(streamReader, fileOffsetMap, gameVersion)
+	if err != nil {
+		return nil, fmt.Errorf("current state: %w", err)
+	}
 	debugPrint("Current player data read - %d players\n", len(playerData))
 
-	ownerTribeMap = buildOwnerTribeMap(playerData)
+	ownerTribeMap, err := buildOwnerTribeMap(playerData)
+	if err != nil {
+		return nil, fmt.Errorf("current state: %w", err)
+	}
 	tribeCityMap := buildTribeCityMap(currentMapHeaderOutput, tileData)
+	offsetIndex.Current = buildStateOffsetIndex(fileOffsetMap, currentMapHeaderOutput.MapWidth, currentMapHeaderOutput.MapHeight, len(playerData))
 
-	_ = readFixedList(streamReader, 2)
+	currentStateGapStart := int(currentOffset(streamReader))
+	currentStateGap, err := readFixedList(streamReader, 2, "current state gap")
+	if err != nil {
+		return nil, withSection(err, "current state gap")
+	}
+	offsetIndex.CurrentStateGap = OffsetRange{currentStateGapStart, currentStateGapStart + len(currentStateGap)}
 
 	debugPrint("Reading actions...\n")
-	turnCaptureMap := readAllActions(streamReader)
-	debugPrint("Actions read - %d turns with captures\n", len(turnCaptureMap))
+	updateFileOffsetMap(fileOffsetMap, streamReader, buildActionsStartKey())
+	actions, err := readAllActions(streamReader, versionLayout)
+	if err != nil {
+		return nil, err
+	}
+	updateFileOffsetMap(fileOffsetMap, streamReader, buildActionsEndKey())
+	trailer, err := readFixedList(streamReader, int(streamReader.Size()-currentOffset(streamReader)), "trailer")
+	if err != nil {
+		return nil, withSection(err, "trailer")
+	}
+	offsetIndex.Actions = OffsetRange{fileOffsetMap[buildActionsStartKey()], fileOffsetMap[buildActionsEndKey()]}
+	offsetIndex.ActionRanges, err = buildActionRanges(offsetIndex.Actions.Start, actions)
+	if err != nil {
+		return nil, err
+	}
+	offsetIndex.Trailer = OffsetRange{offsetIndex.Actions.End, offsetIndex.Actions.End + len(trailer)}
+	turnCaptureMap := buildTurnCaptureMap(actions)
+	debugPrint("Actions read - %d actions, %d turns with captures\n", len(actions), len(turnCaptureMap))
 
 	output := &PolytopiaSaveOutput{
-		MapHeight:         currentMapHeaderOutput.MapHeight,
-		MapWidth:          currentMapHeaderOutput.MapWidth,
-		GameVersion:       int(gameVersion),
-		MapHeaderOutput:   currentMapHeaderOutput,
-		InitialTileData:   initialTileData,
-		InitialPlayerData: initialPlayerData,
-		TileData:          tileData,
-		MaxTurn:           int(currentMapHeaderOutput.MapHeaderInput.CurrentTurn),
-		PlayerData:        playerData,
-		FileOffsetMap:     fileOffsetMap,
-		OwnerTribeMap:     ownerTribeMap,
-		TribeCityMap:      tribeCityMap,
-		TurnCaptureMap:    turnCaptureMap,
+		MapHeight:              currentMapHeaderOutput.MapHeight,
+		MapWidth:               currentMapHeaderOutput.MapWidth,
+		GameVersion:            int(gameVersion),
+		InitialMapHeaderOutput: initialMapHeaderOutput,
+		MapHeaderOutput:        currentMapHeaderOutput,
+		InitialTileData:        initialTileData,
+		InitialPlayerData:      initialPlayerData,
+		TileData:               tileData,
+		MaxTurn:                int(currentMapHeaderOutput.MapHeaderInput.CurrentTurn),
+		PlayerData:             playerData,
+		FileOffsetMap:          fileOffsetMap,
+		Offsets:                offsetIndex,
+		OwnerTribeMap:          ownerTribeMap,
+		TribeCityMap:           tribeCityMap,
+		TurnCaptureMap:         turnCaptureMap,
+		Actions:                actions,
+		InitialStateGap:        initialStateGap,
+		CurrentStateGap:        currentStateGap,
+		Trailer:                trailer,
+	}
+	if versionWarning != "" {
+		output.Warnings = append(output.Warnings, versionWarning)
 	}
 	return output, nil
 }
+
+// SerializePolytopiaSave converts the parsed save back into decompressed file data.
+// A save that was parsed and not modified is serialized to the same bytes as the original file.
+// Gaps that are nil are written as zero bytes.
+func SerializePolytopiaSave(saveOutput *PolytopiaSaveOutput) ([]byte, error) {
+	initialStateGap := saveOutput.InitialStateGap
+	if initialStateGap == nil {
+		initialStateGap = make([]byte, 3)
+	}
+	currentStateGap := saveOutput.CurrentStateGap
+	if currentStateGap == nil {
+		currentStateGap = make([]byte, 2)
+	}
+
+	fileData := make([]byte, 0)
+	initialStateBytes, err := serializeMapState(saveOutput.InitialMapHeaderOutput, saveOutput.InitialTileData, saveOutput.InitialPlayerData, saveOutput.GameVersion)
+	if err != nil {
+		return nil, fmt.Errorf("initial state: %w", err)
+	}
+	fileData = append(fileData, initialStateBytes...)
+	fileData = append(fileData, initialStateGap...)
+
+	currentStateBytes, err := serializeMapState(saveOutput.MapHeaderOutput, saveOutput.TileData, saveOutput.PlayerData, saveOutput.GameVersion)
+	if err != nil {
+		return nil, fmt.Errorf("current state: %w", err)
+	}
+	fileData = append(fileData, currentStateBytes...)
+	fileData = append(fileData, currentStateGap...)
+
+	actionBytes, err := SerializeActionsToBytes(saveOutput.Actions)
+	if err != nil {
+		return nil, err
+	}
+	fileData = append(fileData, actionBytes...)
+	fileData = append(fileData, saveOutput.Trailer...)
+	return fileData, nil
+}
+
+// serializeMapState converts the map header, tiles and players of one state
+func serializeMapState(mapHeader MapHeaderOutput, tileData [][]TileData, playerData []PlayerData, gameVersion int) ([]byte, error) {
+	mapHeaderBytes, err := SerializeMapHeaderToBytes(mapHeader)
+	if err != nil {
+		return nil, fmt.Errorf("map header: %w", err)
+	}
+	mapBytes, err := ConvertMapDataToBytes(tileData, gameVersion)
+	if err != nil {
+		return nil, err
+	}
+	playerBytes, err := ConvertAllPlayerDataToBytes(playerData, gameVersion)
+	if err != nil {
+		return nil, err
+	}
+
+	stateBytes := make([]byte, 0, len(mapHeaderBytes)+len(mapBytes)+len(playerBytes))
+	stateBytes = append(stateBytes, mapHeaderBytes...)
+	stateBytes = append(stateBytes, mapBytes...)
+	return append(stateBytes, playerBytes...), nil
+}
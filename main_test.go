@@ -0,0 +1,247 @@
+package polytopiamapmodel
+
+import (
+	"bytes"
+	"image/color"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+// buildDetailedTestSaveBytes assembles a save where the initial and current state differ,
+// with cities, units, unknown gap bytes and data after the action list
+func buildDetailedTestSaveBytes(gameVersion int) []byte {
+	initialMapHeader := mapHeaderOutput
+	initialMapHeader.MapHeaderInput.Version1 = uint32(gameVersion)
+	initialMapHeader.MapHeaderInput.CurrentTurn = 0
+	initialMapHeader.MapSquareSize = 2
+	initialMapHeader.MapWidth = 2
+	initialMapHeader.MapHeight = 2
+	currentMapHeader := initialMapHeader
+	currentMapHeader.MapHeaderInput.CurrentTurn = 2
+
+	initialTileData := make([][]TileData, 2)
+	for i := 0; i < 2; i++ {
+		initialTileData[i] = make([]TileData, 2)
+		for j := 0; j < 2; j++ {
+			initialTileData[i][j] = BuildEmptyTile(j, i)
+			initialTileData[i][j].PlayerVisibility = []int{1, 255}
+		}
+	}
+	setTileTerrain(&initialTileData[0][1], 1)
+	setCityOnTile(&initialTileData[0][0], 0, 0, "Capital", 1)
+	initialTileData[0][0].Unit = &UnitData{
+		Id:                 1,
+		Owner:              1,
+		UnitType:           2,
+		CurrentCoordinates: [2]int32{0, 0},
+		HomeCoordinates:    [2]int32{0, 0},
+		Health:             100,
+	}
+	initialTileData[0][0].UnitEffectData = []int{}
+	initialTileData[0][0].UnitDirectionData = []int{0, 0, 0, 0, 0}
+
+	currentTileData := cloneTileGrid(initialTileData)
+	currentTileData[1][1].Unit = currentTileData[0][0].Unit
+	currentTileData[1][1].Unit.CurrentCoordinates = [2]int32{1, 1}
+	currentTileData[1][1].PassengerUnit = &UnitData{Id: 2, Owner: 1, UnitType: 5, Health: 150}
+	currentTileData[1][1].PassengerUnitEffectData = []int{3}
+	currentTileData[1][1].PassengerUnitDirectionData = []int{1, 0, 0, 0, 0}
+	currentTileData[1][1].UnitEffectData = []int{2}
+	currentTileData[1][1].UnitDirectionData = []int{0, 0, 0, 1, 0}
+	currentTileData[0][0].Unit = nil
+	currentTileData[0][0].UnitEffectData = []int{}
+	currentTileData[0][0].UnitDirectionData = []int{}
+	currentTileData[1][0].HasRoad = true
+	if gameVersion >= 105 {
+		currentTileData[0][1].FloodedFlag = 1
+		currentTileData[0][1].FloodedValue = 7
+	}
+
+	player := mustBuildEmptyPlayer(1, "Player1", color.RGBA{10, 20, 30, 255})
+	naturePlayer := mustBuildEmptyPlayer(1, "Nature", color.RGBA{0, 0, 0, 255})
+	naturePlayer.PlayerId = 255
+	initialPlayers := []PlayerData{player, naturePlayer}
+	currentPlayers := clonePlayerList(initialPlayers)
+	currentPlayers[0].AvailableTech = []int{12}
+	currentPlayers[0].Currency = 12
+
+	fileBytes := make([]byte, 0)
+	fileBytes = append(fileBytes, mustSerialize(serializeMapState(initialMapHeader, initialTileData, initialPlayers, gameVersion))...)
+	fileBytes = append(fileBytes, 1, 2, 3)
+	fileBytes = append(fileBytes, mustSerialize(serializeMapState(currentMapHeader, currentTileData, currentPlayers, gameVersion))...)
+	fileBytes = append(fileBytes, 4, 5)
+	fileBytes = append(fileBytes, actionListBytes...)
+	fileBytes = append(fileBytes, 9, 8, 7)
+	return fileBytes
+}
+
+func TestSerializePolytopiaSaveRoundTrip(t *testing.T) {
+	fixtures := map[string][]byte{
+		"empty map":      buildTestSaveBytes(),
+		"version 104":    buildDetailedTestSaveBytes(104),
+		"version 105":    buildDetailedTestSaveBytes(105),
+		"unknown action": append(buildTestSaveBytes()[:len(buildTestSaveBytes())-3], 19, 0, 1, 2, 3),
+	}
+	for _, filename := range testStateFilenames(t) {
+		compressedData, err := os.ReadFile(filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		inputByteData, err := Decompress(bytes.NewReader(compressedData))
+		if err != nil {
+			t.Fatalf(`Failed to decompress %v: %v`, filename, err)
+		}
+		fixtures[filename] = inputByteData
+	}
+
+	for name, inputByteData := range fixtures {
+		streamReader := io.NewSectionReader(bytes.NewReader(inputByteData), 0, int64(len(inputByteData)))
+		saveOutput, err := ParsePolytopiaFile(streamReader)
+		if err != nil {
+			t.Fatalf(`Failed to parse %v: %v`, name, err)
+		}
+		resultBytes := serializeTestSave(t, saveOutput)
+		if !bytes.Equal(resultBytes, inputByteData) {
+			t.Errorf(`Round trip of %v doesn't match, size = %v, expected size = %v`, name, len(resultBytes), len(inputByteData))
+			findArrayDifference(resultBytes, inputByteData)
+		}
+	}
+}
+
+// testGameSaves returns the decompressed data of the saves in testdata/game, which were saved by the game, see testdata/README.md.
+// The test fails when there are none, unless it runs with -short.
+func testGameSaves(t *testing.T) map[string][]byte {
+	filenames, err := filepath.Glob(filepath.Join("testdata", "game", "*.state"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(filenames) == 0 {
+		if testing.Short() {
+			t.Skip(`No saves from the game in testdata/game`)
+		}
+		t.Fatalf(`No saves from the game in testdata/game, add them as described in testdata/README.md or run with -short to skip this test`)
+	}
+	saves := make(map[string][]byte)
+	for _, filename := range filenames {
+		compressedData, err := os.ReadFile(filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		inputByteData, err := Decompress(bytes.NewReader(compressedData))
+		if err != nil {
+			t.Fatalf(`Failed to decompress %v: %v`, filename, err)
+		}
+		saves[filename] = inputByteData
+	}
+	return saves
+}
+
+// TestSerializeGameSavesRoundTrip checks the parser and serializer against the layout written by the game
+func TestSerializeGameSavesRoundTrip(t *testing.T) {
+	layoutsFound := make(map[string]bool)
+	for filename, inputByteData := range testGameSaves(t) {
+		saveOutput, err := ParsePolytopiaFile(io.NewSectionReader(bytes.NewReader(inputByteData), 0, int64(len(inputByteData))))
+		if err != nil {
+			t.Fatalf(`Failed to parse %v: %v`, filename, err)
+		}
+		layoutsFound[nearestVersionLayout(saveOutput.GameVersion).Name] = true
+
+		resultBytes := serializeTestSave(t, saveOutput)
+		if !bytes.Equal(resultBytes, inputByteData) {
+			t.Errorf(`Round trip of %v doesn't match, size = %v, expected size = %v`, filename, len(resultBytes), len(inputByteData))
+			findArrayDifference(resultBytes, inputByteData)
+		}
+	}
+	for _, layout := range versionLayouts {
+		if !layoutsFound[layout.Name] {
+			t.Errorf(`No save from the game in testdata/game uses the %q layout for versions %v to %v`,
+				layout.Name, layout.MinVersion, layout.MaxVersion)
+		}
+	}
+}
+
+// testdata/map8x8_v105.state was built with this package, so this is a self-consistency check, see testdata/README.md
+func TestParseTestdataSave(t *testing.T) {
+	saveOutput, err := ReadPolytopiaDecompressedFile("testdata/map8x8_v105.state.decomp")
+	if err != nil {
+		t.Fatalf(`Failed to read save: %v`, err)
+	}
+	if saveOutput.GameVersion != 105 || saveOutput.MapWidth != 8 || saveOutput.MapHeight != 8 {
+		t.Fatalf(`Game version = %v, map size = %vx%v, expected = 105, 8x8`, saveOutput.GameVersion, saveOutput.MapWidth, saveOutput.MapHeight)
+	}
+	if len(saveOutput.PlayerData) != 3 || saveOutput.PlayerData[1].Name != "Player2" || saveOutput.PlayerData[2].PlayerId != 255 {
+		t.Fatalf(`Unexpected players: %+v`, saveOutput.PlayerData)
+	}
+	if city := saveOutput.TileData[5][5].ImprovementData; city == nil || city.CityName != "Second Capital" {
+		t.Fatalf(`Tile (5, 5) doesn't have the city "Second Capital"`)
+	}
+	if unit := saveOutput.TileData[5][6].Unit; unit == nil || unit.Id != 52 || unit.UnitType != 3 {
+		t.Fatalf(`Tile (6, 5) doesn't have unit 52`)
+	}
+	if len(saveOutput.Actions) != 8 {
+		t.Fatalf(`Action count = %v, expected = 8`, len(saveOutput.Actions))
+	}
+	if _, ok := saveOutput.Actions[6].Action.(ActionMove); !ok {
+		t.Fatalf(`Action 6 = %T, expected = ActionMove`, saveOutput.Actions[6].Action)
+	}
+}
+
+func TestParseUnknownGapsAndTrailer(t *testing.T) {
+	inputByteData := buildDetailedTestSaveBytes(105)
+	saveOutput, err := ParsePolytopiaFile(io.NewSectionReader(bytes.NewReader(inputByteData), 0, int64(len(inputByteData))))
+	if err != nil {
+		t.Fatalf(`Failed to parse: %v`, err)
+	}
+	compareArrays(t, saveOutput.InitialStateGap, []byte{1, 2, 3})
+	compareArrays(t, saveOutput.CurrentStateGap, []byte{4, 5})
+	compareArrays(t, saveOutput.Trailer, []byte{9, 8, 7})
+
+	saveOutput.Trailer = append(saveOutput.Trailer, 6)
+	resultBytes := serializeTestSave(t, saveOutput)
+	compareArrays(t, resultBytes, append(inputByteData, 6))
+}
+
+func TestParsePolytopiaFileConcurrently(t *testing.T) {
+	fixtures := [][]byte{buildTestSaveBytes(), buildDetailedTestSaveBytes(105)}
+	expectedOffsets := make([]map[string]int, len(fixtures))
+	for i := 0; i < len(fixtures); i++ {
+		saveOutput, err := ParsePolytopiaFile(io.NewSectionReader(bytes.NewReader(fixtures[i]), 0, int64(len(fixtures[i]))))
+		if err != nil {
+			t.Fatalf(`Failed to parse fixture %v: %v`, i, err)
+		}
+		expectedOffsets[i] = saveOutput.FileOffsetMap
+	}
+	if reflect.DeepEqual(expectedOffsets[0], expectedOffsets[1]) {
+		t.Fatalf(`Fixtures should have different offsets`)
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan string, 100)
+	for worker := 0; worker < 20; worker++ {
+		wg.Add(1)
+		go func(fixtureIndex int) {
+			defer wg.Done()
+			fixture := fixtures[fixtureIndex]
+			for i := 0; i < 5; i++ {
+				saveOutput, err := ParsePolytopiaFile(io.NewSectionReader(bytes.NewReader(fixture), 0, int64(len(fixture))))
+				if err != nil {
+					errs <- err.Error()
+					return
+				}
+				if !reflect.DeepEqual(saveOutput.FileOffsetMap, expectedOffsets[fixtureIndex]) {
+					errs <- "offsets don't match sequential parse"
+					return
+				}
+			}
+		}(worker % len(fixtures))
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatalf(`Concurrent parse failed: %v`, err)
+	}
+}
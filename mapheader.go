@@ -1,107 +1,182 @@
 package polytopiamapmodel
 
 import (
-	"encoding/binary"
+	"fmt"
 	"io"
-	"log"
+	"math"
 )
 
 type MapHeaderInput struct {
-	Version1           uint32
-	Version2           uint32
-	TotalActions       uint16
-	CurrentTurn        uint32
-	CurrentPlayerIndex uint8
-	MaxUnitId          uint32
-	CurrentGameState   uint8
-	Seed               int32
-	TurnLimit          uint32
-	ScoreLimit         uint32
-	WinByCapital       uint8
-	UnknownSettings    [6]byte
-	GameModeBase       uint8
-	GameModeRules      uint8
+	Version1           uint32  `json:"version1"`
+	Version2           uint32  `json:"version2"`
+	TotalActions       uint16  `json:"totalActions"`
+	CurrentTurn        uint32  `json:"currentTurn"`
+	CurrentPlayerIndex uint8   `json:"currentPlayerIndex"`
+	MaxUnitId          uint32  `json:"maxUnitId"`
+	CurrentGameState   uint8   `json:"currentGameState"`
+	Seed               int32   `json:"seed"`
+	TurnLimit          uint32  `json:"turnLimit"`
+	ScoreLimit         uint32  `json:"scoreLimit"`
+	WinByCapital       uint8   `json:"winByCapital"`
+	UnknownSettings    [6]byte `json:"undecodedSettings"`
+	GameModeBase       uint8   `json:"gameModeBase"`
+	GameModeRules      uint8   `json:"gameModeRules"`
 }
 
 type MapHeaderOutput struct {
-	MapHeaderInput       MapHeaderInput
-	MapName              string
-	MapSquareSize        int
-	DisabledTribesArr    []int
-	UnlockedTribesArr    []int
-	GameDifficulty       int
-	NumOpponents         int
-	GameType             int
-	MapPreset            int
-	TurnTimeLimitMinutes int
-	UnknownFloat1        float32
-	UnknownFloat2        float32
-	BaseTimeSeconds      float32
-	TimeSettings         []int
-	SelectedTribeSkins   []TribeSkin
-	MapWidth             int
-	MapHeight            int
+	MapHeaderInput       MapHeaderInput `json:"mapHeaderInput"`
+	MapName              string         `json:"mapName"`
+	MapSquareSize        int            `json:"mapSquareSize"`
+	DisabledTribesArr    []int          `json:"disabledTribesArr"`
+	UnlockedTribesArr    []int          `json:"unlockedTribesArr"`
+	GameDifficulty       int            `json:"gameDifficulty"`
+	NumOpponents         int            `json:"numOpponents"`
+	GameType             int            `json:"gameType"`
+	MapPreset            int            `json:"mapPreset"`
+	TurnTimeLimitMinutes int            `json:"turnTimeLimitMinutes"`
+	UnknownFloat1        float32        `json:"undecodedFloat1"`
+	UnknownFloat2        float32        `json:"undecodedFloat2"`
+	BaseTimeSeconds      float32        `json:"baseTimeSeconds"`
+	TimeSettings         []int          `json:"timeSettings"`
+	SelectedTribeSkins   []TribeSkin    `json:"selectedTribeSkins"`
+	MapWidth             int            `json:"mapWidth"`
+	MapHeight            int            `json:"mapHeight"`
 }
 
 type TribeSkin struct {
-	Tribe int
-	Skin  int
+	Tribe int `json:"tribe"`
+	Skin  int `json:"skin"`
 }
 
-func DeserializeMapHeaderFromBytes(streamReader *io.SectionReader) MapHeaderOutput {
+func DeserializeMapHeaderFromBytes(streamReader *io.SectionReader) (MapHeaderOutput, error) {
+	return readMapHeader(streamReader, make(map[string]int))
+}
+
+// readMapHeader deserializes the map header and records the offsets of the map dimensions in fileOffsetMap
+func readMapHeader(streamReader *io.SectionReader, fileOffsetMap map[string]int) (MapHeaderOutput, error) {
+	mapHeaderOutput, err := deserializeMapHeader(streamReader, fileOffsetMap)
+	if err != nil {
+		return MapHeaderOutput{}, withSection(err, "map header")
+	}
+	return mapHeaderOutput, nil
+}
+
+func deserializeMapHeader(streamReader *io.SectionReader, fileOffsetMap map[string]int) (MapHeaderOutput, error) {
 	mapHeaderInput := MapHeaderInput{}
-	if err := binary.Read(streamReader, binary.LittleEndian, &mapHeaderInput); err != nil {
-		log.Fatal("Failed to load MapHeaderInput: ", err)
+	if err := readStructSafe(streamReader, "MapHeaderInput", &mapHeaderInput); err != nil {
+		return MapHeaderOutput{}, err
 	}
 
-	mapName := readVarString(streamReader, "MapName")
+	mapName, err := readVarString(streamReader, "MapName")
+	if err != nil {
+		return MapHeaderOutput{}, err
+	}
 
 	// map dimenions is a square: squareSize x squareSize
 	updateFileOffsetMap(fileOffsetMap, streamReader, "SquareSizeKey")
-	squareSize := int(unsafeReadUint32(streamReader))
+	squareSize, err := readUint32Safe(streamReader, "map square size")
+	if err != nil {
+		return MapHeaderOutput{}, err
+	}
 
-	disabledTribesSize := unsafeReadUint16(streamReader)
+	disabledTribesSize, err := readUint16Safe(streamReader, "disabled tribes size")
+	if err != nil {
+		return MapHeaderOutput{}, err
+	}
 	disabledTribesArr := make([]int, disabledTribesSize)
 	for i := 0; i < int(disabledTribesSize); i++ {
-		disabledTribesArr[i] = int(unsafeReadUint16(streamReader))
+		disabledTribe, err := readUint16Safe(streamReader, fmt.Sprintf("disabled tribe %d", i))
+		if err != nil {
+			return MapHeaderOutput{}, err
+		}
+		disabledTribesArr[i] = int(disabledTribe)
 	}
 
-	unlockedTribesSize := unsafeReadUint16(streamReader)
+	unlockedTribesSize, err := readUint16Safe(streamReader, "unlocked tribes size")
+	if err != nil {
+		return MapHeaderOutput{}, err
+	}
 	unlockedTribesArr := make([]int, unlockedTribesSize)
 	for i := 0; i < int(unlockedTribesSize); i++ {
-		unlockedTribesArr[i] = int(unsafeReadUint16(streamReader))
+		unlockedTribe, err := readUint16Safe(streamReader, fmt.Sprintf("unlocked tribe %d", i))
+		if err != nil {
+			return MapHeaderOutput{}, err
+		}
+		unlockedTribesArr[i] = int(unlockedTribe)
 	}
 
-	gameDifficulty := unsafeReadUint16(streamReader)
-	numOpponents := unsafeReadUint32(streamReader)
-	gameType := unsafeReadUint16(streamReader)
-	mapPreset := unsafeReadUint8(streamReader)
-	turnTimeLimitMinutes := unsafeReadInt32(streamReader)
-	unknownFloat1 := unsafeReadFloat32(streamReader)
-	unknownFloat2 := unsafeReadFloat32(streamReader)
-	baseTimeSeconds := unsafeReadFloat32(streamReader)
-	timeSettings := readFixedList(streamReader, 4)
+	gameDifficulty, err := readUint16Safe(streamReader, "game difficulty")
+	if err != nil {
+		return MapHeaderOutput{}, err
+	}
+	numOpponents, err := readUint32Safe(streamReader, "number of opponents")
+	if err != nil {
+		return MapHeaderOutput{}, err
+	}
+	gameType, err := readUint16Safe(streamReader, "game type")
+	if err != nil {
+		return MapHeaderOutput{}, err
+	}
+	mapPreset, err := readUint8Safe(streamReader, "map preset")
+	if err != nil {
+		return MapHeaderOutput{}, err
+	}
+	turnTimeLimitMinutes, err := readInt32Safe(streamReader, "turn time limit minutes")
+	if err != nil {
+		return MapHeaderOutput{}, err
+	}
+	unknownFloat1, err := readFloat32Safe(streamReader, "unknown float 1")
+	if err != nil {
+		return MapHeaderOutput{}, err
+	}
+	unknownFloat2, err := readFloat32Safe(streamReader, "unknown float 2")
+	if err != nil {
+		return MapHeaderOutput{}, err
+	}
+	baseTimeSeconds, err := readFloat32Safe(streamReader, "base time seconds")
+	if err != nil {
+		return MapHeaderOutput{}, err
+	}
+	timeSettings, err := readFixedList(streamReader, 4, "time settings")
+	if err != nil {
+		return MapHeaderOutput{}, err
+	}
 
-	selectedTribeSkinSize := unsafeReadUint32(streamReader)
-	selectedTribeSkins := make([]TribeSkin, int(selectedTribeSkinSize))
+	selectedTribeSkinSize, err := readUint32Safe(streamReader, "selected tribe skin size")
+	if err != nil {
+		return MapHeaderOutput{}, err
+	}
+	selectedTribeSkins := make([]TribeSkin, 0)
 	for i := 0; i < int(selectedTribeSkinSize); i++ {
-		tribe := unsafeReadUint16(streamReader)
-		skin := unsafeReadUint16(streamReader)
-		selectedTribeSkins[i] = TribeSkin{
+		tribe, err := readUint16Safe(streamReader, fmt.Sprintf("selected tribe skin %d tribe", i))
+		if err != nil {
+			return MapHeaderOutput{}, err
+		}
+		skin, err := readUint16Safe(streamReader, fmt.Sprintf("selected tribe skin %d skin", i))
+		if err != nil {
+			return MapHeaderOutput{}, err
+		}
+		selectedTribeSkins = append(selectedTribeSkins, TribeSkin{
 			Tribe: int(tribe),
 			Skin:  int(skin),
-		}
+		})
 	}
 
 	updateFileOffsetMap(fileOffsetMap, streamReader, "MapWidth")
-	mapWidth := unsafeReadUint16(streamReader)
+	mapWidth, err := readUint16Safe(streamReader, "map width")
+	if err != nil {
+		return MapHeaderOutput{}, err
+	}
 	updateFileOffsetMap(fileOffsetMap, streamReader, "MapHeight")
-	mapHeight := unsafeReadUint16(streamReader)
+	mapHeight, err := readUint16Safe(streamReader, "map height")
+	if err != nil {
+		return MapHeaderOutput{}, err
+	}
 
 	return MapHeaderOutput{
 		MapHeaderInput:       mapHeaderInput,
 		MapName:              mapName,
-		MapSquareSize:        squareSize,
+		MapSquareSize:        int(squareSize),
 		DisabledTribesArr:    disabledTribesArr,
 		UnlockedTribesArr:    unlockedTribesArr,
 		GameDifficulty:       int(gameDifficulty),
@@ -116,10 +191,33 @@ func DeserializeMapHeaderFromBytes(streamReader *io.SectionReader) MapHeaderOutp
 		SelectedTribeSkins:   selectedTribeSkins,
 		MapWidth:             int(mapWidth),
 		MapHeight:            int(mapHeight),
+	}, nil
+}
+
+// checkMapHeaderFields returns an error for the first value that doesn't fit in its field when the map header is serialized
+func checkMapHeaderFields(mapHeaderOutput MapHeaderOutput) error {
+	errs := []error{
+		checkVarStringField("MapName", mapHeaderOutput.MapName),
+		checkListFields("DisabledTribesArr", mapHeaderOutput.DisabledTribesArr, math.MaxUint16, checkTribeField),
+		checkListFields("UnlockedTribesArr", mapHeaderOutput.UnlockedTribesArr, math.MaxUint16, checkTribeField),
+		checkUint16Field("GameDifficulty", mapHeaderOutput.GameDifficulty),
+		checkUint16Field("GameType", mapHeaderOutput.GameType),
+		checkUint8Field("MapPreset", mapHeaderOutput.MapPreset),
+		checkUint16Field("MapWidth", mapHeaderOutput.MapWidth),
+		checkUint16Field("MapHeight", mapHeaderOutput.MapHeight),
 	}
+	for i, tribeSkin := range mapHeaderOutput.SelectedTribeSkins {
+		errs = append(errs,
+			checkTribeField(fmt.Sprintf("SelectedTribeSkins[%v].Tribe", i), tribeSkin.Tribe),
+			checkUint16Field(fmt.Sprintf("SelectedTribeSkins[%v].Skin", i), tribeSkin.Skin))
+	}
+	return firstFieldError(errs...)
 }
 
-func SerializeMapHeaderToBytes(mapHeaderOutput MapHeaderOutput) []byte {
+func SerializeMapHeaderToBytes(mapHeaderOutput MapHeaderOutput) ([]byte, error) {
+	if err := checkMapHeaderFields(mapHeaderOutput); err != nil {
+		return nil, err
+	}
 	serializedData := make([]byte, 0)
 
 	serializedData = append(serializedData, SerializeMapHeaderInputToBytes(mapHeaderOutput.MapHeaderInput)...)
@@ -144,7 +242,10 @@ func SerializeMapHeaderToBytes(mapHeaderOutput MapHeaderOutput) []byte {
 	serializedData = append(serializedData, ConvertFloat32Bytes(mapHeaderOutput.UnknownFloat1)...)
 	serializedData = append(serializedData, ConvertFloat32Bytes(mapHeaderOutput.UnknownFloat2)...)
 	serializedData = append(serializedData, ConvertFloat32Bytes(mapHeaderOutput.BaseTimeSeconds)...)
-	serializedData = append(serializedData, ConvertByteList(mapHeaderOutput.TimeSettings)...)
+	serializedData, err := appendByteList(serializedData, "TimeSettings", mapHeaderOutput.TimeSettings)
+	if err != nil {
+		return nil, err
+	}
 
 	serializedData = append(serializedData, ConvertUint32Bytes(len(mapHeaderOutput.SelectedTribeSkins))...)
 	for i := 0; i < len(mapHeaderOutput.SelectedTribeSkins); i++ {
@@ -155,7 +256,7 @@ func SerializeMapHeaderToBytes(mapHeaderOutput MapHeaderOutput) []byte {
 	serializedData = append(serializedData, ConvertUint16Bytes(mapHeaderOutput.MapWidth)...)
 	serializedData = append(serializedData, ConvertUint16Bytes(mapHeaderOutput.MapHeight)...)
 
-	return serializedData
+	return serializedData, nil
 }
 
 func SerializeMapHeaderInputToBytes(mapHeaderInput MapHeaderInput) []byte {
@@ -85,7 +85,10 @@ var (
 func TestDeserializeMapHeaderFromBytes(t *testing.T) {
 	inputByteData := mapHeaderBytes
 	streamReader := io.NewSectionReader(bytes.NewReader(inputByteData), 0, int64(len(inputByteData)))
-	result := DeserializeMapHeaderFromBytes(streamReader)
+	result, err := DeserializeMapHeaderFromBytes(streamReader)
+	if err != nil {
+		t.Fatalf(`Failed to deserialize: %v`, err)
+	}
 	expected := mapHeaderOutput
 
 	if !reflect.DeepEqual(result, expected) {
@@ -94,7 +97,10 @@ func TestDeserializeMapHeaderFromBytes(t *testing.T) {
 }
 
 func TestSerializeMapHeaderToBytes(t *testing.T) {
-	resultBytes := SerializeMapHeaderToBytes(mapHeaderOutput)
+	resultBytes, err := SerializeMapHeaderToBytes(mapHeaderOutput)
+	if err != nil {
+		t.Fatalf(`Failed to serialize: %v`, err)
+	}
 	expectedBytes := mapHeaderBytes
 
 	if !reflect.DeepEqual(resultBytes, expectedBytes) {
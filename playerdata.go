@@ -1,11 +1,10 @@
 package polytopiamapmodel
 
 import (
-	"encoding/binary"
 	"fmt"
 	"image/color"
 	"io"
-	"log"
+	"math"
 )
 
 type CityLocationData struct {
@@ -16,82 +15,126 @@ type CityLocationData struct {
 }
 
 type PlayerData struct {
-	PlayerId             int
-	Name                 string
-	AccountId            string
-	AutoPlay             bool
-	StartTileCoordinates [2]int
-	Tribe                int
-	UnknownByte1         int
-	DifficultyHandicap   int
-	AggressionsByPlayers []PlayerAggression
-	Currency             int
-	Score                int
-	UnknownInt2          int
-	NumCities            int
-	AvailableTech        []int
-	EncounteredPlayers   []int
-	Tasks                []PlayerTaskData
-	TotalUnitsKilled     int
-	TotalUnitsLost       int
-	TotalTribesDestroyed int
-	OverrideColor        []int
-	OverrideTribe        byte
-	UniqueImprovements   []int
-	DiplomacyArr         []DiplomacyData
-	DiplomacyMessages    []DiplomacyMessage
-	DestroyedByTribe     int
-	DestroyedTurn        int
-	UnknownBuffer2       []int
-	EndScore             int
-	PlayerSkin           int
-	UnknownBuffer3       []int
+	PlayerId             int                `json:"playerId"`
+	Name                 string             `json:"name"`
+	AccountId            string             `json:"accountId"`
+	AutoPlay             bool               `json:"autoPlay"`
+	StartTileCoordinates [2]int             `json:"startTileCoordinates"`
+	Tribe                int                `json:"tribe"`
+	UnknownByte1         int                `json:"undecodedByte1"`
+	DifficultyHandicap   int                `json:"difficultyHandicap"`
+	AggressionsByPlayers []PlayerAggression `json:"aggressionsByPlayers"`
+	Currency             int                `json:"currency"`
+	Score                int                `json:"score"`
+	UnknownInt2          int                `json:"undecodedInt2"`
+	NumCities            int                `json:"numCities"`
+	AvailableTech        []int              `json:"availableTech"`
+	EncounteredPlayers   []int              `json:"encounteredPlayers"`
+	Tasks                []PlayerTaskData   `json:"tasks"`
+	TotalUnitsKilled     int                `json:"totalUnitsKilled"`
+	TotalUnitsLost       int                `json:"totalUnitsLost"`
+	TotalTribesDestroyed int                `json:"totalTribesDestroyed"`
+	OverrideColor        []int              `json:"overrideColor"`
+	OverrideTribe        byte               `json:"overrideTribe"`
+	UniqueImprovements   []int              `json:"uniqueImprovements"`
+	DiplomacyArr         []DiplomacyData    `json:"diplomacyArr"`
+	DiplomacyMessages    []DiplomacyMessage `json:"diplomacyMessages"`
+	DestroyedByTribe     int                `json:"destroyedByTribe"`
+	DestroyedTurn        int                `json:"destroyedTurn"`
+	UnknownBuffer2       []int              `json:"undecodedBytes2"`
+	EndScore             int                `json:"endScore"`
+	PlayerSkin           int                `json:"playerSkin"`
+	UnknownBuffer3       []int              `json:"undecodedBytes3"`
 }
 
 type PlayerAggression struct {
-	PlayerId   int
-	Aggression int
+	PlayerId   int `json:"playerId"`
+	Aggression int `json:"aggression"`
 }
 
 type PlayerTaskData struct {
-	Type   int
-	Buffer []int
+	Type   int   `json:"type"`
+	Buffer []int `json:"buffer"`
 }
 
 type DiplomacyMessage struct {
-	MessageType int
-	Sender      int
+	MessageType int `json:"messageType"`
+	Sender      int `json:"sender"`
 }
 
 type DiplomacyData struct {
-	PlayerId               uint8
-	DiplomacyRelationState uint8
-	LastAttackTurn         int32
-	EmbassyLevel           uint8
-	LastPeaceBrokenTurn    int32
-	FirstMeet              int32
-	EmbassyBuildTurn       int32
-	PreviousAttackTurn     int32
+	PlayerId               uint8 `json:"playerId"`
+	DiplomacyRelationState uint8 `json:"diplomacyRelationState"`
+	LastAttackTurn         int32 `json:"lastAttackTurn"`
+	EmbassyLevel           uint8 `json:"embassyLevel"`
+	LastPeaceBrokenTurn    int32 `json:"lastPeaceBrokenTurn"`
+	FirstMeet              int32 `json:"firstMeet"`
+	EmbassyBuildTurn       int32 `json:"embassyBuildTurn"`
+	PreviousAttackTurn     int32 `json:"previousAttackTurn"`
 }
 
-func DeserializePlayerDataFromBytes(streamReader *io.SectionReader, gameVersion int) PlayerData {
-	playerId, _ := readUint8Safe(streamReader, "player ID")
-	playerName := readVarString(streamReader, "playerName")
-	playerAccountId := readVarString(streamReader, "playerAccountId")
-	autoPlay, _ := readUint8Safe(streamReader, "auto play flag")
-	startTileCoordinates1, _ := readInt32Safe(streamReader, "start coordinates 1")
-	startTileCoordinates2, _ := readInt32Safe(streamReader, "start coordinates 2")
-	tribe, _ := readUint16Safe(streamReader, "tribe")
-	unknownByte1, _ := readUint8Safe(streamReader, "unknown byte")
-	difficultyHandicap, _ := readUint32Safe(streamReader, "difficulty handicap")
+func DeserializePlayerDataFromBytes(streamReader *io.SectionReader, gameVersion int) (PlayerData, error) {
+	playerData, err := deserializePlayerData(streamReader, gameVersion)
+	if err != nil {
+		return PlayerData{}, withSection(err, "player")
+	}
+	return playerData, nil
+}
+
+func deserializePlayerData(streamReader *io.SectionReader, gameVersion int) (PlayerData, error) {
+	playerId, err := readUint8Safe(streamReader, "player ID")
+	if err != nil {
+		return PlayerData{}, err
+	}
+	playerName, err := readVarString(streamReader, "playerName")
+	if err != nil {
+		return PlayerData{}, err
+	}
+	playerAccountId, err := readVarString(streamReader, "playerAccountId")
+	if err != nil {
+		return PlayerData{}, err
+	}
+	autoPlay, err := readUint8Safe(streamReader, "auto play flag")
+	if err != nil {
+		return PlayerData{}, err
+	}
+	startTileCoordinates1, err := readInt32Safe(streamReader, "start coordinates 1")
+	if err != nil {
+		return PlayerData{}, err
+	}
+	startTileCoordinates2, err := readInt32Safe(streamReader, "start coordinates 2")
+	if err != nil {
+		return PlayerData{}, err
+	}
+	tribe, err := readUint16Safe(streamReader, "tribe")
+	if err != nil {
+		return PlayerData{}, err
+	}
+	unknownByte1, err := readUint8Safe(streamReader, "unknown byte")
+	if err != nil {
+		return PlayerData{}, err
+	}
+	difficultyHandicap, err := readUint32Safe(streamReader, "difficulty handicap")
+	if err != nil {
+		return PlayerData{}, err
+	}
 
 	var aggressionsByPlayers []PlayerAggression
-	if gameVersion < 114 {
-		unknownArrLen1, _ := readUint16Safe(streamReader, "aggressions array length")
+	if nearestVersionLayout(gameVersion).HasPlayerAggressions {
+		unknownArrLen1, err := readUint16Safe(streamReader, "aggressions array length")
+		if err != nil {
+			return PlayerData{}, err
+		}
 		aggressionsByPlayers = make([]PlayerAggression, 0)
 		for i := 0; i < int(unknownArrLen1); i++ {
-			playerIdOther, _ := readUint8Safe(streamReader, fmt.Sprintf("aggression player ID %d", i))
-			aggression, _ := readInt32Safe(streamReader, fmt.Sprintf("aggression value %d", i))
+			playerIdOther, err := readUint8Safe(streamReader, fmt.Sprintf("aggression player ID %d", i))
+			if err != nil {
+				return PlayerData{}, err
+			}
+			aggression, err := readInt32Safe(streamReader, fmt.Sprintf("aggression value %d", i))
+			if err != nil {
+				return PlayerData{}, err
+			}
 			aggressionsByPlayers = append(aggressionsByPlayers, PlayerAggression{
 				PlayerId:   int(playerIdOther),
 				Aggression: int(aggression),
@@ -102,88 +145,173 @@ func DeserializePlayerDataFromBytes(streamReader *io.SectionReader, gameVersion
 		aggressionsByPlayers = make([]PlayerAggression, 0)
 	}
 
-	currency, _ := readUint32Safe(streamReader, "currency")
-	score, _ := readUint32Safe(streamReader, "score")
-	unknownInt2, _ := readUint32Safe(streamReader, "unknown int")
-	numCities, _ := readUint16Safe(streamReader, "number of cities")
+	currency, err := readUint32Safe(streamReader, "currency")
+	if err != nil {
+		return PlayerData{}, err
+	}
+	score, err := readUint32Safe(streamReader, "score")
+	if err != nil {
+		return PlayerData{}, err
+	}
+	unknownInt2, err := readUint32Safe(streamReader, "unknown int")
+	if err != nil {
+		return PlayerData{}, err
+	}
+	numCities, err := readUint16Safe(streamReader, "number of cities")
+	if err != nil {
+		return PlayerData{}, err
+	}
 
-	techArrayLen, _ := readUint16Safe(streamReader, "tech array length")
+	techArrayLen, err := readUint16Safe(streamReader, "tech array length")
+	if err != nil {
+		return PlayerData{}, err
+	}
 	techArray := make([]int, techArrayLen)
 	for i := 0; i < int(techArrayLen); i++ {
-		techType := unsafeReadUint16(streamReader)
+		techType, err := readUint16Safe(streamReader, fmt.Sprintf("tech %d", i))
+		if err != nil {
+			return PlayerData{}, err
+		}
 		techArray[i] = int(techType)
 	}
 
-	encounteredPlayersLen, _ := readUint16Safe(streamReader, "encountered players length")
+	encounteredPlayersLen, err := readUint16Safe(streamReader, "encountered players length")
+	if err != nil {
+		return PlayerData{}, err
+	}
 	encounteredPlayers := make([]int, 0)
 	for i := 0; i < int(encounteredPlayersLen); i++ {
-		playerId := unsafeReadUint8(streamReader)
+		playerId, err := readUint8Safe(streamReader, fmt.Sprintf("encountered player %d", i))
+		if err != nil {
+			return PlayerData{}, err
+		}
 		encounteredPlayers = append(encounteredPlayers, int(playerId))
 	}
 
-	numTasks, _ := readInt16Safe(streamReader, "number of tasks")
-	taskArr := make([]PlayerTaskData, int(numTasks))
+	numTasks, err := readInt16Safe(streamReader, "number of tasks")
+	if err != nil {
+		return PlayerData{}, err
+	}
+	taskArr := make([]PlayerTaskData, 0)
 	for i := 0; i < int(numTasks); i++ {
-		taskType := unsafeReadInt16(streamReader)
+		taskOffset := currentOffset(streamReader)
+		taskType, err := readInt16Safe(streamReader, fmt.Sprintf("task %d type", i))
+		if err != nil {
+			return PlayerData{}, err
+		}
 
 		var buffer []byte
 		if taskType == 1 || taskType == 5 { // Task type 1 is Pacifist, type 5 is Killer
-			buffer = readFixedList(streamReader, 6) // Extra buffer contains a uint32
+			buffer, err = readFixedList(streamReader, 6, fmt.Sprintf("task %d buffer", i)) // Extra buffer contains a uint32
 		} else if taskType >= 1 && taskType <= 8 {
-			buffer = readFixedList(streamReader, 2)
+			buffer, err = readFixedList(streamReader, 2, fmt.Sprintf("task %d buffer", i))
 		} else {
-			log.Fatal("Invalid task type:", taskType)
+			err = newParseError(taskOffset, fmt.Sprintf("task %d type", i), fmt.Errorf("invalid task type: %v", taskType))
 		}
-		taskArr[i] = PlayerTaskData{
+		if err != nil {
+			return PlayerData{}, err
+		}
+		taskArr = append(taskArr, PlayerTaskData{
 			Type:   int(taskType),
 			Buffer: convertByteListToInt(buffer),
-		}
+		})
 	}
 
-	totalKills, _ := readInt32Safe(streamReader, "total kills")
-	totalLosses, _ := readInt32Safe(streamReader, "total losses")
-	totalTribesDestroyed, _ := readInt32Safe(streamReader, "total tribes destroyed")
+	totalKills, err := readInt32Safe(streamReader, "total kills")
+	if err != nil {
+		return PlayerData{}, err
+	}
+	totalLosses, err := readInt32Safe(streamReader, "total losses")
+	if err != nil {
+		return PlayerData{}, err
+	}
+	totalTribesDestroyed, err := readInt32Safe(streamReader, "total tribes destroyed")
+	if err != nil {
+		return PlayerData{}, err
+	}
 	debugPrint("    Reading override color...\n")
-	overrideColor := convertByteListToInt(readFixedList(streamReader, 4))
-	overrideTribe, _ := readUint8Safe(streamReader, "override tribe")
+	overrideColor, err := readFixedList(streamReader, 4, "override color")
+	if err != nil {
+		return PlayerData{}, err
+	}
+	overrideTribe, err := readUint8Safe(streamReader, "override tribe")
+	if err != nil {
+		return PlayerData{}, err
+	}
 
-	playerUniqueImprovementsSize, _ := readUint16Safe(streamReader, "player unique improvements size")
+	playerUniqueImprovementsSize, err := readUint16Safe(streamReader, "player unique improvements size")
+	if err != nil {
+		return PlayerData{}, err
+	}
 	playerUniqueImprovements := make([]int, int(playerUniqueImprovementsSize))
 	for i := 0; i < int(playerUniqueImprovementsSize); i++ {
-		improvement := unsafeReadUint16(streamReader)
+		improvement, err := readUint16Safe(streamReader, fmt.Sprintf("unique improvement %d", i))
+		if err != nil {
+			return PlayerData{}, err
+		}
 		playerUniqueImprovements[i] = int(improvement)
 	}
 
-	diplomacyArrLen, _ := readUint16Safe(streamReader, "diplomacy array length")
-	diplomacyArr := make([]DiplomacyData, int(diplomacyArrLen))
-	for i := 0; i < len(diplomacyArr); i++ {
+	diplomacyArrLen, err := readUint16Safe(streamReader, "diplomacy array length")
+	if err != nil {
+		return PlayerData{}, err
+	}
+	diplomacyArr := make([]DiplomacyData, 0)
+	for i := 0; i < int(diplomacyArrLen); i++ {
 		diplomacyData := DiplomacyData{}
-		if err := binary.Read(streamReader, binary.LittleEndian, &diplomacyData); err != nil {
-			log.Fatal("Failed to load diplomacyData: ", err)
+		if err := readStructSafe(streamReader, fmt.Sprintf("diplomacy data %d", i), &diplomacyData); err != nil {
+			return PlayerData{}, err
 		}
-		diplomacyArr[i] = diplomacyData
+		diplomacyArr = append(diplomacyArr, diplomacyData)
 	}
 
-	diplomacyMessagesSize, _ := readUint16Safe(streamReader, "diplomacy messages size")
-	diplomacyMessagesArr := make([]DiplomacyMessage, int(diplomacyMessagesSize))
+	diplomacyMessagesSize, err := readUint16Safe(streamReader, "diplomacy messages size")
+	if err != nil {
+		return PlayerData{}, err
+	}
+	diplomacyMessagesArr := make([]DiplomacyMessage, 0)
 	for i := 0; i < int(diplomacyMessagesSize); i++ {
-		messageType := unsafeReadUint8(streamReader)
-		sender := unsafeReadUint8(streamReader)
+		messageType, err := readUint8Safe(streamReader, fmt.Sprintf("diplomacy message %d type", i))
+		if err != nil {
+			return PlayerData{}, err
+		}
+		sender, err := readUint8Safe(streamReader, fmt.Sprintf("diplomacy message %d sender", i))
+		if err != nil {
+			return PlayerData{}, err
+		}
 
-		diplomacyMessagesArr[i] = DiplomacyMessage{
+		diplomacyMessagesArr = append(diplomacyMessagesArr, DiplomacyMessage{
 			MessageType: int(messageType),
 			Sender:      int(sender),
-		}
+		})
 	}
 
-	destroyedByTribe, _ := readUint8Safe(streamReader, "destroyed by tribe")
-	destroyedTurn, _ := readUint32Safe(streamReader, "destroyed turn")
+	destroyedByTribe, err := readUint8Safe(streamReader, "destroyed by tribe")
+	if err != nil {
+		return PlayerData{}, err
+	}
+	destroyedTurn, err := readUint32Safe(streamReader, "destroyed turn")
+	if err != nil {
+		return PlayerData{}, err
+	}
 	debugPrint("    Reading unknown buffer 2...\n")
-	unknownBuffer2 := convertByteListToInt(readFixedList(streamReader, 4))
-	endScore, _ := readInt32Safe(streamReader, "end score")
-	playerSkin, _ := readUint16Safe(streamReader, "player skin")
+	unknownBuffer2, err := readFixedList(streamReader, 4, "unknown buffer 2")
+	if err != nil {
+		return PlayerData{}, err
+	}
+	endScore, err := readInt32Safe(streamReader, "end score")
+	if err != nil {
+		return PlayerData{}, err
+	}
+	playerSkin, err := readUint16Safe(streamReader, "player skin")
+	if err != nil {
+		return PlayerData{}, err
+	}
 	debugPrint("    Reading unknown buffer 3...\n")
-	unknownBuffer3 := convertByteListToInt(readFixedList(streamReader, 4))
+	unknownBuffer3, err := readFixedList(streamReader, 4, "unknown buffer 3")
+	if err != nil {
+		return PlayerData{}, err
+	}
 
 	return PlayerData{
 		PlayerId:             int(playerId),
@@ -205,22 +333,61 @@ func DeserializePlayerDataFromBytes(streamReader *io.SectionReader, gameVersion
 		TotalUnitsKilled:     int(totalKills),
 		TotalUnitsLost:       int(totalLosses),
 		TotalTribesDestroyed: int(totalTribesDestroyed),
-		OverrideColor:        overrideColor,
+		OverrideColor:        convertByteListToInt(overrideColor),
 		OverrideTribe:        overrideTribe,
 		UniqueImprovements:   playerUniqueImprovements,
 		DiplomacyArr:         diplomacyArr,
 		DiplomacyMessages:    diplomacyMessagesArr,
 		DestroyedByTribe:     int(destroyedByTribe),
 		DestroyedTurn:        int(destroyedTurn),
-		UnknownBuffer2:       unknownBuffer2,
+		UnknownBuffer2:       convertByteListToInt(unknownBuffer2),
 		EndScore:             int(endScore),
 		PlayerSkin:           int(playerSkin),
-		UnknownBuffer3:       unknownBuffer3,
+		UnknownBuffer3:       convertByteListToInt(unknownBuffer3),
+	}, nil
+}
+
+// checkPlayerFields returns an error for the first value that doesn't fit in its field when the player is serialized
+func checkPlayerFields(playerData PlayerData) error {
+	errs := []error{
+		checkUint8Field("PlayerId", playerData.PlayerId),
+		checkVarStringField("Name", playerData.Name),
+		checkVarStringField("AccountId", playerData.AccountId),
+		checkTribeField("Tribe", playerData.Tribe),
+		checkUint8Field("UnknownByte1", playerData.UnknownByte1),
+		checkUint16Field("NumCities", playerData.NumCities),
+		checkListFields("AvailableTech", playerData.AvailableTech, math.MaxUint16, checkUint16Field),
+		checkListFields("EncounteredPlayers", playerData.EncounteredPlayers, math.MaxUint16, checkUint8Field),
+		checkFieldRange("Tasks length", len(playerData.Tasks), 0, math.MaxInt16),
+		checkListFields("UniqueImprovements", playerData.UniqueImprovements, math.MaxUint16, checkUint16Field),
+		checkFieldRange("DiplomacyArr length", len(playerData.DiplomacyArr), 0, math.MaxUint16),
+		checkFieldRange("DiplomacyMessages length", len(playerData.DiplomacyMessages), 0, math.MaxUint16),
+		checkUint8Field("DestroyedByTribe", playerData.DestroyedByTribe),
+		checkUint16Field("PlayerSkin", playerData.PlayerSkin),
+	}
+	if len(playerData.AggressionsByPlayers) > math.MaxUint16 {
+		errs = append(errs, fmt.Errorf("AggressionsByPlayers has %v values, the limit is %v", len(playerData.AggressionsByPlayers), math.MaxUint16))
+	}
+	for i, aggression := range playerData.AggressionsByPlayers {
+		errs = append(errs, checkUint8Field(fmt.Sprintf("AggressionsByPlayers[%v].PlayerId", i), aggression.PlayerId))
+	}
+	for i, task := range playerData.Tasks {
+		errs = append(errs, checkInt16Field(fmt.Sprintf("Tasks[%v].Type", i), task.Type))
+	}
+	for i, message := range playerData.DiplomacyMessages {
+		errs = append(errs,
+			checkUint8Field(fmt.Sprintf("DiplomacyMessages[%v].MessageType", i), message.MessageType),
+			checkUint8Field(fmt.Sprintf("DiplomacyMessages[%v].Sender", i), message.Sender))
 	}
+	return firstFieldError(errs...)
 }
 
-func SerializePlayerDataToBytes(playerData PlayerData, gameVersion int) []byte {
+func SerializePlayerDataToBytes(playerData PlayerData, gameVersion int) ([]byte, error) {
+	if err := checkPlayerFields(playerData); err != nil {
+		return nil, err
+	}
 	allPlayerData := make([]byte, 0)
+	var err error
 
 	allPlayerData = append(allPlayerData, byte(playerData.PlayerId))
 	allPlayerData = append(allPlayerData, ConvertVarString(playerData.Name)...)
@@ -232,8 +399,8 @@ func SerializePlayerDataToBytes(playerData PlayerData, gameVersion int) []byte {
 	allPlayerData = append(allPlayerData, byte(playerData.UnknownByte1))
 	allPlayerData = append(allPlayerData, ConvertUint32Bytes(playerData.DifficultyHandicap)...)
 
-	// Only write aggressions array for versions < 114
-	if gameVersion < 114 {
+	// Only write aggressions array for versions that have it
+	if nearestVersionLayout(gameVersion).HasPlayerAggressions {
 		allPlayerData = append(allPlayerData, ConvertUint16Bytes(len(playerData.AggressionsByPlayers))...)
 		for i := 0; i < len(playerData.AggressionsByPlayers); i++ {
 			allPlayerData = append(allPlayerData, byte(playerData.AggressionsByPlayers[i].PlayerId))
@@ -259,13 +426,17 @@ func SerializePlayerDataToBytes(playerData PlayerData, gameVersion int) []byte {
 	allPlayerData = append(allPlayerData, ConvertUint16Bytes(len(playerData.Tasks))...)
 	for i := 0; i < len(playerData.Tasks); i++ {
 		allPlayerData = append(allPlayerData, ConvertUint16Bytes(playerData.Tasks[i].Type)...)
-		allPlayerData = append(allPlayerData, ConvertByteList(playerData.Tasks[i].Buffer)...)
+		if allPlayerData, err = appendByteList(allPlayerData, fmt.Sprintf("Tasks[%v].Buffer", i), playerData.Tasks[i].Buffer); err != nil {
+			return nil, err
+		}
 	}
 
 	allPlayerData = append(allPlayerData, ConvertUint32Bytes(playerData.TotalUnitsKilled)...)
 	allPlayerData = append(allPlayerData, ConvertUint32Bytes(playerData.TotalUnitsLost)...)
 	allPlayerData = append(allPlayerData, ConvertUint32Bytes(playerData.TotalTribesDestroyed)...)
-	allPlayerData = append(allPlayerData, ConvertByteList(playerData.OverrideColor)...)
+	if allPlayerData, err = appendByteList(allPlayerData, "OverrideColor", playerData.OverrideColor); err != nil {
+		return nil, err
+	}
 
 	allPlayerData = append(allPlayerData, playerData.OverrideTribe)
 
@@ -287,12 +458,16 @@ func SerializePlayerDataToBytes(playerData PlayerData, gameVersion int) []byte {
 
 	allPlayerData = append(allPlayerData, byte(playerData.DestroyedByTribe))
 	allPlayerData = append(allPlayerData, ConvertUint32Bytes(playerData.DestroyedTurn)...)
-	allPlayerData = append(allPlayerData, ConvertByteList(playerData.UnknownBuffer2)...)
+	if allPlayerData, err = appendByteList(allPlayerData, "UnknownBuffer2", playerData.UnknownBuffer2); err != nil {
+		return nil, err
+	}
 	allPlayerData = append(allPlayerData, ConvertUint32Bytes(playerData.EndScore)...)
 	allPlayerData = append(allPlayerData, ConvertUint16Bytes(playerData.PlayerSkin)...)
-	allPlayerData = append(allPlayerData, ConvertByteList(playerData.UnknownBuffer3)...)
+	if allPlayerData, err = appendByteList(allPlayerData, "UnknownBuffer3", playerData.UnknownBuffer3); err != nil {
+		return nil, err
+	}
 
-	return allPlayerData
+	return allPlayerData, nil
 }
 
 func SerializeDiplomacyDataToBytes(diplomacyData DiplomacyData) []byte {
@@ -308,9 +483,9 @@ func SerializeDiplomacyDataToBytes(diplomacyData DiplomacyData) []byte {
 	return data
 }
 
-func BuildEmptyPlayer(index int, playerName string, overrideColor color.RGBA) PlayerData {
-	if index >= 254 {
-		log.Fatal("Over 255 players")
+func BuildEmptyPlayer(index int, playerName string, overrideColor color.RGBA) (PlayerData, error) {
+	if index < 0 || index >= 254 {
+		return PlayerData{}, fmt.Errorf("player id must be between 0 and 253, value is %v", index)
 	}
 
 	// unknown array
@@ -333,7 +508,7 @@ func BuildEmptyPlayer(index int, playerName string, overrideColor color.RGBA) Pl
 		AccountId:            "00000000-0000-0000-0000-000000000000",
 		AutoPlay:             true,
 		StartTileCoordinates: [2]int{0, 0},
-		Tribe:                2, // Ai-mo
+		Tribe:                int(TribeAiMo),
 		UnknownByte1:         1,
 		DifficultyHandicap:   2,
 		AggressionsByPlayers: aggressionsByPlayers,
@@ -360,50 +535,58 @@ func BuildEmptyPlayer(index int, playerName string, overrideColor color.RGBA) Pl
 		UnknownBuffer3:       []int{255, 255, 255, 255},
 	}
 
-	return playerData
+	return playerData, nil
 }
 
-func readAllPlayerData(streamReader *io.SectionReader, gameVersion int) []PlayerData {
+func readAllPlayerData(streamReader *io.SectionReader, fileOffsetMap map[string]int, gameVersion int) ([]PlayerData, error) {
 	allPlayersStartKey := buildAllPlayersStartKey()
 	updateFileOffsetMap(fileOffsetMap, streamReader, allPlayersStartKey)
 
-	numPlayers, _ := readUint16Safe(streamReader, "number of players")
+	numPlayers, err := readUint16Safe(streamReader, "number of players")
+	if err != nil {
+		return nil, withSection(err, "player list")
+	}
 
-	allPlayerData := make([]PlayerData, int(numPlayers))
+	allPlayerData := make([]PlayerData, 0)
 
 	for i := 0; i < int(numPlayers); i++ {
 		debugPrint("  Reading player %d/%d...\n", i+1, numPlayers)
-		playerData := DeserializePlayerDataFromBytes(streamReader, gameVersion)
-		allPlayerData[i] = playerData
+		updateFileOffsetMap(fileOffsetMap, streamReader, buildPlayerStartKey(i))
+		playerData, err := DeserializePlayerDataFromBytes(streamReader, gameVersion)
+		if err != nil {
+			return nil, withPlayer(err, i)
+		}
+		updateFileOffsetMap(fileOffsetMap, streamReader, buildPlayerEndKey(i))
+		allPlayerData = append(allPlayerData, playerData)
 		debugPrint("  Player %d read - Name: %s, Tribe: %d\n", i+1, playerData.Name, playerData.Tribe)
 	}
 
 	allPlayersEndKey := buildAllPlayersEndKey()
 	updateFileOffsetMap(fileOffsetMap, streamReader, allPlayersEndKey)
 
-	return allPlayerData
+	return allPlayerData, nil
 }
 
-func buildOwnerTribeMap(allPlayerData []PlayerData) map[int]int {
+func buildOwnerTribeMap(allPlayerData []PlayerData) (map[int]int, error) {
 	ownerTribeMap := make(map[int]int)
 
 	for i := 0; i < len(allPlayerData); i++ {
 		playerData := allPlayerData[i]
 		mappedTribe, ok := ownerTribeMap[playerData.PlayerId]
 		if ok {
-			log.Fatal(fmt.Sprintf("Owner to tribe map has duplicate player id %v already mapped to %v", playerData.PlayerId, mappedTribe))
+			return nil, fmt.Errorf("owner to tribe map has duplicate player id %v already mapped to %v", playerData.PlayerId, mappedTribe)
 		}
 		ownerTribeMap[playerData.PlayerId] = playerData.Tribe
 	}
 
-	return ownerTribeMap
+	return ownerTribeMap, nil
 }
 
 func buildTribeCityMap(currentMapHeaderOutput MapHeaderOutput, tileData [][]TileData) map[int][]CityLocationData {
 	tribeCityMap := make(map[int][]CityLocationData)
 	for i := 0; i < int(currentMapHeaderOutput.MapHeight); i++ {
 		for j := 0; j < int(currentMapHeaderOutput.MapWidth); j++ {
-			if tileData[i][j].ImprovementData != nil && tileData[i][j].ImprovementType == 1 {
+			if tileData[i][j].ImprovementData != nil && tileData[i][j].ImprovementType == int(ImprovementCity) {
 				tribeOwner := tileData[i][j].Owner
 				_, ok := tribeCityMap[tribeOwner]
 				if !ok {
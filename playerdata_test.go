@@ -124,7 +124,10 @@ var (
 func TestDeserializePlayerDataFromBytes(t *testing.T) {
 	inputByteData := playerBytes
 	streamReader := io.NewSectionReader(bytes.NewReader(inputByteData), 0, int64(len(inputByteData)))
-	result := DeserializePlayerDataFromBytes(streamReader, 100) // Use version 100 for test (includes aggressions array)
+	result, err := DeserializePlayerDataFromBytes(streamReader, 100) // Use version 100 for test (includes aggressions array)
+	if err != nil {
+		t.Fatalf(`Failed to deserialize: %v`, err)
+	}
 	expected := playerData
 
 	if !reflect.DeepEqual(result, expected) {
@@ -133,7 +136,10 @@ func TestDeserializePlayerDataFromBytes(t *testing.T) {
 }
 
 func TestSerializePlayerDataToBytes(t *testing.T) {
-	resultBytes := SerializePlayerDataToBytes(playerData, 100) // Use version 100 for test
+	resultBytes, err := SerializePlayerDataToBytes(playerData, 100) // Use version 100 for test
+	if err != nil {
+		t.Fatalf(`Failed to serialize: %v`, err)
+	}
 	expectedBytes := playerBytes
 
 	if !reflect.DeepEqual(resultBytes, expectedBytes) {
@@ -141,8 +147,27 @@ func TestSerializePlayerDataToBytes(t *testing.T) {
 	}
 }
 
+func mustBuildEmptyPlayer(index int, playerName string, overrideColor color.RGBA) PlayerData {
+	playerData, err := BuildEmptyPlayer(index, playerName, overrideColor)
+	if err != nil {
+		panic(err)
+	}
+	return playerData
+}
+
+func TestBuildEmptyPlayerRejectsPlayerIdOutOfRange(t *testing.T) {
+	for _, index := range []int{-1, 254, 300} {
+		if _, err := BuildEmptyPlayer(index, "Player", color.RGBA{}); err == nil {
+			t.Fatalf(`Expected error for player id %v`, index)
+		}
+	}
+}
+
 func TestSerializeEmptyPlayer(t *testing.T) {
-	resultBytes := SerializePlayerDataToBytes(BuildEmptyPlayer(17, "Player17", color.RGBA{100, 150, 200, 255}), 100) // Use version 100 for test
+	resultBytes, err := SerializePlayerDataToBytes(mustBuildEmptyPlayer(17, "Player17", color.RGBA{100, 150, 200, 255}), 100) // Use version 100 for test
+	if err != nil {
+		t.Fatalf(`Failed to serialize: %v`, err)
+	}
 	expectedBytes := []byte{17,
 		// Player name
 		8, 80, 108, 97, 121, 101, 114, 49, 55,
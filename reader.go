@@ -4,46 +4,39 @@ import (
 	"encoding/binary"
 	"fmt"
 	"io"
-	"log"
 )
 
-func readVarString(reader *io.SectionReader, varName string) string {
+func readVarString(reader *io.SectionReader, varName string) (string, error) {
 	if DebugMode {
 		debugPrint("    Reading %s...\n", varName)
 	}
 
+	offset := currentOffset(reader)
 	variableLength := uint8(0)
 	if err := binary.Read(reader, binary.LittleEndian, &variableLength); err != nil {
-		log.Fatal("Failed to load variable length: ", err)
+		return "", newParseError(offset, varName, fmt.Errorf("failed to read string length: %w", err))
 	}
 
 	stringValue := make([]byte, variableLength)
 	if err := binary.Read(reader, binary.LittleEndian, &stringValue); err != nil {
-		log.Fatal(fmt.Sprintf("Failed to load string value. Variable length: %v, name: %s. Error:", variableLength, varName), err)
+		return "", newParseError(offset, varName, fmt.Errorf("failed to read string value of length %v: %w", variableLength, err))
 	}
 
 	result := string(stringValue[:])
 	if DebugMode {
 		debugPrint("    %s: %s\n", varName, result)
 	}
-	return result
-}
-
-func unsafeReadUint32(reader *io.SectionReader) uint32 {
-	unsignedIntValue := uint32(0)
-	if err := binary.Read(reader, binary.LittleEndian, &unsignedIntValue); err != nil {
-		log.Fatal("Failed to load uint32: ", err)
-	}
-	return unsignedIntValue
+	return result, nil
 }
 
 func readUint32Safe(reader *io.SectionReader, fieldName string) (uint32, error) {
 	if DebugMode {
 		debugPrint("    Reading %s...\n", fieldName)
 	}
+	offset := currentOffset(reader)
 	unsignedIntValue := uint32(0)
 	if err := binary.Read(reader, binary.LittleEndian, &unsignedIntValue); err != nil {
-		return 0, fmt.Errorf("failed to read uint32 (%s): %w", fieldName, err)
+		return 0, newParseError(offset, fieldName, fmt.Errorf("failed to read uint32: %w", err))
 	}
 	if DebugMode {
 		debugPrint("    %s: %d\n", fieldName, unsignedIntValue)
@@ -51,21 +44,14 @@ func readUint32Safe(reader *io.SectionReader, fieldName string) (uint32, error)
 	return unsignedIntValue, nil
 }
 
-func unsafeReadInt32(reader *io.SectionReader) int32 {
-	signedIntValue := int32(0)
-	if err := binary.Read(reader, binary.LittleEndian, &signedIntValue); err != nil {
-		log.Fatal("Failed to load int32: ", err)
-	}
-	return signedIntValue
-}
-
 func readInt32Safe(reader *io.SectionReader, fieldName string) (int32, error) {
 	if DebugMode {
 		debugPrint("    Reading %s...\n", fieldName)
 	}
+	offset := currentOffset(reader)
 	signedIntValue := int32(0)
 	if err := binary.Read(reader, binary.LittleEndian, &signedIntValue); err != nil {
-		return 0, fmt.Errorf("failed to read int32 (%s): %w", fieldName, err)
+		return 0, newParseError(offset, fieldName, fmt.Errorf("failed to read int32: %w", err))
 	}
 	if DebugMode {
 		debugPrint("    %s: %d\n", fieldName, signedIntValue)
@@ -73,21 +59,14 @@ func readInt32Safe(reader *io.SectionReader, fieldName string) (int32, error) {
 	return signedIntValue, nil
 }
 
-func unsafeReadUint16(reader *io.SectionReader) uint16 {
-	unsignedIntValue := uint16(0)
-	if err := binary.Read(reader, binary.LittleEndian, &unsignedIntValue); err != nil {
-		log.Fatal("Failed to load uint16: ", err)
-	}
-	return unsignedIntValue
-}
-
 func readUint16Safe(reader *io.SectionReader, fieldName string) (uint16, error) {
 	if DebugMode {
 		debugPrint("    Reading %s...\n", fieldName)
 	}
+	offset := currentOffset(reader)
 	unsignedIntValue := uint16(0)
 	if err := binary.Read(reader, binary.LittleEndian, &unsignedIntValue); err != nil {
-		return 0, fmt.Errorf("failed to read uint16 (%s): %w", fieldName, err)
+		return 0, newParseError(offset, fieldName, fmt.Errorf("failed to read uint16: %w", err))
 	}
 	if DebugMode {
 		debugPrint("    %s: %d\n", fieldName, unsignedIntValue)
@@ -95,21 +74,14 @@ func readUint16Safe(reader *io.SectionReader, fieldName string) (uint16, error)
 	return unsignedIntValue, nil
 }
 
-func unsafeReadInt16(reader *io.SectionReader) int16 {
-	signedIntValue := int16(0)
-	if err := binary.Read(reader, binary.LittleEndian, &signedIntValue); err != nil {
-		log.Fatal("Failed to load int16: ", err)
-	}
-	return signedIntValue
-}
-
 func readInt16Safe(reader *io.SectionReader, fieldName string) (int16, error) {
 	if DebugMode {
 		debugPrint("    Reading %s...\n", fieldName)
 	}
+	offset := currentOffset(reader)
 	signedIntValue := int16(0)
 	if err := binary.Read(reader, binary.LittleEndian, &signedIntValue); err != nil {
-		return 0, fmt.Errorf("failed to read int16 (%s): %w", fieldName, err)
+		return 0, newParseError(offset, fieldName, fmt.Errorf("failed to read int16: %w", err))
 	}
 	if DebugMode {
 		debugPrint("    %s: %d\n", fieldName, signedIntValue)
@@ -117,21 +89,14 @@ func readInt16Safe(reader *io.SectionReader, fieldName string) (int16, error) {
 	return signedIntValue, nil
 }
 
-func unsafeReadUint8(reader *io.SectionReader) uint8 {
-	unsignedIntValue := uint8(0)
-	if err := binary.Read(reader, binary.LittleEndian, &unsignedIntValue); err != nil {
-		log.Fatal("Failed to load uint8: ", err)
-	}
-	return unsignedIntValue
-}
-
 func readUint8Safe(reader *io.SectionReader, fieldName string) (uint8, error) {
 	if DebugMode {
 		debugPrint("    Reading %s...\n", fieldName)
 	}
+	offset := currentOffset(reader)
 	unsignedIntValue := uint8(0)
 	if err := binary.Read(reader, binary.LittleEndian, &unsignedIntValue); err != nil {
-		return 0, fmt.Errorf("failed to read uint8 (%s): %w", fieldName, err)
+		return 0, newParseError(offset, fieldName, fmt.Errorf("failed to read uint8: %w", err))
 	}
 	if DebugMode {
 		debugPrint("    %s: %d\n", fieldName, unsignedIntValue)
@@ -139,20 +104,40 @@ func readUint8Safe(reader *io.SectionReader, fieldName string) (uint8, error) {
 	return unsignedIntValue, nil
 }
 
-func unsafeReadFloat32(reader *io.SectionReader) float32 {
+func readFloat32Safe(reader *io.SectionReader, fieldName string) (float32, error) {
+	if DebugMode {
+		debugPrint("    Reading %s...\n", fieldName)
+	}
+	offset := currentOffset(reader)
 	floatValue := float32(0)
 	if err := binary.Read(reader, binary.LittleEndian, &floatValue); err != nil {
-		log.Fatal("Failed to load float32: ", err)
+		return 0, newParseError(offset, fieldName, fmt.Errorf("failed to read float32: %w", err))
+	}
+	if DebugMode {
+		debugPrint("    %s: %f\n", fieldName, floatValue)
+	}
+	return floatValue, nil
+}
+
+// readStructSafe reads a fixed size struct such as TileDataHeader or UnitData
+func readStructSafe(reader *io.SectionReader, fieldName string, data interface{}) error {
+	if DebugMode {
+		debugPrint("    Reading %s...\n", fieldName)
+	}
+	offset := currentOffset(reader)
+	if err := binary.Read(reader, binary.LittleEndian, data); err != nil {
+		return newParseError(offset, fieldName, fmt.Errorf("failed to read %v bytes: %w", binary.Size(data), err))
 	}
-	return floatValue
+	return nil
 }
 
-func readFixedList(streamReader *io.SectionReader, listSize int) []byte {
+func readFixedList(streamReader *io.SectionReader, listSize int, fieldName string) ([]byte, error) {
+	offset := currentOffset(streamReader)
 	buffer := make([]byte, listSize)
 	if err := binary.Read(streamReader, binary.LittleEndian, &buffer); err != nil {
-		log.Fatal("Failed to load buffer: ", err)
+		return nil, newParseError(offset, fieldName, fmt.Errorf("failed to read buffer of size %v: %w", listSize, err))
 	}
-	return buffer
+	return buffer, nil
 }
 
 func convertByteListToInt(oldArr []byte) []int {
@@ -1,224 +1,568 @@
 package polytopiamapmodel
 
 import (
+	"bytes"
 	"encoding/binary"
+	"encoding/json"
 	"fmt"
 	"io"
-	"log"
+	"reflect"
 )
 
+type ActionType uint16
+
+const (
+	ActionTypeBuild              ActionType = 1
+	ActionTypeAttack             ActionType = 2
+	ActionTypeRecover            ActionType = 3
+	ActionTypeDisband            ActionType = 4
+	ActionTypeTrain              ActionType = 5
+	ActionTypeMove               ActionType = 6
+	ActionTypeCaptureCity        ActionType = 7
+	ActionTypeResearch           ActionType = 8
+	ActionTypeDestroyImprovement ActionType = 9
+	ActionTypeCityReward         ActionType = 11
+	ActionTypePromote            ActionType = 13
+	ActionTypeExamineRuins       ActionType = 14
+	ActionTypeEndTurn            ActionType = 15
+	ActionTypeUpgrade            ActionType = 16
+	ActionTypeHealOthers         ActionType = 17
+	ActionTypeBreakIce           ActionType = 18
+	ActionTypeResign             ActionType = 20
+	ActionTypeCityLevelUp        ActionType = 21
+	ActionTypeFreezeArea         ActionType = 24
+	ActionTypeExplode            ActionType = 25
+	ActionTypeEstablishEmbassy   ActionType = 27
+	ActionTypeDiplomacy          ActionType = 28
+	ActionTypeDestroyEmbassy     ActionType = 29
+	ActionTypeInfiltrate         ActionType = 30
+)
+
+var actionTypeNames = map[ActionType]string{
+	ActionTypeBuild:              "Build",
+	ActionTypeAttack:             "Attack",
+	ActionTypeRecover:            "Recover",
+	ActionTypeDisband:            "Disband",
+	ActionTypeTrain:              "Train",
+	ActionTypeMove:               "Move",
+	ActionTypeCaptureCity:        "CaptureCity",
+	ActionTypeResearch:           "Research",
+	ActionTypeDestroyImprovement: "DestroyImprovement",
+	ActionTypeCityReward:         "CityReward",
+	ActionTypePromote:            "Promote",
+	ActionTypeExamineRuins:       "ExamineRuins",
+	ActionTypeEndTurn:            "EndTurn",
+	ActionTypeUpgrade:            "Upgrade",
+	ActionTypeHealOthers:         "HealOthers",
+	ActionTypeBreakIce:           "BreakIce",
+	ActionTypeResign:             "Resign",
+	ActionTypeCityLevelUp:        "CityLevelUp",
+	ActionTypeFreezeArea:         "FreezeArea",
+	ActionTypeExplode:            "Explode",
+	ActionTypeEstablishEmbassy:   "EstablishEmbassy",
+	ActionTypeDiplomacy:          "Diplomacy",
+	ActionTypeDestroyEmbassy:     "DestroyEmbassy",
+	ActionTypeInfiltrate:         "Infiltrate",
+}
+
+func (actionType ActionType) String() string {
+	name, ok := actionTypeNames[actionType]
+	if !ok {
+		return fmt.Sprintf("ActionType(%d)", uint16(actionType))
+	}
+	return name
+}
+
+// Action is implemented only by the action types in this package.
+// Use a type switch on the concrete struct to read the payload.
+type Action interface {
+	ActionType() ActionType
+	isAction()
+}
+
+// ReplayAction is one entry of the action list.
+// Index is the position in the list and Turn is the turn the action was taken in.
+type ReplayAction struct {
+	Index  int
+	Turn   int
+	Action Action
+}
+
 type ActionBuild struct {
-	PlayerId        uint8
-	ImprovementType uint16
-	Coordinates     [2]uint32
+	PlayerId        uint8     `json:"playerId"`
+	ImprovementType uint16    `json:"improvementType"`
+	Coordinates     [2]uint32 `json:"coordinates"`
 }
 
 type ActionAttack struct {
-	PlayerId uint8
-	UnitId   uint32
-	Origin   [2]uint32
-	Target   [2]uint32
+	PlayerId uint8     `json:"playerId"`
+	UnitId   uint32    `json:"unitId"`
+	Origin   [2]uint32 `json:"origin"`
+	Target   [2]uint32 `json:"target"`
 }
 
 type ActionRecover struct {
-	PlayerId    uint8
-	Coordinates [2]uint32
+	PlayerId    uint8     `json:"playerId"`
+	Coordinates [2]uint32 `json:"coordinates"`
+}
+
+type ActionDisband struct {
+	PlayerId    uint8     `json:"playerId"`
+	Coordinates [2]uint32 `json:"coordinates"`
 }
 
 type ActionTrain struct {
-	PlayerId uint8
-	UnitType uint16
-	Position [2]uint32
+	PlayerId uint8     `json:"playerId"`
+	UnitType uint16    `json:"unitType"`
+	Position [2]uint32 `json:"position"`
 }
 
 type ActionMove struct {
-	PlayerId    uint8
-	OldPosition [2]uint32
-	NewPosition [2]uint32
-	UnitId      uint32
+	PlayerId    uint8     `json:"playerId"`
+	OldPosition [2]uint32 `json:"oldPosition"`
+	NewPosition [2]uint32 `json:"newPosition"`
+	UnitId      uint32    `json:"unitId"`
 }
 
 type ActionCaptureCity struct {
-	PlayerId    uint8
-	UnitId      uint32
-	Coordinates [2]uint32
+	PlayerId    uint8     `json:"playerId"`
+	UnitId      uint32    `json:"unitId"`
+	Coordinates [2]uint32 `json:"coordinates"`
 }
 
 type ActionResearch struct {
-	PlayerId uint8
-	TechType uint16
+	PlayerId uint8  `json:"playerId"`
+	TechType uint16 `json:"techType"`
 }
 
 type ActionDestroyImprovement struct {
-	PlayerId    uint8
-	Coordinates [2]uint32
+	PlayerId    uint8     `json:"playerId"`
+	Coordinates [2]uint32 `json:"coordinates"`
 }
 
 type ActionCityReward struct {
-	PlayerId    uint8
-	Coordinates [2]uint32
-	Reward      uint16
+	PlayerId    uint8     `json:"playerId"`
+	Coordinates [2]uint32 `json:"coordinates"`
+	Reward      uint16    `json:"reward"`
 }
 
 type ActionPromote struct {
-	PlayerId    uint8
-	Coordinates [2]uint32
+	PlayerId    uint8     `json:"playerId"`
+	Coordinates [2]uint32 `json:"coordinates"`
 }
 
 type ActionExamineRuins struct {
-	PlayerId    uint8
-	Coordinates [2]uint32
+	PlayerId    uint8     `json:"playerId"`
+	Coordinates [2]uint32 `json:"coordinates"`
 }
 
 type ActionEndTurn struct {
-	PlayerId uint8
+	PlayerId uint8 `json:"playerId"`
 }
 
 type ActionUpgrade struct {
-	PlayerId    uint8
-	UnitType    uint16
-	Coordinates [2]uint32
+	PlayerId    uint8     `json:"playerId"`
+	UnitType    uint16    `json:"unitType"`
+	Coordinates [2]uint32 `json:"coordinates"`
+}
+
+type ActionHealOthers struct {
+	PlayerId    uint8     `json:"playerId"`
+	Coordinates [2]uint32 `json:"coordinates"`
+}
+
+type ActionBreakIce struct {
+	PlayerId    uint8     `json:"playerId"`
+	Coordinates [2]uint32 `json:"coordinates"`
+}
+
+type ActionResign struct {
+	PlayerId uint8 `json:"playerId"`
 }
 
 type ActionCityLevelUp struct {
-	PlayerId    uint8
-	Coordinates [2]uint32
+	PlayerId    uint8     `json:"playerId"`
+	Coordinates [2]uint32 `json:"coordinates"`
 }
 
-func readAllActions(streamReader *io.SectionReader) map[int][]ActionCaptureCity {
-	numActions := unsafeReadUint16(streamReader)
+type ActionFreezeArea struct {
+	PlayerId    uint8     `json:"playerId"`
+	Coordinates [2]uint32 `json:"coordinates"`
+}
 
-	turnCaptureMap := make(map[int][]ActionCaptureCity)
+type ActionExplode struct {
+	PlayerId    uint8     `json:"playerId"`
+	Coordinates [2]uint32 `json:"coordinates"`
+}
+
+type ActionEstablishEmbassy struct {
+	PlayerId       uint8     `json:"playerId"`
+	TargetPlayerId uint8     `json:"targetPlayerId"`
+	Coordinates    [2]uint32 `json:"coordinates"` // city where the embassy is built
+}
+
+// ActionDiplomacy is a diplomacy message sent to another player.
+// MessageType uses the same values as DiplomacyMessage.MessageType.
+type ActionDiplomacy struct {
+	PlayerId       uint8 `json:"playerId"`
+	TargetPlayerId uint8 `json:"targetPlayerId"`
+	MessageType    uint8 `json:"messageType"`
+}
+
+type ActionDestroyEmbassy struct {
+	PlayerId       uint8     `json:"playerId"`
+	TargetPlayerId uint8     `json:"targetPlayerId"`
+	Coordinates    [2]uint32 `json:"coordinates"`
+}
+
+type ActionInfiltrate struct {
+	PlayerId       uint8     `json:"playerId"`
+	TargetPlayerId uint8     `json:"targetPlayerId"`
+	Coordinates    [2]uint32 `json:"coordinates"` // city being infiltrated
+}
+
+// RawAction holds an action whose type this package can't decode.
+// The payload size of an unknown type can't be determined, so Payload holds every
+// remaining byte of the action list (and anything after it) and SkippedActions
+// is the number of actions after this one that are contained in Payload.
+//
+// Because the actions after it are hidden in Payload, a RawAction must stay the last action in the list.
+// SerializeActionsToBytes rejects actions inserted or appended after it and TruncateActionsAfterTurn
+// rejects cutting the list after it, since the turns of the skipped actions aren't known.
+type RawAction struct {
+	Type           ActionType `json:"type"`
+	Payload        []byte     `json:"payload"`
+	SkippedActions int        `json:"skippedActions"`
+}
+
+func (ActionBuild) ActionType() ActionType              { return ActionTypeBuild }
+func (ActionAttack) ActionType() ActionType             { return ActionTypeAttack }
+func (ActionRecover) ActionType() ActionType            { return ActionTypeRecover }
+func (ActionDisband) ActionType() ActionType            { return ActionTypeDisband }
+func (ActionTrain) ActionType() ActionType              { return ActionTypeTrain }
+func (ActionMove) ActionType() ActionType               { return ActionTypeMove }
+func (ActionCaptureCity) ActionType() ActionType        { return ActionTypeCaptureCity }
+func (ActionResearch) ActionType() ActionType           { return ActionTypeResearch }
+func (ActionDestroyImprovement) ActionType() ActionType { return ActionTypeDestroyImprovement }
+func (ActionCityReward) ActionType() ActionType         { return ActionTypeCityReward }
+func (ActionPromote) ActionType() ActionType            { return ActionTypePromote }
+func (ActionExamineRuins) ActionType() ActionType       { return ActionTypeExamineRuins }
+func (ActionEndTurn) ActionType() ActionType            { return ActionTypeEndTurn }
+func (ActionUpgrade) ActionType() ActionType            { return ActionTypeUpgrade }
+func (ActionHealOthers) ActionType() ActionType         { return ActionTypeHealOthers }
+func (ActionBreakIce) ActionType() ActionType           { return ActionTypeBreakIce }
+func (ActionResign) ActionType() ActionType             { return ActionTypeResign }
+func (ActionCityLevelUp) ActionType() ActionType        { return ActionTypeCityLevelUp }
+func (ActionFreezeArea) ActionType() ActionType         { return ActionTypeFreezeArea }
+func (ActionExplode) ActionType() ActionType            { return ActionTypeExplode }
+func (ActionEstablishEmbassy) ActionType() ActionType   { return ActionTypeEstablishEmbassy }
+func (ActionDiplomacy) ActionType() ActionType          { return ActionTypeDiplomacy }
+func (ActionDestroyEmbassy) ActionType() ActionType     { return ActionTypeDestroyEmbassy }
+func (ActionInfiltrate) ActionType() ActionType         { return ActionTypeInfiltrate }
+func (action RawAction) ActionType() ActionType         { return action.Type }
+
+func (ActionBuild) isAction()              {}
+func (ActionAttack) isAction()             {}
+func (ActionRecover) isAction()            {}
+func (ActionDisband) isAction()            {}
+func (ActionTrain) isAction()              {}
+func (ActionMove) isAction()               {}
+func (ActionCaptureCity) isAction()        {}
+func (ActionResearch) isAction()           {}
+func (ActionDestroyImprovement) isAction() {}
+func (ActionCityReward) isAction()         {}
+func (ActionPromote) isAction()            {}
+func (ActionExamineRuins) isAction()       {}
+func (ActionEndTurn) isAction()            {}
+func (ActionUpgrade) isAction()            {}
+func (ActionHealOthers) isAction()         {}
+func (ActionBreakIce) isAction()           {}
+func (ActionResign) isAction()             {}
+func (ActionCityLevelUp) isAction()        {}
+func (ActionFreezeArea) isAction()         {}
+func (ActionExplode) isAction()            {}
+func (ActionEstablishEmbassy) isAction()   {}
+func (ActionDiplomacy) isAction()          {}
+func (ActionDestroyEmbassy) isAction()     {}
+func (ActionInfiltrate) isAction()         {}
+func (RawAction) isAction()                {}
+
+// rawActionJsonType is the type name used in json for actions that can't be decoded
+const rawActionJsonType = "Raw"
+
+// replayActionJson tags the action payload with its type name so the concrete action type can be restored
+type replayActionJson struct {
+	Index  int             `json:"index"`
+	Turn   int             `json:"turn"`
+	Type   string          `json:"type"`
+	Action json.RawMessage `json:"action"`
+}
+
+func (replayAction ReplayAction) MarshalJSON() ([]byte, error) {
+	if replayAction.Action == nil {
+		return nil, fmt.Errorf("action %v is missing", replayAction.Index)
+	}
+	actionJson, err := json.Marshal(replayAction.Action)
+	if err != nil {
+		return nil, err
+	}
+	typeName := replayAction.Action.ActionType().String()
+	if _, ok := replayAction.Action.(RawAction); ok {
+		typeName = rawActionJsonType
+	}
+	return json.Marshal(replayActionJson{
+		Index:  replayAction.Index,
+		Turn:   replayAction.Turn,
+		Type:   typeName,
+		Action: actionJson,
+	})
+}
+
+func (replayAction *ReplayAction) UnmarshalJSON(data []byte) error {
+	var actionJson replayActionJson
+	if err := json.Unmarshal(data, &actionJson); err != nil {
+		return err
+	}
+
+	var action Action
+	if actionJson.Type == rawActionJsonType {
+		action = RawAction{}
+	} else {
+		actionType, ok := findActionType(actionJson.Type)
+		if !ok {
+			return fmt.Errorf("action %v has unknown type %q", actionJson.Index, actionJson.Type)
+		}
+		action = newEmptyAction(actionType)
+	}
+
+	actionValue := reflect.New(reflect.TypeOf(action))
+	if err := json.Unmarshal(actionJson.Action, actionValue.Interface()); err != nil {
+		return fmt.Errorf("action %v (%v): %w", actionJson.Index, actionJson.Type, err)
+	}
+	*replayAction = ReplayAction{
+		Index:  actionJson.Index,
+		Turn:   actionJson.Turn,
+		Action: actionValue.Elem().Interface().(Action),
+	}
+	return nil
+}
+
+// emptyActions has the concrete type of every action type this package can decode
+var emptyActions = map[ActionType]Action{
+	ActionTypeBuild:              ActionBuild{},
+	ActionTypeAttack:             ActionAttack{},
+	ActionTypeRecover:            ActionRecover{},
+	ActionTypeDisband:            ActionDisband{},
+	ActionTypeTrain:              ActionTrain{},
+	ActionTypeMove:               ActionMove{},
+	ActionTypeCaptureCity:        ActionCaptureCity{},
+	ActionTypeResearch:           ActionResearch{},
+	ActionTypeDestroyImprovement: ActionDestroyImprovement{},
+	ActionTypeCityReward:         ActionCityReward{},
+	ActionTypePromote:            ActionPromote{},
+	ActionTypeExamineRuins:       ActionExamineRuins{},
+	ActionTypeEndTurn:            ActionEndTurn{},
+	ActionTypeUpgrade:            ActionUpgrade{},
+	ActionTypeHealOthers:         ActionHealOthers{},
+	ActionTypeBreakIce:           ActionBreakIce{},
+	ActionTypeResign:             ActionResign{},
+	ActionTypeCityLevelUp:        ActionCityLevelUp{},
+	ActionTypeFreezeArea:         ActionFreezeArea{},
+	ActionTypeExplode:            ActionExplode{},
+	ActionTypeEstablishEmbassy:   ActionEstablishEmbassy{},
+	ActionTypeDiplomacy:          ActionDiplomacy{},
+	ActionTypeDestroyEmbassy:     ActionDestroyEmbassy{},
+	ActionTypeInfiltrate:         ActionInfiltrate{},
+}
+
+// newEmptyAction returns an action of the concrete type for actionType with every field set to zero,
+// or nil if the action type is unknown
+func newEmptyAction(actionType ActionType) Action {
+	return emptyActions[actionType]
+}
+
+func findActionType(name string) (ActionType, bool) {
+	for actionType, actionName := range actionTypeNames {
+		if actionName == name {
+			return actionType, true
+		}
+	}
+	return 0, false
+}
 
-	replayActions := make([]string, 0)
+func readAllActions(streamReader *io.SectionReader, versionLayout VersionLayout) ([]ReplayAction, error) {
+	actions, err := readActionList(streamReader, versionLayout.Actions)
+	if err != nil {
+		return nil, withSection(err, "actions")
+	}
+	return actions, nil
+}
+
+func readActionList(streamReader *io.SectionReader, actionPayloads map[ActionType]Action) ([]ReplayAction, error) {
+	numActions, err := readUint16Safe(streamReader, "number of actions")
+	if err != nil {
+		return nil, err
+	}
+
+	actions := make([]ReplayAction, 0)
 	turn := 1
 	for i := 0; i < int(numActions); i++ {
-		actionType := unsafeReadUint16(streamReader)
+		actionOffset := currentOffset(streamReader)
+		actionField := fmt.Sprintf("action %d", i)
+		actionTypeValue, err := readUint16Safe(streamReader, actionField+" type")
+		if err != nil {
+			return nil, err
+		}
 
-		var buffer []byte
-		if actionType == 1 {
-			action := ActionBuild{}
-			if err := binary.Read(streamReader, binary.LittleEndian, &action); err != nil {
-				log.Fatal("Failed to load action: ", err)
-			}
-			replayActions = append(replayActions, fmt.Sprintf("Build: %+v\n", action))
-		} else if actionType == 2 {
-			action := ActionAttack{}
-			if err := binary.Read(streamReader, binary.LittleEndian, &action); err != nil {
-				log.Fatal("Failed to load action: ", err)
-			}
-			replayActions = append(replayActions, fmt.Sprintf("Attack: %+v\n", action))
-		} else if actionType == 3 {
-			action := ActionRecover{}
-			if err := binary.Read(streamReader, binary.LittleEndian, &action); err != nil {
-				log.Fatal("Failed to load action: ", err)
-			}
-			replayActions = append(replayActions, fmt.Sprintf("Recover: %+v\n", action))
-		} else if actionType == 4 {
-			buffer = readFixedList(streamReader, 9)
-		} else if actionType == 5 {
-			action := ActionTrain{}
-			if err := binary.Read(streamReader, binary.LittleEndian, &action); err != nil {
-				log.Fatal("Failed to load action: ", err)
-			}
-			replayActions = append(replayActions, fmt.Sprintf("Train: %+v\n", action))
-		} else if actionType == 6 {
-			action := ActionMove{}
-			if err := binary.Read(streamReader, binary.LittleEndian, &action); err != nil {
-				log.Fatal("Failed to load action: ", err)
-			}
-			replayActions = append(replayActions, fmt.Sprintf("Move: %+v\n", action))
-		} else if actionType == 7 {
-			action := ActionCaptureCity{}
-			if err := binary.Read(streamReader, binary.LittleEndian, &action); err != nil {
-				log.Fatal("Failed to load action: ", err)
+		actionType := ActionType(actionTypeValue)
+		action, err := readActionPayload(streamReader, actionPayloads[actionType], actionField)
+		if err != nil {
+			return nil, err
+		}
+		if action == nil {
+			// Unknown action type, keep the rest of the list as is since the payload size isn't known
+			debugPrint("  Unknown action type %d at offset %d, keeping remaining data as raw action\n", actionTypeValue, actionOffset)
+			payloadSize := streamReader.Size() - currentOffset(streamReader)
+			payload, err := readFixedList(streamReader, int(payloadSize), actionField)
+			if err != nil {
+				return nil, err
 			}
-			replayActions = append(replayActions, fmt.Sprintf("CaptureCity: %+v\n", action))
+			actions = append(actions, ReplayAction{
+				Index: i,
+				Turn:  turn,
+				Action: RawAction{
+					Type:           actionType,
+					Payload:        payload,
+					SkippedActions: int(numActions) - i - 1,
+				},
+			})
+			break
+		}
+		if DebugMode {
+			debugPrint("  Action %d, turn %d, %v: %+v\n", i, turn, action.ActionType(), action)
+		}
 
-			_, ok := turnCaptureMap[turn]
-			if !ok {
-				turnCaptureMap[turn] = make([]ActionCaptureCity, 0)
-			}
-			turnCaptureMap[turn] = append(turnCaptureMap[turn], action)
-		} else if actionType == 8 {
-			action := ActionResearch{}
-			if err := binary.Read(streamReader, binary.LittleEndian, &action); err != nil {
-				log.Fatal("Failed to load action: ", err)
-			}
-			replayActions = append(replayActions, fmt.Sprintf("Research: %+v\n", action))
-		} else if actionType == 9 {
-			action := ActionDestroyImprovement{}
-			if err := binary.Read(streamReader, binary.LittleEndian, &action); err != nil {
-				log.Fatal("Failed to load action: ", err)
-			}
-			replayActions = append(replayActions, fmt.Sprintf("DestroyImprovement: %+v\n", action))
-		} else if actionType == 11 {
-			action := ActionCityReward{}
-			if err := binary.Read(streamReader, binary.LittleEndian, &action); err != nil {
-				log.Fatal("Failed to load action: ", err)
-			}
-			replayActions = append(replayActions, fmt.Sprintf("CityReward: %+v\n", action))
-		} else if actionType == 13 {
-			action := ActionPromote{}
-			if err := binary.Read(streamReader, binary.LittleEndian, &action); err != nil {
-				log.Fatal("Failed to load action: ", err)
-			}
-			replayActions = append(replayActions, fmt.Sprintf("Promote: %+v\n", action))
-		} else if actionType == 14 {
-			action := ActionExamineRuins{}
-			if err := binary.Read(streamReader, binary.LittleEndian, &action); err != nil {
-				log.Fatal("Failed to load action: ", err)
-			}
-			replayActions = append(replayActions, fmt.Sprintf("ExamineRuins: %+v\n", action))
-		} else if actionType == 15 {
-			action := ActionEndTurn{}
-			if err := binary.Read(streamReader, binary.LittleEndian, &action); err != nil {
-				log.Fatal("Failed to load action: ", err)
-			}
-			replayActions = append(replayActions, fmt.Sprintf("EndTurn: %+v\n", action))
+		actions = append(actions, ReplayAction{
+			Index:  i,
+			Turn:   turn,
+			Action: action,
+		})
 
-			if action.PlayerId == 255 {
-				turn++
-				replayActions = append(replayActions, fmt.Sprintf("Start new turn %v", turn))
-			}
-		} else if actionType == 16 {
-			action := ActionUpgrade{}
-			if err := binary.Read(streamReader, binary.LittleEndian, &action); err != nil {
-				log.Fatal("Failed to load action: ", err)
-			}
-			replayActions = append(replayActions, fmt.Sprintf("Upgrade: %+v\n", action))
-		} else if actionType == 17 {
-			buffer = readFixedList(streamReader, 9)
-		} else if actionType == 18 {
-			buffer = readFixedList(streamReader, 9)
-		} else if actionType == 20 {
-			buffer = readFixedList(streamReader, 1)
-		} else if actionType == 21 {
-			action := ActionCityLevelUp{}
-			if err := binary.Read(streamReader, binary.LittleEndian, &action); err != nil {
-				log.Fatal("Failed to load action: ", err)
-			}
-			replayActions = append(replayActions, fmt.Sprintf("CityLevelUp: %+v\n", action))
-		} else if actionType == 24 {
-			buffer = readFixedList(streamReader, 9)
-		} else if actionType == 25 {
-			buffer = readFixedList(streamReader, 9)
-		} else if actionType == 27 {
-			buffer = readFixedList(streamReader, 10)
-		} else if actionType == 28 {
-			buffer = readFixedList(streamReader, 3)
-		} else if actionType == 29 {
-			buffer = readFixedList(streamReader, 10)
-		} else if actionType == 30 {
-			buffer = readFixedList(streamReader, 10)
-		} else {
-			log.Fatal("Unknown action type:", actionType)
+		if endTurn, ok := action.(ActionEndTurn); ok && endTurn.PlayerId == 255 {
+			turn++
+		}
+	}
+
+	return actions, nil
+}
+
+// readActionPayload decodes a payload with the type of emptyAction, or returns a nil action if the action type is unknown
+func readActionPayload(streamReader *io.SectionReader, emptyAction Action, fieldName string) (Action, error) {
+	if emptyAction == nil {
+		return nil, nil
+	}
+	// every action payload is a fixed size struct
+	actionValue := reflect.New(reflect.TypeOf(emptyAction))
+	if err := readStructSafe(streamReader, fieldName, actionValue.Interface()); err != nil {
+		return nil, err
+	}
+	return actionValue.Elem().Interface().(Action), nil
+}
+
+// countSerializedActions includes the actions stored inside a raw action's payload
+func countSerializedActions(actions []ReplayAction) int {
+	numActions := len(actions)
+	for i := 0; i < len(actions); i++ {
+		if rawAction, ok := actions[i].Action.(RawAction); ok {
+			numActions += rawAction.SkippedActions
+		}
+	}
+	return numActions
+}
+
+func SerializeActionsToBytes(actions []ReplayAction) ([]byte, error) {
+	if err := checkRawActionIsLast(actions); err != nil {
+		return nil, err
+	}
+	data := make([]byte, 0)
+	data = append(data, ConvertUint16Bytes(countSerializedActions(actions))...)
+	for i := 0; i < len(actions); i++ {
+		actionBytes, err := SerializeActionToBytes(actions[i].Action)
+		if err != nil {
+			return nil, fmt.Errorf("action %v: %w", i, err)
+		}
+		data = append(data, ConvertUint16Bytes(int(actions[i].Action.ActionType()))...)
+		data = append(data, actionBytes...)
+	}
+	return data, nil
+}
+
+// checkRawActionIsLast returns an error if an action follows a RawAction,
+// since the RawAction payload already holds the rest of the original list
+func checkRawActionIsLast(actions []ReplayAction) error {
+	for i := 0; i < len(actions)-1; i++ {
+		if _, ok := actions[i].Action.(RawAction); ok {
+			return fmt.Errorf("action %v follows raw action %v, actions can't be added after an action that can't be decoded", i+1, i)
+		}
+	}
+	return nil
+}
+
+// SerializeActionToBytes returns the action payload without the action type
+func SerializeActionToBytes(action Action) ([]byte, error) {
+	if rawAction, ok := action.(RawAction); ok {
+		return rawAction.Payload, nil
+	}
+
+	buffer := new(bytes.Buffer)
+	if err := binary.Write(buffer, binary.LittleEndian, action); err != nil {
+		return nil, fmt.Errorf("failed to serialize action %v: %w", action.ActionType(), err)
+	}
+	return buffer.Bytes(), nil
+}
+
+// ReindexActions updates the index and turn of every action after actions are added or removed
+func ReindexActions(actions []ReplayAction) []ReplayAction {
+	reindexedActions := make([]ReplayAction, len(actions))
+	turn := 1
+	for i := 0; i < len(actions); i++ {
+		reindexedActions[i] = ReplayAction{
+			Index:  i,
+			Turn:   turn,
+			Action: actions[i].Action,
+		}
+		if endTurn, ok := actions[i].Action.(ActionEndTurn); ok && endTurn.PlayerId == 255 {
+			turn++
 		}
+	}
+	return reindexedActions
+}
 
-		if len(buffer) > 0 {
-			replayActions = append(replayActions, fmt.Sprintf("Index %d, action type: %d, buffer: %v", i, actionType, buffer))
+// TruncateActionsAfterTurn keeps only the actions taken up to and including the given turn.
+// Returns an error if a RawAction is kept, because the turns of the actions in its payload aren't known.
+func TruncateActionsAfterTurn(actions []ReplayAction, lastTurn int) ([]ReplayAction, error) {
+	truncatedActions := make([]ReplayAction, 0)
+	for i := 0; i < len(actions); i++ {
+		if actions[i].Turn > lastTurn {
+			break
 		}
+		if rawAction, ok := actions[i].Action.(RawAction); ok && rawAction.SkippedActions > 0 {
+			return nil, fmt.Errorf("can't truncate after turn %v, raw action %v holds %v actions whose turns aren't known",
+				lastTurn, i, rawAction.SkippedActions)
+		}
+		truncatedActions = append(truncatedActions, actions[i])
 	}
+	return truncatedActions, nil
+}
 
+func buildTurnCaptureMap(actions []ReplayAction) map[int][]ActionCaptureCity {
+	turnCaptureMap := make(map[int][]ActionCaptureCity)
+	for _, replayAction := range actions {
+		captureAction, ok := replayAction.Action.(ActionCaptureCity)
+		if !ok {
+			continue
+		}
+		turnCaptureMap[replayAction.Turn] = append(turnCaptureMap[replayAction.Turn], captureAction)
+	}
 	return turnCaptureMap
 }
@@ -0,0 +1,199 @@
+package polytopiamapmodel
+
+import (
+	"bytes"
+	"io"
+	"reflect"
+	"testing"
+)
+
+var (
+	actionListBytes = []byte{5, 0,
+		// build
+		1, 0, 1, 5, 0, 3, 0, 0, 0, 4, 0, 0, 0,
+		// end turn for player 255
+		15, 0, 255,
+		// capture city
+		7, 0, 2, 10, 0, 0, 0, 6, 0, 0, 0, 7, 0, 0, 0,
+		// research
+		8, 0, 2, 12, 0,
+		// end turn for player 2
+		15, 0, 2,
+	}
+
+	actionList = []ReplayAction{
+		{Index: 0, Turn: 1, Action: ActionBuild{PlayerId: 1, ImprovementType: 5, Coordinates: [2]uint32{3, 4}}},
+		{Index: 1, Turn: 1, Action: ActionEndTurn{PlayerId: 255}},
+		{Index: 2, Turn: 2, Action: ActionCaptureCity{PlayerId: 2, UnitId: 10, Coordinates: [2]uint32{6, 7}}},
+		{Index: 3, Turn: 2, Action: ActionResearch{PlayerId: 2, TechType: 12}},
+		{Index: 4, Turn: 2, Action: ActionEndTurn{PlayerId: 2}},
+	}
+)
+
+func TestReadAllActions(t *testing.T) {
+	streamReader := io.NewSectionReader(bytes.NewReader(actionListBytes), 0, int64(len(actionListBytes)))
+	result, err := readAllActions(streamReader, versionLayouts[0])
+	if err != nil {
+		t.Fatalf(`Failed to read actions: %v`, err)
+	}
+
+	if !reflect.DeepEqual(result, actionList) {
+		t.Fatalf(`Contents not equal. Result = %+v, expected = %+v`, result, actionList)
+	}
+}
+
+func TestBuildTurnCaptureMap(t *testing.T) {
+	result := buildTurnCaptureMap(actionList)
+	expected := map[int][]ActionCaptureCity{
+		2: {{PlayerId: 2, UnitId: 10, Coordinates: [2]uint32{6, 7}}},
+	}
+
+	if !reflect.DeepEqual(result, expected) {
+		t.Fatalf(`Contents not equal. Result = %+v, expected = %+v`, result, expected)
+	}
+}
+
+func TestReadNewerActionTypes(t *testing.T) {
+	inputByteData := []byte{4, 0,
+		// disband
+		4, 0, 1, 2, 0, 0, 0, 3, 0, 0, 0,
+		// establish embassy
+		27, 0, 1, 3, 8, 0, 0, 0, 9, 0, 0, 0,
+		// diplomacy
+		28, 0, 1, 3, 2,
+		// resign
+		20, 0, 4,
+	}
+	streamReader := io.NewSectionReader(bytes.NewReader(inputByteData), 0, int64(len(inputByteData)))
+	result, err := readAllActions(streamReader, versionLayouts[0])
+	if err != nil {
+		t.Fatalf(`Failed to read actions: %v`, err)
+	}
+	expected := []ReplayAction{
+		{Index: 0, Turn: 1, Action: ActionDisband{PlayerId: 1, Coordinates: [2]uint32{2, 3}}},
+		{Index: 1, Turn: 1, Action: ActionEstablishEmbassy{PlayerId: 1, TargetPlayerId: 3, Coordinates: [2]uint32{8, 9}}},
+		{Index: 2, Turn: 1, Action: ActionDiplomacy{PlayerId: 1, TargetPlayerId: 3, MessageType: 2}},
+		{Index: 3, Turn: 1, Action: ActionResign{PlayerId: 4}},
+	}
+
+	if !reflect.DeepEqual(result, expected) {
+		t.Fatalf(`Contents not equal. Result = %+v, expected = %+v`, result, expected)
+	}
+}
+
+func TestReadUnknownActionTypeKeepsRemainingData(t *testing.T) {
+	inputByteData := []byte{3, 0,
+		// end turn
+		15, 0, 1,
+		// unknown action type 19, followed by one more action
+		19, 0, 1, 2, 3, 15, 0, 2,
+	}
+	streamReader := io.NewSectionReader(bytes.NewReader(inputByteData), 0, int64(len(inputByteData)))
+	result, err := readAllActions(streamReader, versionLayouts[0])
+	if err != nil {
+		t.Fatalf(`Failed to read actions: %v`, err)
+	}
+	expected := []ReplayAction{
+		{Index: 0, Turn: 1, Action: ActionEndTurn{PlayerId: 1}},
+		{Index: 1, Turn: 1, Action: RawAction{Type: 19, Payload: []byte{1, 2, 3, 15, 0, 2}, SkippedActions: 1}},
+	}
+
+	if !reflect.DeepEqual(result, expected) {
+		t.Fatalf(`Contents not equal. Result = %+v, expected = %+v`, result, expected)
+	}
+}
+
+func TestSerializeActionsToBytes(t *testing.T) {
+	resultBytes, err := SerializeActionsToBytes(actionList)
+	if err != nil {
+		t.Fatalf(`Failed to serialize actions: %v`, err)
+	}
+	compareArrays(t, resultBytes, actionListBytes)
+}
+
+// unserializableAction has a field without a fixed size, so binary.Write can't encode it
+type unserializableAction struct {
+	Values []int
+}
+
+func (unserializableAction) ActionType() ActionType { return ActionTypeMove }
+func (unserializableAction) isAction()              {}
+
+func TestSerializeActionsReturnsError(t *testing.T) {
+	inputByteData := buildTestSaveBytes()
+	saveOutput, err := ParsePolytopiaFile(io.NewSectionReader(bytes.NewReader(inputByteData), 0, int64(len(inputByteData))))
+	if err != nil {
+		t.Fatalf(`Failed to parse save: %v`, err)
+	}
+	saveOutput.Actions = append(saveOutput.Actions, ReplayAction{Action: unserializableAction{Values: []int{1}}})
+	if _, err := SerializeActionsToBytes(saveOutput.Actions); err == nil {
+		t.Fatalf(`Expected error for an action that can't be serialized`)
+	}
+	if _, err := SerializePolytopiaSave(saveOutput); err == nil {
+		t.Fatalf(`Expected error for a save with an action that can't be serialized`)
+	}
+}
+
+func TestSerializeRawActionKeepsSkippedActionCount(t *testing.T) {
+	actions := []ReplayAction{
+		{Index: 0, Turn: 1, Action: ActionEndTurn{PlayerId: 1}},
+		{Index: 1, Turn: 1, Action: RawAction{Type: 19, Payload: []byte{1, 2, 3, 15, 0, 2}, SkippedActions: 1}},
+	}
+	resultBytes, err := SerializeActionsToBytes(actions)
+	if err != nil {
+		t.Fatalf(`Failed to serialize actions: %v`, err)
+	}
+	expectedBytes := []byte{3, 0, 15, 0, 1, 19, 0, 1, 2, 3, 15, 0, 2}
+	compareArrays(t, resultBytes, expectedBytes)
+}
+
+func TestTruncateActionsAfterTurn(t *testing.T) {
+	result, err := TruncateActionsAfterTurn(actionList, 1)
+	if err != nil {
+		t.Fatalf(`Failed to truncate actions: %v`, err)
+	}
+	expected := actionList[:2]
+	if !reflect.DeepEqual(result, expected) {
+		t.Fatalf(`Contents not equal. Result = %+v, expected = %+v`, result, expected)
+	}
+}
+
+func TestActionEditsAfterRawActionAreRejected(t *testing.T) {
+	rawAction := ReplayAction{Index: 1, Turn: 1, Action: RawAction{Type: 19, Payload: []byte{1, 2, 3, 15, 0, 2}, SkippedActions: 1}}
+	actions := []ReplayAction{actionList[0], rawAction}
+
+	if _, err := TruncateActionsAfterTurn(actions, 1); err == nil {
+		t.Fatalf(`Expected error when truncating after a raw action`)
+	}
+	if _, err := SerializeActionsToBytes(append(actions, actionList[1])); err == nil {
+		t.Fatalf(`Expected error when appending after a raw action`)
+	}
+	if _, err := SerializeActionsToBytes([]ReplayAction{actionList[1], rawAction}); err != nil {
+		t.Fatalf(`Failed to serialize an action inserted before a raw action: %v`, err)
+	}
+}
+
+func TestEmptyActionsMatchActionTypes(t *testing.T) {
+	for actionType := range actionTypeNames {
+		action := newEmptyAction(actionType)
+		if action == nil || action.ActionType() != actionType {
+			t.Fatalf(`Empty action for %v = %#v`, actionType, action)
+		}
+	}
+	if len(emptyActions) != len(actionTypeNames) {
+		t.Fatalf(`Empty action count = %v, expected = %v`, len(emptyActions), len(actionTypeNames))
+	}
+}
+
+func TestReindexActions(t *testing.T) {
+	actions := []ReplayAction{actionList[2], actionList[1], actionList[0]}
+	result := ReindexActions(actions)
+	expected := []ReplayAction{
+		{Index: 0, Turn: 1, Action: actionList[2].Action},
+		{Index: 1, Turn: 1, Action: actionList[1].Action},
+		{Index: 2, Turn: 2, Action: actionList[0].Action},
+	}
+	if !reflect.DeepEqual(result, expected) {
+		t.Fatalf(`Contents not equal. Result = %+v, expected = %+v`, result, expected)
+	}
+}
@@ -0,0 +1,456 @@
+package polytopiamapmodel
+
+import (
+	"fmt"
+	"sort"
+)
+
+// TurnSnapshot is the board state after every action of a turn was applied.
+// Turn 0 is the initial state before any action.
+type TurnSnapshot struct {
+	Turn       int
+	TileData   [][]TileData
+	PlayerData []PlayerData
+}
+
+// ReplayDivergence is a tile where the replayed state doesn't match the saved current state
+type ReplayDivergence struct {
+	X        int
+	Y        int
+	Field    string
+	Replayed string
+	Actual   string
+}
+
+// ReplayPlayerDivergence is a player where the replayed state doesn't match the saved current state
+type ReplayPlayerDivergence struct {
+	PlayerId int
+	Field    string
+	Replayed string
+	Actual   string
+}
+
+type ReplayResult struct {
+	Snapshots []TurnSnapshot
+	// Actions that couldn't be applied, such as raw actions, attacks, heals or moves from an empty tile
+	UnappliedActions  []ReplayAction
+	Divergences       []ReplayDivergence
+	PlayerDivergences []ReplayPlayerDivergence
+}
+
+// Replayer applies actions one at a time to a copy of the initial state
+type Replayer struct {
+	tileData   [][]TileData
+	playerData []PlayerData
+	mapWidth   int
+	mapHeight  int
+	nextUnitId uint32
+}
+
+func NewReplayer(saveOutput *PolytopiaSaveOutput) *Replayer {
+	tileData := cloneTileGrid(saveOutput.InitialTileData)
+	mapHeight := len(tileData)
+	mapWidth := 0
+	if mapHeight > 0 {
+		mapWidth = len(tileData[0])
+	}
+
+	return &Replayer{
+		tileData:   tileData,
+		playerData: clonePlayerList(saveOutput.InitialPlayerData),
+		mapWidth:   mapWidth,
+		mapHeight:  mapHeight,
+		nextUnitId: findMaxUnitId(tileData) + 1,
+	}
+}
+
+// Snapshot returns a copy of the current replayed state
+func (replayer *Replayer) Snapshot(turn int) TurnSnapshot {
+	return TurnSnapshot{
+		Turn:       turn,
+		TileData:   cloneTileGrid(replayer.tileData),
+		PlayerData: clonePlayerList(replayer.playerData),
+	}
+}
+
+// ReplayAllTurns replays the whole action list and returns the state at the end of every turn
+func ReplayAllTurns(saveOutput *PolytopiaSaveOutput) ReplayResult {
+	replayer := NewReplayer(saveOutput)
+	result := ReplayResult{
+		Snapshots:        []TurnSnapshot{replayer.Snapshot(0)},
+		UnappliedActions: make([]ReplayAction, 0),
+	}
+
+	for i := 0; i < len(saveOutput.Actions); i++ {
+		replayAction := saveOutput.Actions[i]
+		if err := replayer.ApplyAction(replayAction); err != nil {
+			debugPrint("Replay: action %d not applied: %v\n", replayAction.Index, err)
+			result.UnappliedActions = append(result.UnappliedActions, replayAction)
+		}
+
+		isLastActionOfTurn := i == len(saveOutput.Actions)-1 || saveOutput.Actions[i+1].Turn != replayAction.Turn
+		if isLastActionOfTurn {
+			result.Snapshots = append(result.Snapshots, replayer.Snapshot(replayAction.Turn))
+		}
+	}
+
+	result.Divergences = compareReplayedTiles(replayer.tileData, saveOutput.TileData)
+	result.PlayerDivergences = compareReplayedPlayers(replayer.playerData, saveOutput.PlayerData)
+	return result
+}
+
+func (replayer *Replayer) getTile(coordinates [2]uint32) (*TileData, error) {
+	x := int(coordinates[0])
+	y := int(coordinates[1])
+	if x < 0 || x >= replayer.mapWidth || y < 0 || y >= replayer.mapHeight {
+		return nil, fmt.Errorf("coordinates (%v, %v) are outside the map", x, y)
+	}
+	return &replayer.tileData[y][x], nil
+}
+
+func (replayer *Replayer) getPlayer(playerId uint8) (*PlayerData, error) {
+	for i := 0; i < len(replayer.playerData); i++ {
+		if replayer.playerData[i].PlayerId == int(playerId) {
+			return &replayer.playerData[i], nil
+		}
+	}
+	return nil, fmt.Errorf("player %v doesn't exist", playerId)
+}
+
+// ApplyAction updates the replayed state. Actions that don't change the stored state are ignored.
+// Attacks, explosions, recovers and heals return an error since the action doesn't store the damage or the health restored,
+// so units killed in combat can't be removed and unit health isn't replayed.
+// A promotion only raises the promotion level, the health it restores shows up as a UnitHealth divergence.
+// A trained unit is created in the turn of the action. A built city gets new city data,
+// other improvements have no ImprovementData since the action doesn't store it.
+func (replayer *Replayer) ApplyAction(replayAction ReplayAction) error {
+	switch action := replayAction.Action.(type) {
+	case ActionMove:
+		oldTile, err := replayer.getTile(action.OldPosition)
+		if err != nil {
+			return err
+		}
+		newTile, err := replayer.getTile(action.NewPosition)
+		if err != nil {
+			return err
+		}
+		if oldTile.Unit == nil {
+			return fmt.Errorf("no unit to move at (%v, %v)", action.OldPosition[0], action.OldPosition[1])
+		}
+		unit := oldTile.Unit
+		unit.CurrentCoordinates = [2]int32{int32(action.NewPosition[0]), int32(action.NewPosition[1])}
+		unit.Moved = true
+		if oldTile == newTile {
+			break
+		}
+		newTile.Unit = unit
+		newTile.PassengerUnit = oldTile.PassengerUnit
+		newTile.UnitEffectData = oldTile.UnitEffectData
+		newTile.UnitDirectionData = oldTile.UnitDirectionData
+		newTile.PassengerUnitEffectData = oldTile.PassengerUnitEffectData
+		newTile.PassengerUnitDirectionData = oldTile.PassengerUnitDirectionData
+		clearTileUnit(oldTile)
+	case ActionAttack:
+		return fmt.Errorf("attack from (%v, %v) to (%v, %v) doesn't store the damage",
+			action.Origin[0], action.Origin[1], action.Target[0], action.Target[1])
+	case ActionExplode:
+		return fmt.Errorf("explosion at (%v, %v) doesn't store the damage", action.Coordinates[0], action.Coordinates[1])
+	case ActionRecover:
+		return fmt.Errorf("recover at (%v, %v) doesn't store the health restored", action.Coordinates[0], action.Coordinates[1])
+	case ActionHealOthers:
+		return fmt.Errorf("heal from (%v, %v) doesn't store the health restored", action.Coordinates[0], action.Coordinates[1])
+	case ActionTrain:
+		tile, err := replayer.getTile(action.Position)
+		if err != nil {
+			return err
+		}
+		unit := placeNewUnit(tile, replayer.nextUnitId, action.PlayerId, action.UnitType,
+			int(action.Position[0]), int(action.Position[1]), replayAction.Turn)
+		// a unit can't move or attack in the turn it was trained
+		unit.Moved = true
+		unit.Attacked = true
+		replayer.nextUnitId++
+	case ActionDisband:
+		tile, err := replayer.getTile(action.Coordinates)
+		if err != nil {
+			return err
+		}
+		if tile.Unit == nil {
+			return fmt.Errorf("no unit to disband at (%v, %v)", action.Coordinates[0], action.Coordinates[1])
+		}
+		clearTileUnit(tile)
+	case ActionUpgrade:
+		tile, err := replayer.getTile(action.Coordinates)
+		if err != nil {
+			return err
+		}
+		if tile.Unit == nil {
+			return fmt.Errorf("no unit to upgrade at (%v, %v)", action.Coordinates[0], action.Coordinates[1])
+		}
+		tile.Unit.UnitType = action.UnitType
+	case ActionPromote:
+		tile, err := replayer.getTile(action.Coordinates)
+		if err != nil {
+			return err
+		}
+		if tile.Unit == nil {
+			return fmt.Errorf("no unit to promote at (%v, %v)", action.Coordinates[0], action.Coordinates[1])
+		}
+		tile.Unit.PromotionLevel++
+	case ActionBuild:
+		tile, err := replayer.getTile(action.Coordinates)
+		if err != nil {
+			return err
+		}
+		tile.ImprovementExists = true
+		tile.ImprovementType = int(action.ImprovementType)
+		if ImprovementType(action.ImprovementType) != ImprovementCity {
+			tile.ImprovementData = nil
+		} else if tile.ImprovementData == nil {
+			tile.ImprovementData = &ImprovementData{
+				Level:           1,
+				CityRewards:     []int{},
+				RebellionBuffer: []int{},
+			}
+		}
+	case ActionDestroyImprovement:
+		tile, err := replayer.getTile(action.Coordinates)
+		if err != nil {
+			return err
+		}
+		clearTileImprovement(tile)
+	case ActionExamineRuins:
+		tile, err := replayer.getTile(action.Coordinates)
+		if err != nil {
+			return err
+		}
+		clearTileImprovement(tile)
+	case ActionCaptureCity:
+		cityTile, err := replayer.getTile(action.Coordinates)
+		if err != nil {
+			return err
+		}
+		previousOwner := cityTile.Owner
+		cityTile.Owner = int(action.PlayerId)
+		// the city's territory changes owner along with the city
+		for i := 0; i < replayer.mapHeight; i++ {
+			for j := 0; j < replayer.mapWidth; j++ {
+				tile := &replayer.tileData[i][j]
+				if tile.Owner == previousOwner &&
+					tile.CapitalCoordinates[0] == int(action.Coordinates[0]) &&
+					tile.CapitalCoordinates[1] == int(action.Coordinates[1]) {
+					tile.Owner = int(action.PlayerId)
+				}
+			}
+		}
+	case ActionCityLevelUp:
+		tile, err := replayer.getTile(action.Coordinates)
+		if err != nil {
+			return err
+		}
+		if tile.ImprovementData == nil {
+			return fmt.Errorf("no city to level up at (%v, %v)", action.Coordinates[0], action.Coordinates[1])
+		}
+		tile.ImprovementData.Level++
+	case ActionCityReward:
+		tile, err := replayer.getTile(action.Coordinates)
+		if err != nil {
+			return err
+		}
+		if tile.ImprovementData == nil {
+			return fmt.Errorf("no city to reward at (%v, %v)", action.Coordinates[0], action.Coordinates[1])
+		}
+		tile.ImprovementData.CityRewards = append(tile.ImprovementData.CityRewards, int(action.Reward))
+	case ActionResearch:
+		player, err := replayer.getPlayer(action.PlayerId)
+		if err != nil {
+			return err
+		}
+		player.AvailableTech = append(player.AvailableTech, int(action.TechType))
+	case ActionEndTurn:
+		for i := 0; i < replayer.mapHeight; i++ {
+			for j := 0; j < replayer.mapWidth; j++ {
+				unit := replayer.tileData[i][j].Unit
+				if unit != nil && unit.Owner == action.PlayerId {
+					unit.Moved = false
+					unit.Attacked = false
+				}
+			}
+		}
+	case RawAction:
+		return fmt.Errorf("action type %v isn't decoded", action.Type)
+	}
+	return nil
+}
+
+func clearTileUnit(tile *TileData) {
+	tile.Unit = nil
+	tile.PassengerUnit = nil
+	tile.UnitEffectData = []int{}
+	tile.UnitDirectionData = []int{}
+	tile.PassengerUnitEffectData = []int{}
+	tile.PassengerUnitDirectionData = []int{}
+}
+
+func clearTileImprovement(tile *TileData) {
+	tile.ImprovementExists = false
+	tile.ImprovementType = -1
+	tile.ImprovementData = nil
+}
+
+func compareReplayedTiles(replayedTiles [][]TileData, actualTiles [][]TileData) []ReplayDivergence {
+	divergences := make([]ReplayDivergence, 0)
+	addDivergence := func(x int, y int, field string, replayed interface{}, actual interface{}) {
+		replayedValue := fmt.Sprintf("%v", replayed)
+		actualValue := fmt.Sprintf("%v", actual)
+		if replayedValue != actualValue {
+			divergences = append(divergences, ReplayDivergence{X: x, Y: y, Field: field, Replayed: replayedValue, Actual: actualValue})
+		}
+	}
+
+	for i := 0; i < len(actualTiles) && i < len(replayedTiles); i++ {
+		for j := 0; j < len(actualTiles[i]) && j < len(replayedTiles[i]); j++ {
+			replayed := replayedTiles[i][j]
+			actual := actualTiles[i][j]
+			addDivergence(j, i, "Owner", replayed.Owner, actual.Owner)
+			addDivergence(j, i, "ImprovementType", replayed.ImprovementType, actual.ImprovementType)
+			addDivergence(j, i, "Unit", describeUnit(replayed.Unit), describeUnit(actual.Unit))
+			if replayed.Unit != nil && actual.Unit != nil {
+				addDivergence(j, i, "UnitHealth", replayed.Unit.Health, actual.Unit.Health)
+			}
+		}
+	}
+	return divergences
+}
+
+// compareReplayedPlayers compares the players and their tech, which are the only player fields changed by a replay
+func compareReplayedPlayers(replayedPlayers []PlayerData, actualPlayers []PlayerData) []ReplayPlayerDivergence {
+	divergences := make([]ReplayPlayerDivergence, 0)
+	addDivergence := func(playerId int, field string, replayed string, actual string) {
+		if replayed != actual {
+			divergences = append(divergences, ReplayPlayerDivergence{PlayerId: playerId, Field: field, Replayed: replayed, Actual: actual})
+		}
+	}
+
+	replayedById := make(map[int]PlayerData)
+	for _, player := range replayedPlayers {
+		replayedById[player.PlayerId] = player
+	}
+	for _, actual := range actualPlayers {
+		replayed, ok := replayedById[actual.PlayerId]
+		if !ok {
+			addDivergence(actual.PlayerId, "Player", "none", "exists")
+			continue
+		}
+		delete(replayedById, actual.PlayerId)
+		addDivergence(actual.PlayerId, "AvailableTech", describeTech(replayed.AvailableTech), describeTech(actual.AvailableTech))
+	}
+	for _, replayed := range replayedPlayers {
+		if _, ok := replayedById[replayed.PlayerId]; ok {
+			addDivergence(replayed.PlayerId, "Player", "exists", "none")
+		}
+	}
+	return divergences
+}
+
+// describeTech sorts the tech, since the save may not list it in the order it was researched
+func describeTech(availableTech []int) string {
+	sortedTech := append([]int{}, availableTech...)
+	sort.Ints(sortedTech)
+	return fmt.Sprintf("%v", sortedTech)
+}
+
+func describeUnit(unit *UnitData) string {
+	if unit == nil {
+		return "none"
+	}
+	return fmt.Sprintf("type %v owned by %v", unit.UnitType, unit.Owner)
+}
+
+// findMaxUnitId returns the largest id of all units and passenger units on the map
+func findMaxUnitId(tileData [][]TileData) uint32 {
+	maxUnitId := uint32(0)
+	for i := 0; i < len(tileData); i++ {
+		for j := 0; j < len(tileData[i]); j++ {
+			if tileData[i][j].Unit != nil && tileData[i][j].Unit.Id > maxUnitId {
+				maxUnitId = tileData[i][j].Unit.Id
+			}
+			if tileData[i][j].PassengerUnit != nil && tileData[i][j].PassengerUnit.Id > maxUnitId {
+				maxUnitId = tileData[i][j].PassengerUnit.Id
+			}
+		}
+	}
+	return maxUnitId
+}
+
+func cloneTileGrid(tileData [][]TileData) [][]TileData {
+	clonedTileData := make([][]TileData, len(tileData))
+	for i := 0; i < len(tileData); i++ {
+		clonedTileData[i] = make([]TileData, len(tileData[i]))
+		for j := 0; j < len(tileData[i]); j++ {
+			clonedTileData[i][j] = cloneTile(tileData[i][j])
+		}
+	}
+	return clonedTileData
+}
+
+func cloneTile(tile TileData) TileData {
+	clonedTile := tile
+	if tile.ImprovementData != nil {
+		improvementData := *tile.ImprovementData
+		improvementData.CityRewards = cloneIntList(tile.ImprovementData.CityRewards)
+		improvementData.RebellionBuffer = cloneIntList(tile.ImprovementData.RebellionBuffer)
+		clonedTile.ImprovementData = &improvementData
+	}
+	if tile.Unit != nil {
+		unit := *tile.Unit
+		clonedTile.Unit = &unit
+	}
+	if tile.PassengerUnit != nil {
+		passengerUnit := *tile.PassengerUnit
+		clonedTile.PassengerUnit = &passengerUnit
+	}
+	clonedTile.UnitEffectData = cloneIntList(tile.UnitEffectData)
+	clonedTile.UnitDirectionData = cloneIntList(tile.UnitDirectionData)
+	clonedTile.PassengerUnitEffectData = cloneIntList(tile.PassengerUnitEffectData)
+	clonedTile.PassengerUnitDirectionData = cloneIntList(tile.PassengerUnitDirectionData)
+	clonedTile.PlayerVisibility = cloneIntList(tile.PlayerVisibility)
+	clonedTile.Unknown = cloneIntList(tile.Unknown)
+	return clonedTile
+}
+
+func clonePlayerList(playerData []PlayerData) []PlayerData {
+	clonedPlayerData := make([]PlayerData, len(playerData))
+	for i := 0; i < len(playerData); i++ {
+		clonedPlayerData[i] = clonePlayer(playerData[i])
+	}
+	return clonedPlayerData
+}
+
+func clonePlayer(player PlayerData) PlayerData {
+	clonedPlayer := player
+	clonedPlayer.AggressionsByPlayers = append([]PlayerAggression{}, player.AggressionsByPlayers...)
+	clonedPlayer.AvailableTech = cloneIntList(player.AvailableTech)
+	clonedPlayer.EncounteredPlayers = cloneIntList(player.EncounteredPlayers)
+	clonedPlayer.Tasks = make([]PlayerTaskData, len(player.Tasks))
+	for i := 0; i < len(player.Tasks); i++ {
+		clonedPlayer.Tasks[i] = PlayerTaskData{
+			Type:   player.Tasks[i].Type,
+			Buffer: cloneIntList(player.Tasks[i].Buffer),
+		}
+	}
+	clonedPlayer.OverrideColor = cloneIntList(player.OverrideColor)
+	clonedPlayer.UniqueImprovements = cloneIntList(player.UniqueImprovements)
+	clonedPlayer.DiplomacyArr = append([]DiplomacyData{}, player.DiplomacyArr...)
+	clonedPlayer.DiplomacyMessages = append([]DiplomacyMessage{}, player.DiplomacyMessages...)
+	clonedPlayer.UnknownBuffer2 = cloneIntList(player.UnknownBuffer2)
+	clonedPlayer.UnknownBuffer3 = cloneIntList(player.UnknownBuffer3)
+	return clonedPlayer
+}
+
+func cloneIntList(list []int) []int {
+	if list == nil {
+		return nil
+	}
+	return append([]int{}, list...)
+}
@@ -0,0 +1,171 @@
+package polytopiamapmodel
+
+import (
+	"reflect"
+	"testing"
+)
+
+func buildReplayTestSave() *PolytopiaSaveOutput {
+	initialTiles := make([][]TileData, 2)
+	for i := 0; i < 2; i++ {
+		initialTiles[i] = make([]TileData, 2)
+		for j := 0; j < 2; j++ {
+			initialTiles[i][j] = TileData{
+				WorldCoordinates:   [2]int{j, i},
+				Owner:              1,
+				CapitalCoordinates: [2]int{0, 0},
+				ImprovementType:    -1,
+			}
+		}
+	}
+	initialTiles[0][0].ImprovementExists = true
+	initialTiles[0][0].ImprovementType = 1
+	initialTiles[0][0].ImprovementData = &ImprovementData{Level: 1, CityRewards: []int{}, RebellionBuffer: []int{}}
+	initialTiles[0][0].Unit = &UnitData{Id: 4, Owner: 2, UnitType: 2, Health: 100}
+
+	finalTiles := cloneTileGrid(initialTiles)
+	for i := 0; i < 2; i++ {
+		for j := 0; j < 2; j++ {
+			finalTiles[i][j].Owner = 2
+		}
+	}
+	finalTiles[0][0].Unit = nil
+	finalTiles[1][1].Unit = &UnitData{Id: 4, Owner: 2, UnitType: 2, Health: 100}
+	finalTiles[0][1].ImprovementType = 5
+
+	return &PolytopiaSaveOutput{
+		InitialTileData:   initialTiles,
+		InitialPlayerData: []PlayerData{{PlayerId: 2, AvailableTech: []int{}}},
+		TileData:          finalTiles,
+		PlayerData:        []PlayerData{{PlayerId: 2, AvailableTech: []int{12}}},
+		Actions: []ReplayAction{
+			{Index: 0, Turn: 1, Action: ActionCaptureCity{PlayerId: 2, UnitId: 4, Coordinates: [2]uint32{0, 0}}},
+			{Index: 1, Turn: 1, Action: ActionResearch{PlayerId: 2, TechType: 12}},
+			{Index: 2, Turn: 1, Action: ActionEndTurn{PlayerId: 2}},
+			{Index: 3, Turn: 2, Action: ActionMove{PlayerId: 2, UnitId: 4, OldPosition: [2]uint32{0, 0}, NewPosition: [2]uint32{1, 1}}},
+			{Index: 4, Turn: 2, Action: ActionMove{PlayerId: 2, UnitId: 9, OldPosition: [2]uint32{0, 1}, NewPosition: [2]uint32{1, 0}}},
+		},
+	}
+}
+
+func TestReplayAllTurns(t *testing.T) {
+	saveOutput := buildReplayTestSave()
+	result := ReplayAllTurns(saveOutput)
+
+	if len(result.Snapshots) != 3 {
+		t.Fatalf(`Snapshot count = %v, expected = 3`, len(result.Snapshots))
+	}
+	if result.Snapshots[0].TileData[0][0].Owner != 1 {
+		t.Fatalf(`Turn 0 owner = %v, expected = 1`, result.Snapshots[0].TileData[0][0].Owner)
+	}
+	if result.Snapshots[1].TileData[1][1].Owner != 2 {
+		t.Fatalf(`Turn 1 owner = %v, expected = 2`, result.Snapshots[1].TileData[1][1].Owner)
+	}
+	if !reflect.DeepEqual(result.Snapshots[1].PlayerData[0].AvailableTech, []int{12}) {
+		t.Fatalf(`Turn 1 tech = %v, expected = [12]`, result.Snapshots[1].PlayerData[0].AvailableTech)
+	}
+	if result.Snapshots[1].TileData[1][1].Unit != nil || result.Snapshots[2].TileData[1][1].Unit == nil {
+		t.Fatalf(`Unit was not moved at turn 2`)
+	}
+
+	// the second move starts from an empty tile
+	if len(result.UnappliedActions) != 1 || result.UnappliedActions[0].Index != 4 {
+		t.Fatalf(`Unapplied actions = %+v, expected only action 4`, result.UnappliedActions)
+	}
+
+	expectedDivergences := []ReplayDivergence{
+		{X: 1, Y: 0, Field: "ImprovementType", Replayed: "-1", Actual: "5"},
+	}
+	if !reflect.DeepEqual(result.Divergences, expectedDivergences) {
+		t.Fatalf(`Contents not equal. Result = %+v, expected = %+v`, result.Divergences, expectedDivergences)
+	}
+	if len(result.PlayerDivergences) != 0 {
+		t.Fatalf(`Player divergences = %+v, expected none`, result.PlayerDivergences)
+	}
+}
+
+func TestReplayMoveAndAttackOnSameTile(t *testing.T) {
+	saveOutput := buildReplayTestSave()
+	saveOutput.PlayerData = []PlayerData{{PlayerId: 2, AvailableTech: []int{12, 3}}, {PlayerId: 3}}
+	saveOutput.Actions = []ReplayAction{
+		{Index: 0, Turn: 1, Action: ActionMove{PlayerId: 2, UnitId: 4, OldPosition: [2]uint32{0, 0}, NewPosition: [2]uint32{0, 0}}},
+		{Index: 1, Turn: 1, Action: ActionAttack{PlayerId: 2, UnitId: 4, Origin: [2]uint32{0, 0}, Target: [2]uint32{1, 0}}},
+		{Index: 2, Turn: 1, Action: ActionResearch{PlayerId: 2, TechType: 3}},
+		{Index: 3, Turn: 1, Action: ActionResearch{PlayerId: 2, TechType: 12}},
+	}
+	result := ReplayAllTurns(saveOutput)
+
+	if unit := result.Snapshots[1].TileData[0][0].Unit; unit == nil || unit.Id != 4 || !unit.Moved {
+		t.Fatalf(`Unit moved to its own tile = %+v, expected unit 4`, unit)
+	}
+	// the damage isn't stored, so an attack can't be applied
+	if len(result.UnappliedActions) != 1 || result.UnappliedActions[0].Index != 1 {
+		t.Fatalf(`Unapplied actions = %+v, expected only action 1`, result.UnappliedActions)
+	}
+	expectedDivergences := []ReplayPlayerDivergence{
+		{PlayerId: 3, Field: "Player", Replayed: "none", Actual: "exists"},
+	}
+	if !reflect.DeepEqual(result.PlayerDivergences, expectedDivergences) {
+		t.Fatalf(`Contents not equal. Result = %+v, expected = %+v`, result.PlayerDivergences, expectedDivergences)
+	}
+}
+
+func TestReplayDoesNotModifyInitialState(t *testing.T) {
+	saveOutput := buildReplayTestSave()
+	ReplayAllTurns(saveOutput)
+
+	if saveOutput.InitialTileData[0][0].Unit == nil || saveOutput.InitialTileData[0][0].Unit.Moved {
+		t.Fatalf(`Initial unit was modified by the replay`)
+	}
+	if saveOutput.InitialTileData[1][1].Owner != 1 || len(saveOutput.InitialPlayerData[0].AvailableTech) != 0 {
+		t.Fatalf(`Initial state was modified by the replay`)
+	}
+}
+
+func TestReplayHealthActionsAreUnapplied(t *testing.T) {
+	saveOutput := buildReplayTestSave()
+	saveOutput.TileData = cloneTileGrid(saveOutput.InitialTileData)
+	saveOutput.TileData[0][0].Unit.Health = 80
+	saveOutput.PlayerData = saveOutput.InitialPlayerData
+	saveOutput.Actions = []ReplayAction{
+		{Index: 0, Turn: 1, Action: ActionRecover{PlayerId: 2, Coordinates: [2]uint32{0, 0}}},
+		{Index: 1, Turn: 1, Action: ActionHealOthers{PlayerId: 2, Coordinates: [2]uint32{0, 0}}},
+		{Index: 2, Turn: 1, Action: ActionExplode{PlayerId: 2, Coordinates: [2]uint32{0, 0}}},
+	}
+	result := ReplayAllTurns(saveOutput)
+
+	if len(result.UnappliedActions) != 3 {
+		t.Fatalf(`Unapplied actions = %+v, expected all 3 health actions`, result.UnappliedActions)
+	}
+	expectedDivergences := []ReplayDivergence{
+		{X: 0, Y: 0, Field: "UnitHealth", Replayed: "100", Actual: "80"},
+	}
+	if !reflect.DeepEqual(result.Divergences, expectedDivergences) {
+		t.Fatalf(`Contents not equal. Result = %+v, expected = %+v`, result.Divergences, expectedDivergences)
+	}
+}
+
+func TestReplayTrainAndBuild(t *testing.T) {
+	saveOutput := buildReplayTestSave()
+	saveOutput.Actions = []ReplayAction{
+		{Index: 0, Turn: 3, Action: ActionTrain{PlayerId: 2, UnitType: 5, Position: [2]uint32{1, 1}}},
+		{Index: 1, Turn: 3, Action: ActionBuild{PlayerId: 2, ImprovementType: 5, Coordinates: [2]uint32{1, 0}}},
+		{Index: 2, Turn: 3, Action: ActionBuild{PlayerId: 2, ImprovementType: uint16(ImprovementCity), Coordinates: [2]uint32{0, 1}}},
+	}
+	result := ReplayAllTurns(saveOutput)
+	tiles := result.Snapshots[len(result.Snapshots)-1].TileData
+
+	unit := tiles[1][1].Unit
+	if unit == nil || unit.Id != 5 || unit.CreatedTurn != 3 || !unit.Moved || !unit.Attacked {
+		t.Fatalf(`Trained unit = %+v, expected unit 5 created in turn 3`, unit)
+	}
+	if !reflect.DeepEqual(tiles[1][1].UnitDirectionData, []int{0, 0, 0, 0, 0}) {
+		t.Fatalf(`Unit direction data = %v, expected = [0 0 0 0 0]`, tiles[1][1].UnitDirectionData)
+	}
+	if tiles[0][1].ImprovementType != 5 || tiles[0][1].ImprovementData != nil {
+		t.Fatalf(`Built improvement = %v with data %+v, expected 5 without data`, tiles[0][1].ImprovementType, tiles[0][1].ImprovementData)
+	}
+	if tiles[1][0].ImprovementData == nil || tiles[1][0].ImprovementData.Level != 1 {
+		t.Fatalf(`Built city data = %+v, expected level 1`, tiles[1][0].ImprovementData)
+	}
+}
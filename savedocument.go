@@ -0,0 +1,447 @@
+package polytopiamapmodel
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// SaveDocument is a save file loaded into memory.
+// Edits are made to the parsed model and the file is only written once when Save is called.
+type SaveDocument struct {
+	InputFilename string
+	Compressed    bool
+	Output        *PolytopiaSaveOutput
+	Target        EditTarget // copy of the map to edit, defaults to the current state
+	Backup        bool       // copy the existing file to a timestamped .bak file before it is replaced
+}
+
+// OpenSaveDocument loads a decompressed save file
+func OpenSaveDocument(inputFilename string) (*SaveDocument, error) {
+	rawData, err := os.ReadFile(inputFilename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load save state: %w", err)
+	}
+	return newSaveDocument(inputFilename, rawData, false)
+}
+
+// OpenCompressedSaveDocument loads a compressed .state file. Save writes it back compressed.
+func OpenCompressedSaveDocument(inputFilename string) (*SaveDocument, error) {
+	rawData, err := readDecompressedContents(inputFilename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load state file: %w", err)
+	}
+	return newSaveDocument(inputFilename, rawData, true)
+}
+
+// OpenDetectedSaveDocument loads a save file in either format. Save writes it back in the same format.
+func OpenDetectedSaveDocument(inputFilename string) (*SaveDocument, error) {
+	fileData, err := os.ReadFile(inputFilename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load state file: %w", err)
+	}
+	rawData, saveFormat, err := decompressDetectedFormat(fileData)
+	if err != nil {
+		return nil, err
+	}
+	return newSaveDocument(inputFilename, rawData, saveFormat == SaveFormatCompressed)
+}
+
+func newSaveDocument(inputFilename string, rawData []byte, compressed bool) (*SaveDocument, error) {
+	saveOutput, err := ParsePolytopiaFile(io.NewSectionReader(bytes.NewReader(rawData), 0, int64(len(rawData))))
+	if err != nil {
+		return nil, err
+	}
+	return &SaveDocument{
+		InputFilename: inputFilename,
+		Compressed:    compressed,
+		Output:        saveOutput,
+	}, nil
+}
+
+// Bytes returns the decompressed file contents with all edits applied
+func (doc *SaveDocument) Bytes() ([]byte, error) {
+	return SerializePolytopiaSave(doc.Output)
+}
+
+// Save writes all edits to InputFilename
+func (doc *SaveDocument) Save() error {
+	return doc.SaveAs(doc.InputFilename, doc.Compressed)
+}
+
+// SaveAs writes all edits to outputFilename, compressing the data if requested.
+// Output is replaced with the parsed result of the written data so derived fields like TribeCityMap stay up to date.
+func (doc *SaveDocument) SaveAs(outputFilename string, compressed bool) error {
+	fileData, err := doc.Bytes()
+	if err != nil {
+		return err
+	}
+	saveOutput, err := ParsePolytopiaFile(io.NewSectionReader(bytes.NewReader(fileData), 0, int64(len(fileData))))
+	if err != nil {
+		return fmt.Errorf("edited save is invalid: %w", err)
+	}
+
+	outputData := fileData
+	if compressed {
+		outputData, err = compressBytes(fileData)
+		if err != nil {
+			return fmt.Errorf("failed to compress save: %w", err)
+		}
+	}
+	if doc.Backup {
+		if _, err := os.Stat(outputFilename); err == nil {
+			if _, err := BackupFile(outputFilename); err != nil {
+				return err
+			}
+		}
+	}
+	if err := WriteFileAtomic(outputFilename, outputData); err != nil {
+		return fmt.Errorf("failed to write save: %w", err)
+	}
+
+	doc.Output = saveOutput
+	return nil
+}
+
+// targetTiles returns the tile from each copy of the map selected by Target.
+// All tiles are checked before any are returned so an edit is never applied to only one copy.
+func (doc *SaveDocument) targetTiles(targetX int, targetY int) ([]*TileData, error) {
+	states := targetMapStates(doc.Output, doc.Target)
+	tiles := make([]*TileData, len(states))
+	for i, state := range states {
+		tile, err := state.getTile(targetX, targetY)
+		if err != nil {
+			return nil, err
+		}
+		tiles[i] = tile
+	}
+	return tiles, nil
+}
+
+// checkPlayer returns an error if the player id doesn't fit in a byte or the player is missing from a copy of the map selected by Target
+func (doc *SaveDocument) checkPlayer(playerId int) error {
+	if playerId < 0 || playerId > 255 {
+		return fmt.Errorf("player id must be between 0 and 255, value is %v", playerId)
+	}
+	for _, state := range targetMapStates(doc.Output, doc.Target) {
+		if !hasPlayer(*state.playerData, playerId) {
+			return fmt.Errorf("player %v doesn't exist in the %v", playerId, state)
+		}
+	}
+	return nil
+}
+
+// PlayerId returns the id of a player given as a player id or as a tribe name.
+// A tribe name must belong to exactly one player, with the same id in each copy of the map selected by Target.
+func (doc *SaveDocument) PlayerId(name string) (int, error) {
+	if playerId, err := strconv.Atoi(strings.TrimSpace(name)); err == nil {
+		return playerId, doc.checkPlayer(playerId)
+	}
+	tribe, err := ParseTribeType(name)
+	if err != nil {
+		return 0, err
+	}
+	playerId := -1
+	for _, state := range targetMapStates(doc.Output, doc.Target) {
+		statePlayerId := -1
+		for _, player := range *state.playerData {
+			if player.Tribe != int(tribe) {
+				continue
+			}
+			if statePlayerId >= 0 {
+				return 0, fmt.Errorf("more than one player has tribe %v in the %v, use the player id", tribe, state)
+			}
+			statePlayerId = player.PlayerId
+		}
+		if statePlayerId < 0 {
+			return 0, fmt.Errorf("no player has tribe %v in the %v", tribe, state)
+		}
+		if playerId >= 0 && playerId != statePlayerId {
+			return 0, fmt.Errorf("tribe %v is player %v in the initial state and player %v in the current state", tribe, playerId, statePlayerId)
+		}
+		playerId = statePlayerId
+	}
+	return playerId, nil
+}
+
+func hasPlayer(playerData []PlayerData, playerId int) bool {
+	for _, player := range playerData {
+		if player.PlayerId == playerId {
+			return true
+		}
+	}
+	return false
+}
+
+func (doc *SaveDocument) SetTerrain(targetX int, targetY int, terrain int) error {
+	tiles, err := doc.targetTiles(targetX, targetY)
+	if err != nil {
+		return err
+	}
+	for _, tile := range tiles {
+		setTileTerrain(tile, terrain)
+	}
+	return nil
+}
+
+func (doc *SaveDocument) SetUnitOwner(targetX int, targetY int, owner int) error {
+	tiles, err := doc.targetUnitTiles(targetX, targetY)
+	if err != nil {
+		return err
+	}
+	if err := doc.checkPlayer(owner); err != nil {
+		return err
+	}
+	for _, tile := range tiles {
+		if err := setTileUnitOwner(tile, owner); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (doc *SaveDocument) SetUnitType(targetX int, targetY int, unitType int) error {
+	tiles, err := doc.targetUnitTiles(targetX, targetY)
+	if err != nil {
+		return err
+	}
+	if err := checkUint16Field("unit type", unitType); err != nil {
+		return err
+	}
+	for _, tile := range tiles {
+		tile.Unit.UnitType = uint16(unitType)
+	}
+	return nil
+}
+
+// PlaceUnit adds a new unit with full health to an empty tile.
+// The unit gets the same new id in each copy of the map selected by Target and MaxUnitId is increased to match.
+func (doc *SaveDocument) PlaceUnit(targetX int, targetY int, unitType int, owner int) error {
+	tiles, err := doc.targetTiles(targetX, targetY)
+	if err != nil {
+		return err
+	}
+	if err := checkUint16Field("unit type", unitType); err != nil {
+		return err
+	}
+	if err := doc.checkPlayer(owner); err != nil {
+		return err
+	}
+	for _, tile := range tiles {
+		if tile.Unit != nil {
+			return fmt.Errorf("tile (%v, %v) already has a unit", targetX, targetY)
+		}
+	}
+
+	// the game takes new ids from MaxUnitId, which also counts units that have died since
+	unitId := uint32(0)
+	states := targetMapStates(doc.Output, doc.Target)
+	for _, state := range states {
+		unitId = max(unitId, state.mapHeader.MapHeaderInput.MaxUnitId, findMaxUnitId(*state.tileData))
+	}
+	unitId++
+	for _, state := range states {
+		state.mapHeader.MapHeaderInput.MaxUnitId = unitId
+	}
+	for _, tile := range tiles {
+		placeNewUnit(tile, unitId, uint8(owner), uint16(unitType), targetX, targetY, doc.Output.MaxTurn)
+	}
+	return nil
+}
+
+func (doc *SaveDocument) targetUnitTiles(targetX int, targetY int) ([]*TileData, error) {
+	tiles, err := doc.targetTiles(targetX, targetY)
+	if err != nil {
+		return nil, err
+	}
+	for _, tile := range tiles {
+		if tile.Unit == nil {
+			return nil, fmt.Errorf("no unit on tile (%v, %v)", targetX, targetY)
+		}
+	}
+	return tiles, nil
+}
+
+// ConvertTribe changes the owner of all units on tiles owned by oldTribe and returns the number of units converted
+func (doc *SaveDocument) ConvertTribe(oldTribe int, newTribe int) (int, error) {
+	if err := doc.checkPlayer(newTribe); err != nil {
+		return 0, err
+	}
+	states := targetMapStates(doc.Output, doc.Target)
+	for _, state := range states {
+		if _, ok := buildTribeUnitMapFromTiles(*state.tileData)[oldTribe]; !ok {
+			return 0, fmt.Errorf("tribe %v doesn't exist in %v", oldTribe, state)
+		}
+	}
+
+	numConverted := 0
+	for _, state := range states {
+		tribeUnits, err := convertTribeUnits(*state.tileData, oldTribe, newTribe)
+		if err != nil {
+			return numConverted, err
+		}
+		numConverted += len(tribeUnits)
+	}
+	return numConverted, nil
+}
+
+func (doc *SaveDocument) AddCity(targetX int, targetY int, cityName string, tribe int) error {
+	tiles, err := doc.targetTiles(targetX, targetY)
+	if err != nil {
+		return err
+	}
+	if err := doc.checkPlayer(tribe); err != nil {
+		return err
+	}
+	for _, tile := range tiles {
+		setCityOnTile(tile, targetX, targetY, cityName, tribe)
+	}
+	return nil
+}
+
+func (doc *SaveDocument) ResetTile(targetX int, targetY int) error {
+	tiles, err := doc.targetTiles(targetX, targetY)
+	if err != nil {
+		return err
+	}
+	for _, tile := range tiles {
+		*tile = BuildEmptyTile(targetX, targetY)
+	}
+	return nil
+}
+
+func (doc *SaveDocument) RevealTile(targetX int, targetY int, tribe int) error {
+	tiles, err := doc.targetTiles(targetX, targetY)
+	if err != nil {
+		return err
+	}
+	if err := doc.checkPlayer(tribe); err != nil {
+		return err
+	}
+	for _, tile := range tiles {
+		revealTile(tile, tribe)
+	}
+	return nil
+}
+
+func (doc *SaveDocument) RevealAllTiles(tribe int) error {
+	if err := doc.checkPlayer(tribe); err != nil {
+		return err
+	}
+	for _, state := range targetMapStates(doc.Output, doc.Target) {
+		tileData := *state.tileData
+		for i := 0; i < len(tileData); i++ {
+			for j := 0; j < len(tileData[i]); j++ {
+				revealTile(&tileData[i][j], tribe)
+			}
+		}
+	}
+	return nil
+}
+
+// SwapPlayers swaps the tiles, units, tribe, color and start tile of two players.
+// Both players must exist in each copy of the map selected by Target.
+func (doc *SaveDocument) SwapPlayers(playerId1 int, playerId2 int) error {
+	for _, playerId := range []int{playerId1, playerId2} {
+		if err := doc.checkPlayer(playerId); err != nil {
+			return err
+		}
+	}
+	if playerId1 == swapUnusedPlayerId || playerId2 == swapUnusedPlayerId {
+		return fmt.Errorf("player %v can't be swapped, the id is used while swapping", swapUnusedPlayerId)
+	}
+	for _, state := range targetMapStates(doc.Output, doc.Target) {
+		swapPlayerTiles(*state.tileData, playerId1, playerId2)
+		swapPlayerData(*state.playerData, playerId1, playerId2)
+	}
+	return nil
+}
+
+// AddPlayer inserts a new player before player 255 and returns the new player id.
+// The same player is added to each copy of the map selected by Target.
+func (doc *SaveDocument) AddPlayer() (int, error) {
+	states := targetMapStates(doc.Output, doc.Target)
+	newPlayerId := len(*states[len(states)-1].playerData)
+	newPlayer, err := BuildEmptyPlayer(newPlayerId, fmt.Sprintf("Player%v", newPlayerId), generateRandomColor())
+	if err != nil {
+		return 0, err
+	}
+	// insert into every state before assigning any, so a failure leaves the document unchanged
+	newPlayerLists := make([][]PlayerData, len(states))
+	for i, state := range states {
+		newPlayerLists[i], err = insertPlayer(*state.playerData, newPlayer, doc.Output.GameVersion)
+		if err != nil {
+			return 0, fmt.Errorf("%v: %w", state, err)
+		}
+	}
+	for i, state := range states {
+		*state.playerData = newPlayerLists[i]
+	}
+	return newPlayerId, nil
+}
+
+func (doc *SaveDocument) SetCapital(targetX int, targetY int, cityName string, tribe int) error {
+	if _, err := doc.targetTiles(targetX, targetY); err != nil {
+		return err
+	}
+	if tribe >= 255 {
+		return fmt.Errorf("tribe must be less than 255, value is %v", tribe)
+	}
+	if err := doc.checkPlayer(tribe); err != nil {
+		return err
+	}
+	for _, state := range targetMapStates(doc.Output, doc.Target) {
+		setTileCapital(*state.tileData, *state.playerData, targetX, targetY, cityName, tribe)
+	}
+	return nil
+}
+
+// ExpandRows adds empty rows to both copies of the map so their dimensions stay the same.
+// Target is ignored, because the game expects the initial and current maps to have the same size.
+func (doc *SaveDocument) ExpandRows(newRowDimensions int) error {
+	if newRowDimensions >= 256 {
+		return fmt.Errorf("updated value is over 256")
+	}
+	if newRowDimensions <= doc.Output.MapHeight {
+		return fmt.Errorf("new row dimensions are less than existing dimensions, new value: %v, existing height: %v",
+			newRowDimensions, doc.Output.MapHeight)
+	}
+	for _, state := range targetMapStates(doc.Output, EditTargetBoth) {
+		*state.tileData = appendEmptyRows(*state.tileData, state.mapHeader.MapWidth, newRowDimensions)
+		setMapHeaderDimensions(state.mapHeader, state.mapHeader.MapWidth, newRowDimensions)
+	}
+	doc.Output.MapHeight = newRowDimensions
+	return nil
+}
+
+// ExpandColumns adds empty columns to both copies of the map so their dimensions stay the same.
+// Target is ignored, because the game expects the initial and current maps to have the same size.
+func (doc *SaveDocument) ExpandColumns(newColDimensions int) error {
+	if newColDimensions >= 256 {
+		return fmt.Errorf("updated value is over 256")
+	}
+	if newColDimensions <= doc.Output.MapWidth {
+		return fmt.Errorf("new column dimensions are less than existing dimensions, new value: %v, existing width: %v",
+			newColDimensions, doc.Output.MapWidth)
+	}
+	for _, state := range targetMapStates(doc.Output, EditTargetBoth) {
+		*state.tileData = appendEmptyColumns(*state.tileData, state.mapHeader.MapWidth, newColDimensions)
+		setMapHeaderDimensions(state.mapHeader, newColDimensions, state.mapHeader.MapHeight)
+	}
+	doc.Output.MapWidth = newColDimensions
+	return nil
+}
+
+func (doc *SaveDocument) ExpandTiles(newSquareSizeDimensions int) error {
+	if newSquareSizeDimensions <= doc.Output.MapWidth || newSquareSizeDimensions <= doc.Output.MapHeight {
+		return fmt.Errorf("new dimensions are less than existing dimensions, new value: %v, existing width: %v, height: %v",
+			newSquareSizeDimensions, doc.Output.MapWidth, doc.Output.MapHeight)
+	}
+	if err := doc.ExpandColumns(newSquareSizeDimensions); err != nil {
+		return err
+	}
+	return doc.ExpandRows(newSquareSizeDimensions)
+}
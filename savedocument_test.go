@@ -0,0 +1,327 @@
+package polytopiamapmodel
+
+import (
+	"image/color"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func buildTestSaveBytes() []byte {
+	mapHeader := mapHeaderOutput
+	mapHeader.MapHeaderInput.Version1 = 104
+	mapHeader.MapSquareSize = 2
+	mapHeader.MapWidth = 2
+	mapHeader.MapHeight = 2
+
+	tileData := make([][]TileData, 2)
+	for i := 0; i < 2; i++ {
+		tileData[i] = make([]TileData, 2)
+		for j := 0; j < 2; j++ {
+			tileData[i][j] = BuildEmptyTile(j, i)
+		}
+	}
+
+	player := mustBuildEmptyPlayer(1, "Player1", color.RGBA{10, 20, 30, 255})
+	naturePlayer := mustBuildEmptyPlayer(1, "Nature", color.RGBA{0, 0, 0, 255})
+	naturePlayer.PlayerId = 255
+	naturePlayer.Tribe = int(TribeNature)
+	players := []PlayerData{player, naturePlayer}
+
+	stateBytes := mustSerialize(serializeMapState(mapHeader, tileData, players, 104))
+
+	fileBytes := make([]byte, 0)
+	fileBytes = append(fileBytes, stateBytes...)
+	fileBytes = append(fileBytes, 0, 0, 0)
+	fileBytes = append(fileBytes, stateBytes...)
+	fileBytes = append(fileBytes, 0, 0)
+	fileBytes = append(fileBytes, actionListBytes...)
+	return fileBytes
+}
+
+func writeTestSaveFile(t *testing.T) string {
+	return writeTestSaveBytes(t, buildTestSaveBytes())
+}
+
+func writeTestSaveBytes(t *testing.T, fileData []byte) string {
+	inputFilename := filepath.Join(t.TempDir(), "test.state.decomp")
+	if err := os.WriteFile(inputFilename, fileData, 0666); err != nil {
+		t.Fatalf(`Failed to write test save: %v`, err)
+	}
+	return inputFilename
+}
+
+func TestSaveDocumentWithoutEditsKeepsFileContents(t *testing.T) {
+	inputFilename := writeTestSaveFile(t)
+	doc, err := OpenSaveDocument(inputFilename)
+	if err != nil {
+		t.Fatalf(`Failed to open save: %v`, err)
+	}
+
+	compareArrays(t, serializeTestSave(t, doc.Output), buildTestSaveBytes())
+}
+
+func TestSaveDocumentSave(t *testing.T) {
+	inputFilename := writeTestSaveFile(t)
+	doc, err := OpenSaveDocument(inputFilename)
+	if err != nil {
+		t.Fatalf(`Failed to open save: %v`, err)
+	}
+
+	if err := doc.SetTerrain(1, 0, 4); err != nil {
+		t.Fatalf(`Failed to set terrain: %v`, err)
+	}
+	if err := doc.AddCity(0, 1, "Test City", 1); err != nil {
+		t.Fatalf(`Failed to add city: %v`, err)
+	}
+	if err := doc.RevealTile(1, 1, 1); err != nil {
+		t.Fatalf(`Failed to reveal tile: %v`, err)
+	}
+	if err := doc.ExpandTiles(3); err != nil {
+		t.Fatalf(`Failed to expand map: %v`, err)
+	}
+	if newPlayerId, err := doc.AddPlayer(); err != nil || newPlayerId != 2 {
+		t.Fatalf(`New player id = %v, expected = 2, error: %v`, newPlayerId, err)
+	}
+	if err := doc.SetTerrain(5, 5, 4); err == nil {
+		t.Fatalf(`Expected error for tile outside the map`)
+	}
+	if err := doc.Save(); err != nil {
+		t.Fatalf(`Failed to save: %v`, err)
+	}
+
+	result, err := ReadPolytopiaDecompressedFile(inputFilename)
+	if err != nil {
+		t.Fatalf(`Failed to read saved file: %v`, err)
+	}
+	if result.MapWidth != 3 || result.MapHeight != 3 || result.MapHeaderOutput.MapSquareSize != 3 {
+		t.Fatalf(`Map size = %vx%v (square size %v), expected = 3x3`, result.MapWidth, result.MapHeight, result.MapHeaderOutput.MapSquareSize)
+	}
+	if result.TileData[0][1].Terrain != 4 || result.TileData[0][1].Altitude != 2 {
+		t.Fatalf(`Terrain = %v, altitude = %v, expected = 4, 2`, result.TileData[0][1].Terrain, result.TileData[0][1].Altitude)
+	}
+	if result.TileData[1][0].ImprovementData == nil || result.TileData[1][0].ImprovementData.CityName != "Test City" {
+		t.Fatalf(`City was not added to tile (0, 1)`)
+	}
+	if !reflect.DeepEqual(result.TileData[1][1].PlayerVisibility, []int{1}) {
+		t.Fatalf(`Visibility = %v, expected = [1]`, result.TileData[1][1].PlayerVisibility)
+	}
+	if len(result.PlayerData) != 3 || result.PlayerData[1].PlayerId != 2 || result.PlayerData[2].PlayerId != 255 {
+		t.Fatalf(`Unexpected player list %+v`, result.PlayerData)
+	}
+	// only the current state is edited, but both copies of the map are expanded
+	if result.InitialTileData[0][1].Terrain != 3 || len(result.InitialPlayerData) != 2 {
+		t.Fatalf(`Initial state was modified`)
+	}
+	if len(result.InitialTileData) != 3 || len(result.InitialTileData[0]) != 3 || result.InitialMapHeaderOutput.MapWidth != 3 {
+		t.Fatalf(`Initial map size = %vx%v, expected = 3x3`, result.InitialMapHeaderOutput.MapWidth, result.InitialMapHeaderOutput.MapHeight)
+	}
+	if !reflect.DeepEqual(result.Actions, actionList) {
+		t.Fatalf(`Contents not equal. Result = %+v, expected = %+v`, result.Actions, actionList)
+	}
+}
+
+func TestSaveDocumentEditTarget(t *testing.T) {
+	doc, err := OpenSaveDocument(writeTestSaveFile(t))
+	if err != nil {
+		t.Fatalf(`Failed to open save: %v`, err)
+	}
+
+	doc.Target = EditTargetInitial
+	if err := doc.SetTerrain(1, 0, 4); err != nil {
+		t.Fatalf(`Failed to set terrain: %v`, err)
+	}
+	doc.Target = EditTargetBoth
+	if err := doc.SetCapital(0, 0, "Capital", 1); err != nil {
+		t.Fatalf(`Failed to set capital: %v`, err)
+	}
+	if newPlayerId, err := doc.AddPlayer(); err != nil || newPlayerId != 2 {
+		t.Fatalf(`New player id = %v, expected = 2, error: %v`, newPlayerId, err)
+	}
+	if err := doc.Save(); err != nil {
+		t.Fatalf(`Failed to save: %v`, err)
+	}
+
+	result := doc.Output
+	if result.InitialTileData[0][1].Terrain != 4 || result.TileData[0][1].Terrain != 3 {
+		t.Fatalf(`Terrain = %v (initial), %v (current), expected = 4, 3`, result.InitialTileData[0][1].Terrain, result.TileData[0][1].Terrain)
+	}
+	if result.InitialTileData[0][0].Capital != 1 || result.TileData[0][0].Capital != 1 || result.InitialTileData[1][1].Owner != 1 {
+		t.Fatalf(`Capital was not set in both states`)
+	}
+	if !reflect.DeepEqual(result.InitialPlayerData, result.PlayerData) || len(result.PlayerData) != 3 {
+		t.Fatalf(`Players not equal. Initial = %+v, current = %+v`, result.InitialPlayerData, result.PlayerData)
+	}
+}
+
+func TestSaveDocumentAddPlayer(t *testing.T) {
+	for _, gameVersion := range []int{105, 114} {
+		doc, err := OpenSaveDocument(writeTestSaveBytes(t, buildDetailedTestSaveBytes(gameVersion)))
+		if err != nil {
+			t.Fatalf(`Failed to open version %v save: %v`, gameVersion, err)
+		}
+		doc.Target = EditTargetBoth
+		if newPlayerId, err := doc.AddPlayer(); err != nil || newPlayerId != 2 {
+			t.Fatalf(`Version %v new player id = %v, expected = 2, error: %v`, gameVersion, newPlayerId, err)
+		}
+		if err := doc.Save(); err != nil {
+			t.Fatalf(`Failed to save version %v: %v`, gameVersion, err)
+		}
+
+		for _, players := range [][]PlayerData{doc.Output.InitialPlayerData, doc.Output.PlayerData} {
+			if len(players) != 3 || players[1].PlayerId != 2 || players[2].PlayerId != 255 {
+				t.Fatalf(`Version %v players = %+v, expected ids 1, 2, 255`, gameVersion, players)
+			}
+			expectedAggressions := 3
+			if gameVersion >= 114 {
+				expectedAggressions = 0
+			}
+			for _, player := range players {
+				if len(player.AggressionsByPlayers) != expectedAggressions {
+					t.Fatalf(`Version %v player %v has %v aggressions, expected = %v`,
+						gameVersion, player.PlayerId, len(player.AggressionsByPlayers), expectedAggressions)
+				}
+			}
+		}
+	}
+}
+
+func TestSaveDocumentAddPlayerRejectsEmptyAggressions(t *testing.T) {
+	doc, err := OpenSaveDocument(writeTestSaveBytes(t, buildDetailedTestSaveBytes(105)))
+	if err != nil {
+		t.Fatalf(`Failed to open save: %v`, err)
+	}
+	doc.Output.PlayerData[0].AggressionsByPlayers = []PlayerAggression{}
+	if _, err := doc.AddPlayer(); err == nil {
+		t.Fatalf(`Expected error for a player without aggressions in version 105`)
+	}
+	if len(doc.Output.PlayerData) != 2 {
+		t.Fatalf(`Player count = %v after a failed AddPlayer, expected = 2`, len(doc.Output.PlayerData))
+	}
+}
+
+func TestParseEditTarget(t *testing.T) {
+	for _, target := range []EditTarget{EditTargetCurrent, EditTargetInitial, EditTargetBoth} {
+		result, err := ParseEditTarget(target.String())
+		if err != nil || result != target {
+			t.Fatalf(`ParseEditTarget(%q) = %v, %v, expected = %v`, target.String(), result, err, target)
+		}
+	}
+	if _, err := ParseEditTarget("all"); err == nil {
+		t.Fatalf(`Expected error for unknown target`)
+	}
+}
+
+func TestSaveDocumentBackup(t *testing.T) {
+	inputFilename := writeTestSaveFile(t)
+	doc, err := OpenSaveDocument(inputFilename)
+	if err != nil {
+		t.Fatalf(`Failed to open save: %v`, err)
+	}
+	doc.Backup = true
+	if err := doc.SetTerrain(1, 0, 4); err != nil {
+		t.Fatalf(`Failed to set terrain: %v`, err)
+	}
+	if err := doc.Save(); err != nil {
+		t.Fatalf(`Failed to save: %v`, err)
+	}
+
+	backupFilenames, err := filepath.Glob(inputFilename + ".*.bak")
+	if err != nil || len(backupFilenames) != 1 {
+		t.Fatalf(`Expected one backup file, found %v`, backupFilenames)
+	}
+	backupData, err := os.ReadFile(backupFilenames[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	compareArrays(t, backupData, buildTestSaveBytes())
+}
+
+func TestSaveDocumentPlaceUnitUsesMaxUnitId(t *testing.T) {
+	doc, err := OpenSaveDocument(writeTestSaveFile(t))
+	if err != nil {
+		t.Fatalf(`Failed to open save: %v`, err)
+	}
+	doc.Output.InitialMapHeaderOutput.MapHeaderInput.MaxUnitId = 30
+	doc.Output.MapHeaderOutput.MapHeaderInput.MaxUnitId = 40
+	doc.Target = EditTargetBoth
+
+	if err := doc.PlaceUnit(0, 0, int(UnitWarrior), 1); err != nil {
+		t.Fatalf(`Failed to place unit: %v`, err)
+	}
+	for _, state := range targetMapStates(doc.Output, EditTargetBoth) {
+		if unit := (*state.tileData)[0][0].Unit; unit == nil || unit.Id != 41 {
+			t.Fatalf(`Unit in the %v = %+v, expected id 41`, state, unit)
+		}
+		if maxUnitId := state.mapHeader.MapHeaderInput.MaxUnitId; maxUnitId != 41 {
+			t.Fatalf(`MaxUnitId in the %v = %v, expected 41`, state, maxUnitId)
+		}
+	}
+}
+
+func TestSaveDocumentMutatorsRejectInvalidPlayers(t *testing.T) {
+	inputFilename := writeTestSaveFile(t)
+	doc, err := OpenSaveDocument(inputFilename)
+	if err != nil {
+		t.Fatalf(`Failed to open save: %v`, err)
+	}
+
+	edits := map[string]func() error{
+		"place unit":       func() error { return doc.PlaceUnit(0, 0, int(UnitWarrior), 7) },
+		"add city":         func() error { return doc.AddCity(0, 0, "Test", 300) },
+		"reveal tile":      func() error { return doc.RevealTile(0, 0, -1) },
+		"reveal all tiles": func() error { return doc.RevealAllTiles(7) },
+		"swap players":     func() error { return doc.SwapPlayers(1, 7) },
+	}
+	for name, edit := range edits {
+		if err := edit(); err == nil || !strings.Contains(err.Error(), "player") {
+			t.Fatalf(`Expected %v to fail for an invalid player, got %v`, name, err)
+		}
+	}
+	compareArrays(t, serializeTestSave(t, doc.Output), buildTestSaveBytes())
+
+	if err := doc.SwapPlayers(1, 255); err != nil {
+		t.Fatalf(`Failed to swap existing players: %v`, err)
+	}
+}
+
+func TestSaveDocumentMutatorsRejectOutOfRangeUnitTypes(t *testing.T) {
+	doc, err := OpenSaveDocument(writeTestSaveBytes(t, buildDetailedTestSaveBytes(105)))
+	if err != nil {
+		t.Fatalf(`Failed to open save: %v`, err)
+	}
+	originalBytes := serializeTestSave(t, doc.Output)
+
+	edits := map[string]func() error{
+		"set unit type":          func() error { return doc.SetUnitType(1, 1, 65536) },
+		"set negative unit type": func() error { return doc.SetUnitType(1, 1, -1) },
+		"place unit":             func() error { return doc.PlaceUnit(0, 1, 70000, 1) },
+	}
+	for name, edit := range edits {
+		if err := edit(); err == nil || !strings.Contains(err.Error(), "unit type") {
+			t.Fatalf(`Expected %v to fail for an invalid unit type, got %v`, name, err)
+		}
+	}
+	compareArrays(t, serializeTestSave(t, doc.Output), originalBytes)
+}
+
+func TestSaveDocumentPlayerId(t *testing.T) {
+	doc, err := OpenSaveDocument(writeTestSaveFile(t))
+	if err != nil {
+		t.Fatalf(`Failed to open save: %v`, err)
+	}
+	doc.Target = EditTargetBoth
+
+	for name, expected := range map[string]int{"1": 1, "255": 255, "ai-mo": 1} {
+		if playerId, err := doc.PlayerId(name); err != nil || playerId != expected {
+			t.Fatalf(`PlayerId(%v) = %v, %v, expected %v`, name, playerId, err, expected)
+		}
+	}
+	for _, name := range []string{"7", "300", "Bardur", "NotATribe"} {
+		if _, err := doc.PlayerId(name); err == nil {
+			t.Fatalf(`Expected PlayerId(%v) to fail`, name)
+		}
+	}
+}
@@ -0,0 +1,386 @@
+package polytopiamapmodel
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+type TableFormat int
+
+const (
+	TableFormatCSV TableFormat = iota
+	TableFormatTSV
+)
+
+func (tableFormat TableFormat) Extension() string {
+	if tableFormat == TableFormatTSV {
+		return ".tsv"
+	}
+	return ".csv"
+}
+
+type TableExportOptions struct {
+	Format TableFormat
+	// Write terrain, climate, resource, improvement, unit, tech and tribe ids as names
+	UseEnumNames bool
+	// Export the tiles and players of the initial state instead of the current state
+	Initial bool
+	// Export the tiles and players at the end of every turn with a turn column, rebuilt by replaying the actions.
+	// Turn 0 is the initial state. Actions the replay can't apply, such as attacks, are skipped, see ReplayAllTurns.
+	PerTurn bool
+}
+
+var (
+	tileTableHeader = []string{"x", "y", "terrain", "climate", "altitude", "owner", "capital", "resource", "improvement",
+		"city_name", "city_level", "unit_id", "unit_type", "unit_owner", "unit_health", "passenger_unit_type",
+		"road", "water_route", "visibility"}
+	playerTableHeader = []string{"index", "player_id", "name", "account_id", "auto_play", "tribe", "start_x", "start_y",
+		"difficulty_handicap", "currency", "score", "num_cities", "available_tech", "encountered_players",
+		"total_units_killed", "total_units_lost", "total_tribes_destroyed", "destroyed_by_tribe", "destroyed_turn", "end_score"}
+	actionTableHeader = []string{"index", "turn", "type", "player_id", "unit_id", "unit_type", "x", "y",
+		"target_x", "target_y", "target_player_id", "value"}
+)
+
+// ExportPolytopiaTables writes the tiles, players and actions to <outputPrefix>_tiles, _players and _actions files.
+// Each table has one row per tile, player or action so it can be loaded directly into data frames.
+// With PerTurn set, the tiles and players tables have one row per tile or player for every turn.
+func ExportPolytopiaTables(saveOutput *PolytopiaSaveOutput, outputPrefix string, options TableExportOptions) error {
+	if options.Initial && options.PerTurn {
+		return fmt.Errorf("the initial state and per turn tables can't be exported together, per turn tables start with the initial state")
+	}
+	tileData, playerData := saveOutput.TileData, saveOutput.PlayerData
+	if options.Initial {
+		tileData, playerData = saveOutput.InitialTileData, saveOutput.InitialPlayerData
+	}
+	writeTiles := func(writer io.Writer) error { return WriteTileTable(writer, tileData, options) }
+	writePlayers := func(writer io.Writer) error { return WritePlayerTable(writer, playerData, options) }
+	if options.PerTurn {
+		snapshots := ReplayAllTurns(saveOutput).Snapshots
+		writeTiles = func(writer io.Writer) error { return WriteTurnTileTable(writer, snapshots, options) }
+		writePlayers = func(writer io.Writer) error { return WriteTurnPlayerTable(writer, snapshots, options) }
+	}
+
+	tables := []struct {
+		name  string
+		write func(writer io.Writer) error
+	}{
+		{"tiles", writeTiles},
+		{"players", writePlayers},
+		{"actions", func(writer io.Writer) error { return WriteActionTable(writer, saveOutput.Actions, options) }},
+	}
+	for _, table := range tables {
+		var builder strings.Builder
+		if err := table.write(&builder); err != nil {
+			return fmt.Errorf("failed to export %v: %w", table.name, err)
+		}
+		outputFilename := outputPrefix + "_" + table.name + options.Format.Extension()
+		if err := WriteFileAtomic(outputFilename, []byte(builder.String())); err != nil {
+			return fmt.Errorf("failed to write %v: %w", outputFilename, err)
+		}
+	}
+	return nil
+}
+
+func newTableWriter(writer io.Writer, options TableExportOptions) *csv.Writer {
+	csvWriter := csv.NewWriter(writer)
+	if options.Format == TableFormatTSV {
+		csvWriter.Comma = '\t'
+	}
+	return csvWriter
+}
+
+func writeTableRows(csvWriter *csv.Writer, header []string, rows [][]string) error {
+	if err := csvWriter.Write(header); err != nil {
+		return err
+	}
+	if err := csvWriter.WriteAll(rows); err != nil {
+		return err
+	}
+	return csvWriter.Error()
+}
+
+// formatEnumValue writes an id as a name if names are enabled. Negative ids mean there is no value and are left empty.
+func formatEnumValue(id int, format func(int) string, options TableExportOptions) string {
+	if id < 0 {
+		return ""
+	}
+	if options.UseEnumNames {
+		return format(id)
+	}
+	return strconv.Itoa(id)
+}
+
+func formatIntList(values []int) string {
+	formattedValues := make([]string, len(values))
+	for i, value := range values {
+		formattedValues[i] = strconv.Itoa(value)
+	}
+	return strings.Join(formattedValues, ";")
+}
+
+// formatVisibilityMask writes the players that can see a tile as the sum of 1<<playerId.
+// Player 255 is nature, so the mask doesn't fit in 64 bits and is written as a decimal of any length.
+func formatVisibilityMask(playerIds []int) string {
+	mask := new(big.Int)
+	for _, playerId := range playerIds {
+		mask.SetBit(mask, playerId, 1)
+	}
+	return mask.String()
+}
+
+// WriteTileTable writes one row per tile.
+// The visibility column is a bitmask with bit playerId set for every player that can see the tile, see formatVisibilityMask.
+func WriteTileTable(writer io.Writer, tileData [][]TileData, options TableExportOptions) error {
+	return writeTableRows(newTableWriter(writer, options), tileTableHeader, buildTileRows(tileData, options))
+}
+
+// WriteTurnTileTable writes one row per tile for every snapshot, starting with a turn column
+func WriteTurnTileTable(writer io.Writer, snapshots []TurnSnapshot, options TableExportOptions) error {
+	rows := make([][]string, 0)
+	for _, snapshot := range snapshots {
+		rows = append(rows, prependTurn(snapshot.Turn, buildTileRows(snapshot.TileData, options))...)
+	}
+	return writeTableRows(newTableWriter(writer, options), append([]string{"turn"}, tileTableHeader...), rows)
+}
+
+// prependTurn adds the turn as the first column of every row
+func prependTurn(turn int, rows [][]string) [][]string {
+	for i := range rows {
+		rows[i] = append([]string{strconv.Itoa(turn)}, rows[i]...)
+	}
+	return rows
+}
+
+func buildTileRows(tileData [][]TileData, options TableExportOptions) [][]string {
+	rows := make([][]string, 0)
+	for y := 0; y < len(tileData); y++ {
+		for x := 0; x < len(tileData[y]); x++ {
+			tile := tileData[y][x]
+			resourceType := -1
+			if tile.ResourceExists {
+				resourceType = tile.ResourceType
+			}
+			improvementType := -1
+			cityName, cityLevel := "", ""
+			if tile.ImprovementExists {
+				improvementType = tile.ImprovementType
+			}
+			if tile.ImprovementData != nil && tile.ImprovementData.CityName != "" {
+				cityName = tile.ImprovementData.CityName
+				cityLevel = strconv.Itoa(tile.ImprovementData.Level)
+			}
+			unitId, unitType, unitOwner, unitHealth, passengerUnitType := "", "", "", "", ""
+			if tile.Unit != nil {
+				unitId = strconv.Itoa(int(tile.Unit.Id))
+				unitType = formatEnumValue(int(tile.Unit.UnitType), func(id int) string { return UnitType(id).String() }, options)
+				unitOwner = strconv.Itoa(int(tile.Unit.Owner))
+				unitHealth = strconv.Itoa(int(tile.Unit.Health))
+			}
+			if tile.PassengerUnit != nil {
+				passengerUnitType = formatEnumValue(int(tile.PassengerUnit.UnitType), func(id int) string { return UnitType(id).String() }, options)
+			}
+
+			rows = append(rows, []string{
+				strconv.Itoa(x),
+				strconv.Itoa(y),
+				formatEnumValue(tile.Terrain, func(id int) string { return TerrainType(id).String() }, options),
+				formatEnumValue(tile.Climate, func(id int) string { return ClimateType(id).String() }, options),
+				strconv.Itoa(tile.Altitude),
+				strconv.Itoa(tile.Owner),
+				strconv.Itoa(tile.Capital),
+				formatEnumValue(resourceType, func(id int) string { return ResourceType(id).String() }, options),
+				formatEnumValue(improvementType, func(id int) string { return ImprovementType(id).String() }, options),
+				cityName,
+				cityLevel,
+				unitId,
+				unitType,
+				unitOwner,
+				unitHealth,
+				passengerUnitType,
+				strconv.FormatBool(tile.HasRoad),
+				strconv.FormatBool(tile.HasWaterRoute),
+				formatVisibilityMask(tile.PlayerVisibility),
+			})
+		}
+	}
+	return rows
+}
+
+// WritePlayerTable writes one row per player. Lists such as available_tech are separated by semicolons.
+func WritePlayerTable(writer io.Writer, playerData []PlayerData, options TableExportOptions) error {
+	return writeTableRows(newTableWriter(writer, options), playerTableHeader, buildPlayerRows(playerData, options))
+}
+
+// WriteTurnPlayerTable writes one row per player for every snapshot, starting with a turn column
+func WriteTurnPlayerTable(writer io.Writer, snapshots []TurnSnapshot, options TableExportOptions) error {
+	rows := make([][]string, 0)
+	for _, snapshot := range snapshots {
+		rows = append(rows, prependTurn(snapshot.Turn, buildPlayerRows(snapshot.PlayerData, options))...)
+	}
+	return writeTableRows(newTableWriter(writer, options), append([]string{"turn"}, playerTableHeader...), rows)
+}
+
+func buildPlayerRows(playerData []PlayerData, options TableExportOptions) [][]string {
+	rows := make([][]string, len(playerData))
+	for i, player := range playerData {
+		availableTech := formatIntList(player.AvailableTech)
+		if options.UseEnumNames {
+			techNames := make([]string, len(player.AvailableTech))
+			for j, tech := range player.AvailableTech {
+				techNames[j] = TechType(tech).String()
+			}
+			availableTech = strings.Join(techNames, ";")
+		}
+
+		rows[i] = []string{
+			strconv.Itoa(i),
+			strconv.Itoa(player.PlayerId),
+			player.Name,
+			player.AccountId,
+			strconv.FormatBool(player.AutoPlay),
+			formatEnumValue(player.Tribe, func(id int) string { return TribeType(id).String() }, options),
+			strconv.Itoa(player.StartTileCoordinates[0]),
+			strconv.Itoa(player.StartTileCoordinates[1]),
+			strconv.Itoa(player.DifficultyHandicap),
+			strconv.Itoa(player.Currency),
+			strconv.Itoa(player.Score),
+			strconv.Itoa(player.NumCities),
+			availableTech,
+			formatIntList(player.EncounteredPlayers),
+			strconv.Itoa(player.TotalUnitsKilled),
+			strconv.Itoa(player.TotalUnitsLost),
+			strconv.Itoa(player.TotalTribesDestroyed),
+			strconv.Itoa(player.DestroyedByTribe),
+			strconv.Itoa(player.DestroyedTurn),
+			strconv.Itoa(player.EndScore),
+		}
+	}
+	return rows
+}
+
+// actionColumns are the action table columns that depend on the action type. Columns that don't apply are empty.
+type actionColumns struct {
+	playerId       string
+	unitId         string
+	unitType       string
+	position       [2]string
+	target         [2]string
+	targetPlayerId string
+	value          string
+}
+
+func formatTableCoordinates(coordinates [2]uint32) [2]string {
+	return [2]string{strconv.FormatUint(uint64(coordinates[0]), 10), strconv.FormatUint(uint64(coordinates[1]), 10)}
+}
+
+// tileActionColumns has the columns of an action taken by a player on one tile
+func tileActionColumns(playerId uint8, coordinates [2]uint32) actionColumns {
+	return actionColumns{playerId: strconv.Itoa(int(playerId)), position: formatTableCoordinates(coordinates)}
+}
+
+// playerActionColumns has the columns of an action sent from one player to another
+func playerActionColumns(playerId uint8, targetPlayerId uint8) actionColumns {
+	return actionColumns{playerId: strconv.Itoa(int(playerId)), targetPlayerId: strconv.Itoa(int(targetPlayerId))}
+}
+
+func buildActionColumns(action Action, options TableExportOptions) actionColumns {
+	formatUnitType := func(unitType uint16) string {
+		return formatEnumValue(int(unitType), func(id int) string { return UnitType(id).String() }, options)
+	}
+
+	var columns actionColumns
+	switch action := action.(type) {
+	case ActionBuild:
+		columns = tileActionColumns(action.PlayerId, action.Coordinates)
+		columns.value = formatEnumValue(int(action.ImprovementType), func(id int) string { return ImprovementType(id).String() }, options)
+	case ActionAttack:
+		columns = tileActionColumns(action.PlayerId, action.Origin)
+		columns.unitId = strconv.FormatUint(uint64(action.UnitId), 10)
+		columns.target = formatTableCoordinates(action.Target)
+	case ActionRecover:
+		columns = tileActionColumns(action.PlayerId, action.Coordinates)
+	case ActionDisband:
+		columns = tileActionColumns(action.PlayerId, action.Coordinates)
+	case ActionTrain:
+		columns = tileActionColumns(action.PlayerId, action.Position)
+		columns.unitType = formatUnitType(action.UnitType)
+	case ActionMove:
+		columns = tileActionColumns(action.PlayerId, action.OldPosition)
+		columns.unitId = strconv.FormatUint(uint64(action.UnitId), 10)
+		columns.target = formatTableCoordinates(action.NewPosition)
+	case ActionCaptureCity:
+		columns = tileActionColumns(action.PlayerId, action.Coordinates)
+		columns.unitId = strconv.FormatUint(uint64(action.UnitId), 10)
+	case ActionResearch:
+		columns.playerId = strconv.Itoa(int(action.PlayerId))
+		columns.value = formatEnumValue(int(action.TechType), func(id int) string { return TechType(id).String() }, options)
+	case ActionDestroyImprovement:
+		columns = tileActionColumns(action.PlayerId, action.Coordinates)
+	case ActionCityReward:
+		columns = tileActionColumns(action.PlayerId, action.Coordinates)
+		columns.value = strconv.Itoa(int(action.Reward))
+	case ActionPromote:
+		columns = tileActionColumns(action.PlayerId, action.Coordinates)
+	case ActionExamineRuins:
+		columns = tileActionColumns(action.PlayerId, action.Coordinates)
+	case ActionEndTurn:
+		columns.playerId = strconv.Itoa(int(action.PlayerId))
+	case ActionUpgrade:
+		columns = tileActionColumns(action.PlayerId, action.Coordinates)
+		columns.unitType = formatUnitType(action.UnitType)
+	case ActionHealOthers:
+		columns = tileActionColumns(action.PlayerId, action.Coordinates)
+	case ActionBreakIce:
+		columns = tileActionColumns(action.PlayerId, action.Coordinates)
+	case ActionResign:
+		columns.playerId = strconv.Itoa(int(action.PlayerId))
+	case ActionCityLevelUp:
+		columns = tileActionColumns(action.PlayerId, action.Coordinates)
+	case ActionFreezeArea:
+		columns = tileActionColumns(action.PlayerId, action.Coordinates)
+	case ActionExplode:
+		columns = tileActionColumns(action.PlayerId, action.Coordinates)
+	case ActionEstablishEmbassy:
+		columns = tileActionColumns(action.PlayerId, action.Coordinates)
+		columns.targetPlayerId = strconv.Itoa(int(action.TargetPlayerId))
+	case ActionDiplomacy:
+		columns = playerActionColumns(action.PlayerId, action.TargetPlayerId)
+		columns.value = strconv.Itoa(int(action.MessageType))
+	case ActionDestroyEmbassy:
+		columns = tileActionColumns(action.PlayerId, action.Coordinates)
+		columns.targetPlayerId = strconv.Itoa(int(action.TargetPlayerId))
+	case ActionInfiltrate:
+		columns = tileActionColumns(action.PlayerId, action.Coordinates)
+		columns.targetPlayerId = strconv.Itoa(int(action.TargetPlayerId))
+	}
+	return columns
+}
+
+// WriteActionTable writes one row per action. Columns that don't apply to an action are left empty.
+// The value column holds the improvement type of build actions, the tech type of research actions,
+// the reward of city rewards and the message type of diplomacy actions.
+func WriteActionTable(writer io.Writer, actions []ReplayAction, options TableExportOptions) error {
+	rows := make([][]string, len(actions))
+	for i, replayAction := range actions {
+		columns := buildActionColumns(replayAction.Action, options)
+		rows[i] = []string{
+			strconv.Itoa(replayAction.Index),
+			strconv.Itoa(replayAction.Turn),
+			replayAction.Action.ActionType().String(),
+			columns.playerId,
+			columns.unitId,
+			columns.unitType,
+			columns.position[0],
+			columns.position[1],
+			columns.target[0],
+			columns.target[1],
+			columns.targetPlayerId,
+			columns.value,
+		}
+	}
+	return writeTableRows(newTableWriter(writer, options), actionTableHeader, rows)
+}
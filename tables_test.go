@@ -0,0 +1,146 @@
+package polytopiamapmodel
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestWriteTileTable(t *testing.T) {
+	tileData := [][]TileData{{
+		{Terrain: int(TerrainForest), Climate: 2, Altitude: 1, Owner: 2, ResourceExists: true, ResourceType: 0, ImprovementType: -1,
+			Unit: &UnitData{Id: 7, Owner: 2, UnitType: uint16(UnitWarrior), Health: 100}, PlayerVisibility: []int{2, 255}, HasRoad: true},
+		{Terrain: int(TerrainWater), ImprovementType: -1},
+	}}
+
+	var builder strings.Builder
+	if err := WriteTileTable(&builder, tileData, TableExportOptions{}); err != nil {
+		t.Fatalf(`Failed to write tiles: %v`, err)
+	}
+	lines := strings.Split(strings.TrimSpace(builder.String()), "\n")
+	expected := []string{
+		strings.Join(tileTableHeader, ","),
+		// visible to players 2 and 255, 1<<2 + 1<<255
+		"0,0,5,2,1,2,0,0,,,,7,2,2,100,,true,false,57896044618658097711785492504343953926634992332820282019728792003956564819972",
+		"1,0,1,0,0,0,0,,,,,,,,,,false,false,0",
+	}
+	if len(lines) != len(expected) {
+		t.Fatalf(`Tile table has %v lines, expected %v: %v`, len(lines), len(expected), lines)
+	}
+	for i := range expected {
+		if lines[i] != expected[i] {
+			t.Fatalf(`Tile table line %v = %q, expected %q`, i, lines[i], expected[i])
+		}
+	}
+
+	builder.Reset()
+	if err := WriteTileTable(&builder, tileData, TableExportOptions{Format: TableFormatTSV, UseEnumNames: true}); err != nil {
+		t.Fatalf(`Failed to write tiles: %v`, err)
+	}
+	if line := strings.Split(builder.String(), "\n")[1]; !strings.HasPrefix(line, "0\t0\tForest\t") || !strings.Contains(line, "\tWarrior\t") {
+		t.Fatalf(`Tsv line with enum names = %q`, line)
+	}
+}
+
+func TestWriteActionTable(t *testing.T) {
+	actions := []ReplayAction{
+		{Index: 0, Turn: 1, Action: ActionMove{PlayerId: 1, OldPosition: [2]uint32{1, 2}, NewPosition: [2]uint32{2, 3}, UnitId: 4}},
+		{Index: 1, Turn: 1, Action: ActionResearch{PlayerId: 1, TechType: 3}},
+		{Index: 2, Turn: 2, Action: ActionTrain{PlayerId: 2, UnitType: uint16(UnitWarrior), Position: [2]uint32{5, 6}}},
+		{Index: 3, Turn: 2, Action: RawAction{Type: 99}},
+	}
+
+	var builder strings.Builder
+	if err := WriteActionTable(&builder, actions, TableExportOptions{}); err != nil {
+		t.Fatalf(`Failed to write actions: %v`, err)
+	}
+	expected := strings.Join([]string{
+		strings.Join(actionTableHeader, ","),
+		"0,1,Move,1,4,,1,2,2,3,,",
+		"1,1,Research,1,,,,,,,,3",
+		"2,2,Train,2,,2,5,6,,,,",
+		"3,2,ActionType(99),,,,,,,,,",
+	}, "\n") + "\n"
+	if builder.String() != expected {
+		t.Fatalf(`Action table = %q, expected %q`, builder.String(), expected)
+	}
+}
+
+func TestExportPolytopiaTables(t *testing.T) {
+	saveOutput, err := ReadPolytopiaDecompressedFile(writeTestSaveFile(t))
+	if err != nil {
+		t.Fatalf(`Failed to read save: %v`, err)
+	}
+	outputPrefix := filepath.Join(t.TempDir(), "save")
+	if err := ExportPolytopiaTables(saveOutput, outputPrefix, TableExportOptions{Format: TableFormatTSV}); err != nil {
+		t.Fatalf(`Failed to export tables: %v`, err)
+	}
+
+	expectedRows := map[string]int{
+		"tiles":   saveOutput.MapWidth*saveOutput.MapHeight + 1,
+		"players": len(saveOutput.PlayerData) + 1,
+		"actions": len(saveOutput.Actions) + 1,
+	}
+	for name, rows := range expectedRows {
+		contents, err := os.ReadFile(outputPrefix + "_" + name + ".tsv")
+		if err != nil {
+			t.Fatalf(`Failed to read %v table: %v`, name, err)
+		}
+		if lines := strings.Count(string(contents), "\n"); lines != rows {
+			t.Fatalf(`%v table has %v lines, expected %v`, name, lines, rows)
+		}
+	}
+}
+
+func TestWriteTurnTables(t *testing.T) {
+	snapshots := []TurnSnapshot{
+		{Turn: 0, TileData: [][]TileData{{{Terrain: int(TerrainField), ImprovementType: -1}}}, PlayerData: []PlayerData{{PlayerId: 1, Name: "A"}}},
+		{Turn: 1, TileData: [][]TileData{{{Terrain: int(TerrainForest), ImprovementType: -1}}}, PlayerData: []PlayerData{{PlayerId: 1, Name: "A"}}},
+	}
+
+	var builder strings.Builder
+	if err := WriteTurnTileTable(&builder, snapshots, TableExportOptions{}); err != nil {
+		t.Fatalf(`Failed to write tiles: %v`, err)
+	}
+	lines := strings.Split(strings.TrimSpace(builder.String()), "\n")
+	if len(lines) != 3 || lines[0] != "turn,"+strings.Join(tileTableHeader, ",") {
+		t.Fatalf(`Turn tile table = %q`, lines)
+	}
+	if !strings.HasPrefix(lines[1], "0,0,0,3,") || !strings.HasPrefix(lines[2], "1,0,0,5,") {
+		t.Fatalf(`Turn tile rows = %q`, lines[1:])
+	}
+
+	builder.Reset()
+	if err := WriteTurnPlayerTable(&builder, snapshots, TableExportOptions{}); err != nil {
+		t.Fatalf(`Failed to write players: %v`, err)
+	}
+	lines = strings.Split(strings.TrimSpace(builder.String()), "\n")
+	if len(lines) != 3 || !strings.HasPrefix(lines[1], "0,0,1,A,") || !strings.HasPrefix(lines[2], "1,0,1,A,") {
+		t.Fatalf(`Turn player table = %q`, lines)
+	}
+}
+
+func TestExportPolytopiaTablesPerTurn(t *testing.T) {
+	saveOutput, err := ReadPolytopiaDecompressedFile(writeTestSaveFile(t))
+	if err != nil {
+		t.Fatalf(`Failed to read save: %v`, err)
+	}
+	outputPrefix := filepath.Join(t.TempDir(), "save")
+	if err := ExportPolytopiaTables(saveOutput, outputPrefix, TableExportOptions{Initial: true, PerTurn: true}); err == nil {
+		t.Fatalf(`Expected error when exporting the initial state per turn`)
+	}
+	if err := ExportPolytopiaTables(saveOutput, outputPrefix, TableExportOptions{PerTurn: true}); err != nil {
+		t.Fatalf(`Failed to export tables: %v`, err)
+	}
+
+	snapshots := ReplayAllTurns(saveOutput).Snapshots
+	contents, err := os.ReadFile(outputPrefix + "_tiles.csv")
+	if err != nil {
+		t.Fatalf(`Failed to read tiles table: %v`, err)
+	}
+	expectedRows := len(snapshots)*saveOutput.MapWidth*saveOutput.MapHeight + 1
+	if lines := strings.Count(string(contents), "\n"); lines != expectedRows {
+		t.Fatalf(`Tiles table has %v lines, expected %v`, lines, expectedRows)
+	}
+}
@@ -1,10 +1,9 @@
 package polytopiamapmodel
 
 import (
-	"encoding/binary"
 	"fmt"
 	"io"
-	"log"
+	"math"
 )
 
 type TileDataHeader struct {
@@ -17,83 +16,116 @@ type TileDataHeader struct {
 	CapitalCoordinates [2]int32
 }
 
+// minTileSize is the size of a tile without a resource, improvement, unit or visible players
+const minTileSize = 34
+
 type TileData struct {
-	WorldCoordinates           [2]int
-	Terrain                    int
-	Climate                    int
-	Altitude                   int
-	Owner                      int
-	Capital                    int
-	CapitalCoordinates         [2]int
-	ResourceExists             bool
-	ResourceType               int
-	ImprovementExists          bool
-	ImprovementType            int
-	ImprovementData            *ImprovementData
-	Unit                       *UnitData
-	PassengerUnit              *UnitData
-	UnitEffectData             []int // flags: 0 - ice, 1 - poison, 2 - boost, 3 - invisible
-	UnitDirectionData          []int // contains direction flag (0 - southwest, 1 - west, 2 - northwest, 3 - north, 4 - northeast, 5 - east, 6 - southwest, 7 - south)
-	PassengerUnitEffectData    []int
-	PassengerUnitDirectionData []int
-	PlayerVisibility           []int
-	HasRoad                    bool
-	HasWaterRoute              bool
-	TileSkin                   int
-	Unknown                    []int
-	FloodedFlag                int // introduced in new aquarion update (version 105)
-	FloodedValue               int // introduced in new aquarion update (version 105)
+	WorldCoordinates           [2]int           `json:"worldCoordinates"`
+	Terrain                    int              `json:"terrain"`
+	Climate                    int              `json:"climate"`
+	Altitude                   int              `json:"altitude"`
+	Owner                      int              `json:"owner"`
+	Capital                    int              `json:"capital"`
+	CapitalCoordinates         [2]int           `json:"capitalCoordinates"`
+	ResourceExists             bool             `json:"resourceExists"`
+	ResourceType               int              `json:"resourceType"`
+	ImprovementExists          bool             `json:"improvementExists"`
+	ImprovementType            int              `json:"improvementType"`
+	ImprovementData            *ImprovementData `json:"improvementData,omitempty"`
+	Unit                       *UnitData        `json:"unit,omitempty"`
+	PassengerUnit              *UnitData        `json:"passengerUnit,omitempty"`
+	UnitEffectData             []int            `json:"unitEffectData"`    // flags: 0 - ice, 1 - poison, 2 - boost, 3 - invisible
+	UnitDirectionData          []int            `json:"unitDirectionData"` // contains direction flag (0 - southwest, 1 - west, 2 - northwest, 3 - north, 4 - northeast, 5 - east, 6 - southwest, 7 - south)
+	PassengerUnitEffectData    []int            `json:"passengerUnitEffectData"`
+	PassengerUnitDirectionData []int            `json:"passengerUnitDirectionData"`
+	PlayerVisibility           []int            `json:"playerVisibility"`
+	HasRoad                    bool             `json:"hasRoad"`
+	HasWaterRoute              bool             `json:"hasWaterRoute"`
+	TileSkin                   int              `json:"tileSkin"`
+	Unknown                    []int            `json:"undecodedBytes"`
+	FloodedFlag                int              `json:"floodedFlag,omitempty"`  // introduced in new aquarion update (version 105)
+	FloodedValue               int              `json:"floodedValue,omitempty"` // introduced in new aquarion update (version 105)
 }
 
 type UnitData struct {
-	Id                 uint32
-	Owner              uint8
-	UnitType           uint16
-	FollowerUnitId     uint32 // only initialized for cymanti centipedes and segments
-	LeaderUnitId       uint32 // only initialized for cymanti centipedes and segments
-	CurrentCoordinates [2]int32
-	HomeCoordinates    [2]int32
-	Health             uint16 // should be divided by 10 to get value ingame
-	PromotionLevel     uint16
-	Experience         uint16
-	Moved              bool
-	Attacked           bool
-	Flipped            bool
-	CreatedTurn        uint16
+	Id                 uint32   `json:"id"`
+	Owner              uint8    `json:"owner"`
+	UnitType           uint16   `json:"unitType"`
+	FollowerUnitId     uint32   `json:"followerUnitId"` // only initialized for cymanti centipedes and segments
+	LeaderUnitId       uint32   `json:"leaderUnitId"`   // only initialized for cymanti centipedes and segments
+	CurrentCoordinates [2]int32 `json:"currentCoordinates"`
+	HomeCoordinates    [2]int32 `json:"homeCoordinates"`
+	Health             uint16   `json:"health"` // should be divided by 10 to get value ingame
+	PromotionLevel     uint16   `json:"promotionLevel"`
+	Experience         uint16   `json:"experience"`
+	Moved              bool     `json:"moved"`
+	Attacked           bool     `json:"attacked"`
+	Flipped            bool     `json:"flipped"`
+	CreatedTurn        uint16   `json:"createdTurn"`
+}
+
+func DeserializeTileDataFromBytes(streamReader *io.SectionReader, expectedRow int, expectedCol int, gameVersion int) (TileData, error) {
+	tileData, err := deserializeTileData(streamReader, expectedRow, expectedCol, gameVersion)
+	if err != nil {
+		return TileData{}, withTile(withSection(err, "tile"), expectedCol, expectedRow)
+	}
+	return tileData, nil
 }
 
-func DeserializeTileDataFromBytes(streamReader *io.SectionReader, expectedRow int, expectedCol int, gameVersion int) TileData {
+func deserializeTileData(streamReader *io.SectionReader, expectedRow int, expectedCol int, gameVersion int) (TileData, error) {
+	headerOffset := currentOffset(streamReader)
 	tileDataHeader := TileDataHeader{}
-	if err := binary.Read(streamReader, binary.LittleEndian, &tileDataHeader); err != nil {
-		log.Fatal("Failed to load tileDataHeader: ", err)
+	if err := readStructSafe(streamReader, "tileDataHeader", &tileDataHeader); err != nil {
+		return TileData{}, err
 	}
 
 	// Sanity check
 	if int(tileDataHeader.WorldCoordinates[0]) != expectedCol || int(tileDataHeader.WorldCoordinates[1]) != expectedRow {
-		log.Fatal(fmt.Sprintf("File reached unexpected location. Iteration (%v, %v) isn't equal to world coordinates (%v, %v)",
-			expectedRow, expectedCol, tileDataHeader.WorldCoordinates[0], tileDataHeader.WorldCoordinates[1]))
+		return TileData{}, newParseError(headerOffset, "WorldCoordinates",
+			fmt.Errorf("file reached unexpected location. Iteration (%v, %v) isn't equal to world coordinates (%v, %v)",
+				expectedRow, expectedCol, tileDataHeader.WorldCoordinates[0], tileDataHeader.WorldCoordinates[1]))
 	}
 
-	resourceExistsFlag := unsafeReadUint8(streamReader)
+	resourceExistsFlag, err := readUint8Safe(streamReader, "resource exists flag")
+	if err != nil {
+		return TileData{}, err
+	}
 	resourceType := -1
 	if resourceExistsFlag == 1 {
-		resourceType = int(unsafeReadUint16(streamReader))
+		resourceTypeValue, err := readUint16Safe(streamReader, "resource type")
+		if err != nil {
+			return TileData{}, err
+		}
+		resourceType = int(resourceTypeValue)
 	}
 
-	improvementExistsFlag := unsafeReadUint8(streamReader)
+	improvementExistsFlag, err := readUint8Safe(streamReader, "improvement exists flag")
+	if err != nil {
+		return TileData{}, err
+	}
 	improvementType := -1
 	if improvementExistsFlag == 1 {
-		improvementType = int(unsafeReadUint16(streamReader))
+		improvementTypeValue, err := readUint16Safe(streamReader, "improvement type")
+		if err != nil {
+			return TileData{}, err
+		}
+		improvementType = int(improvementTypeValue)
 	}
 
 	var improvementDataPtr *ImprovementData
 	if improvementType != -1 {
-		improvementData := DeserializeImprovementDataFromBytes(streamReader)
+		improvementData, err := DeserializeImprovementDataFromBytes(streamReader)
+		if err != nil {
+			return TileData{}, err
+		}
 		improvementDataPtr = &improvementData
 	}
 
 	// Read unit data
-	hasUnitFlag := unsafeReadUint8(streamReader)
+	hasUnitFlag, err := readUint8Safe(streamReader, "has unit flag")
+	if err != nil {
+		return TileData{}, err
+	}
 	var unitDataPtr *UnitData
 	var passengerUnitDataPtr *UnitData
 
@@ -103,62 +135,85 @@ func DeserializeTileDataFromBytes(streamReader *io.SectionReader, expectedRow in
 	passengerUnitDirectionData := make([]byte, 0)
 	if hasUnitFlag == 1 {
 		unitData := UnitData{}
-		if err := binary.Read(streamReader, binary.LittleEndian, &unitData); err != nil {
-			log.Fatal("Failed to load buffer: ", err)
+		if err := readStructSafe(streamReader, "unit", &unitData); err != nil {
+			return TileData{}, err
 		}
 		unitDataPtr = &unitData
 
-		hasOtherUnitFlag := unsafeReadUint8(streamReader)
+		hasOtherUnitFlag, err := readUint8Safe(streamReader, "has other unit flag")
+		if err != nil {
+			return TileData{}, err
+		}
 		if hasOtherUnitFlag == 1 {
 			// If unit embarks or disembarks, a new unit is created in the backend, but it's still the same unit in the game
 			passengerUnitData := UnitData{}
-			if err := binary.Read(streamReader, binary.LittleEndian, &passengerUnitData); err != nil {
-				log.Fatal("Failed to load buffer: ", err)
+			if err := readStructSafe(streamReader, "passenger unit", &passengerUnitData); err != nil {
+				return TileData{}, err
 			}
 			passengerUnitDataPtr = &passengerUnitData
 
 			// might be other unit flag for passenger unit
 			// should always be zero because passenger unit can't carry another unit
-			unknownFlag := int(unsafeReadUint8(streamReader))
+			flagOffset := currentOffset(streamReader)
+			unknownFlag, err := readUint8Safe(streamReader, "passenger unit other unit flag")
+			if err != nil {
+				return TileData{}, err
+			}
 			if unknownFlag != 0 {
-				log.Fatal("Passenger unit's other unit flag isn't zero")
+				return TileData{}, newParseError(flagOffset, "passenger unit other unit flag",
+					fmt.Errorf("passenger unit's other unit flag isn't zero, value is %v", unknownFlag))
 			}
 
-			passengerUnitEffectCount := int(unsafeReadUint16(streamReader))
-			passengerUnitEffectData = make([]int, 0)
-			for statusIndex := 0; statusIndex < passengerUnitEffectCount; statusIndex++ {
-				passengerUnitEffectData = append(passengerUnitEffectData, int(unsafeReadUint16(streamReader)))
+			passengerUnitEffectData, passengerUnitDirectionData, err = readUnitEffectData(streamReader, "passenger unit")
+			if err != nil {
+				return TileData{}, err
 			}
-			passengerUnitDirectionData = readFixedList(streamReader, 5)
+		}
 
-			unitEffectCount := int(unsafeReadUint16(streamReader))
-			unitEffectData = make([]int, 0)
-			for statusIndex := 0; statusIndex < unitEffectCount; statusIndex++ {
-				unitEffectData = append(unitEffectData, int(unsafeReadUint16(streamReader)))
-			}
-			unitDirectionData = readFixedList(streamReader, 5)
-		} else {
-			unitEffectCount := int(unsafeReadUint16(streamReader))
-			unitEffectData = make([]int, 0)
-			for statusIndex := 0; statusIndex < unitEffectCount; statusIndex++ {
-				unitEffectData = append(unitEffectData, int(unsafeReadUint16(streamReader)))
-			}
-			unitDirectionData = readFixedList(streamReader, 5)
+		unitEffectData, unitDirectionData, err = readUnitEffectData(streamReader, "unit")
+		if err != nil {
+			return TileData{}, err
 		}
 	}
 
-	playerVisibilityListSize := unsafeReadUint8(streamReader)
-	playerVisibilityList := convertByteListToInt(readFixedList(streamReader, int(playerVisibilityListSize)))
-	hasRoad := unsafeReadUint8(streamReader)
-	hasWaterRoute := unsafeReadUint8(streamReader)
-	tileSkin := unsafeReadUint16(streamReader)
-	unknown := convertByteListToInt(readFixedList(streamReader, 2))
+	playerVisibilityListSize, err := readUint8Safe(streamReader, "player visibility list size")
+	if err != nil {
+		return TileData{}, err
+	}
+	playerVisibilityBuffer, err := readFixedList(streamReader, int(playerVisibilityListSize), "player visibility")
+	if err != nil {
+		return TileData{}, err
+	}
+	hasRoad, err := readUint8Safe(streamReader, "has road")
+	if err != nil {
+		return TileData{}, err
+	}
+	hasWaterRoute, err := readUint8Safe(streamReader, "has water route")
+	if err != nil {
+		return TileData{}, err
+	}
+	tileSkin, err := readUint16Safe(streamReader, "tile skin")
+	if err != nil {
+		return TileData{}, err
+	}
+	unknownBuffer, err := readFixedList(streamReader, 2, "unknown")
+	if err != nil {
+		return TileData{}, err
+	}
 	var floodedFlag int
 	var floodedValue int
-	if gameVersion >= 105 {
-		floodedFlag = int(unsafeReadUint8(streamReader))
+	if nearestVersionLayout(gameVersion).HasTileFlooding {
+		floodedFlagValue, err := readUint8Safe(streamReader, "flooded flag")
+		if err != nil {
+			return TileData{}, err
+		}
+		floodedFlag = int(floodedFlagValue)
 		if floodedFlag == 1 {
-			floodedValue = int(unsafeReadUint32(streamReader))
+			floodedValueValue, err := readUint32Safe(streamReader, "flooded value")
+			if err != nil {
+				return TileData{}, err
+			}
+			floodedValue = int(floodedValueValue)
 		}
 	}
 
@@ -181,18 +236,66 @@ func DeserializeTileDataFromBytes(streamReader *io.SectionReader, expectedRow in
 		UnitDirectionData:          convertByteListToInt(unitDirectionData),
 		PassengerUnitEffectData:    passengerUnitEffectData,
 		PassengerUnitDirectionData: convertByteListToInt(passengerUnitDirectionData),
-		PlayerVisibility:           playerVisibilityList,
+		PlayerVisibility:           convertByteListToInt(playerVisibilityBuffer),
 		HasRoad:                    hasRoad != 0,
 		HasWaterRoute:              hasWaterRoute != 0,
 		TileSkin:                   int(tileSkin),
-		Unknown:                    unknown,
+		Unknown:                    convertByteListToInt(unknownBuffer),
 		FloodedFlag:                floodedFlag,
 		FloodedValue:               floodedValue,
+	}, nil
+}
+
+// readUnitEffectData reads the effect list and direction data that follow a unit
+func readUnitEffectData(streamReader *io.SectionReader, unitName string) ([]int, []byte, error) {
+	unitEffectCount, err := readUint16Safe(streamReader, unitName+" effect count")
+	if err != nil {
+		return nil, nil, err
+	}
+	unitEffectData := make([]int, 0)
+	for statusIndex := 0; statusIndex < int(unitEffectCount); statusIndex++ {
+		unitEffect, err := readUint16Safe(streamReader, fmt.Sprintf("%s effect %d", unitName, statusIndex))
+		if err != nil {
+			return nil, nil, err
+		}
+		unitEffectData = append(unitEffectData, int(unitEffect))
 	}
+	unitDirectionData, err := readFixedList(streamReader, 5, unitName+" direction data")
+	if err != nil {
+		return nil, nil, err
+	}
+	return unitEffectData, unitDirectionData, nil
 }
 
-func SerializeTileToBytes(tileData TileData, gameVersion int) []byte {
+// checkTileFields returns an error for the first value that doesn't fit in its field when the tile is serialized
+func checkTileFields(tileData TileData) error {
+	errs := []error{
+		checkUint16Field("Terrain", tileData.Terrain),
+		checkUint16Field("Climate", tileData.Climate),
+		checkInt16Field("Altitude", tileData.Altitude),
+		checkUint8Field("Owner", tileData.Owner),
+		checkUint8Field("Capital", tileData.Capital),
+		checkUint16Field("TileSkin", tileData.TileSkin),
+		checkUint8Field("FloodedFlag", tileData.FloodedFlag),
+		checkListFields("UnitEffectData", tileData.UnitEffectData, math.MaxUint16, checkUint16Field),
+		checkListFields("PassengerUnitEffectData", tileData.PassengerUnitEffectData, math.MaxUint16, checkUint16Field),
+		checkListFields("PlayerVisibility", tileData.PlayerVisibility, math.MaxUint8, checkUint8Field),
+	}
+	if tileData.ResourceExists {
+		errs = append(errs, checkUint16Field("ResourceType", tileData.ResourceType))
+	}
+	if tileData.ImprovementExists {
+		errs = append(errs, checkUint16Field("ImprovementType", tileData.ImprovementType))
+	}
+	return firstFieldError(errs...)
+}
+
+func SerializeTileToBytes(tileData TileData, gameVersion int) ([]byte, error) {
+	if err := checkTileFields(tileData); err != nil {
+		return nil, err
+	}
 	tileBytes := make([]byte, 0)
+	var err error
 
 	headerBytes := make([]byte, 0)
 	headerBytes = append(headerBytes, ConvertUint32Bytes(int(tileData.WorldCoordinates[0]))...)
@@ -221,7 +324,11 @@ func SerializeTileToBytes(tileData TileData, gameVersion int) []byte {
 	}
 
 	if tileData.ImprovementData != nil {
-		tileBytes = append(tileBytes, SerializeImprovementDataToBytes(*tileData.ImprovementData)...)
+		improvementBytes, err := SerializeImprovementDataToBytes(*tileData.ImprovementData)
+		if err != nil {
+			return nil, fmt.Errorf("improvement data: %w", err)
+		}
+		tileBytes = append(tileBytes, improvementBytes...)
 	}
 
 	// no unit
@@ -241,13 +348,17 @@ func SerializeTileToBytes(tileData TileData, gameVersion int) []byte {
 			for i := 0; i < len(tileData.PassengerUnitEffectData); i++ {
 				tileBytes = append(tileBytes, ConvertUint16Bytes(tileData.PassengerUnitEffectData[i])...)
 			}
-			tileBytes = append(tileBytes, ConvertByteList(tileData.PassengerUnitDirectionData)...)
+			if tileBytes, err = appendByteList(tileBytes, "PassengerUnitDirectionData", tileData.PassengerUnitDirectionData); err != nil {
+				return nil, err
+			}
 
 			tileBytes = append(tileBytes, ConvertUint16Bytes(len(tileData.UnitEffectData))...)
 			for i := 0; i < len(tileData.UnitEffectData); i++ {
 				tileBytes = append(tileBytes, ConvertUint16Bytes(tileData.UnitEffectData[i])...)
 			}
-			tileBytes = append(tileBytes, ConvertByteList(tileData.UnitDirectionData)...)
+			if tileBytes, err = appendByteList(tileBytes, "UnitDirectionData", tileData.UnitDirectionData); err != nil {
+				return nil, err
+			}
 		} else {
 			tileBytes = append(tileBytes, 0) // has other unit flag is 0
 
@@ -255,25 +366,31 @@ func SerializeTileToBytes(tileData TileData, gameVersion int) []byte {
 			for i := 0; i < len(tileData.UnitEffectData); i++ {
 				tileBytes = append(tileBytes, ConvertUint16Bytes(tileData.UnitEffectData[i])...)
 			}
-			tileBytes = append(tileBytes, ConvertByteList(tileData.UnitDirectionData)...)
+			if tileBytes, err = appendByteList(tileBytes, "UnitDirectionData", tileData.UnitDirectionData); err != nil {
+				return nil, err
+			}
 		}
 	} else {
 		tileBytes = append(tileBytes, 0)
 	}
 
 	tileBytes = append(tileBytes, byte(len(tileData.PlayerVisibility)))
-	tileBytes = append(tileBytes, ConvertByteList(tileData.PlayerVisibility)...)
+	if tileBytes, err = appendByteList(tileBytes, "PlayerVisibility", tileData.PlayerVisibility); err != nil {
+		return nil, err
+	}
 	tileBytes = append(tileBytes, ConvertBoolToByte(tileData.HasRoad))
 	tileBytes = append(tileBytes, ConvertBoolToByte(tileData.HasWaterRoute))
 	tileBytes = append(tileBytes, ConvertUint16Bytes(tileData.TileSkin)...)
-	tileBytes = append(tileBytes, ConvertByteList(tileData.Unknown)...)
-	if gameVersion >= 105 {
+	if tileBytes, err = appendByteList(tileBytes, "Unknown", tileData.Unknown); err != nil {
+		return nil, err
+	}
+	if nearestVersionLayout(gameVersion).HasTileFlooding {
 		tileBytes = append(tileBytes, byte(tileData.FloodedFlag))
 		if tileData.FloodedFlag == 1 {
 			tileBytes = append(tileBytes, ConvertUint32Bytes(tileData.FloodedValue)...)
 		}
 	}
-	return tileBytes
+	return tileBytes, nil
 }
 
 func SerializeUnitDataToBytes(unitData UnitData) []byte {
@@ -297,7 +414,7 @@ func SerializeUnitDataToBytes(unitData UnitData) []byte {
 	return data
 }
 
-func readTileData(streamReader *io.SectionReader, tileData [][]TileData, mapWidth int, mapHeight int, gameVersion int) {
+func readTileData(streamReader *io.SectionReader, fileOffsetMap map[string]int, tileData [][]TileData, mapWidth int, mapHeight int, gameVersion int) error {
 	updateFileOffsetMap(fileOffsetMap, streamReader, buildMapStartKey())
 
 	for i := 0; i < int(mapHeight); i++ {
@@ -305,7 +422,11 @@ func readTileData(streamReader *io.SectionReader, tileData [][]TileData, mapWidt
 			tileStartKey := buildTileStartKey(j, i)
 			updateFileOffsetMap(fileOffsetMap, streamReader, tileStartKey)
 
-			tileData[i][j] = DeserializeTileDataFromBytes(streamReader, i, j, gameVersion)
+			tile, err := DeserializeTileDataFromBytes(streamReader, i, j, gameVersion)
+			if err != nil {
+				return err
+			}
+			tileData[i][j] = tile
 
 			tileEndKey := buildTileEndKey(j, i)
 			updateFileOffsetMap(fileOffsetMap, streamReader, tileEndKey)
@@ -313,5 +434,5 @@ func readTileData(streamReader *io.SectionReader, tileData [][]TileData, mapWidt
 	}
 
 	updateFileOffsetMap(fileOffsetMap, streamReader, buildMapEndKey())
+	return nil
 }
-
@@ -19,7 +19,10 @@ func TestDeserializeEmptyTileDataFromBytes(t *testing.T) {
 		0, 0, 0, 0, 0, 0, 0, 0,
 	}
 	streamReader := io.NewSectionReader(bytes.NewReader(inputByteData), 0, int64(len(inputByteData)))
-	result := DeserializeTileDataFromBytes(streamReader, 1, 3, 104)
+	result, err := DeserializeTileDataFromBytes(streamReader, 1, 3, 104)
+	if err != nil {
+		t.Fatalf(`Failed to deserialize: %v`, err)
+	}
 	expected := TileData{
 		WorldCoordinates:           [2]int{3, 1},
 		Terrain:                    3,
@@ -78,7 +81,10 @@ func TestSerializeEmptyTileDataToBytes(t *testing.T) {
 		Unknown:                    []int{0, 0},
 	}
 	versionNumber := 104
-	resultBytes := SerializeTileToBytes(tileData, versionNumber)
+	resultBytes, err := SerializeTileToBytes(tileData, versionNumber)
+	if err != nil {
+		t.Fatalf(`Failed to serialize: %v`, err)
+	}
 	expectedBytes := []byte{3, 0, 0, 0, 1, 0, 0, 0, 3, 0, 8, 0, 1, 0, 0, 0,
 		// coordinates
 		255, 255, 255, 255, 255, 255, 255, 255,
@@ -135,7 +141,10 @@ func TestSerializeTileDataWithImprovementToBytes(t *testing.T) {
 	}
 
 	versionNumber := 104
-	resultBytes := SerializeTileToBytes(tileData, versionNumber)
+	resultBytes, err := SerializeTileToBytes(tileData, versionNumber)
+	if err != nil {
+		t.Fatalf(`Failed to serialize: %v`, err)
+	}
 	expectedBytes := []byte{3, 0, 0, 0, 1, 0, 0, 0, 3, 0, 8, 0, 1, 0, 0, 0,
 		// coordinates
 		255, 255, 255, 255, 255, 255, 255, 255,
@@ -192,7 +201,10 @@ func TestSerializeTileDataWithUnitToBytes(t *testing.T) {
 	}
 
 	versionNumber := 104
-	resultBytes := SerializeTileToBytes(tileData, versionNumber)
+	resultBytes, err := SerializeTileToBytes(tileData, versionNumber)
+	if err != nil {
+		t.Fatalf(`Failed to serialize: %v`, err)
+	}
 	expectedBytes := []byte{3, 0, 0, 0, 1, 0, 0, 0, 3, 0, 8, 0, 1, 0, 0, 0,
 		// coordinates
 		255, 255, 255, 255, 255, 255, 255, 255,
@@ -0,0 +1,117 @@
+package polytopiamapmodel
+
+import (
+	"fmt"
+)
+
+// VersionLayout describes the fields used by a range of game versions (Version1 in the map header)
+type VersionLayout struct {
+	Name       string
+	MinVersion int
+	MaxVersion int
+	// Tiles end with a flooded flag and value, added in the aquarion update
+	HasTileFlooding bool
+	// Players have an aggressions array after the difficulty handicap
+	HasPlayerAggressions bool
+	// Action types that can be decoded, with the struct of their fixed size payload.
+	// An action of any other type and every action after it are kept as a RawAction.
+	Actions map[ActionType]Action
+}
+
+// Layouts are sorted by version. Newer versions should be added here once their layout is checked.
+//
+// Every bound comes from a version the code or tests used before this table, no save from the game is checked in yet:
+//   - 100 is the version the original player serializer tests were written for (TestSerializePlayerDataToBytes).
+//   - 104 is the version of the original tile serializer tests (TestSerializeEmptyTileDataToBytes), the last one before the flooded data.
+//   - 105 and 114 are the version checks the parser used: tiledata.go read the flooded flag when gameVersion >= 105
+//     and playerdata.go read the aggressions when gameVersion < 114.
+//   - 114 is also the newest version the parser had a check for, so nothing newer is known to have the same layout.
+//
+// Raise MaxVersion of the newest layout once a save from a newer version round trips (see testdata/README.md).
+// Versions below 100 are still read with the original layout, as the parser did before this table, see readableVersionLayout.
+// No version is known to change an action payload, so every layout decodes the same action types.
+var versionLayouts = []VersionLayout{
+	{Name: "original", MinVersion: 100, MaxVersion: 104, HasTileFlooding: false, HasPlayerAggressions: true, Actions: emptyActions},
+	{Name: "aquarion flooding", MinVersion: 105, MaxVersion: 113, HasTileFlooding: true, HasPlayerAggressions: true, Actions: emptyActions},
+	{Name: "no player aggressions", MinVersion: 114, MaxVersion: 114, HasTileFlooding: true, HasPlayerAggressions: false, Actions: emptyActions},
+}
+
+type UnsupportedVersionError struct {
+	Version       int
+	NearestLayout VersionLayout
+}
+
+func (e *UnsupportedVersionError) Error() string {
+	message := fmt.Sprintf("unsupported version %v, nearest known layout is %q for versions %v to %v",
+		e.Version, e.NearestLayout.Name, e.NearestLayout.MinVersion, e.NearestLayout.MaxVersion)
+	if e.Version > e.NearestLayout.MaxVersion {
+		message += ", set ParseOptions.ReadNewerVersions to read it with that layout"
+	}
+	return message
+}
+
+// SupportedVersions returns every Version1 value that has a known layout
+func SupportedVersions() []int {
+	versions := make([]int, 0)
+	for _, layout := range versionLayouts {
+		for version := layout.MinVersion; version <= layout.MaxVersion; version++ {
+			versions = append(versions, version)
+		}
+	}
+	return versions
+}
+
+// LookupVersionLayout returns the layout for a game version or an UnsupportedVersionError if the version isn't known
+func LookupVersionLayout(gameVersion int) (VersionLayout, error) {
+	layout := nearestVersionLayout(gameVersion)
+	if gameVersion < layout.MinVersion || gameVersion > layout.MaxVersion {
+		return VersionLayout{}, &UnsupportedVersionError{Version: gameVersion, NearestLayout: layout}
+	}
+	return layout, nil
+}
+
+// readableVersionLayout returns the layout used to read a game version.
+//
+// A version older than every layout is read with the original layout and a warning, because the parser read every
+// version before 105 that way before the layout table was added and no older layout is known.
+// A version newer than every layout returns an UnsupportedVersionError, since the updates so far have added fields.
+// With readNewerVersions it is read with the newest layout instead, which is the best guess until the update is checked,
+// and the returned warning says so. The parse still fails if the sections don't line up, such as a tile at the wrong position.
+func readableVersionLayout(gameVersion int, readNewerVersions bool) (VersionLayout, string, error) {
+	layout, err := LookupVersionLayout(gameVersion)
+	if err == nil {
+		return layout, "", nil
+	}
+	oldestLayout := versionLayouts[0]
+	if gameVersion < oldestLayout.MinVersion {
+		return oldestLayout, fmt.Sprintf("version %v is older than every known layout, read with the %q layout for versions %v to %v",
+			gameVersion, oldestLayout.Name, oldestLayout.MinVersion, oldestLayout.MaxVersion), nil
+	}
+	newestLayout := versionLayouts[len(versionLayouts)-1]
+	if readNewerVersions {
+		return newestLayout, fmt.Sprintf("version %v is newer than every known layout, read with the %q layout for versions %v to %v",
+			gameVersion, newestLayout.Name, newestLayout.MinVersion, newestLayout.MaxVersion), nil
+	}
+	return VersionLayout{}, "", err
+}
+
+// withVersionWarning adds the version warning to a parse error, since a layout that doesn't match the version is the likely cause
+func withVersionWarning(versionWarning string, err error) error {
+	if versionWarning == "" {
+		return err
+	}
+	return fmt.Errorf("%w (%v)", err, versionWarning)
+}
+
+// nearestVersionLayout returns the layout containing the version, or the closest one if no layout contains it
+func nearestVersionLayout(gameVersion int) VersionLayout {
+	if gameVersion < versionLayouts[0].MinVersion {
+		return versionLayouts[0]
+	}
+	for _, layout := range versionLayouts {
+		if gameVersion <= layout.MaxVersion {
+			return layout
+		}
+	}
+	return versionLayouts[len(versionLayouts)-1]
+}
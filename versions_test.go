@@ -0,0 +1,135 @@
+package polytopiamapmodel
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestSupportedVersions(t *testing.T) {
+	versions := SupportedVersions()
+	for i := 1; i < len(versions); i++ {
+		if versions[i] <= versions[i-1] {
+			t.Fatalf(`Versions aren't sorted: %v`, versions)
+		}
+	}
+	for _, version := range []int{104, 105, 114} {
+		if _, err := LookupVersionLayout(version); err != nil {
+			t.Fatalf(`Version %v should be supported: %v`, version, err)
+		}
+	}
+}
+
+func TestLookupVersionLayout(t *testing.T) {
+	layout, err := LookupVersionLayout(110)
+	if err != nil {
+		t.Fatalf(`Failed to find layout: %v`, err)
+	}
+	if !layout.HasTileFlooding || !layout.HasPlayerAggressions {
+		t.Fatalf(`Unexpected layout for version 110: %+v`, layout)
+	}
+
+	layout, err = LookupVersionLayout(114)
+	if err != nil {
+		t.Fatalf(`Failed to find layout: %v`, err)
+	}
+	if !layout.HasTileFlooding || layout.HasPlayerAggressions {
+		t.Fatalf(`Unexpected layout for version 114: %+v`, layout)
+	}
+}
+
+func TestParseOlderVersionWithOriginalLayout(t *testing.T) {
+	inputByteData := buildTestSaveBytes()
+	// Version1 is the first field of the map header
+	inputByteData[0] = 99
+
+	result, err := ParsePolytopiaFile(io.NewSectionReader(bytes.NewReader(inputByteData), 0, int64(len(inputByteData))))
+	if err != nil {
+		t.Fatalf(`Failed to parse older version: %v`, err)
+	}
+	if result.GameVersion != 99 || len(result.Warnings) != 1 || !strings.Contains(result.Warnings[0], "older than every known layout") {
+		t.Fatalf(`Game version = %v, warnings = %v, expected a warning for version 99`, result.GameVersion, result.Warnings)
+	}
+
+	if _, err := LookupVersionLayout(99); err == nil {
+		t.Fatalf(`Expected LookupVersionLayout to reject version 99`)
+	}
+}
+
+func TestParseNewerVersionIsUnsupported(t *testing.T) {
+	newestVersion := versionLayouts[len(versionLayouts)-1].MaxVersion
+	inputByteData := buildDetailedTestSaveBytes(newestVersion)
+	inputByteData[0] = byte(newestVersion + 1)
+
+	_, err := ParsePolytopiaFile(io.NewSectionReader(bytes.NewReader(inputByteData), 0, int64(len(inputByteData))))
+	var versionErr *UnsupportedVersionError
+	if !errors.As(err, &versionErr) {
+		t.Fatalf(`Expected UnsupportedVersionError, got %v`, err)
+	}
+	if versionErr.Version != newestVersion+1 || versionErr.NearestLayout.MaxVersion != newestVersion {
+		t.Fatalf(`Unexpected error contents: %+v`, versionErr)
+	}
+	if !strings.Contains(err.Error(), fmt.Sprintf("unsupported version %v", newestVersion+1)) {
+		t.Fatalf(`Unexpected error message: %v`, err)
+	}
+}
+
+func TestParseNewerVersionWithNewestLayout(t *testing.T) {
+	newestVersion := versionLayouts[len(versionLayouts)-1].MaxVersion
+	inputByteData := buildDetailedTestSaveBytes(newestVersion)
+	inputByteData[0] = byte(newestVersion + 1)
+	options := ParseOptions{ReadNewerVersions: true}
+
+	result, err := ParsePolytopiaFileWithOptions(io.NewSectionReader(bytes.NewReader(inputByteData), 0, int64(len(inputByteData))), options)
+	if err != nil {
+		t.Fatalf(`Failed to parse newer version: %v`, err)
+	}
+	if result.GameVersion != newestVersion+1 || len(result.Warnings) != 1 || !strings.Contains(result.Warnings[0], "newer than every known layout") {
+		t.Fatalf(`Game version = %v, warnings = %v, expected a warning for version %v`, result.GameVersion, result.Warnings, newestVersion+1)
+	}
+
+	// a newer version whose layout changed still fails, with the warning in the error
+	inputByteData = buildTestSaveBytes()
+	inputByteData[0] = byte(newestVersion + 1)
+	_, err = ParsePolytopiaFileWithOptions(io.NewSectionReader(bytes.NewReader(inputByteData), 0, int64(len(inputByteData))), options)
+	if err == nil || !strings.Contains(err.Error(), "newer than every known layout") {
+		t.Fatalf(`Expected parse error with the version warning, got %v`, err)
+	}
+}
+
+func TestVersionLayoutBoundaries(t *testing.T) {
+	testCases := []struct {
+		version        int
+		supported      bool
+		hasFlooding    bool
+		hasAggressions bool
+	}{
+		{99, false, false, true},
+		{100, true, false, true},
+		{104, true, false, true},
+		{105, true, true, true},
+		{113, true, true, true},
+		{114, true, true, false},
+		{115, false, true, false},
+	}
+	for _, testCase := range testCases {
+		_, err := LookupVersionLayout(testCase.version)
+		if (err == nil) != testCase.supported {
+			t.Fatalf(`Version %v supported = %v, expected = %v`, testCase.version, err == nil, testCase.supported)
+		}
+		layout := nearestVersionLayout(testCase.version)
+		if layout.HasTileFlooding != testCase.hasFlooding || layout.HasPlayerAggressions != testCase.hasAggressions {
+			t.Fatalf(`Version %v layout = %+v, expected flooding = %v, aggressions = %v`,
+				testCase.version, layout, testCase.hasFlooding, testCase.hasAggressions)
+		}
+	}
+
+	// the first unknown version names the option that reads it
+	err := (&UnsupportedVersionError{Version: 115, NearestLayout: nearestVersionLayout(115)}).Error()
+	if !strings.Contains(err, "ReadNewerVersions") {
+		t.Fatalf(`Error %q doesn't mention ParseOptions.ReadNewerVersions`, err)
+	}
+}
@@ -4,119 +4,168 @@ import (
 	"encoding/binary"
 	"fmt"
 	"image/color"
-	"io/ioutil"
-	"log"
+	"io"
 	"math"
 	"math/rand"
 	"os"
+	"sort"
 	"time"
 )
 
 type FileInfo struct {
 	InputFilename string
-	GameVersion   int
+	GameVersion   int        // 0 to use the version read from the file, otherwise the file must have this version
+	Target        EditTarget // copy of the map to edit, defaults to the current state
+	Backup        bool       // copy the file to a timestamped .bak file before it is modified
 }
 
-type UnitLocationData struct {
-	X        int
-	Y        int
-	UnitType int
-}
-
-func WriteUint8AtFileOffset(inputFilename string, offset int, value int) {
-	inputFile, err := os.OpenFile(inputFilename, os.O_RDWR, 0644)
-	defer inputFile.Close()
+// backupBeforeWrite makes the backup requested by fileInfo.Backup.
+// It returns fileInfo without Backup set, so helpers called by the same edit don't make another backup.
+func backupBeforeWrite(fileInfo FileInfo) (FileInfo, error) {
+	if !fileInfo.Backup {
+		return fileInfo, nil
+	}
+	backupFilename, err := BackupFile(fileInfo.InputFilename)
 	if err != nil {
-		log.Fatal("Failed to load save state: ", err)
+		return fileInfo, err
 	}
+	debugPrint("Backed up %v to %v\n", fileInfo.InputFilename, backupFilename)
+	fileInfo.Backup = false
+	return fileInfo, nil
+}
 
-	if value >= 256 {
-		log.Fatal("Value is too large for uint8")
+// readFileInfoSave parses fileInfo.InputFilename and checks it has the version in fileInfo.GameVersion, if one is set
+func readFileInfoSave(fileInfo FileInfo) (*PolytopiaSaveOutput, error) {
+	saveOutput, err := ReadPolytopiaDecompressedFile(fileInfo.InputFilename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read save file: %w", err)
 	}
-	if _, err := inputFile.WriteAt([]byte{uint8(value)}, int64(offset)); err != nil {
-		log.Fatal("Failed to write uint8 to file:", err)
+	if fileInfo.GameVersion != 0 && fileInfo.GameVersion != saveOutput.GameVersion {
+		return nil, fmt.Errorf("save file has game version %v, expected %v", saveOutput.GameVersion, fileInfo.GameVersion)
 	}
+	return saveOutput, nil
 }
 
-func WriteUint16AtFileOffset(inputFilename string, offset int, updatedValue int) {
-	inputFile, err := os.OpenFile(inputFilename, os.O_RDWR, 0644)
-	defer inputFile.Close()
-	if err != nil {
-		log.Fatal("Failed to load save state: ", err)
+type UnitLocationData struct {
+	X        int
+	Y        int
+	UnitType int
+}
+
+func WriteUint8AtFileOffset(inputFilename string, offset int, value int) error {
+	if value < 0 || value >= 256 {
+		return fmt.Errorf("value %v is out of range for uint8", value)
 	}
+	return writeBytesAtFileOffset(inputFilename, offset, []byte{uint8(value)})
+}
 
-	if updatedValue >= 65536 {
-		log.Fatal("Value is too large for uint16")
+func WriteUint16AtFileOffset(inputFilename string, offset int, updatedValue int) error {
+	if updatedValue < 0 || updatedValue >= 65536 {
+		return fmt.Errorf("value %v is out of range for uint16", updatedValue)
 	}
 	byteArrUnitType := make([]byte, 2)
 	binary.LittleEndian.PutUint16(byteArrUnitType, uint16(updatedValue))
-	if _, err := inputFile.WriteAt(byteArrUnitType, int64(offset)); err != nil {
-		log.Fatal(err)
-	}
+	return writeBytesAtFileOffset(inputFilename, offset, byteArrUnitType)
 }
 
-func WriteUint32AtFileOffset(inputFilename string, offset int, updatedValue int) {
-	inputFile, err := os.OpenFile(inputFilename, os.O_RDWR, 0644)
-	defer inputFile.Close()
-	if err != nil {
-		log.Fatal("Failed to load save state: ", err)
-	}
-
-	if updatedValue >= 4294967295 {
-		log.Fatal("Value is too large for uint32")
+func WriteUint32AtFileOffset(inputFilename string, offset int, updatedValue int) error {
+	if updatedValue < 0 || updatedValue > math.MaxUint32 {
+		return fmt.Errorf("value %v is out of range for uint32", updatedValue)
 	}
 	byteArrUnitType := make([]byte, 4)
 	binary.LittleEndian.PutUint32(byteArrUnitType, uint32(updatedValue))
-	if _, err := inputFile.WriteAt(byteArrUnitType, int64(offset)); err != nil {
-		log.Fatal(err)
-	}
+	return writeBytesAtFileOffset(inputFilename, offset, byteArrUnitType)
 }
 
-func GetFileRemainingData(inputFile *os.File, offset int) []byte {
+// writeBytesAtFileOffset overwrites bytes without changing the file size
+func writeBytesAtFileOffset(inputFilename string, offset int, newData []byte) error {
+	return writeAndShiftRange(inputFilename, offset, offset+len(newData), newData)
+}
+
+func GetFileRemainingData(inputFile *os.File, offset int) ([]byte, error) {
 	if _, err := inputFile.Seek(int64(offset), 0); err != nil {
-		log.Fatal(err)
-	}
-	remainder, err := ioutil.ReadAll(inputFile)
-	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
-	return remainder
+	return io.ReadAll(inputFile)
 }
 
-func WriteAndShiftData(inputFilename string, offsetStartOriginalBlockKey string, offsetEndOriginalBlockKey string, newData []byte) {
+func WriteAndShiftData(inputFilename string, offsetStartOriginalBlockKey string, offsetEndOriginalBlockKey string, newData []byte) error {
 	// Update file offsets to make sure they are up to date
-	_, err := ReadPolytopiaDecompressedFile(inputFilename)
-	if err != nil {
-		log.Fatal("Failed to read save file")
-	}
-
-	// Open file to modify
-	inputFile, err := os.OpenFile(inputFilename, os.O_RDWR, 0644)
-	defer inputFile.Close()
+	saveOutput, err := ReadPolytopiaDecompressedFile(inputFilename)
 	if err != nil {
-		log.Fatal("Failed to load save state:", err)
+		return fmt.Errorf("failed to read save file: %w", err)
 	}
+	fileOffsetMap := saveOutput.FileOffsetMap
 
 	offsetOriginalBlockStart, ok := fileOffsetMap[offsetStartOriginalBlockKey]
 	if !ok {
-		log.Fatal(fmt.Sprintf("Error: Unable to find start of data block with key %v. Command not run.", offsetStartOriginalBlockKey))
+		return fmt.Errorf("unable to find start of data block with key %v", offsetStartOriginalBlockKey)
 	}
 	offsetOriginalBlockEnd, ok := fileOffsetMap[offsetEndOriginalBlockKey]
 	if !ok {
-		log.Fatal(fmt.Sprintf("Error: Unable to find end of data block with key %v. Command not run.", offsetStartOriginalBlockKey))
+		return fmt.Errorf("unable to find end of data block with key %v", offsetEndOriginalBlockKey)
 	}
-	// Get all data after end of block
-	remainder := GetFileRemainingData(inputFile, offsetOriginalBlockEnd)
+	return writeAndShiftRange(inputFilename, offsetOriginalBlockStart, offsetOriginalBlockEnd, newData)
+}
+
+// writeAndShiftRange replaces the bytes from offsetOriginalBlockStart to offsetOriginalBlockEnd with newData.
+// The file is replaced with a renamed temporary file, so it is never left partially written.
+func writeAndShiftRange(inputFilename string, offsetOriginalBlockStart int, offsetOriginalBlockEnd int, newData []byte) error {
+	return writeSections(inputFilename, []fileSection{
+		{OffsetRange{Start: offsetOriginalBlockStart, End: offsetOriginalBlockEnd}, newData},
+	})
+}
 
-	// overwrite block with new data at original block start
-	if _, err := inputFile.WriteAt(newData, int64(offsetOriginalBlockStart)); err != nil {
-		log.Fatal(err)
+// fileSection is a range of the file and the data that replaces it
+type fileSection struct {
+	offsetRange OffsetRange
+	data        []byte
+}
+
+// writeSections replaces several ranges of the file with a single atomic write.
+// The ranges are offsets in the file before the write and must not overlap.
+func writeSections(inputFilename string, sections []fileSection) error {
+	fileData, err := os.ReadFile(inputFilename)
+	if err != nil {
+		return fmt.Errorf("failed to load save state: %w", err)
+	}
+	updatedData, err := spliceSections(fileData, sections)
+	if err != nil {
+		return err
+	}
+	if err := WriteFileAtomic(inputFilename, updatedData); err != nil {
+		return fmt.Errorf("failed to write save state: %w", err)
 	}
+	return nil
+}
 
-	// shift remaining data and write after new data instead of original end start
-	if _, err := inputFile.WriteAt(remainder, int64(offsetOriginalBlockStart+len(newData))); err != nil {
-		log.Fatal(err)
+// spliceSections returns a copy of fileData with each range replaced by the data of its section
+func spliceSections(fileData []byte, sections []fileSection) ([]byte, error) {
+	sortedSections := make([]fileSection, len(sections))
+	copy(sortedSections, sections)
+	sort.Slice(sortedSections, func(i, j int) bool { return sortedSections[i].offsetRange.Start < sortedSections[j].offsetRange.Start })
+
+	updatedSize := len(fileData)
+	for _, section := range sortedSections {
+		updatedSize += len(section.data) - section.offsetRange.Length()
+	}
+	updatedData := make([]byte, 0, updatedSize)
+	previousEnd := 0
+	for _, section := range sortedSections {
+		offsetRange := section.offsetRange
+		if offsetRange.Start < 0 || offsetRange.Start > offsetRange.End || offsetRange.End > len(fileData) {
+			return nil, fmt.Errorf("data block from %v to %v is outside the file, size is %v",
+				offsetRange.Start, offsetRange.End, len(fileData))
+		}
+		if offsetRange.Start < previousEnd {
+			return nil, fmt.Errorf("data block from %v to %v overlaps the block ending at %v",
+				offsetRange.Start, offsetRange.End, previousEnd)
+		}
+		updatedData = append(updatedData, fileData[previousEnd:offsetRange.Start]...)
+		updatedData = append(updatedData, section.data...)
+		previousEnd = offsetRange.End
 	}
+	return append(updatedData, fileData[previousEnd:]...), nil
 }
 
 func ConvertUint32Bytes(value int) []byte {
@@ -144,16 +193,82 @@ func ConvertVarString(value string) []byte {
 	return byteArr
 }
 
-func ConvertByteList(oldArr []int) []byte {
+func ConvertByteList(oldArr []int) ([]byte, error) {
 	// the values are stored as ints but they were originally bytes
 	newArr := make([]byte, len(oldArr))
 	for i := 0; i < len(oldArr); i++ {
-		if oldArr[i] > 255 {
-			log.Fatal(fmt.Sprintf("Byte list has value over 255. Value is %v for index %v", oldArr[i], i))
+		if oldArr[i] < 0 || oldArr[i] > 255 {
+			return nil, fmt.Errorf("byte list has value outside 0 to 255, value is %v for index %v", oldArr[i], i)
 		}
 		newArr[i] = byte(oldArr[i])
 	}
-	return newArr
+	return newArr, nil
+}
+
+// appendByteList appends a list of byte values and names the field if a value doesn't fit in a byte
+func appendByteList(data []byte, field string, values []int) ([]byte, error) {
+	byteList, err := ConvertByteList(values)
+	if err != nil {
+		return nil, fmt.Errorf("%v: %w", field, err)
+	}
+	return append(data, byteList...), nil
+}
+
+// checkFieldRange returns an error if value is outside the range of the field it is written to
+func checkFieldRange(field string, value int, minValue int, maxValue int) error {
+	if value < minValue || value > maxValue {
+		return fmt.Errorf("%v must be between %v and %v, value is %v", field, minValue, maxValue, value)
+	}
+	return nil
+}
+
+func checkUint8Field(field string, value int) error {
+	return checkFieldRange(field, value, 0, math.MaxUint8)
+}
+
+func checkUint16Field(field string, value int) error {
+	return checkFieldRange(field, value, 0, math.MaxUint16)
+}
+
+func checkInt16Field(field string, value int) error {
+	return checkFieldRange(field, value, math.MinInt16, math.MaxInt16)
+}
+
+// checkTribeField checks a tribe id. Some tribe fields are two bytes, but OverrideTribe and FoundedTribe
+// store tribes in one byte, so an id over 255 can't be a real tribe.
+func checkTribeField(field string, value int) error {
+	return checkUint8Field(field, value)
+}
+
+// checkListFields checks every value of a list and the list length against the size of its length prefix
+func checkListFields(field string, values []int, maxLength int, checkValue func(string, int) error) error {
+	if len(values) > maxLength {
+		return fmt.Errorf("%v has %v values, the limit is %v", field, len(values), maxLength)
+	}
+	for i, value := range values {
+		if err := checkValue(fmt.Sprintf("%v[%v]", field, i), value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// firstFieldError returns the first error from a list of field checks
+func firstFieldError(errs ...error) error {
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkVarStringField returns an error if the string is too long for its length byte
+func checkVarStringField(field string, value string) error {
+	if len(value) > math.MaxUint8 {
+		return fmt.Errorf("%v is %v bytes long, the limit is %v", field, len(value), math.MaxUint8)
+	}
+	return nil
 }
 
 func ConvertBoolToByte(value bool) byte {
@@ -164,109 +279,310 @@ func ConvertBoolToByte(value bool) byte {
 	}
 }
 
-func ConvertMapDataToBytes(tileData [][]TileData, gameVersion int) []byte {
-	mapHeight := len(tileData)
-	mapWidth := len(tileData[0])
-
+func ConvertMapDataToBytes(tileData [][]TileData, gameVersion int) ([]byte, error) {
 	allMapBytes := make([]byte, 0)
-	for i := 0; i < mapHeight; i++ {
-		for j := 0; j < mapWidth; j++ {
-			tileBytes := SerializeTileToBytes(tileData[i][j], gameVersion)
+	for i := 0; i < len(tileData); i++ {
+		for j := 0; j < len(tileData[i]); j++ {
+			tileBytes, err := SerializeTileToBytes(tileData[i][j], gameVersion)
+			if err != nil {
+				return nil, fmt.Errorf("tile (%v, %v): %w", j, i, err)
+			}
 			allMapBytes = append(allMapBytes, tileBytes...)
 		}
 	}
-	return allMapBytes
+	return allMapBytes, nil
 }
 
-func ConvertAllPlayerDataToBytes(allPlayerData []PlayerData, gameVersion int) []byte {
+func ConvertAllPlayerDataToBytes(allPlayerData []PlayerData, gameVersion int) ([]byte, error) {
+	if len(allPlayerData) > math.MaxUint16 {
+		return nil, fmt.Errorf("too many players, the player count must fit in uint16, found %v", len(allPlayerData))
+	}
 	allPlayerBytes := make([]byte, 0)
 	allPlayerBytes = append(allPlayerBytes, ConvertUint16Bytes(len(allPlayerData))...)
 	for i := 0; i < len(allPlayerData); i++ {
-		allPlayerBytes = append(allPlayerBytes, SerializePlayerDataToBytes(allPlayerData[i], gameVersion)...)
+		playerBytes, err := SerializePlayerDataToBytes(allPlayerData[i], gameVersion)
+		if err != nil {
+			return nil, fmt.Errorf("player %v: %w", allPlayerData[i].PlayerId, err)
+		}
+		allPlayerBytes = append(allPlayerBytes, playerBytes...)
+	}
+	return allPlayerBytes, nil
+}
+
+// WriteTileToFile overwrites the tile in each copy of the map selected by fileInfo.Target
+func WriteTileToFile(fileInfo FileInfo, tileDataOverwrite TileData, targetX int, targetY int) error {
+	fileInfo, err := backupBeforeWrite(fileInfo)
+	if err != nil {
+		return err
+	}
+	saveOutput, err := readFileInfoSave(fileInfo)
+	if err != nil {
+		return err
+	}
+
+	sections := make([]fileSection, 0)
+	for _, state := range targetMapStates(saveOutput, fileInfo.Target) {
+		if _, err := state.getTile(targetX, targetY); err != nil {
+			return err
+		}
+		section, err := stateTileSection(saveOutput, state.initial, tileDataOverwrite, targetX, targetY)
+		if err != nil {
+			return err
+		}
+		sections = append(sections, section)
+	}
+	return writeSections(fileInfo.InputFilename, sections)
+}
+
+// WriteMapToFile overwrites all tiles in each copy of the map selected by fileInfo.Target
+func WriteMapToFile(fileInfo FileInfo, tileDataOverwrite [][]TileData) error {
+	fileInfo, err := backupBeforeWrite(fileInfo)
+	if err != nil {
+		return err
+	}
+	saveOutput, err := readFileInfoSave(fileInfo)
+	if err != nil {
+		return err
 	}
-	return allPlayerBytes
+
+	sections := make([]fileSection, 0)
+	for _, state := range targetMapStates(saveOutput, fileInfo.Target) {
+		section, err := stateMapSection(saveOutput, state.initial, tileDataOverwrite)
+		if err != nil {
+			return err
+		}
+		sections = append(sections, section)
+	}
+	return writeSections(fileInfo.InputFilename, sections)
 }
 
-func WriteTileToFile(fileInfo FileInfo, tileDataOverwrite TileData, targetX int, targetY int) {
-	tileBytes := SerializeTileToBytes(tileDataOverwrite, fileInfo.GameVersion)
-	WriteAndShiftData(fileInfo.InputFilename, buildTileStartKey(targetX, targetY), buildTileEndKey(targetX, targetY), tileBytes)
+// The state section functions serialize part of the initial or current state and return it with its range in the file.
+// An edit reads the file once, builds a section for everything it changes with the offsets of that parse
+// and writes them all with one writeSections call, so the file is never left with only part of an edit.
+
+func stateTileSection(saveOutput *PolytopiaSaveOutput, initial bool, tile TileData, targetX int, targetY int) (fileSection, error) {
+	tileOffsets := stateOffsets(saveOutput.Offsets, initial).Tiles
+	if targetY < 0 || targetY >= len(tileOffsets) || targetX < 0 || targetX >= len(tileOffsets[targetY]) {
+		return fileSection{}, fmt.Errorf("tile (%v, %v) is outside the map", targetX, targetY)
+	}
+	tileBytes, err := SerializeTileToBytes(tile, saveOutput.GameVersion)
+	if err != nil {
+		return fileSection{}, err
+	}
+	return fileSection{tileOffsets[targetY][targetX], tileBytes}, nil
 }
 
-func WriteMapToFile(fileInfo FileInfo, tileDataOverwrite [][]TileData) {
-	allTileBytes := ConvertMapDataToBytes(tileDataOverwrite, fileInfo.GameVersion)
-	WriteAndShiftData(fileInfo.InputFilename, buildMapStartKey(), buildMapEndKey(), allTileBytes)
+func stateMapSection(saveOutput *PolytopiaSaveOutput, initial bool, tileData [][]TileData) (fileSection, error) {
+	allTileBytes, err := ConvertMapDataToBytes(tileData, saveOutput.GameVersion)
+	if err != nil {
+		return fileSection{}, err
+	}
+	return fileSection{stateOffsets(saveOutput.Offsets, initial).Map, allTileBytes}, nil
 }
 
-func WritePlayersToFile(inputFilename string, playersList []PlayerData, gameVersion int) {
-	allPlayerBytes := ConvertAllPlayerDataToBytes(playersList, gameVersion)
-	WriteAndShiftData(inputFilename, buildAllPlayersStartKey(), buildAllPlayersEndKey(), allPlayerBytes)
+func statePlayersSection(saveOutput *PolytopiaSaveOutput, initial bool, playersList []PlayerData) (fileSection, error) {
+	allPlayerBytes, err := ConvertAllPlayerDataToBytes(playersList, saveOutput.GameVersion)
+	if err != nil {
+		return fileSection{}, err
+	}
+	return fileSection{stateOffsets(saveOutput.Offsets, initial).Players, allPlayerBytes}, nil
 }
 
-func WriteMapHeaderToFile(inputFilename string, mapHeader MapHeaderOutput) {
-	mapHeaderBytes := SerializeMapHeaderToBytes(mapHeader)
-	WriteAndShiftData(inputFilename, buildMapHeaderStartKey(), buildMapHeaderEndKey(), mapHeaderBytes)
+func stateMapHeaderSection(saveOutput *PolytopiaSaveOutput, initial bool, mapHeader MapHeaderOutput) (fileSection, error) {
+	mapHeaderBytes, err := SerializeMapHeaderToBytes(mapHeader)
+	if err != nil {
+		return fileSection{}, err
+	}
+	return fileSection{stateOffsets(saveOutput.Offsets, initial).MapHeader, mapHeaderBytes}, nil
 }
 
-func ModifyTileTerrain(fileInfo FileInfo, targetX int, targetY int, updatedValue int) {
-	saveOutput, err := ReadPolytopiaDecompressedFile(fileInfo.InputFilename)
+func targetStateFlags(target EditTarget) []bool {
+	switch target {
+	case EditTargetInitial:
+		return []bool{true}
+	case EditTargetBoth:
+		return []bool{true, false}
+	}
+	return []bool{false}
+}
+
+// WritePlayersToFile overwrites the player list in the current state
+func WritePlayersToFile(inputFilename string, playersList []PlayerData, gameVersion int) error {
+	return WriteTargetPlayersToFile(FileInfo{InputFilename: inputFilename, GameVersion: gameVersion}, playersList)
+}
+
+// WriteTargetPlayersToFile overwrites the player list in each copy of the map selected by fileInfo.Target
+func WriteTargetPlayersToFile(fileInfo FileInfo, playersList []PlayerData) error {
+	fileInfo, err := backupBeforeWrite(fileInfo)
+	if err != nil {
+		return err
+	}
+	saveOutput, err := readFileInfoSave(fileInfo)
 	if err != nil {
-		log.Fatal("Failed to read save file")
+		return err
 	}
 
-	updatedTile := saveOutput.TileData[targetY][targetX]
+	sections := make([]fileSection, 0)
+	for _, state := range targetMapStates(saveOutput, fileInfo.Target) {
+		section, err := statePlayersSection(saveOutput, state.initial, playersList)
+		if err != nil {
+			return err
+		}
+		sections = append(sections, section)
+	}
+	return writeSections(fileInfo.InputFilename, sections)
+}
 
-	// write terrain
-	updatedTile.Terrain = updatedValue
+// WriteMapHeaderToFile overwrites the map header in the current state
+func WriteMapHeaderToFile(inputFilename string, mapHeader MapHeaderOutput) error {
+	return WriteTargetMapHeaderToFile(FileInfo{InputFilename: inputFilename}, mapHeader)
+}
 
-	// write altitude
-	altitude := 0
-	if updatedValue == 1 { // water altitude is -1
-		altitude = -1
-	} else if updatedValue == 2 { // ocean altitude is -2
-		altitude = -2
-	} else if updatedValue == 3 || updatedValue == 5 { // flat tile altitude is 1
-		altitude = 1
-	} else if updatedValue == 4 { // mountain altitude is 2
-		altitude = 2
+// WriteTargetMapHeaderToFile overwrites the map header in each copy of the map selected by fileInfo.Target
+func WriteTargetMapHeaderToFile(fileInfo FileInfo, mapHeader MapHeaderOutput) error {
+	fileInfo, err := backupBeforeWrite(fileInfo)
+	if err != nil {
+		return err
+	}
+	saveOutput, err := readFileInfoSave(fileInfo)
+	if err != nil {
+		return err
 	}
-	updatedTile.Altitude = altitude
 
-	WriteTileToFile(fileInfo, updatedTile, targetX, targetY)
+	sections := make([]fileSection, 0)
+	for _, state := range targetMapStates(saveOutput, fileInfo.Target) {
+		section, err := stateMapHeaderSection(saveOutput, state.initial, mapHeader)
+		if err != nil {
+			return err
+		}
+		sections = append(sections, section)
+	}
+	return writeSections(fileInfo.InputFilename, sections)
 }
 
-func ModifyUnitTribe(fileInfo FileInfo, targetX int, targetY int, updatedValue int) {
-	saveOutput, err := ReadPolytopiaDecompressedFile(fileInfo.InputFilename)
+// WriteActionsToFile replaces the action list and updates TotalActions to match in each map header selected by fileInfo.Target.
+// The actions and headers are written together, so TotalActions never disagrees with the action list.
+func WriteActionsToFile(fileInfo FileInfo, actions []ReplayAction) error {
+	numActions := countSerializedActions(actions)
+	if numActions >= 65536 {
+		return fmt.Errorf("too many actions, the action count must fit in uint16, found %v", numActions)
+	}
+	actionBytes, err := SerializeActionsToBytes(actions)
 	if err != nil {
-		log.Fatal("Failed to read save file")
+		return err
 	}
 
-	updatedTile := saveOutput.TileData[targetY][targetX]
-	if updatedTile.Unit != nil {
-		fmt.Println(fmt.Sprintf("Before changing unit's owner on tile (%v, %v), current owner is %v",
-			targetX, targetY, updatedTile.Unit.Owner))
-		updatedTile.Unit.Owner = uint8(updatedValue)
-	} else {
-		fmt.Println(fmt.Sprintf("No unit on tile (%v, %v)", targetX, targetY))
+	fileInfo, err = backupBeforeWrite(fileInfo)
+	if err != nil {
+		return err
 	}
-	if updatedTile.PassengerUnit != nil {
-		fmt.Println(fmt.Sprintf("Before changing transition unit's owner on tile (%v, %v), current owner is %v",
-			targetX, targetY, updatedTile.PassengerUnit.Owner))
-		updatedTile.PassengerUnit.Owner = uint8(updatedValue)
-	} else {
-		fmt.Println(fmt.Sprintf("No transition unit on tile (%v, %v)", targetX, targetY))
+	saveOutput, err := readFileInfoSave(fileInfo)
+	if err != nil {
+		return err
+	}
+
+	sections := []fileSection{{saveOutput.Offsets.Actions, actionBytes}}
+	for _, state := range targetMapStates(saveOutput, fileInfo.Target) {
+		mapHeader := *state.mapHeader
+		mapHeader.MapHeaderInput.TotalActions = uint16(numActions)
+		section, err := stateMapHeaderSection(saveOutput, state.initial, mapHeader)
+		if err != nil {
+			return err
+		}
+		sections = append(sections, section)
+	}
+	return writeSections(fileInfo.InputFilename, sections)
+}
+
+func ModifyTileTerrain(fileInfo FileInfo, targetX int, targetY int, updatedValue int) error {
+	return modifyTargetTiles(fileInfo, targetX, targetY, func(state mapState, tile *TileData) error {
+		setTileTerrain(tile, updatedValue)
+		return nil
+	})
+}
+
+// modifyTargetTiles applies the edit to the tile in each copy of the map selected by fileInfo.Target
+// and writes the changed tiles together
+func modifyTargetTiles(fileInfo FileInfo, targetX int, targetY int, edit func(state mapState, tile *TileData) error) error {
+	fileInfo, err := backupBeforeWrite(fileInfo)
+	if err != nil {
+		return err
+	}
+	saveOutput, err := readFileInfoSave(fileInfo)
+	if err != nil {
+		return err
+	}
+
+	sections := make([]fileSection, 0)
+	for _, state := range targetMapStates(saveOutput, fileInfo.Target) {
+		tile, err := state.getTile(targetX, targetY)
+		if err != nil {
+			return err
+		}
+		if err := edit(state, tile); err != nil {
+			return err
+		}
+		section, err := stateTileSection(saveOutput, state.initial, *tile, targetX, targetY)
+		if err != nil {
+			return err
+		}
+		sections = append(sections, section)
 	}
-	WriteTileToFile(fileInfo, updatedTile, targetX, targetY)
+	return writeSections(fileInfo.InputFilename, sections)
+}
+
+func setTileTerrain(tile *TileData, terrain int) {
+	tile.Terrain = terrain
+
+	// altitude depends on terrain
+	altitude := 0
+	switch TerrainType(terrain) {
+	case TerrainWater:
+		altitude = -1
+	case TerrainOcean:
+		altitude = -2
+	case TerrainField, TerrainForest: // flat tiles
+		altitude = 1
+	case TerrainMountain:
+		altitude = 2
+	}
+	tile.Altitude = altitude
+}
+
+func ModifyUnitTribe(fileInfo FileInfo, targetX int, targetY int, updatedValue int) error {
+	if err := checkUint8Field("unit owner", updatedValue); err != nil {
+		return err
+	}
+	return modifyTargetTiles(fileInfo, targetX, targetY, func(state mapState, updatedTile *TileData) error {
+		if updatedTile.Unit != nil {
+			debugPrint("Before changing unit's owner on tile (%v, %v) in %v, current owner is %v\n",
+				targetX, targetY, state, updatedTile.Unit.Owner)
+		} else {
+			debugPrint("No unit on tile (%v, %v) in %v\n", targetX, targetY, state)
+		}
+		if updatedTile.PassengerUnit != nil {
+			debugPrint("Before changing transition unit's owner on tile (%v, %v) in %v, current owner is %v\n",
+				targetX, targetY, state, updatedTile.PassengerUnit.Owner)
+		} else {
+			debugPrint("No transition unit on tile (%v, %v) in %v\n", targetX, targetY, state)
+		}
+		return setTileUnitOwner(updatedTile, updatedValue)
+	})
 }
 
 func BuildTribeUnitMap(saveOutput *PolytopiaSaveOutput) map[int][]UnitLocationData {
+	return buildTribeUnitMapFromTiles(saveOutput.TileData)
+}
+
+func buildTribeUnitMapFromTiles(tileData [][]TileData) map[int][]UnitLocationData {
 	tribeUnitMap := make(map[int][]UnitLocationData)
 
-	for i := 0; i < int(saveOutput.MapHeight); i++ {
-		for j := 0; j < int(saveOutput.MapWidth); j++ {
-			if saveOutput.TileData[i][j].Unit == nil {
+	for i := 0; i < len(tileData); i++ {
+		for j := 0; j < len(tileData[i]); j++ {
+			if tileData[i][j].Unit == nil {
 				continue
 			}
-			tribeOwner := saveOutput.TileData[i][j].Owner
+			tribeOwner := tileData[i][j].Owner
 
 			_, ok := tribeUnitMap[tribeOwner]
 			if !ok {
@@ -275,7 +591,7 @@ func BuildTribeUnitMap(saveOutput *PolytopiaSaveOutput) map[int][]UnitLocationDa
 			unitLocationData := UnitLocationData{
 				X:        j,
 				Y:        i,
-				UnitType: int(saveOutput.TileData[i][j].Unit.UnitType),
+				UnitType: int(tileData[i][j].Unit.UnitType),
 			}
 			tribeUnitMap[tribeOwner] = append(tribeUnitMap[tribeOwner], unitLocationData)
 		}
@@ -284,61 +600,92 @@ func BuildTribeUnitMap(saveOutput *PolytopiaSaveOutput) map[int][]UnitLocationDa
 	return tribeUnitMap
 }
 
-func ConvertTribe(fileInfo FileInfo, oldTribe int, newTribe int) {
-	saveOutput, err := ReadPolytopiaDecompressedFile(fileInfo.InputFilename)
+func ConvertTribe(fileInfo FileInfo, oldTribe int, newTribe int) error {
+	fileInfo, err := backupBeforeWrite(fileInfo)
 	if err != nil {
-		log.Fatal("Failed to read save file")
+		return err
+	}
+	saveOutput, err := readFileInfoSave(fileInfo)
+	if err != nil {
+		return err
+	}
+
+	// convert all states before writing so nothing is written if the tribe is missing from one of them
+	sections := make([]fileSection, 0)
+	for _, state := range targetMapStates(saveOutput, fileInfo.Target) {
+		tribeUnits, err := convertTribeUnits(*state.tileData, oldTribe, newTribe)
+		if err != nil {
+			return fmt.Errorf("%v: %w", state, err)
+		}
+		for i := 0; i < len(tribeUnits); i++ {
+			debugPrint("Converted unit on (%v, %v) in %v from tribe %v to %v\n", tribeUnits[i].X, tribeUnits[i].Y, state, oldTribe, newTribe)
+		}
+		debugPrint("Changed all units under tribe %v to tribe %v in %v. Total of %v units converted.\n", oldTribe, newTribe, state, len(tribeUnits))
+
+		section, err := stateMapSection(saveOutput, state.initial, *state.tileData)
+		if err != nil {
+			return err
+		}
+		sections = append(sections, section)
 	}
+	return writeSections(fileInfo.InputFilename, sections)
+}
 
-	tribeUnitMap := BuildTribeUnitMap(saveOutput)
+func convertTribeUnits(tileData [][]TileData, oldTribe int, newTribe int) ([]UnitLocationData, error) {
+	if err := checkUint8Field("unit owner", newTribe); err != nil {
+		return nil, err
+	}
+	tribeUnitMap := buildTribeUnitMapFromTiles(tileData)
 
 	tribeUnits, ok := tribeUnitMap[oldTribe]
 	if !ok {
-		log.Fatal(fmt.Sprintf("Tribe %v doesn't exist", oldTribe))
+		return nil, fmt.Errorf("tribe %v doesn't exist", oldTribe)
 	}
 
 	for i := 0; i < len(tribeUnits); i++ {
-		targetX := tribeUnits[i].X
-		targetY := tribeUnits[i].Y
-
-		updatedTile := saveOutput.TileData[targetY][targetX]
-		if updatedTile.Unit != nil {
-			updatedTile.Unit.Owner = uint8(newTribe)
-		}
-		if updatedTile.PassengerUnit != nil {
-			updatedTile.PassengerUnit.Owner = uint8(newTribe)
+		if err := setTileUnitOwner(&tileData[tribeUnits[i].Y][tribeUnits[i].X], newTribe); err != nil {
+			return nil, err
 		}
-		fmt.Println(fmt.Sprintf("Converted unit on (%v, %v) from tribe %v to %v", targetX, targetY, oldTribe, newTribe))
-
-		saveOutput.TileData[targetY][targetX] = updatedTile
 	}
-
-	WriteMapToFile(fileInfo, saveOutput.TileData)
-	fmt.Println(fmt.Sprintf("Changed all units under tribe %v to tribe %v. Total of %v units converted.", oldTribe, newTribe, len(tribeUnits)))
+	return tribeUnits, nil
 }
 
-func ModifyUnitType(fileInfo FileInfo, targetX int, targetY int, updatedValue int) {
-	saveOutput, err := ReadPolytopiaDecompressedFile(fileInfo.InputFilename)
-	if err != nil {
-		log.Fatal("Failed to read save file")
+// setTileUnitOwner changes the owner of the unit and passenger unit on the tile.
+// The owner is stored in one byte, so a larger owner returns an error.
+func setTileUnitOwner(tile *TileData, owner int) error {
+	if err := checkUint8Field("unit owner", owner); err != nil {
+		return err
+	}
+	if tile.Unit != nil {
+		tile.Unit.Owner = uint8(owner)
+	}
+	if tile.PassengerUnit != nil {
+		tile.PassengerUnit.Owner = uint8(owner)
 	}
+	return nil
+}
 
-	updatedTile := saveOutput.TileData[targetY][targetX]
-	if updatedTile.Unit != nil {
-		fmt.Println(fmt.Sprintf("Before changing unit's owner on tile (%v, %v), current type is %v",
-			targetX, targetY, updatedTile.Unit.UnitType))
-		updatedTile.Unit.UnitType = uint16(updatedValue)
-	} else {
-		fmt.Println(fmt.Sprintf("No unit on tile (%v, %v)", targetX, targetY))
+func ModifyUnitType(fileInfo FileInfo, targetX int, targetY int, updatedValue int) error {
+	if err := checkUint16Field("unit type", updatedValue); err != nil {
+		return err
 	}
-	WriteTileToFile(fileInfo, updatedTile, targetX, targetY)
+	return modifyTargetTiles(fileInfo, targetX, targetY, func(state mapState, updatedTile *TileData) error {
+		if updatedTile.Unit != nil {
+			debugPrint("Before changing unit's type on tile (%v, %v) in %v, current type is %v\n",
+				targetX, targetY, state, updatedTile.Unit.UnitType)
+			updatedTile.Unit.UnitType = uint16(updatedValue)
+		} else {
+			debugPrint("No unit on tile (%v, %v) in %v\n", targetX, targetY, state)
+		}
+		return nil
+	})
 }
 
 func BuildEmptyTile(x int, y int) TileData {
 	return TileData{
 		WorldCoordinates:   [2]int{x, y},
-		Terrain:            3,
-		Climate:            1,
+		Terrain:            int(TerrainField),
+		Climate:            int(ClimateXinXi),
 		Altitude:           1,
 		Owner:              0,
 		Capital:            0,
@@ -353,32 +700,67 @@ func BuildEmptyTile(x int, y int) TileData {
 		PlayerVisibility:   []int{},
 		HasRoad:            false,
 		HasWaterRoute:      false,
-		Unknown:            []int{0, 0, 0, 0},
+		TileSkin:           0,
+		Unknown:            []int{0, 0},
 	}
 }
 
-func ModifyMapDimensions(inputFilename string, width int, height int) {
-	minSquareSize := width
-	if minSquareSize > height {
-		minSquareSize = height
-	}
-	squareSizeOffset, ok := fileOffsetMap["SquareSizeKey"]
-	if !ok {
-		log.Fatal("Error: No square size key. Command not run.")
-	}
-	WriteUint32AtFileOffset(inputFilename, squareSizeOffset, minSquareSize)
+// placeNewUnit puts a unit that was just created on the tile at (x, y), replacing any unit and passenger there,
+// and returns it so the caller can set the flags that depend on how it was created
+func placeNewUnit(tile *TileData, id uint32, owner uint8, unitType uint16, x int, y int, createdTurn int) *UnitData {
+	tile.Unit = &UnitData{
+		Id:                 id,
+		Owner:              owner,
+		UnitType:           unitType,
+		CurrentCoordinates: [2]int32{int32(x), int32(y)},
+		HomeCoordinates:    [2]int32{int32(x), int32(y)},
+		Health:             100,
+		CreatedTurn:        uint16(createdTurn),
+	}
+	tile.PassengerUnit = nil
+	tile.UnitEffectData = []int{}
+	tile.UnitDirectionData = []int{0, 0, 0, 0, 0}
+	return tile.Unit
+}
 
-	widthOffset, ok := fileOffsetMap["MapWidth"]
-	if !ok {
-		log.Fatal("Error: No map width key. Command not run.")
+// ModifyMapDimensions overwrites the dimensions in the current map header without changing the tiles.
+// ExpandRows and ExpandColumns also add the tiles.
+func ModifyMapDimensions(inputFilename string, width int, height int) error {
+	return ModifyTargetMapDimensions(FileInfo{InputFilename: inputFilename}, width, height)
+}
+
+// ModifyTargetMapDimensions overwrites the dimensions in each map header selected by fileInfo.Target without changing the tiles
+func ModifyTargetMapDimensions(fileInfo FileInfo, width int, height int) error {
+	fileInfo, err := backupBeforeWrite(fileInfo)
+	if err != nil {
+		return err
 	}
-	WriteUint16AtFileOffset(inputFilename, widthOffset, width)
+	inputFilename := fileInfo.InputFilename
+	saveOutput, err := ReadPolytopiaDecompressedFile(inputFilename)
+	if err != nil {
+		return fmt.Errorf("failed to read save file: %w", err)
+	}
+
+	// the file can't be parsed again once a header no longer matches its tiles,
+	// so every header is replaced in one write with the offsets from this parse
+	sections := make([]fileSection, 0)
+	for _, state := range targetMapStates(saveOutput, fileInfo.Target) {
+		mapHeader := *state.mapHeader
+		setMapHeaderDimensions(&mapHeader, width, height)
+		mapHeaderBytes, err := SerializeMapHeaderToBytes(mapHeader)
+		if err != nil {
+			return err
+		}
+		sections = append(sections, fileSection{stateOffsets(saveOutput.Offsets, state.initial).MapHeader, mapHeaderBytes})
+	}
+	return writeSections(inputFilename, sections)
+}
 
-	heightOffset, ok := fileOffsetMap["MapHeight"]
-	if !ok {
-		log.Fatal("Error: No map height key. Command not run.")
+func stateOffsets(offsets OffsetIndex, initial bool) StateOffsetIndex {
+	if initial {
+		return offsets.Initial
 	}
-	WriteUint16AtFileOffset(inputFilename, heightOffset, height)
+	return offsets.Current
 }
 
 func BuildEmptyCity(cityName string) ImprovementData {
@@ -401,167 +783,244 @@ func BuildEmptyCity(cityName string) ImprovementData {
 	}
 }
 
-func AddCityToTile(fileInfo FileInfo, targetX int, targetY int, cityName string, tribe int) {
-	saveOutput, err := ReadPolytopiaDecompressedFile(fileInfo.InputFilename)
-	if err != nil {
-		log.Fatal("Failed to read save file")
-	}
+func AddCityToTile(fileInfo FileInfo, targetX int, targetY int, cityName string, tribe int) error {
+	return modifyTargetTiles(fileInfo, targetX, targetY, func(state mapState, tile *TileData) error {
+		setCityOnTile(tile, targetX, targetY, cityName, tribe)
+		return nil
+	})
+}
+
+func setCityOnTile(tile *TileData, targetX int, targetY int, cityName string, tribe int) {
 	// Overwrite tile header tribe
 	// world coordinates, terrain, climate, altitude are the same for all players
 	// the difference is the tribe that owns this city
-	saveOutput.TileData[targetY][targetX].Owner = tribe
+	tile.Owner = tribe
 	// set capital to 0 unless this city is designated as capital city
-	saveOutput.TileData[targetY][targetX].Capital = 0
-	saveOutput.TileData[targetY][targetX].CapitalCoordinates = [2]int{targetX, targetY}
+	tile.Capital = 0
+	tile.CapitalCoordinates = [2]int{targetX, targetY}
 	// Overwrite improvement data and set city
-	saveOutput.TileData[targetY][targetX].ImprovementExists = true
-	saveOutput.TileData[targetY][targetX].ImprovementType = 1
+	tile.ImprovementExists = true
+	tile.ImprovementType = int(ImprovementCity)
 	improvementData := BuildEmptyCity(cityName)
-	saveOutput.TileData[targetY][targetX].ImprovementData = &improvementData
-	WriteTileToFile(fileInfo, saveOutput.TileData[targetY][targetX], targetX, targetY)
+	tile.ImprovementData = &improvementData
 }
 
-func ResetTile(fileInfo FileInfo, targetX int, targetY int) {
+func ResetTile(fileInfo FileInfo, targetX int, targetY int) error {
 	updatedTile := BuildEmptyTile(targetX, targetY)
-	WriteTileToFile(fileInfo, updatedTile, targetX, targetY)
+	return WriteTileToFile(fileInfo, updatedTile, targetX, targetY)
 }
 
-func ExpandRows(fileInfo FileInfo, newRowDimensions int) {
+// ExpandRows adds empty rows to both copies of the map so their dimensions stay the same.
+// fileInfo.Target is ignored, because the game expects the initial and current maps to have the same size.
+func ExpandRows(fileInfo FileInfo, newRowDimensions int) error {
 	if newRowDimensions >= 256 {
-		log.Fatal("Updated value is over 256")
+		return fmt.Errorf("updated value is over 256")
+	}
+	fileInfo, err := backupBeforeWrite(fileInfo)
+	if err != nil {
+		return err
 	}
 
-	saveOutput, err := ReadPolytopiaDecompressedFile(fileInfo.InputFilename)
+	saveOutput, err := readFileInfoSave(fileInfo)
 	if err != nil {
-		log.Fatal("Failed to read save file")
+		return err
 	}
-	fmt.Println(fmt.Sprintf("Old dimensions width: %v, height: %v", saveOutput.MapWidth, saveOutput.MapHeight))
+	debugPrint("Old dimensions width: %v, height: %v\n", saveOutput.MapWidth, saveOutput.MapHeight)
 
 	if newRowDimensions <= saveOutput.MapHeight {
-		log.Fatal(fmt.Sprintf("New row dimensions are less than existing dimensions, new value: %v, existing height: %v",
-			newRowDimensions, saveOutput.MapHeight))
+		return fmt.Errorf("new row dimensions are less than existing dimensions, new value: %v, existing height: %v",
+			newRowDimensions, saveOutput.MapHeight)
 	}
 
-	for y := saveOutput.MapHeight; y < newRowDimensions; y++ {
-		newTileRow := make([]TileData, saveOutput.MapWidth)
-		for x := 0; x < saveOutput.MapWidth; x++ {
-			newTileRow[x] = BuildEmptyTile(x, y)
-		}
-		saveOutput.TileData = append(saveOutput.TileData, newTileRow)
+	for _, state := range targetMapStates(saveOutput, EditTargetBoth) {
+		*state.tileData = appendEmptyRows(*state.tileData, state.mapHeader.MapWidth, newRowDimensions)
+		setMapHeaderDimensions(state.mapHeader, state.mapHeader.MapWidth, newRowDimensions)
 	}
-	WriteMapToFile(fileInfo, saveOutput.TileData)
-	ModifyMapDimensions(fileInfo.InputFilename, saveOutput.MapWidth, newRowDimensions)
-
-	finalSaveOutput, err := ReadPolytopiaDecompressedFile(fileInfo.InputFilename)
-	fmt.Println(fmt.Sprintf("New dimensions, width: %v, height: %v", finalSaveOutput.MapWidth, finalSaveOutput.MapHeight))
+	if err := writeResizedMaps(fileInfo, saveOutput); err != nil {
+		return err
+	}
+	debugPrint("New dimensions, width: %v, height: %v\n", saveOutput.MapHeaderOutput.MapWidth, saveOutput.MapHeaderOutput.MapHeight)
+	return nil
 }
 
-func ExpandColumns(fileInfo FileInfo, newColDimensions int) {
-	inputFilename := fileInfo.InputFilename
+// ExpandColumns adds empty columns to both copies of the map so their dimensions stay the same.
+// fileInfo.Target is ignored, because the game expects the initial and current maps to have the same size.
+func ExpandColumns(fileInfo FileInfo, newColDimensions int) error {
 	if newColDimensions >= 256 {
-		log.Fatal("Updated value is over 256")
+		return fmt.Errorf("updated value is over 256")
+	}
+	fileInfo, err := backupBeforeWrite(fileInfo)
+	if err != nil {
+		return err
 	}
 
-	saveOutput, err := ReadPolytopiaDecompressedFile(inputFilename)
+	saveOutput, err := readFileInfoSave(fileInfo)
 	if err != nil {
-		log.Fatal("Failed to read save file")
+		return err
 	}
-	fmt.Println(fmt.Sprintf("Old dimensions width: %v, height: %v", saveOutput.MapWidth, saveOutput.MapHeight))
+	debugPrint("Old dimensions width: %v, height: %v\n", saveOutput.MapWidth, saveOutput.MapHeight)
 
 	if newColDimensions <= saveOutput.MapWidth {
-		log.Fatal(fmt.Sprintf("New column dimensions are less than existing dimensions, new value: %v, existing width: %v",
-			newColDimensions, saveOutput.MapWidth))
+		return fmt.Errorf("new column dimensions are less than existing dimensions, new value: %v, existing width: %v",
+			newColDimensions, saveOutput.MapWidth)
 	}
 
-	for y := saveOutput.MapHeight - 1; y >= 0; y-- {
-		for x := saveOutput.MapWidth; x < newColDimensions; x++ {
-			saveOutput.TileData[y] = append(saveOutput.TileData[y], BuildEmptyTile(x, y))
-		}
+	for _, state := range targetMapStates(saveOutput, EditTargetBoth) {
+		*state.tileData = appendEmptyColumns(*state.tileData, state.mapHeader.MapWidth, newColDimensions)
+		setMapHeaderDimensions(state.mapHeader, newColDimensions, state.mapHeader.MapHeight)
+	}
+	if err := writeResizedMaps(fileInfo, saveOutput); err != nil {
+		return err
+	}
+	debugPrint("New dimensions, width: %v, height: %v\n", saveOutput.MapHeaderOutput.MapWidth, saveOutput.MapHeaderOutput.MapHeight)
+	return nil
+}
+
+// writeResizedMaps writes the map headers and tiles of both states.
+// The file can't be parsed again until a map and its header are both written, so all four sections are replaced
+// in one write with the offsets from the first parse.
+func writeResizedMaps(fileInfo FileInfo, saveOutput *PolytopiaSaveOutput) error {
+	offsets := saveOutput.Offsets
+	currentMapBytes, err := ConvertMapDataToBytes(saveOutput.TileData, saveOutput.GameVersion)
+	if err != nil {
+		return err
+	}
+	currentHeaderBytes, err := SerializeMapHeaderToBytes(saveOutput.MapHeaderOutput)
+	if err != nil {
+		return err
+	}
+	initialMapBytes, err := ConvertMapDataToBytes(saveOutput.InitialTileData, saveOutput.GameVersion)
+	if err != nil {
+		return err
+	}
+	initialHeaderBytes, err := SerializeMapHeaderToBytes(saveOutput.InitialMapHeaderOutput)
+	if err != nil {
+		return err
 	}
-	WriteMapToFile(fileInfo, saveOutput.TileData)
-	ModifyMapDimensions(inputFilename, newColDimensions, saveOutput.MapHeight)
 
-	finalSaveOutput, err := ReadPolytopiaDecompressedFile(inputFilename)
-	fmt.Println(fmt.Sprintf("New dimensions, width: %v, height: %v", finalSaveOutput.MapWidth, finalSaveOutput.MapHeight))
+	return writeSections(fileInfo.InputFilename, []fileSection{
+		{offsets.Initial.MapHeader, initialHeaderBytes},
+		{offsets.Initial.Map, initialMapBytes},
+		{offsets.Current.MapHeader, currentHeaderBytes},
+		{offsets.Current.Map, currentMapBytes},
+	})
 }
 
-func ExpandTiles(fileInfo FileInfo, newSquareSizeDimensions int) {
+func setMapHeaderDimensions(mapHeader *MapHeaderOutput, width int, height int) {
+	mapHeader.MapWidth = width
+	mapHeader.MapHeight = height
+	mapHeader.MapSquareSize = getMinSquareSize(width, height)
+}
+
+// ExpandTiles adds empty columns and rows to both copies of the map, making it a square of the new size
+func ExpandTiles(fileInfo FileInfo, newSquareSizeDimensions int) error {
 	if newSquareSizeDimensions >= 256 {
-		log.Fatal("Updated value is over 256")
+		return fmt.Errorf("updated value is over 256")
+	}
+	fileInfo, err := backupBeforeWrite(fileInfo)
+	if err != nil {
+		return err
 	}
 
-	saveOutput, err := ReadPolytopiaDecompressedFile(fileInfo.InputFilename)
+	saveOutput, err := readFileInfoSave(fileInfo)
 	if err != nil {
-		log.Fatal("Failed to read save file")
+		return err
 	}
 
 	if newSquareSizeDimensions <= saveOutput.MapWidth || newSquareSizeDimensions <= saveOutput.MapHeight {
-		log.Fatal(fmt.Sprintf("New dimensions are less than existing dimensions, new value: %v, existing width: %v, height: %v",
-			newSquareSizeDimensions, saveOutput.MapWidth, saveOutput.MapHeight))
+		return fmt.Errorf("new dimensions are less than existing dimensions, new value: %v, existing width: %v, height: %v",
+			newSquareSizeDimensions, saveOutput.MapWidth, saveOutput.MapHeight)
 	}
 
-	ExpandColumns(fileInfo, newSquareSizeDimensions)
-	ExpandRows(fileInfo, newSquareSizeDimensions)
-}
-
-func RevealAllTiles(fileInfo FileInfo, newTribe int) {
-	saveOutput, err := ReadPolytopiaDecompressedFile(fileInfo.InputFilename)
-	if err != nil {
-		log.Fatal("Failed to read save file")
+	for _, state := range targetMapStates(saveOutput, EditTargetBoth) {
+		*state.tileData = appendEmptyColumns(*state.tileData, state.mapHeader.MapWidth, newSquareSizeDimensions)
+		*state.tileData = appendEmptyRows(*state.tileData, newSquareSizeDimensions, newSquareSizeDimensions)
+		setMapHeaderDimensions(state.mapHeader, newSquareSizeDimensions, newSquareSizeDimensions)
 	}
+	return writeResizedMaps(fileInfo, saveOutput)
+}
 
-	for i := saveOutput.MapHeight - 1; i >= 0; i-- {
-		for j := saveOutput.MapWidth - 1; j >= 0; j-- {
-			targetX := j
-			targetY := i
-
-			visibilityData := saveOutput.TileData[i][j].PlayerVisibility
-			fmt.Println("Existing visibility data:", visibilityData)
-			isAlreadyVisible := false
-			for visibilityIndex := 0; visibilityIndex < len(visibilityData); visibilityIndex++ {
-				if int(visibilityData[visibilityIndex]) == newTribe {
-					fmt.Printf("Tile is already visible to tribe %v. No change will be made to visibility data.\n", newTribe)
-					isAlreadyVisible = true
-					break
-				}
-			}
-			if !isAlreadyVisible {
-				saveOutput.TileData[i][j].PlayerVisibility = append(saveOutput.TileData[i][j].PlayerVisibility, newTribe)
-				fmt.Println(fmt.Sprintf("Revealed (%v, %v) for tribe %v", targetX, targetY, newTribe))
-			}
+func appendEmptyRows(tileData [][]TileData, mapWidth int, newRowDimensions int) [][]TileData {
+	for y := len(tileData); y < newRowDimensions; y++ {
+		newTileRow := make([]TileData, mapWidth)
+		for x := 0; x < mapWidth; x++ {
+			newTileRow[x] = BuildEmptyTile(x, y)
 		}
+		tileData = append(tileData, newTileRow)
 	}
+	return tileData
+}
 
-	for i := saveOutput.MapHeight - 1; i >= 0; i-- {
-		for j := saveOutput.MapWidth - 1; j >= 0; j-- {
-			fmt.Println(fmt.Sprintf("Tile (%v, %v) visibility: %v", j, i, saveOutput.TileData[i][j].PlayerVisibility))
+func appendEmptyColumns(tileData [][]TileData, mapWidth int, newColDimensions int) [][]TileData {
+	for y := len(tileData) - 1; y >= 0; y-- {
+		for x := mapWidth; x < newColDimensions; x++ {
+			tileData[y] = append(tileData[y], BuildEmptyTile(x, y))
 		}
 	}
+	return tileData
+}
 
-	WriteMapToFile(fileInfo, saveOutput.TileData)
+func getMinSquareSize(width int, height int) int {
+	minSquareSize := width
+	if minSquareSize > height {
+		minSquareSize = height
+	}
+	return minSquareSize
 }
 
-func RevealTileForTribe(fileInfo FileInfo, targetX int, targetY int, newTribe int) {
-	saveOutput, err := ReadPolytopiaDecompressedFile(fileInfo.InputFilename)
+func RevealAllTiles(fileInfo FileInfo, newTribe int) error {
+	fileInfo, err := backupBeforeWrite(fileInfo)
 	if err != nil {
-		log.Fatal("Failed to read save file")
+		return err
 	}
+	saveOutput, err := readFileInfoSave(fileInfo)
+	if err != nil {
+		return err
+	}
+
+	sections := make([]fileSection, 0)
+	for _, state := range targetMapStates(saveOutput, fileInfo.Target) {
+		tileData := *state.tileData
+		for i := len(tileData) - 1; i >= 0; i-- {
+			for j := len(tileData[i]) - 1; j >= 0; j-- {
+				if revealTile(&tileData[i][j], newTribe) {
+					debugPrint("Revealed (%v, %v) in %v for tribe %v\n", j, i, state, newTribe)
+				} else {
+					debugPrint("Tile (%v, %v) in %v is already visible to tribe %v\n", j, i, state, newTribe)
+				}
+			}
+		}
 
-	visibilityData := saveOutput.TileData[targetY][targetX].PlayerVisibility
-	fmt.Println("Existing visibility data:", visibilityData)
-	isAlreadyVisible := false
-	for visibilityIndex := 0; visibilityIndex < len(visibilityData); visibilityIndex++ {
-		if int(visibilityData[visibilityIndex]) == newTribe {
-			fmt.Printf("Tile is already visible to tribe %v. No change will be made to visibility data.\n", newTribe)
-			isAlreadyVisible = true
-			break
+		section, err := stateMapSection(saveOutput, state.initial, tileData)
+		if err != nil {
+			return err
 		}
+		sections = append(sections, section)
 	}
-	if !isAlreadyVisible {
-		saveOutput.TileData[targetY][targetX].PlayerVisibility = append(saveOutput.TileData[targetY][targetX].PlayerVisibility, newTribe)
-		fmt.Println(fmt.Sprintf("Revealed (%v, %v) for tribe %v", targetX, targetY, newTribe))
+	return writeSections(fileInfo.InputFilename, sections)
+}
+
+func RevealTileForTribe(fileInfo FileInfo, targetX int, targetY int, newTribe int) error {
+	return modifyTargetTiles(fileInfo, targetX, targetY, func(state mapState, tile *TileData) error {
+		debugPrint("Existing visibility data: %v\n", tile.PlayerVisibility)
+		if revealTile(tile, newTribe) {
+			debugPrint("Revealed (%v, %v) in %v for tribe %v\n", targetX, targetY, state, newTribe)
+		} else {
+			debugPrint("Tile is already visible to tribe %v. No change will be made to visibility data.\n", newTribe)
+		}
+		return nil
+	})
+}
+
+// revealTile adds the tribe to the tile's visibility list and returns false if the tile was already visible
+func revealTile(tile *TileData, newTribe int) bool {
+	for visibilityIndex := 0; visibilityIndex < len(tile.PlayerVisibility); visibilityIndex++ {
+		if tile.PlayerVisibility[visibilityIndex] == newTribe {
+			return false
+		}
 	}
-	WriteTileToFile(fileInfo, saveOutput.TileData[targetY][targetX], targetX, targetY)
+	tile.PlayerVisibility = append(tile.PlayerVisibility, newTribe)
+	return true
 }
 
 func generateRandomColor() color.RGBA {
@@ -569,18 +1028,23 @@ func generateRandomColor() color.RGBA {
 	return color.RGBA{uint8(rand.Intn(255)), uint8(rand.Intn(255)), uint8(rand.Intn(255)), 255}
 }
 
-func BuildNewPlayerUnknownArr(oldRelationArr []PlayerAggression, newPlayerId int) []PlayerAggression {
+// BuildNewPlayerUnknownArr adds newPlayerId before player 255 in a player's aggressions list.
+// The list must end with player 255, so an empty list returns an error.
+func BuildNewPlayerUnknownArr(oldRelationArr []PlayerAggression, newPlayerId int) ([]PlayerAggression, error) {
 	existingLen := len(oldRelationArr)
+	if existingLen == 0 {
+		return nil, fmt.Errorf("aggressions list is empty, expected it to end with player 255")
+	}
 
 	oldPlayerCount := existingLen
 	oldMaximumPlayerId := oldPlayerCount - 1 // excludes player 255 nature
 	if oldMaximumPlayerId >= newPlayerId {
-		fmt.Println(fmt.Sprintf("Existing player count is %v, which includes players 1 to %v. No need to add player id %v.",
-			oldPlayerCount, oldPlayerCount-1, newPlayerId))
-		return oldRelationArr
+		debugPrint("Existing player count is %v, which includes players 1 to %v. No need to add player id %v.\n",
+			oldPlayerCount, oldPlayerCount-1, newPlayerId)
+		return oldRelationArr, nil
 	} else {
-		fmt.Println(fmt.Sprintf("Existing player count is %v, which includes players 1 to %v. New player id %v needs to be included.",
-			oldPlayerCount, oldPlayerCount-1, newPlayerId))
+		debugPrint("Existing player count is %v, which includes players 1 to %v. New player id %v needs to be included.\n",
+			oldPlayerCount, oldPlayerCount-1, newPlayerId)
 	}
 
 	dataInsert := PlayerAggression{
@@ -588,11 +1052,10 @@ func BuildNewPlayerUnknownArr(oldRelationArr []PlayerAggression, newPlayerId int
 		Aggression: 0,
 	}
 	// assumes player 255 is always last
-	existingPlayers := oldRelationArr[0 : existingLen-1]
-	naturePlayer := oldRelationArr[existingLen-1]
-
-	newRelationArr := append(existingPlayers, dataInsert, naturePlayer)
-	return newRelationArr
+	newRelationArr := make([]PlayerAggression, 0, existingLen+1)
+	newRelationArr = append(newRelationArr, oldRelationArr[0:existingLen-1]...)
+	newRelationArr = append(newRelationArr, dataInsert, oldRelationArr[existingLen-1])
+	return newRelationArr, nil
 }
 
 func convertPlayerIndexToId(playerIndex int, totalPlayers int) int {
@@ -603,204 +1066,284 @@ func convertPlayerIndexToId(playerIndex int, totalPlayers int) int {
 	}
 }
 
-func ModifyAllExistingPlayerUnknownArr(inputFilename string) {
-	saveOutput, err := ReadPolytopiaDecompressedFile(inputFilename)
+// ModifyAllExistingPlayerUnknownArr adds the newest player to the aggressions list of every player in the current state
+func ModifyAllExistingPlayerUnknownArr(inputFilename string) error {
+	return ModifyTargetPlayerUnknownArr(FileInfo{InputFilename: inputFilename})
+}
+
+// ModifyTargetPlayerUnknownArr adds the newest player to the aggressions list of every player
+// in each copy of the map selected by fileInfo.Target
+func ModifyTargetPlayerUnknownArr(fileInfo FileInfo) error {
+	fileInfo, err := backupBeforeWrite(fileInfo)
 	if err != nil {
-		log.Fatal("Failed to read save file")
+		return err
+	}
+	saveOutput, err := readFileInfoSave(fileInfo)
+	if err != nil {
+		return err
+	}
+
+	sections := make([]fileSection, 0)
+	for _, state := range targetMapStates(saveOutput, fileInfo.Target) {
+		debugPrint("New player count in %v: %v\n", state, len(*state.playerData))
+		if err := updateAllPlayerAggressions(*state.playerData, saveOutput.GameVersion); err != nil {
+			return fmt.Errorf("%v: %w", state, err)
+		}
+		section, err := statePlayersSection(saveOutput, state.initial, *state.playerData)
+		if err != nil {
+			return err
+		}
+		sections = append(sections, section)
 	}
-	newPlayerCount := len(saveOutput.PlayerData)
-	fmt.Println(fmt.Sprintf("New player count: %v", newPlayerCount))
+	return writeSections(fileInfo.InputFilename, sections)
+}
 
-	for i := len(saveOutput.PlayerData) - 1; i >= 0; i-- {
+// updateAllPlayerAggressions adds the newest player to the aggressions list of every player.
+// Versions without aggressions lists are left unchanged.
+func updateAllPlayerAggressions(playerData []PlayerData, gameVersion int) error {
+	if !nearestVersionLayout(gameVersion).HasPlayerAggressions {
+		return nil
+	}
+	newPlayerCount := len(playerData)
+	for i := len(playerData) - 1; i >= 0; i-- {
 		newPlayerId := newPlayerCount - 1
-		newRelationArr := BuildNewPlayerUnknownArr(saveOutput.PlayerData[i].AggressionsByPlayers, newPlayerId)
-		saveOutput.PlayerData[i].AggressionsByPlayers = newRelationArr
+		newRelationArr, err := BuildNewPlayerUnknownArr(playerData[i].AggressionsByPlayers, newPlayerId)
+		if err != nil {
+			return fmt.Errorf("player %v: %w", playerData[i].PlayerId, err)
+		}
+		playerData[i].AggressionsByPlayers = newRelationArr
 	}
-	WritePlayersToFile(inputFilename, saveOutput.PlayerData, saveOutput.GameVersion)
+	return nil
 }
 
-func AddPlayer(inputFilename string) {
-	saveOutput, err := ReadPolytopiaDecompressedFile(inputFilename)
+// AddPlayer adds a new player to the current state
+func AddPlayer(inputFilename string) error {
+	return AddTargetPlayer(FileInfo{InputFilename: inputFilename})
+}
+
+// AddTargetPlayer adds the same new player to each copy of the map selected by fileInfo.Target
+func AddTargetPlayer(fileInfo FileInfo) error {
+	fileInfo, err := backupBeforeWrite(fileInfo)
 	if err != nil {
-		log.Fatal("Failed to read save file")
+		return err
+	}
+	saveOutput, err := readFileInfoSave(fileInfo)
+	if err != nil {
+		return err
 	}
-	oldPlayerCount := len(saveOutput.PlayerData)
-	fmt.Println(fmt.Sprintf("Old num players: %v", oldPlayerCount))
 
 	// existing index will be 1, 2, 3, ..., oldPlayerCount-1, 255 (size is oldPlayerCount)
 	// new index list will be 1, 2, 3, ..., oldPlayerCount-1, oldPlayerCount, 255 (size is oldPlayerCount + 1)
-	playerName := fmt.Sprintf("Player%v", oldPlayerCount)
-	overrideColor := generateRandomColor()
-	newPlayer := BuildEmptyPlayer(oldPlayerCount, playerName, overrideColor)
+	states := targetMapStates(saveOutput, fileInfo.Target)
+	oldPlayerCount := len(*states[len(states)-1].playerData)
+	newPlayer, err := BuildEmptyPlayer(oldPlayerCount, fmt.Sprintf("Player%v", oldPlayerCount), generateRandomColor())
+	if err != nil {
+		return err
+	}
+	sections := make([]fileSection, 0)
+	for _, state := range states {
+		debugPrint("Old num players in %v: %v\n", state, len(*state.playerData))
+		newPlayerData, err := insertPlayer(*state.playerData, newPlayer, saveOutput.GameVersion)
+		if err != nil {
+			return fmt.Errorf("%v: %w", state, err)
+		}
+		section, err := statePlayersSection(saveOutput, state.initial, newPlayerData)
+		if err != nil {
+			return err
+		}
+		sections = append(sections, section)
+	}
+	return writeSections(fileInfo.InputFilename, sections)
+}
 
+// insertPlayer adds the player before player 255 and adds the player to every aggressions list.
+// The new player has no aggressions list in versions without one.
+func insertPlayer(playerData []PlayerData, newPlayer PlayerData, gameVersion int) ([]PlayerData, error) {
+	if len(playerData) == 0 {
+		return nil, fmt.Errorf("player list is empty, expected it to end with player 255")
+	}
+	if !nearestVersionLayout(gameVersion).HasPlayerAggressions {
+		newPlayer.AggressionsByPlayers = nil
+	}
 	newPlayerData := make([]PlayerData, 0)
-	for i := 0; i < len(saveOutput.PlayerData)-1; i++ {
-		newPlayerData = append(newPlayerData, saveOutput.PlayerData[i])
+	for i := 0; i < len(playerData)-1; i++ {
+		newPlayerData = append(newPlayerData, playerData[i])
 	}
 	newPlayerData = append(newPlayerData, newPlayer)
-	newPlayerData = append(newPlayerData, saveOutput.PlayerData[len(saveOutput.PlayerData)-1])
-	WritePlayersToFile(inputFilename, newPlayerData, saveOutput.GameVersion)
+	newPlayerData = append(newPlayerData, playerData[len(playerData)-1])
 
-	ModifyAllExistingPlayerUnknownArr(inputFilename)
+	if err := updateAllPlayerAggressions(newPlayerData, gameVersion); err != nil {
+		return nil, err
+	}
+	return newPlayerData, nil
 }
 
-func SwapPlayers(fileInfo FileInfo, playerId1 int, playerId2 int) {
-	inputFilename := fileInfo.InputFilename
-	saveOutput, err := ReadPolytopiaDecompressedFile(inputFilename)
+// SwapPlayers swaps the tiles, units, tribe, color and start tile of two players in each copy of the map selected by fileInfo.Target.
+// The tiles and players of every state are written together.
+func SwapPlayers(fileInfo FileInfo, playerId1 int, playerId2 int) error {
+	for _, playerId := range []int{playerId1, playerId2} {
+		if err := checkUint8Field("player id", playerId); err != nil {
+			return err
+		}
+	}
+	fileInfo, err := backupBeforeWrite(fileInfo)
 	if err != nil {
-		log.Fatal("Failed to read save file")
+		return err
+	}
+	saveOutput, err := readFileInfoSave(fileInfo)
+	if err != nil {
+		return err
 	}
 
-	// assumes 254 is not used by any players
-	unusedPlayerId := 254
-
-	// Need to reassign so we don't merge the players
-	for i := 0; i < saveOutput.MapHeight; i++ {
-		for j := 0; j < saveOutput.MapWidth; j++ {
-			if saveOutput.TileData[i][j].Owner == playerId1 {
-				saveOutput.TileData[i][j].Owner = unusedPlayerId
-			}
-
-			if saveOutput.TileData[i][j].Capital == playerId1 {
-				saveOutput.TileData[i][j].Capital = unusedPlayerId
-			}
-			if saveOutput.TileData[i][j].ImprovementData != nil && saveOutput.TileData[i][j].ImprovementData.ConnectedPlayerCapital == playerId1 {
-				saveOutput.TileData[i][j].ImprovementData.ConnectedPlayerCapital = unusedPlayerId
-			}
-
-			if saveOutput.TileData[i][j].Unit != nil && saveOutput.TileData[i][j].Unit.Owner == uint8(playerId1) {
-				saveOutput.TileData[i][j].Unit.Owner = uint8(unusedPlayerId)
-			}
+	sections := make([]fileSection, 0)
+	for _, state := range targetMapStates(saveOutput, fileInfo.Target) {
+		swapPlayerTiles(*state.tileData, playerId1, playerId2)
+		swapPlayerData(*state.playerData, playerId1, playerId2)
 
-			if saveOutput.TileData[i][j].PassengerUnit != nil && saveOutput.TileData[i][j].PassengerUnit.Owner == uint8(playerId1) {
-				saveOutput.TileData[i][j].PassengerUnit.Owner = uint8(unusedPlayerId)
-			}
+		mapSection, err := stateMapSection(saveOutput, state.initial, *state.tileData)
+		if err != nil {
+			return err
+		}
+		playersSection, err := statePlayersSection(saveOutput, state.initial, *state.playerData)
+		if err != nil {
+			return err
 		}
+		sections = append(sections, mapSection, playersSection)
 	}
+	return writeSections(fileInfo.InputFilename, sections)
+}
 
-	// Overwrite all playerId2 tiles and units with playerId1
-	for i := 0; i < saveOutput.MapHeight; i++ {
-		for j := 0; j < saveOutput.MapWidth; j++ {
-			if saveOutput.TileData[i][j].Owner == playerId2 {
-				saveOutput.TileData[i][j].Owner = playerId1
-			}
-
-			if saveOutput.TileData[i][j].Capital == playerId2 {
-				saveOutput.TileData[i][j].Capital = playerId1
-			}
-			if saveOutput.TileData[i][j].ImprovementData != nil && saveOutput.TileData[i][j].ImprovementData.ConnectedPlayerCapital == playerId2 {
-				saveOutput.TileData[i][j].ImprovementData.ConnectedPlayerCapital = playerId1
-			}
-
-			if saveOutput.TileData[i][j].Unit != nil && saveOutput.TileData[i][j].Unit.Owner == uint8(playerId2) {
-				saveOutput.TileData[i][j].Unit.Owner = uint8(playerId1)
-			}
+// swapUnusedPlayerId holds the tiles of the first player while the players are swapped
+const swapUnusedPlayerId = 254
 
-			if saveOutput.TileData[i][j].PassengerUnit != nil && saveOutput.TileData[i][j].PassengerUnit.Owner == uint8(playerId2) {
-				saveOutput.TileData[i][j].PassengerUnit.Owner = uint8(playerId1)
-			}
-		}
-	}
+func swapPlayerTiles(tileData [][]TileData, playerId1 int, playerId2 int) {
+	// assumes 254 is not used by any players
+	unusedPlayerId := swapUnusedPlayerId
 
+	// Need to reassign so we don't merge the players
+	replaceTilePlayerId(tileData, playerId1, unusedPlayerId)
+	// Overwrite all playerId2 tiles and units with playerId1
+	replaceTilePlayerId(tileData, playerId2, playerId1)
 	// Overwrite old playerId tiles and units with playerId2
-	for i := 0; i < saveOutput.MapHeight; i++ {
-		for j := 0; j < saveOutput.MapWidth; j++ {
-			if saveOutput.TileData[i][j].Owner == unusedPlayerId {
-				saveOutput.TileData[i][j].Owner = playerId2
+	replaceTilePlayerId(tileData, unusedPlayerId, playerId2)
+}
+
+func replaceTilePlayerId(tileData [][]TileData, oldPlayerId int, newPlayerId int) {
+	for i := 0; i < len(tileData); i++ {
+		for j := 0; j < len(tileData[i]); j++ {
+			tile := &tileData[i][j]
+			if tile.Owner == oldPlayerId {
+				tile.Owner = newPlayerId
 			}
 
-			if saveOutput.TileData[i][j].Capital == unusedPlayerId {
-				saveOutput.TileData[i][j].Capital = playerId2
+			if tile.Capital == oldPlayerId {
+				tile.Capital = newPlayerId
 			}
-			if saveOutput.TileData[i][j].ImprovementData != nil && saveOutput.TileData[i][j].ImprovementData.ConnectedPlayerCapital == unusedPlayerId {
-				saveOutput.TileData[i][j].ImprovementData.ConnectedPlayerCapital = playerId2
+			if tile.ImprovementData != nil && tile.ImprovementData.ConnectedPlayerCapital == oldPlayerId {
+				tile.ImprovementData.ConnectedPlayerCapital = newPlayerId
 			}
 
-			if saveOutput.TileData[i][j].Unit != nil && saveOutput.TileData[i][j].Unit.Owner == uint8(unusedPlayerId) {
-				saveOutput.TileData[i][j].Unit.Owner = uint8(playerId2)
+			if tile.Unit != nil && tile.Unit.Owner == uint8(oldPlayerId) {
+				tile.Unit.Owner = uint8(newPlayerId)
 			}
 
-			if saveOutput.TileData[i][j].PassengerUnit != nil && saveOutput.TileData[i][j].PassengerUnit.Owner == uint8(unusedPlayerId) {
-				saveOutput.TileData[i][j].PassengerUnit.Owner = uint8(playerId2)
+			if tile.PassengerUnit != nil && tile.PassengerUnit.Owner == uint8(oldPlayerId) {
+				tile.PassengerUnit.Owner = uint8(newPlayerId)
 			}
 		}
 	}
+}
 
-	WriteMapToFile(fileInfo, saveOutput.TileData)
-
+func swapPlayerData(playerData []PlayerData, playerId1 int, playerId2 int) {
 	var player1Tribe, player2Tribe int
 	player1Color := make([]int, 4)
 	player2Color := make([]int, 4)
 	player1StartTile := [2]int{0, 0}
 	player2StartTile := [2]int{0, 0}
-	for i := 0; i < len(saveOutput.PlayerData); i++ {
-		if saveOutput.PlayerData[i].PlayerId == playerId1 {
-			player1Tribe = saveOutput.PlayerData[i].Tribe
-			copy(player1Color, saveOutput.PlayerData[i].OverrideColor)
-			player1StartTile[0] = saveOutput.PlayerData[i].StartTileCoordinates[0]
-			player1StartTile[1] = saveOutput.PlayerData[i].StartTileCoordinates[1]
-		} else if saveOutput.PlayerData[i].PlayerId == playerId2 {
-			player2Tribe = saveOutput.PlayerData[i].Tribe
-			copy(player2Color, saveOutput.PlayerData[i].OverrideColor)
-			player2StartTile[0] = saveOutput.PlayerData[i].StartTileCoordinates[0]
-			player2StartTile[1] = saveOutput.PlayerData[i].StartTileCoordinates[1]
+	for i := 0; i < len(playerData); i++ {
+		if playerData[i].PlayerId == playerId1 {
+			player1Tribe = playerData[i].Tribe
+			copy(player1Color, playerData[i].OverrideColor)
+			player1StartTile[0] = playerData[i].StartTileCoordinates[0]
+			player1StartTile[1] = playerData[i].StartTileCoordinates[1]
+		} else if playerData[i].PlayerId == playerId2 {
+			player2Tribe = playerData[i].Tribe
+			copy(player2Color, playerData[i].OverrideColor)
+			player2StartTile[0] = playerData[i].StartTileCoordinates[0]
+			player2StartTile[1] = playerData[i].StartTileCoordinates[1]
 		}
 	}
 
-	for i := 0; i < len(saveOutput.PlayerData); i++ {
-		if saveOutput.PlayerData[i].PlayerId == playerId1 {
-			saveOutput.PlayerData[i].Tribe = player2Tribe
-			saveOutput.PlayerData[i].OverrideColor = player2Color
-			saveOutput.PlayerData[i].StartTileCoordinates[0] = player2StartTile[0]
-			saveOutput.PlayerData[i].StartTileCoordinates[1] = player2StartTile[1]
-		} else if saveOutput.PlayerData[i].PlayerId == playerId2 {
-			saveOutput.PlayerData[i].Tribe = player1Tribe
-			saveOutput.PlayerData[i].OverrideColor = player1Color
-			saveOutput.PlayerData[i].StartTileCoordinates[0] = player1StartTile[0]
-			saveOutput.PlayerData[i].StartTileCoordinates[1] = player1StartTile[1]
+	for i := 0; i < len(playerData); i++ {
+		if playerData[i].PlayerId == playerId1 {
+			playerData[i].Tribe = player2Tribe
+			playerData[i].OverrideColor = player2Color
+			playerData[i].StartTileCoordinates[0] = player2StartTile[0]
+			playerData[i].StartTileCoordinates[1] = player2StartTile[1]
+		} else if playerData[i].PlayerId == playerId2 {
+			playerData[i].Tribe = player1Tribe
+			playerData[i].OverrideColor = player1Color
+			playerData[i].StartTileCoordinates[0] = player1StartTile[0]
+			playerData[i].StartTileCoordinates[1] = player1StartTile[1]
 		}
 	}
-
-	WritePlayersToFile(inputFilename, saveOutput.PlayerData, fileInfo.GameVersion)
 }
 
-func SetTileCapital(fileInfo FileInfo, targetX int, targetY int, newCityName string, updatedTribe int) {
-	inputFilename := fileInfo.InputFilename
-	saveOutput, err := ReadPolytopiaDecompressedFile(inputFilename)
+// SetTileCapital builds a capital city on the tile in each copy of the map selected by fileInfo.Target.
+// The tiles and the player's start tile are written together.
+func SetTileCapital(fileInfo FileInfo, targetX int, targetY int, newCityName string, updatedTribe int) error {
+	if updatedTribe >= 255 {
+		return fmt.Errorf("tribe must be less than 255, value is %v", updatedTribe)
+	}
+	fileInfo, err := backupBeforeWrite(fileInfo)
 	if err != nil {
-		log.Fatal("Failed to read save file")
+		return err
+	}
+	saveOutput, err := readFileInfoSave(fileInfo)
+	if err != nil {
+		return err
 	}
 
-	if updatedTribe >= 255 {
-		log.Fatal("Value must be less than 255")
+	sections := make([]fileSection, 0)
+	for _, state := range targetMapStates(saveOutput, fileInfo.Target) {
+		if _, err := state.getTile(targetX, targetY); err != nil {
+			return err
+		}
+		playerIndex := setTileCapital(*state.tileData, *state.playerData, targetX, targetY, newCityName, updatedTribe)
+		debugPrint("Modified tile (%v, %v) in %v to have capital %v\n", targetX, targetY, state, updatedTribe)
+		mapSection, err := stateMapSection(saveOutput, state.initial, *state.tileData)
+		if err != nil {
+			return err
+		}
+		sections = append(sections, mapSection)
+
+		if playerIndex != -1 {
+			playersSection, err := statePlayersSection(saveOutput, state.initial, *state.playerData)
+			if err != nil {
+				return err
+			}
+			sections = append(sections, playersSection)
+			debugPrint("Set player id %v start coordinates to (%v, %v) in %v\n",
+				(*state.playerData)[playerIndex].PlayerId, targetX, targetY, state)
+		}
 	}
-	capitalTile := saveOutput.TileData[targetY][targetX]
+	return writeSections(fileInfo.InputFilename, sections)
+}
+
+// setTileCapital builds a capital city and claims the neighboring tiles.
+// Returns the index of the player whose start tile was moved to the capital, or -1 if there is no such player.
+func setTileCapital(tileData [][]TileData, playerData []PlayerData, targetX int, targetY int, newCityName string, updatedTribe int) int {
+	capitalTile := tileData[targetY][targetX]
 	capitalTile.Capital = updatedTribe
 	capitalTile.Owner = updatedTribe
 	capitalTile.CapitalCoordinates[0] = capitalTile.WorldCoordinates[0]
 	capitalTile.CapitalCoordinates[1] = capitalTile.WorldCoordinates[1]
 
 	capitalTile.ImprovementExists = true
-	capitalTile.ImprovementType = 1
-	capitalTile.ImprovementData = &ImprovementData{
-		Level:                  1,
-		FoundedTurn:            0,
-		CurrentPopulation:      0,
-		TotalPopulation:        0,
-		Production:             1,
-		BaseScore:              0,
-		BorderSize:             1,
-		UpgradeCount:           0,
-		ConnectedPlayerCapital: 0,
-		HasCityName:            1,
-		CityName:               newCityName,
-		FoundedTribe:           0,
-		CityRewards:            []int{},
-		RebellionFlag:          0,
-		RebellionBuffer:        []int{},
-	}
-	saveOutput.TileData[targetY][targetX] = capitalTile
-	fmt.Println(fmt.Sprintf("Modified tile (%v, %v) to have capital %v", targetX, targetY, updatedTribe))
+	capitalTile.ImprovementType = int(ImprovementCity)
+	improvementData := BuildEmptyCity(newCityName)
+	capitalTile.ImprovementData = &improvementData
+	tileData[targetY][targetX] = capitalTile
 
 	for deltaX := -1; deltaX <= 1; deltaX++ {
 		for deltaY := -1; deltaY <= 1; deltaY++ {
@@ -811,29 +1354,25 @@ func SetTileCapital(fileInfo FileInfo, targetX int, targetY int, newCityName str
 			neighborX := capitalTile.WorldCoordinates[0] + deltaX
 			neighborY := capitalTile.WorldCoordinates[1] + deltaY
 
-			if neighborX < 0 || neighborX >= saveOutput.MapWidth {
+			if neighborY < 0 || neighborY >= len(tileData) {
 				continue
 			}
-			if neighborY < 0 || neighborY >= saveOutput.MapHeight {
+			if neighborX < 0 || neighborX >= len(tileData[neighborY]) {
 				continue
 			}
 
-			saveOutput.TileData[neighborY][neighborX].Owner = updatedTribe
-			saveOutput.TileData[neighborY][neighborX].CapitalCoordinates[0] = capitalTile.WorldCoordinates[0]
-			saveOutput.TileData[neighborY][neighborX].CapitalCoordinates[1] = capitalTile.WorldCoordinates[1]
-			fmt.Println(fmt.Sprintf("Set neighboring tile (%v, %v) to have owner %v", neighborX, neighborY, updatedTribe))
+			tileData[neighborY][neighborX].Owner = updatedTribe
+			tileData[neighborY][neighborX].CapitalCoordinates[0] = capitalTile.WorldCoordinates[0]
+			tileData[neighborY][neighborX].CapitalCoordinates[1] = capitalTile.WorldCoordinates[1]
 		}
 	}
-	WriteMapToFile(fileInfo, saveOutput.TileData)
 
-	for i := 0; i < len(saveOutput.PlayerData); i++ {
-		if saveOutput.PlayerData[i].PlayerId == updatedTribe {
-			saveOutput.PlayerData[i].StartTileCoordinates[0] = capitalTile.WorldCoordinates[0]
-			saveOutput.PlayerData[i].StartTileCoordinates[1] = capitalTile.WorldCoordinates[1]
-			WritePlayersToFile(inputFilename, saveOutput.PlayerData, fileInfo.GameVersion)
-			fmt.Printf("Set player id %v start coordinates to (%v, %v)\n",
-				saveOutput.PlayerData[i].PlayerId, saveOutput.PlayerData[i].StartTileCoordinates[0], saveOutput.PlayerData[i].StartTileCoordinates[1])
-			break
+	for i := 0; i < len(playerData); i++ {
+		if playerData[i].PlayerId == updatedTribe {
+			playerData[i].StartTileCoordinates[0] = capitalTile.WorldCoordinates[0]
+			playerData[i].StartTileCoordinates[1] = capitalTile.WorldCoordinates[1]
+			return i
 		}
 	}
+	return -1
 }
@@ -1,7 +1,11 @@
 package polytopiamapmodel
 
 import (
+	"bytes"
 	"fmt"
+	"io"
+	"os"
+	"path/filepath"
 	"reflect"
 	"testing"
 )
@@ -50,17 +54,38 @@ var (
 
 func TestBuildNewPlayerUnknownArr(t *testing.T) {
 	oldArr := oldPlayerRelationData
-	resultBytesNoChange := BuildNewPlayerUnknownArr(oldArr, 16)
+	resultBytesNoChange, err := BuildNewPlayerUnknownArr(oldArr, 16)
 	expectedBytesNoChange := oldPlayerRelationData
-	if !reflect.DeepEqual(resultBytesNoChange, expectedBytesNoChange) {
-		t.Fatalf(`No change failed. Result = %v, expected = %v`, resultBytesNoChange, expectedBytesNoChange)
+	if err != nil || !reflect.DeepEqual(resultBytesNoChange, expectedBytesNoChange) {
+		t.Fatalf(`No change failed. Result = %v, expected = %v, error: %v`, resultBytesNoChange, expectedBytesNoChange, err)
 	}
 
-	resultBytesWithChange := BuildNewPlayerUnknownArr(oldArr, 17)
+	resultBytesWithChange, err := BuildNewPlayerUnknownArr(oldArr, 17)
 	expectedBytesWithChange := newPlayerRelationData
-	if !reflect.DeepEqual(resultBytesWithChange, expectedBytesWithChange) {
-		t.Fatalf(`Change to include player 17 failed. Result = %v, expected = %v`, resultBytesWithChange, expectedBytesWithChange)
+	if err != nil || !reflect.DeepEqual(resultBytesWithChange, expectedBytesWithChange) {
+		t.Fatalf(`Change to include player 17 failed. Result = %v, expected = %v, error: %v`, resultBytesWithChange, expectedBytesWithChange, err)
 	}
+
+	if _, err := BuildNewPlayerUnknownArr([]PlayerAggression{}, 17); err == nil {
+		t.Fatalf(`Expected error for an empty aggressions list`)
+	}
+}
+
+// serializeTestSave serializes the save and fails the test if it can't be serialized
+func serializeTestSave(t *testing.T, saveOutput *PolytopiaSaveOutput) []byte {
+	fileData, err := SerializePolytopiaSave(saveOutput)
+	if err != nil {
+		t.Fatalf(`Failed to serialize save: %v`, err)
+	}
+	return fileData
+}
+
+// mustSerialize returns the serialized bytes of a test fixture, which always fits its fields
+func mustSerialize(data []byte, err error) []byte {
+	if err != nil {
+		panic(err)
+	}
+	return data
 }
 
 func compareArrays(t *testing.T, resultBytes []byte, expectedBytes []byte) {
@@ -86,3 +111,338 @@ func findArrayDifference(resultBytes []byte, expectedBytes []byte) {
 		}
 	}
 }
+
+func TestConvertByteListRejectsValuesOutsideByte(t *testing.T) {
+	if _, err := ConvertByteList([]int{1, 300}); err == nil {
+		t.Fatalf(`Expected error for a value over 255`)
+	}
+	tile := BuildEmptyTile(0, 0)
+	tile.PlayerVisibility = []int{1, -1}
+	if _, err := SerializeTileToBytes(tile, 104); err == nil {
+		t.Fatalf(`Expected error for a visibility list with -1`)
+	}
+}
+
+func TestExpandRowsUpdatesFile(t *testing.T) {
+	inputFilename := writeTestSaveFile(t)
+	fileInfo := FileInfo{InputFilename: inputFilename, GameVersion: 104}
+	if err := ModifyTileTerrain(fileInfo, 1, 0, 4); err != nil {
+		t.Fatalf(`Failed to modify terrain: %v`, err)
+	}
+	if err := ExpandRows(fileInfo, 3); err != nil {
+		t.Fatalf(`Failed to expand rows: %v`, err)
+	}
+
+	result, err := ReadPolytopiaDecompressedFile(inputFilename)
+	if err != nil {
+		t.Fatalf(`Failed to read modified file: %v`, err)
+	}
+	if result.MapWidth != 2 || result.MapHeight != 3 || len(result.TileData) != 3 {
+		t.Fatalf(`Map size = %vx%v, expected = 2x3`, result.MapWidth, result.MapHeight)
+	}
+	if result.TileData[0][1].Terrain != 4 {
+		t.Fatalf(`Terrain = %v, expected = 4`, result.TileData[0][1].Terrain)
+	}
+}
+
+func TestModifyInitialAndCurrentState(t *testing.T) {
+	inputFilename := writeTestSaveFile(t)
+	if err := ModifyTileTerrain(FileInfo{InputFilename: inputFilename, GameVersion: 104, Target: EditTargetInitial}, 1, 0, 4); err != nil {
+		t.Fatalf(`Failed to modify terrain: %v`, err)
+	}
+	if err := AddCityToTile(FileInfo{InputFilename: inputFilename, GameVersion: 104, Target: EditTargetBoth}, 0, 1, "Test City", 1); err != nil {
+		t.Fatalf(`Failed to add city: %v`, err)
+	}
+	if err := ExpandColumns(FileInfo{InputFilename: inputFilename, GameVersion: 104}, 3); err != nil {
+		t.Fatalf(`Failed to expand columns: %v`, err)
+	}
+
+	result, err := ReadPolytopiaDecompressedFile(inputFilename)
+	if err != nil {
+		t.Fatalf(`Failed to read modified file: %v`, err)
+	}
+	if result.InitialTileData[0][1].Terrain != 4 || result.TileData[0][1].Terrain != 3 {
+		t.Fatalf(`Terrain = %v (initial), %v (current), expected = 4, 3`, result.InitialTileData[0][1].Terrain, result.TileData[0][1].Terrain)
+	}
+	for _, tileData := range [][][]TileData{result.InitialTileData, result.TileData} {
+		if tileData[1][0].ImprovementData == nil || tileData[1][0].ImprovementData.CityName != "Test City" {
+			t.Fatalf(`City was not added to tile (0, 1) in both states`)
+		}
+	}
+	if result.InitialMapHeaderOutput.MapWidth != 3 || result.MapHeaderOutput.MapWidth != 3 || len(result.InitialTileData[0]) != 3 {
+		t.Fatalf(`Map width = %v (initial), %v (current), expected = 3`, result.InitialMapHeaderOutput.MapWidth, result.MapHeaderOutput.MapWidth)
+	}
+}
+
+func TestFileWritersRejectTilesOutsideMap(t *testing.T) {
+	inputFilename := writeTestSaveFile(t)
+	originalData := readTestSaveFile(t, inputFilename)
+	fileInfo := FileInfo{InputFilename: inputFilename, GameVersion: 104, Target: EditTargetBoth}
+
+	if err := AddCityToTile(fileInfo, 2, 0, "Test City", 1); err == nil {
+		t.Fatalf(`AddCityToTile outside the map should fail`)
+	}
+	if err := ModifyTileTerrain(fileInfo, 0, -1, 4); err == nil {
+		t.Fatalf(`ModifyTileTerrain outside the map should fail`)
+	}
+	if err := ExpandRows(fileInfo, 1); err == nil {
+		t.Fatalf(`ExpandRows to a smaller height should fail`)
+	}
+	compareArrays(t, readTestSaveFile(t, inputFilename), originalData)
+}
+
+func TestResetTileShrinksFile(t *testing.T) {
+	inputFilename := filepath.Join(t.TempDir(), "test.state")
+	if err := os.WriteFile(inputFilename, buildDetailedTestSaveBytes(105), 0640); err != nil {
+		t.Fatal(err)
+	}
+	oldFileInfo, err := os.Stat(inputFilename)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// tile (1, 1) has a unit with a passenger
+	if err := ResetTile(FileInfo{InputFilename: inputFilename, GameVersion: 105}, 1, 1); err != nil {
+		t.Fatalf(`Failed to reset tile: %v`, err)
+	}
+
+	fileData, err := os.ReadFile(inputFilename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := ReadPolytopiaDecompressedFile(inputFilename)
+	if err != nil {
+		t.Fatalf(`Failed to read modified file: %v`, err)
+	}
+	if int64(len(fileData)) >= oldFileInfo.Size() {
+		t.Fatalf(`File size = %v, expected less than %v`, len(fileData), oldFileInfo.Size())
+	}
+	compareArrays(t, fileData, serializeTestSave(t, result))
+	compareArrays(t, result.Trailer, []byte{9, 8, 7})
+
+	newFileInfo, err := os.Stat(inputFilename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if newFileInfo.Mode().Perm() != 0640 {
+		t.Fatalf(`File mode = %v, expected = %v`, newFileInfo.Mode().Perm(), os.FileMode(0640))
+	}
+	tempFiles, _ := filepath.Glob(filepath.Join(filepath.Dir(inputFilename), "*.tmp"))
+	if len(tempFiles) != 0 {
+		t.Fatalf(`Temporary files were not removed: %v`, tempFiles)
+	}
+}
+
+func TestPlayerAndHeaderWritersUseTarget(t *testing.T) {
+	inputFilename := writeTestSaveFile(t)
+	if err := AddTargetPlayer(FileInfo{InputFilename: inputFilename, Target: EditTargetBoth}); err != nil {
+		t.Fatalf(`Failed to add player: %v`, err)
+	}
+	if err := WriteActionsToFile(FileInfo{InputFilename: inputFilename, Target: EditTargetBoth}, actionList[:2]); err != nil {
+		t.Fatalf(`Failed to write actions: %v`, err)
+	}
+
+	result, err := ReadPolytopiaDecompressedFile(inputFilename)
+	if err != nil {
+		t.Fatalf(`Failed to read modified file: %v`, err)
+	}
+	for _, state := range targetMapStates(result, EditTargetBoth) {
+		if players := *state.playerData; len(players) != 3 || players[1].PlayerId != 2 || players[2].PlayerId != 255 {
+			t.Fatalf(`Unexpected player list in the %v: %+v`, state, players)
+		}
+		if totalActions := state.mapHeader.MapHeaderInput.TotalActions; totalActions != 2 {
+			t.Fatalf(`TotalActions in the %v = %v, expected = 2`, state, totalActions)
+		}
+	}
+
+	// the tiles aren't changed, so the headers are checked without parsing the whole file
+	if err := ModifyTargetMapDimensions(FileInfo{InputFilename: inputFilename, Target: EditTargetBoth}, 3, 2); err != nil {
+		t.Fatalf(`Failed to modify map dimensions: %v`, err)
+	}
+	fileData, err := os.ReadFile(inputFilename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, headerRange := range []OffsetRange{result.Offsets.Initial.MapHeader, result.Offsets.Current.MapHeader} {
+		headerData := fileData[headerRange.Start:headerRange.End]
+		mapHeader, err := DeserializeMapHeaderFromBytes(io.NewSectionReader(bytes.NewReader(headerData), 0, int64(len(headerData))))
+		if err != nil {
+			t.Fatalf(`Failed to read map header: %v`, err)
+		}
+		if mapHeader.MapWidth != 3 || mapHeader.MapHeight != 2 || mapHeader.MapSquareSize != 2 {
+			t.Fatalf(`Map size = %vx%v (square size %v), expected = 3x2`, mapHeader.MapWidth, mapHeader.MapHeight, mapHeader.MapSquareSize)
+		}
+	}
+}
+
+func TestExpandTilesBacksUpFileOnce(t *testing.T) {
+	inputFilename := writeTestSaveFile(t)
+	originalData := readTestSaveFile(t, inputFilename)
+	if err := ExpandTiles(FileInfo{InputFilename: inputFilename, GameVersion: 104, Backup: true}, 3); err != nil {
+		t.Fatalf(`Failed to expand tiles: %v`, err)
+	}
+
+	backupFilenames, _ := filepath.Glob(inputFilename + ".*.bak")
+	if len(backupFilenames) != 1 {
+		t.Fatalf(`Backup files = %v, expected one backup`, backupFilenames)
+	}
+	compareArrays(t, readTestSaveFile(t, backupFilenames[0]), originalData)
+	result, err := ReadPolytopiaDecompressedFile(inputFilename)
+	if err != nil {
+		t.Fatalf(`Failed to read modified file: %v`, err)
+	}
+	if result.MapWidth != 3 || result.MapHeight != 3 {
+		t.Fatalf(`Map size = %vx%v, expected = 3x3`, result.MapWidth, result.MapHeight)
+	}
+}
+
+func TestSpliceSectionsRejectsOverlappingRanges(t *testing.T) {
+	fileData := []byte{0, 1, 2, 3, 4, 5}
+	result, err := spliceSections(fileData, []fileSection{
+		{OffsetRange{Start: 4, End: 5}, []byte{9, 9}},
+		{OffsetRange{Start: 0, End: 2}, []byte{}},
+	})
+	if err != nil {
+		t.Fatalf(`Failed to splice sections: %v`, err)
+	}
+	compareArrays(t, result, []byte{2, 3, 9, 9, 5})
+
+	if _, err := spliceSections(fileData, []fileSection{
+		{OffsetRange{Start: 0, End: 3}, []byte{}},
+		{OffsetRange{Start: 2, End: 4}, []byte{}},
+	}); err == nil {
+		t.Fatalf(`Expected error for overlapping ranges`)
+	}
+}
+
+func TestPlayerWritersDefaultToCurrentState(t *testing.T) {
+	inputFilename := writeTestSaveFile(t)
+	if err := AddPlayer(inputFilename); err != nil {
+		t.Fatalf(`Failed to add player: %v`, err)
+	}
+
+	result, err := ReadPolytopiaDecompressedFile(inputFilename)
+	if err != nil {
+		t.Fatalf(`Failed to read modified file: %v`, err)
+	}
+	if len(result.InitialPlayerData) != 2 || len(result.PlayerData) != 3 {
+		t.Fatalf(`Player count = %v (initial), %v (current), expected = 2, 3`, len(result.InitialPlayerData), len(result.PlayerData))
+	}
+}
+
+func TestAddPlayerWithoutAggressions(t *testing.T) {
+	inputFilename := writeTestSaveBytes(t, buildDetailedTestSaveBytes(114))
+	if err := AddTargetPlayer(FileInfo{InputFilename: inputFilename, GameVersion: 114, Target: EditTargetBoth}); err != nil {
+		t.Fatalf(`Failed to add player: %v`, err)
+	}
+
+	result, err := ReadPolytopiaDecompressedFile(inputFilename)
+	if err != nil {
+		t.Fatalf(`Failed to read modified file: %v`, err)
+	}
+	if len(result.InitialPlayerData) != 3 || len(result.PlayerData) != 3 {
+		t.Fatalf(`Player count = %v (initial), %v (current), expected = 3, 3`, len(result.InitialPlayerData), len(result.PlayerData))
+	}
+}
+
+func TestFileEditsWriteOnce(t *testing.T) {
+	renameCount := 0
+	defer func(originalRename func(string, string) error) { renameFile = originalRename }(renameFile)
+	renameFile = func(oldpath string, newpath string) error {
+		renameCount++
+		return os.Rename(oldpath, newpath)
+	}
+
+	city := BuildEmptyCity("City")
+	tile := BuildEmptyTile(1, 0)
+	tile.ImprovementExists = true
+	tile.ImprovementType = int(ImprovementCity)
+	tile.ImprovementData = &city
+	mapHeader := mapHeaderOutput
+	mapHeader.MapHeaderInput.Version1 = 105
+	setMapHeaderDimensions(&mapHeader, 2, 2)
+	edits := map[string]func(fileInfo FileInfo) error{
+		"write actions":     func(fileInfo FileInfo) error { return WriteActionsToFile(fileInfo, actionList[:1]) },
+		"write tile":        func(fileInfo FileInfo) error { return WriteTileToFile(fileInfo, tile, 1, 0) },
+		"write players":     func(fileInfo FileInfo) error { return WriteTargetPlayersToFile(fileInfo, []PlayerData{}) },
+		"write map header":  func(fileInfo FileInfo) error { return WriteTargetMapHeaderToFile(fileInfo, mapHeader) },
+		"modify terrain":    func(fileInfo FileInfo) error { return ModifyTileTerrain(fileInfo, 1, 0, 4) },
+		"modify unit tribe": func(fileInfo FileInfo) error { return ModifyUnitTribe(fileInfo, 1, 1, 255) },
+		"modify unit type":  func(fileInfo FileInfo) error { return ModifyUnitType(fileInfo, 1, 1, 3) },
+		"add city":          func(fileInfo FileInfo) error { return AddCityToTile(fileInfo, 1, 0, "City", 1) },
+		"reset tile":        func(fileInfo FileInfo) error { return ResetTile(fileInfo, 1, 0) },
+		"reveal tile":       func(fileInfo FileInfo) error { return RevealTileForTribe(fileInfo, 1, 0, 2) },
+		"reveal all tiles":  func(fileInfo FileInfo) error { return RevealAllTiles(fileInfo, 2) },
+		"convert tribe": func(fileInfo FileInfo) error {
+			// the only unit in the initial state is on a tile owned by player 1, the current state has none
+			fileInfo.Target = EditTargetInitial
+			return ConvertTribe(fileInfo, 1, 255)
+		},
+		"swap players":        func(fileInfo FileInfo) error { return SwapPlayers(fileInfo, 1, 255) },
+		"set capital":         func(fileInfo FileInfo) error { return SetTileCapital(fileInfo, 1, 0, "Capital", 1) },
+		"add player":          func(fileInfo FileInfo) error { return AddTargetPlayer(fileInfo) },
+		"update aggressions":  func(fileInfo FileInfo) error { return ModifyTargetPlayerUnknownArr(fileInfo) },
+		"modify dimensions":   func(fileInfo FileInfo) error { return ModifyTargetMapDimensions(fileInfo, 2, 2) },
+		"expand tiles":        func(fileInfo FileInfo) error { return ExpandTiles(fileInfo, 3) },
+		"expand rows":         func(fileInfo FileInfo) error { return ExpandRows(fileInfo, 3) },
+		"expand columns":      func(fileInfo FileInfo) error { return ExpandColumns(fileInfo, 3) },
+		"write with a backup": func(fileInfo FileInfo) error { fileInfo.Backup = true; return ModifyTileTerrain(fileInfo, 1, 0, 4) },
+	}
+	for name, edit := range edits {
+		// the players edit writes an empty list, which can't be parsed, so it is only checked for the write count
+		inputFilename := writeTestSaveBytes(t, buildDetailedTestSaveBytes(105))
+		renameCount = 0
+		if err := edit(FileInfo{InputFilename: inputFilename, GameVersion: 105, Target: EditTargetBoth}); err != nil {
+			t.Fatalf(`Failed to %v: %v`, name, err)
+		}
+		if renameCount != 1 {
+			t.Fatalf(`%v replaced the file %v times, expected once`, name, renameCount)
+		}
+		if name == "write players" {
+			continue
+		}
+		if _, err := ReadPolytopiaDecompressedFile(inputFilename); err != nil {
+			t.Fatalf(`Failed to read the file after %v: %v`, name, err)
+		}
+	}
+}
+
+func TestUnitWritersRejectOutOfRangeValues(t *testing.T) {
+	inputFilename := writeTestSaveBytes(t, buildDetailedTestSaveBytes(105))
+	fileInfo := FileInfo{InputFilename: inputFilename, GameVersion: 105, Target: EditTargetBoth}
+	edits := map[string]func() error{
+		"modify unit type":  func() error { return ModifyUnitType(fileInfo, 1, 1, 65536) },
+		"modify unit tribe": func() error { return ModifyUnitTribe(fileInfo, 1, 1, 256) },
+		"convert tribe":     func() error { return ConvertTribe(fileInfo, 1, -1) },
+		"swap players":      func() error { return SwapPlayers(fileInfo, 1, 300) },
+	}
+	for name, edit := range edits {
+		if err := edit(); err == nil {
+			t.Fatalf(`Expected %v to fail for an out of range value`, name)
+		}
+	}
+	fileData, err := os.ReadFile(inputFilename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	compareArrays(t, fileData, buildDetailedTestSaveBytes(105))
+}
+
+func TestFileWritersUseVersionOfSave(t *testing.T) {
+	inputFilename := writeTestSaveBytes(t, buildDetailedTestSaveBytes(105))
+	if err := ModifyTileTerrain(FileInfo{InputFilename: inputFilename, GameVersion: 104}, 1, 0, 4); err == nil {
+		t.Fatalf(`Expected an error for a save with a different game version`)
+	}
+
+	// tile (1, 0) of the current state is flooded, which is only written from version 105
+	if err := ModifyTileTerrain(FileInfo{InputFilename: inputFilename}, 1, 0, 4); err != nil {
+		t.Fatalf(`Failed to modify terrain: %v`, err)
+	}
+	result, err := ReadPolytopiaDecompressedFile(inputFilename)
+	if err != nil {
+		t.Fatalf(`Failed to read modified file: %v`, err)
+	}
+	if tile := result.TileData[0][1]; tile.Terrain != 4 || tile.FloodedFlag != 1 || tile.FloodedValue != 7 {
+		t.Fatalf(`Tile (1, 0) terrain = %v, flooded = %v, %v, expected = 4, 1, 7`, tile.Terrain, tile.FloodedFlag, tile.FloodedValue)
+	}
+}